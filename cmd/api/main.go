@@ -1,28 +1,134 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/joho/godotenv"
 	"github.com/redis/go-redis/v9"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 
+	"wallet/internal/audit"
 	"wallet/internal/cache"
+	"wallet/internal/currency"
 	db "wallet/internal/db"
 	handler "wallet/internal/handler"
+	"wallet/internal/metrics"
+	wallet "wallet/internal/model"
 )
 
 const (
 	ErrWorkingDir   = "Ошибка получения рабочей директории: %v"
 	ErrLoadEnvFile  = "Ошибка загрузки .env файла: %v"
 	ErrDBConnection = "Ошибка подключения к БД: %v"
+	ErrRedisConnect = "Ошибка подключения к Redis: %v"
+	ErrLoadLocales  = "Ошибка загрузки переводов: %v"
+	ErrEnvValue     = "Некорректное значение переменной %s: %v, используется значение по умолчанию"
+	ErrShutdown     = "Ошибка при остановке сервера: %v"
+	ErrAuditSink    = "Ошибка настройки журнала аудита: %v"
 )
 
+// Время на завершение активных запросов при остановке
+const shutdownTimeout = 10 * time.Second
+
+// newHTTPServer создаёт сервер с таймаутами из окружения, чтобы медленные
+// клиенты не удерживали соединения бесконечно. HTTP/2 по TLS включается
+// автоматически; при HTTP2_CLEARTEXT=true сервер принимает и h2c без TLS,
+// чтобы клиенты за балансировщиком опрашивали баланс по одному соединению.
+func newHTTPServer(addr string, h http.Handler) *http.Server {
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           h,
+		ReadHeaderTimeout: getEnvDuration("HTTP_READ_HEADER_TIMEOUT", 5*time.Second),
+		ReadTimeout:       getEnvDuration("HTTP_READ_TIMEOUT", 10*time.Second),
+		WriteTimeout:      getEnvDuration("HTTP_WRITE_TIMEOUT", 15*time.Second),
+		IdleTimeout:       getEnvDuration("HTTP_IDLE_TIMEOUT", 60*time.Second),
+	}
+	if os.Getenv("HTTP2_CLEARTEXT") == "true" {
+		h2Server := &http2.Server{IdleTimeout: server.IdleTimeout}
+		server.Handler = h2c.NewHandler(h, h2Server)
+		// Соединения h2c перехватываются у сервера; ConfigureServer закрывает
+		// их при Shutdown вместе с остальными
+		if err := http2.ConfigureServer(server, h2Server); err != nil {
+			log.Printf(ErrEnvValue, "HTTP2_CLEARTEXT", err)
+		}
+	}
+	return server
+}
+
+// dbConnConfigFromEnv читает параметры подключения к БД из DB_HOST, DB_PORT,
+// DB_USER, DB_PASSWORD, DB_NAME и DB_SSLMODE
+func dbConnConfigFromEnv() db.ConnConfig {
+	return db.ConnConfig{
+		Host:     os.Getenv("DB_HOST"),
+		Port:     os.Getenv("DB_PORT"),
+		User:     os.Getenv("DB_USER"),
+		Password: os.Getenv("DB_PASSWORD"),
+		Name:     os.Getenv("DB_NAME"),
+		SSLMode:  os.Getenv("DB_SSLMODE"),
+	}
+}
+
+// getEnvInt возвращает целое значение переменной окружения или значение по умолчанию
+func getEnvInt(key string, def int) int {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return def
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil {
+		log.Printf(ErrEnvValue, key, err)
+		return def
+	}
+	return value
+}
+
+// getEnvAmounts разбирает суммы по типам операций в формате "DEPOSIT:1.00,WITHDRAW:10"
+func getEnvAmounts(key string) map[wallet.OperationType]float64 {
+	amounts := make(map[wallet.OperationType]float64)
+	raw := os.Getenv(key)
+	if raw == "" {
+		return amounts
+	}
+
+	for _, pair := range strings.Split(raw, ",") {
+		opType, value, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		amount, err := strconv.ParseFloat(value, 64)
+		if !ok || err != nil {
+			log.Printf(ErrEnvValue, key, pair)
+			continue
+		}
+		amounts[wallet.OperationType(strings.ToUpper(opType))] = amount
+	}
+	return amounts
+}
+
+// getEnvDuration возвращает длительность из переменной окружения (например, "2s") или значение по умолчанию
+func getEnvDuration(key string, def time.Duration) time.Duration {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return def
+	}
+	value, err := time.ParseDuration(raw)
+	if err != nil {
+		log.Printf(ErrEnvValue, key, err)
+		return def
+	}
+	return value
+}
+
 func main() {
 	log.Println("Запуск сервера...")
 
@@ -35,18 +141,23 @@ func main() {
 	log.Println("Конфигурация загружена")
 
 	// Строка подключения к БД
-	dbHost := os.Getenv("DB_HOST")
-	dbPort := os.Getenv("DB_PORT")
-	dbUser := os.Getenv("DB_USER")
-	dbPass := os.Getenv("DB_PASSWORD")
-	dbName := os.Getenv("DB_NAME")
+	connConfig := dbConnConfigFromEnv()
+	dbURL := connConfig.URL()
+	log.Printf("Подключение к БД: %s", connConfig.Host)
 
-	dbURL := fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=disable",
-		dbUser, dbPass, dbHost, dbPort, dbName)
-	log.Printf("Подключение к БД: %s", dbHost)
+	// Повторные попытки подключения на случай, если БД или Redis ещё не готовы
+	retryConfig := db.RetryConfig{
+		Attempts: getEnvInt("CONNECT_RETRY_ATTEMPTS", 5),
+		Delay:    getEnvDuration("CONNECT_RETRY_DELAY", time.Second),
+	}
 
 	// Настройка пула соединений для PostgreSQL
-	database, err := db.NewPostgresConnection(dbURL)
+	// Таймауты сессии освобождают блокировки зависших запросов
+	sessionConfig := db.DefaultSessionConfig()
+	sessionConfig.StatementTimeout = getEnvDuration("DB_STATEMENT_TIMEOUT", sessionConfig.StatementTimeout)
+	sessionConfig.LockTimeout = getEnvDuration("DB_LOCK_TIMEOUT", sessionConfig.LockTimeout)
+
+	database, err := db.NewPostgresConnectionWithConfig(dbURL, retryConfig, sessionConfig)
 	if err != nil {
 		log.Fatalf(ErrDBConnection, err)
 	}
@@ -69,21 +180,250 @@ func main() {
 		ConnMaxLifetime: time.Minute * 3,
 		ConnMaxIdleTime: 90 * time.Second,
 	})
+	if err := db.WithRetry(retryConfig, func() error {
+		return redisClient.Ping(context.Background()).Err()
+	}); err != nil {
+		log.Fatalf(ErrRedisConnect, err)
+	}
 	log.Println("Redis подключен успешно")
 
 	debugMode := os.Getenv("DEBUG_MODE") == "true"
 
+	handlerConfig := handler.DefaultConfig()
+	handlerConfig.SnapshotInterval = getEnvDuration("SNAPSHOT_INTERVAL", handlerConfig.SnapshotInterval)
+	handlerConfig.WebSocketPingInterval = getEnvDuration("WEBSOCKET_PING_INTERVAL", handlerConfig.WebSocketPingInterval)
+	// События кошельков для WebSocket доходят до подписчиков всех экземпляров через Redis pub/sub
+	handlerConfig.Events = handler.NewRedisEvents(redisClient)
+	handlerConfig.HistoryRetention = getEnvDuration("HISTORY_RETENTION", handlerConfig.HistoryRetention)
+	handlerConfig.ArchiveInterval = getEnvDuration("ARCHIVE_INTERVAL", handlerConfig.ArchiveInterval)
+	handlerConfig.ArchiveBatchSize = getEnvInt("ARCHIVE_BATCH_SIZE", handlerConfig.ArchiveBatchSize)
+	handlerConfig.MinAmounts = getEnvAmounts("MIN_AMOUNTS")
+	// Переводы сообщений: встроенный русский и файлы каталога locales
+	handlerConfig.Messages = handler.DefaultMessages()
+	localesDir := os.Getenv("LOCALES_DIR")
+	if localesDir == "" {
+		localesDir = filepath.Join(projectRoot, "locales")
+	}
+	if err := handlerConfig.Messages.LoadDir(localesDir); err != nil {
+		log.Printf(ErrLoadLocales, err)
+	}
+	handlerConfig.HealthCheckInterval = getEnvDuration("HEALTH_CHECK_INTERVAL", handlerConfig.HealthCheckInterval)
+	handlerConfig.MaxOperationRetries = getEnvInt("MAX_OPERATION_RETRIES", handlerConfig.MaxOperationRetries)
+	handlerConfig.RetryBaseDelay = getEnvDuration("RETRY_BASE_DELAY", handlerConfig.RetryBaseDelay)
+	handlerConfig.MaxWriteTransactions = getEnvInt("MAX_WRITE_TRANSACTIONS", handlerConfig.MaxWriteTransactions)
+	handlerConfig.RateLimit = float64(getEnvInt("RATE_LIMIT", int(handlerConfig.RateLimit)))
+	handlerConfig.RateBurst = getEnvInt("RATE_BURST", handlerConfig.RateBurst)
+	handlerConfig.IPRateLimit = float64(getEnvInt("IP_RATE_LIMIT", int(handlerConfig.IPRateLimit)))
+	handlerConfig.IPRateBurst = getEnvInt("IP_RATE_BURST", handlerConfig.IPRateBurst)
+	if rawProxies := os.Getenv("TRUSTED_PROXIES"); rawProxies != "" {
+		proxies, err := handler.ParseTrustedProxies(rawProxies)
+		if err != nil {
+			log.Printf(ErrEnvValue, "TRUSTED_PROXIES", err)
+		} else {
+			handlerConfig.TrustedProxies = proxies
+		}
+	}
+	handlerConfig.SubjectRateLimit = float64(getEnvInt("SUBJECT_RATE_LIMIT", int(handlerConfig.SubjectRateLimit)))
+	handlerConfig.SubjectRateBurst = getEnvInt("SUBJECT_RATE_BURST", handlerConfig.SubjectRateBurst)
+	handlerConfig.LowPriorityEvery = getEnvInt("LOW_PRIORITY_EVERY", handlerConfig.LowPriorityEvery)
+	handlerConfig.MaxWalletOperations = getEnvInt("MAX_WALLET_OPERATIONS", handlerConfig.MaxWalletOperations)
+	handlerConfig.ResponseEnvelope = os.Getenv("RESPONSE_ENVELOPE") == "true"
+	handlerConfig.BlockReads = os.Getenv("BLOCK_READS") == "true"
+	handlerConfig.BalanceCacheHeaders = os.Getenv("BALANCE_CACHE_HEADERS") == "true"
+	handlerConfig.QueueFallback = os.Getenv("QUEUE_FALLBACK") == "true"
+	handlerConfig.ConcealForbiddenWallets = os.Getenv("CONCEAL_FORBIDDEN_WALLETS") == "true"
+	// Строгий режим: операция незарегистрированного типа отклоняется сразу после разбора тела
+	handlerConfig.StrictOperationTypes = os.Getenv("STRICT_OPERATION_TYPES") == "true"
+	handlerConfig.AdminToken = os.Getenv("ADMIN_TOKEN")
+	handlerConfig.TrustedCallerKey = os.Getenv("TRUSTED_CALLER_KEY")
+	handlerConfig.ReceiptKey = os.Getenv("RECEIPT_KEY")
+	handlerConfig.Production = os.Getenv("PRODUCTION") == "true"
+	handlerConfig.AllowBalanceReset = os.Getenv("ALLOW_BALANCE_RESET") == "true"
+	handlerConfig.MaintenanceMode = os.Getenv("MAINTENANCE_MODE") == "true"
+	handlerConfig.MaintenanceRetryAfter = getEnvDuration("MAINTENANCE_RETRY_AFTER", handlerConfig.MaintenanceRetryAfter)
+	handlerConfig.BalanceSoftTTL = getEnvDuration("BALANCE_SOFT_TTL", handlerConfig.BalanceSoftTTL)
+	handlerConfig.OperationTimeout = getEnvDuration("OPERATION_TIMEOUT", handlerConfig.OperationTimeout)
+	handlerConfig.MaxRequestTimeout = getEnvDuration("MAX_REQUEST_TIMEOUT", handlerConfig.MaxRequestTimeout)
+	handlerConfig.MaxRetries = getEnvInt("MAX_RETRIES", handlerConfig.MaxRetries)
+	handlerConfig.DBLatencyThreshold = getEnvDuration("DB_LATENCY_THRESHOLD", handlerConfig.DBLatencyThreshold)
+	handlerConfig.SaturatedDBReads = getEnvInt("SATURATED_DB_READS", handlerConfig.SaturatedDBReads)
+	handlerConfig.OperationDedupWindow = getEnvDuration("OPERATION_DEDUP_WINDOW", handlerConfig.OperationDedupWindow)
+	handlerConfig.OperationStatusTTL = getEnvDuration("OPERATION_STATUS_TTL", handlerConfig.OperationStatusTTL)
+	handlerConfig.MaxScheduleHorizon = getEnvDuration("MAX_SCHEDULE_HORIZON", handlerConfig.MaxScheduleHorizon)
+	handlerConfig.ClockSkew = getEnvDuration("CLOCK_SKEW", handlerConfig.ClockSkew)
+	handlerConfig.MaxListSize = getEnvInt("MAX_LIST_SIZE", handlerConfig.MaxListSize)
+	handlerConfig.ClampListLimit = os.Getenv("CLAMP_LIST_LIMIT") == "true"
+	handlerConfig.NoContentOnNoOp = os.Getenv("NO_CONTENT_ON_NOOP") == "true"
+	handlerConfig.InvariantBatchSize = getEnvInt("INVARIANT_BATCH_SIZE", handlerConfig.InvariantBatchSize)
+
+	// Курсы для ориентировочной конвертации баланса: внешний сервис или статические значения
+	if ratesURL := os.Getenv("RATES_URL"); ratesURL != "" {
+		handlerConfig.RateProvider = currency.NewHTTPRateProvider(ratesURL)
+	} else if rawRates := os.Getenv("CURRENCY_RATES"); rawRates != "" {
+		rates, err := currency.ParseRates(rawRates)
+		if err != nil {
+			log.Printf(ErrEnvValue, "CURRENCY_RATES", err)
+		} else {
+			handlerConfig.RateProvider = currency.NewStaticRateProvider(rates)
+		}
+	}
+	if code := os.Getenv("DISPLAY_CURRENCY"); code != "" {
+		if _, err := currency.Lookup(code); err != nil {
+			log.Printf(ErrEnvValue, "DISPLAY_CURRENCY", err)
+		} else {
+			handlerConfig.DisplayCurrency = code
+		}
+	}
+	handlerConfig.EnforceCurrencyPrecision = os.Getenv("ENFORCE_CURRENCY_PRECISION") == "true"
+	if strategy := os.Getenv("LOCK_STRATEGY"); strategy != "" {
+		handlerConfig.LockStrategy = handler.LockStrategy(strategy)
+	}
+	if mode := os.Getenv("BALANCE_MODE"); mode != "" {
+		handlerConfig.BalanceMode = handler.BalanceMode(mode)
+	}
+	handlerConfig.CacheWriteBatchWindow = getEnvDuration("CACHE_WRITE_BATCH_WINDOW", handlerConfig.CacheWriteBatchWindow)
+	handlerConfig.CacheWriteBatchSize = getEnvInt("CACHE_WRITE_BATCH_SIZE", handlerConfig.CacheWriteBatchSize)
+	handlerConfig.ShutdownFlushTimeout = getEnvDuration("SHUTDOWN_FLUSH_TIMEOUT", handlerConfig.ShutdownFlushTimeout)
+	// Локальный кэш балансов; другие экземпляры сбрасывают его через Redis pub/sub
+	handlerConfig.LocalCacheSize = getEnvInt("LOCAL_CACHE_SIZE", handlerConfig.LocalCacheSize)
+	handlerConfig.LocalCacheTTL = getEnvDuration("LOCAL_CACHE_TTL", handlerConfig.LocalCacheTTL)
+	if handlerConfig.LocalCacheSize > 0 {
+		handlerConfig.BalanceInvalidations = handler.NewRedisInvalidations(redisClient)
+	}
+	if naming := os.Getenv("JSON_NAMING"); naming != "" {
+		handlerConfig.JSONNaming = handler.JSONNaming(naming)
+	}
+	if policy := os.Getenv("WALLET_POLICY"); policy != "" {
+		handlerConfig.WalletPolicy = handler.WalletPolicy(policy)
+	}
+	if level := os.Getenv("LOG_LEVEL"); level != "" {
+		handlerConfig.LogLevel = handler.LogLevel(level)
+	}
+
+	// Журнал аудита: таблица audit_log (по умолчанию), файл или отключен
+	switch os.Getenv("AUDIT_SINK") {
+	case "", "db":
+		handlerConfig.AuditSink = audit.NewDBSink(database)
+	case "file":
+		fileSink, err := audit.NewFileSink(os.Getenv("AUDIT_FILE"))
+		if err != nil {
+			log.Fatalf(ErrAuditSink, err)
+		}
+		defer fileSink.Close()
+		handlerConfig.AuditSink = fileSink
+	case "none":
+	default:
+		log.Printf(ErrEnvValue, "AUDIT_SINK", os.Getenv("AUDIT_SINK"))
+		handlerConfig.AuditSink = audit.NewDBSink(database)
+	}
+
+	// Метрики: METRICS=prometheus отдаёт их на /metrics, без неё измерения отбрасываются
+	var prometheus *metrics.Prometheus
+	switch os.Getenv("METRICS") {
+	case "", "none":
+	case "prometheus":
+		prometheus = metrics.NewPrometheus()
+		handlerConfig.Metrics = prometheus
+	default:
+		log.Printf(ErrEnvValue, "METRICS", os.Getenv("METRICS"))
+	}
+
 	// Инициализация обработчиков с подключением к БД и к Redis
-	walletHandler := handler.NewWalletHandler(database, cache.NewRedisCache(redisClient), debugMode)
+	walletHandler := handler.NewWalletHandlerWithConfig(database, cache.NewRedisCache(redisClient), debugMode, handlerConfig)
+
+	// Фоновые задачи останавливаются по SIGINT/SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Фоновая запись снимков балансов
+	go walletHandler.RunSnapshots(ctx)
+	go walletHandler.RunArchival(ctx)
+	go walletHandler.RunLocalCacheInvalidation(ctx)
+
+	// Фоновые записи останавливаются после HTTP-сервера: записи, поставленные
+	// обработчиками последних запросов, успевают попасть в буферы и дописываются
+	flushCtx, stopWriters := context.WithCancel(context.Background())
+	defer stopWriters()
+
+	// Запись балансов в кэш после ответа клиенту
+	cacheWriterDone := make(chan struct{})
+	go func() {
+		defer close(cacheWriterDone)
+		walletHandler.RunCacheWriter(flushCtx)
+	}()
+
+	// Запись журнала аудита; дописывается при остановке
+	auditDone := make(chan struct{})
+	go func() {
+		defer close(auditDone)
+		walletHandler.RunAuditLog(flushCtx)
+	}()
+
+	// Обработка очереди операций и возврат отложенных повторов
+	if !debugMode {
+		go walletHandler.RunHealthCheck(ctx)
+		go walletHandler.ProcessQueue(ctx)
+		go walletHandler.RunRetryScheduler(ctx)
+	}
 
 	http.HandleFunc("/api/v1/wallets/{uuid}", walletHandler.GetWalletBalance)
-	http.HandleFunc("/api/v1/wallet", walletHandler.HandleWalletOperation)
+	http.HandleFunc("/api/v1/wallets/{uuid}/transactions", walletHandler.GetTransactionHistory)
+	http.HandleFunc("/api/v1/wallets/{uuid}/can-withdraw", walletHandler.CanWithdraw)
+	http.HandleFunc("/api/v1/wallets/{uuid}/ws", walletHandler.HandleWalletEvents)
+	http.HandleFunc("/api/v1/wallets/{uuid}/balance/poll", walletHandler.HandleBalancePoll)
+	http.HandleFunc("/api/v1/wallet", walletHandler.RejectWritesInMaintenance(walletHandler.HandleWalletOperation))
+	http.HandleFunc("/api/v1/operations/{id}", walletHandler.HandleOperationStatus)
+	http.HandleFunc("/api/v1/receipts/verify", walletHandler.HandleReceiptVerify)
+	http.HandleFunc("/api/v1/transactions/{id}/void", walletHandler.RejectWritesInMaintenance(walletHandler.VoidTransaction))
+	http.HandleFunc("/api/v1/admin/wallets/{uuid}/block", walletHandler.HandleWalletBlock)
+	http.HandleFunc("/api/v1/admin/wallets/{uuid}/reset", walletHandler.RejectWritesInMaintenance(walletHandler.HandleWalletReset))
+	http.HandleFunc("/api/v1/admin/wallets/{uuid}/rebuild", walletHandler.RejectWritesInMaintenance(walletHandler.HandleWalletRebuild))
+	http.HandleFunc("/api/v1/admin/wallets/{uuid}/cache/flush", walletHandler.HandleCacheFlush)
+	http.HandleFunc("/api/v1/admin/queue", walletHandler.HandleQueuePeek)
+	http.HandleFunc("/api/v1/admin/dlq", walletHandler.HandleDeadLetterPeek)
+	http.HandleFunc("/api/v1/admin/inflight", walletHandler.HandleInFlight)
+	http.HandleFunc("/api/v1/admin/totals", walletHandler.HandleTotals)
+	http.HandleFunc("/api/v1/admin/invariant", walletHandler.HandleInvariantCheck)
+	http.HandleFunc("/api/v1/admin/maintenance", walletHandler.HandleMaintenance)
+	http.HandleFunc("/api/v1/admin/config", walletHandler.HandleSettings)
+	http.HandleFunc("/api/v1/admin/import", walletHandler.RejectWritesInMaintenance(walletHandler.HandleImport))
+	if prometheus != nil {
+		http.Handle("/metrics", prometheus)
+	}
 
 	port := os.Getenv("SERVER_PORT")
 	if port == "" {
 		port = "8080"
 	}
 
+	server := newHTTPServer(":"+port, walletHandler.RecoverPanics(http.DefaultServeMux))
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Printf(ErrShutdown, err)
+		}
+		stopWriters()
+	}()
+
+	// С сертификатом сервер работает по TLS и согласует HTTP/2 через ALPN
+	certFile, keyFile := os.Getenv("TLS_CERT_FILE"), os.Getenv("TLS_KEY_FILE")
 	log.Printf("Сервер запущен на порту :%s", port)
-	log.Fatal(http.ListenAndServe(":"+port, nil))
+	var serveErr error
+	if certFile != "" && keyFile != "" {
+		serveErr = server.ListenAndServeTLS(certFile, keyFile)
+	} else {
+		serveErr = server.ListenAndServe()
+	}
+	if serveErr != nil && !errors.Is(serveErr, http.ErrServerClosed) {
+		log.Fatal(serveErr)
+	}
+
+	<-shutdownDone
+	<-cacheWriterDone
+	<-auditDone
+	log.Println("Сервер остановлен")
 }
@@ -3,9 +3,12 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -21,6 +24,7 @@ import (
 	"github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
 )
 
 func TestMain(m *testing.M) {
@@ -39,16 +43,13 @@ func TestAll(t *testing.T) {
 	t.Run("LoadPerformance", TestLoadPerformance)
 	t.Run("DatabaseConnectionErrors", TestDatabaseConnectionErrors)
 	t.Run("EnvVariablesErrors", TestEnvVariablesErrors)
+	t.Run("ServerTimeouts", TestServerTimeouts)
+	t.Run("HTTP2", TestHTTP2)
 }
 
 func TestDatabaseConnection(t *testing.T) {
 	// Подготовка тестовых данных для подключения
-	dbURL := fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=disable",
-		os.Getenv("DB_USER"),
-		os.Getenv("DB_PASSWORD"),
-		os.Getenv("DB_HOST"),
-		os.Getenv("DB_PORT"),
-		os.Getenv("DB_NAME"))
+	dbURL := dbConnConfigFromEnv().URL()
 
 	// Проверка подключения к БД
 	db, err := db.NewPostgresConnection(dbURL)
@@ -83,12 +84,7 @@ func TestHTTPEndpoints(t *testing.T) {
 
 func setupTestServer(t *testing.T) (*httptest.Server, func(), string) {
 	// Инициализация БД
-	dbURL := fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=disable",
-		os.Getenv("DB_USER"),
-		os.Getenv("DB_PASSWORD"),
-		os.Getenv("DB_HOST"),
-		os.Getenv("DB_PORT"),
-		os.Getenv("DB_NAME"))
+	dbURL := dbConnConfigFromEnv().URL()
 
 	database, err := db.NewPostgresConnection(dbURL)
 	if err != nil {
@@ -243,11 +239,11 @@ func TestDatabaseConnectionErrors(t *testing.T) {
 		},
 		{
 			name: "Неверное имя базы данных",
-			dbURL: fmt.Sprintf("postgres://%s:%s@%s:%s/nonexistent_db?sslmode=disable",
-				os.Getenv("DB_USER"),
-				os.Getenv("DB_PASSWORD"),
-				os.Getenv("DB_HOST"),
-				os.Getenv("DB_PORT")),
+			dbURL: func() string {
+				config := dbConnConfigFromEnv()
+				config.Name = "nonexistent_db"
+				return config.URL()
+			}(),
 			errText: "pq: database \"nonexistent_db\" does not exist",
 		},
 		{
@@ -354,12 +350,7 @@ func TestEnvVariablesErrors(t *testing.T) {
 			}
 
 			// Формируем строку подключения
-			dbURL := fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=disable",
-				os.Getenv("DB_USER"),
-				os.Getenv("DB_PASSWORD"),
-				os.Getenv("DB_HOST"),
-				os.Getenv("DB_PORT"),
-				os.Getenv("DB_NAME"))
+			dbURL := dbConnConfigFromEnv().URL()
 
 			// Пытаемся установить соединение
 			db, err := db.NewPostgresConnection(dbURL)
@@ -401,3 +392,103 @@ func TestRedisConnection(t *testing.T) {
 	err := cache.Client().Ping(ctx).Err()
 	assert.NoError(t, err, "Ошибка подключения к Redis")
 }
+
+// Тест таймаутов HTTP-сервера: клиент, не дославший заголовки, отключается
+func TestServerTimeouts(t *testing.T) {
+	t.Setenv("HTTP_READ_HEADER_TIMEOUT", "200ms")
+
+	t.Run("Таймауты из окружения и значения по умолчанию", func(t *testing.T) {
+		server := newHTTPServer(":0", http.NewServeMux())
+		assert.Equal(t, 200*time.Millisecond, server.ReadHeaderTimeout)
+		assert.Equal(t, 10*time.Second, server.ReadTimeout)
+		assert.Equal(t, 15*time.Second, server.WriteTimeout)
+		assert.Equal(t, 60*time.Second, server.IdleTimeout)
+	})
+
+	t.Run("Медленные заголовки", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		server := newHTTPServer(listener.Addr().String(), http.NewServeMux())
+		go server.Serve(listener)
+		defer server.Close()
+
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+
+		// Заголовки не завершены пустой строкой
+		_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n"))
+		require.NoError(t, err)
+
+		start := time.Now()
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		_, err = conn.Read(make([]byte, 1))
+
+		assert.ErrorIs(t, err, io.EOF, "сервер должен закрыть соединение")
+		assert.Less(t, time.Since(start), time.Second)
+	})
+}
+
+// Тест HTTP/2: по TLS протокол согласуется автоматически, без TLS - только с HTTP2_CLEARTEXT
+func TestHTTP2(t *testing.T) {
+	protoHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Proto)
+	})
+
+	t.Run("HTTP/2 по TLS", func(t *testing.T) {
+		server := httptest.NewUnstartedServer(protoHandler)
+		server.Config = newHTTPServer("", protoHandler)
+		server.EnableHTTP2 = true
+		server.StartTLS()
+		defer server.Close()
+
+		resp, err := server.Client().Get(server.URL)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+
+		assert.Equal(t, 2, resp.ProtoMajor)
+		assert.Equal(t, "HTTP/2.0", string(body))
+	})
+
+	// Клиент HTTP/2 без TLS с предварительным знанием протокола
+	h2cClient := func() *http.Client {
+		return &http.Client{Transport: &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, network, addr)
+			},
+		}}
+	}
+
+	t.Run("h2c с HTTP2_CLEARTEXT", func(t *testing.T) {
+		t.Setenv("HTTP2_CLEARTEXT", "true")
+		server := httptest.NewUnstartedServer(protoHandler)
+		server.Config = newHTTPServer("", protoHandler)
+		server.Start()
+		defer server.Close()
+
+		resp, err := h2cClient().Get(server.URL)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+
+		assert.Equal(t, 2, resp.ProtoMajor)
+		assert.Equal(t, "HTTP/2.0", string(body))
+	})
+
+	t.Run("Без HTTP2_CLEARTEXT h2c отключён", func(t *testing.T) {
+		server := httptest.NewUnstartedServer(protoHandler)
+		server.Config = newHTTPServer("", protoHandler)
+		server.Start()
+		defer server.Close()
+
+		resp, err := http.Get(server.URL)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, 1, resp.ProtoMajor)
+
+		_, err = h2cClient().Get(server.URL)
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,97 @@
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+)
+
+// Entry - неизменяемая запись журнала аудита: кто, что, когда, откуда и с каким результатом
+type Entry struct {
+	Time          time.Time `json:"time"`
+	Subject       string    `json:"subject"`
+	Action        string    `json:"action"`
+	WalletID      string    `json:"wallet_id,omitempty"`
+	OperationID   string    `json:"operation_id,omitempty"`
+	OperationType string    `json:"operation_type,omitempty"`
+	Amount        float64   `json:"amount,omitempty"`
+	SourceIP      string    `json:"source_ip,omitempty"`
+	Result        string    `json:"result"`
+	Error         string    `json:"error,omitempty"`
+}
+
+// Sink сохраняет записи аудита. Помимо встроенных DBSink и FileSink можно
+// подключить свою реализацию, например отправку в Kafka.
+type Sink interface {
+	Write(ctx context.Context, entry Entry) error
+}
+
+// Execer - часть *sql.DB, нужная DBSink
+type Execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+const insertEntryQuery = `
+	INSERT INTO audit_log (created_at, subject, action, wallet_id, operation_id, operation_type, amount, source_ip, result, error)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`
+
+// DBSink пишет записи в таблицу audit_log
+type DBSink struct {
+	db Execer
+}
+
+func NewDBSink(db Execer) *DBSink {
+	return &DBSink{db: db}
+}
+
+func (s *DBSink) Write(ctx context.Context, entry Entry) error {
+	_, err := s.db.ExecContext(ctx, insertEntryQuery,
+		entry.Time, entry.Subject, entry.Action, entry.WalletID, entry.OperationID,
+		entry.OperationType, entry.Amount, entry.SourceIP, entry.Result, entry.Error)
+	if err != nil {
+		return fmt.Errorf("ошибка записи в журнал аудита: %w", err)
+	}
+	return nil
+}
+
+// FileSink дописывает записи в файл по одной JSON-строке
+type FileSink struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewFileSink открывает файл только на дозапись, создавая его при необходимости
+func NewFileSink(path string) (*FileSink, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка открытия журнала аудита: %w", err)
+	}
+	return &FileSink{file: file}, nil
+}
+
+func (s *FileSink) Write(_ context.Context, entry Entry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("ошибка записи в журнал аудита: %w", err)
+	}
+	return nil
+}
+
+func (s *FileSink) Close() error {
+	return s.file.Close()
+}
@@ -0,0 +1,78 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAll(t *testing.T) {
+	t.Run("DBSink", TestDBSink)
+	t.Run("FileSink", TestFileSink)
+}
+
+var testEntry = Entry{
+	Time:          time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC),
+	Subject:       "admin",
+	Action:        "operation",
+	WalletID:      "8d0c3f5e-6a0c-4b8e-9f39-2d6c1c0a7b11",
+	OperationType: "DEPOSIT",
+	Amount:        100,
+	SourceIP:      "10.0.0.1",
+	Result:        ResultSuccess,
+}
+
+func TestDBSink(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectExec("INSERT INTO audit_log").
+		WithArgs(testEntry.Time, "admin", "operation", testEntry.WalletID, "", "DEPOSIT", 100.0, "10.0.0.1", ResultSuccess, "").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	assert.NoError(t, NewDBSink(db).Write(context.Background(), testEntry))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestFileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	sink, err := NewFileSink(path)
+	require.NoError(t, err)
+	assert.NoError(t, sink.Write(context.Background(), testEntry))
+	assert.NoError(t, sink.Close())
+
+	// Повторное открытие дописывает, а не перезаписывает файл
+	sink, err = NewFileSink(path)
+	require.NoError(t, err)
+	failed := testEntry
+	failed.Result = ResultFailure
+	failed.Error = "недостаточно средств"
+	assert.NoError(t, sink.Write(context.Background(), failed))
+	assert.NoError(t, sink.Close())
+
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry Entry
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &entry))
+		entries = append(entries, entry)
+	}
+	require.Len(t, entries, 2)
+	assert.Equal(t, testEntry, entries[0])
+	assert.Equal(t, ResultFailure, entries[1].Result)
+	assert.Equal(t, "недостаточно средств", entries[1].Error)
+}
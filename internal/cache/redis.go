@@ -2,6 +2,8 @@ package cache
 
 import (
 	"context"
+	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -29,10 +31,101 @@ func (c *RedisCache) BRPop(ctx context.Context, timeout time.Duration, keys ...s
 	return c.client.BRPop(ctx, timeout, keys...)
 }
 
+func (c *RedisCache) ZAdd(ctx context.Context, key string, score float64, member string) error {
+	return c.client.ZAdd(ctx, key, redis.Z{Score: score, Member: member}).Err()
+}
+
+// ZRangeByScore возвращает не более limit элементов со score не больше max
+func (c *RedisCache) ZRangeByScore(ctx context.Context, key string, max float64, limit int64) ([]string, error) {
+	return c.client.ZRangeByScore(ctx, key, &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   strconv.FormatFloat(max, 'f', -1, 64),
+		Count: limit,
+	}).Result()
+}
+
+func (c *RedisCache) ZRem(ctx context.Context, key string, member string) (int64, error) {
+	return c.client.ZRem(ctx, key, member).Result()
+}
+
+// moveToQueueScript переносит элемент ARGV[1] из отложенного множества KEYS[1]
+// в очередь KEYS[2]. ZREM и LPUSH выполняются атомарно: операция не теряется
+// между ними и не попадает в очередь дважды. Результат - 1, если элемент перенесён.
+var moveToQueueScript = redis.NewScript(`
+if redis.call('ZREM', KEYS[1], ARGV[1]) == 0 then
+	return 0
+end
+redis.call('LPUSH', KEYS[2], ARGV[1])
+return 1
+`)
+
+// MoveToQueue переносит member из множества setKey в очередь queueKey. Если
+// элемента уже нет (его забрал другой экземпляр), возвращается false.
+func (c *RedisCache) MoveToQueue(ctx context.Context, setKey, member, queueKey string) (bool, error) {
+	moved, err := moveToQueueScript.Run(ctx, c.client, []string{setKey, queueKey}, member).Int64()
+	if err != nil {
+		return false, err
+	}
+	return moved == 1, nil
+}
+
+// LRange возвращает элементы списка с индексами от start до stop включительно
+func (c *RedisCache) LRange(ctx context.Context, key string, start, stop int64) ([]string, error) {
+	return c.client.LRange(ctx, key, start, stop).Result()
+}
+
+func (c *RedisCache) LLen(ctx context.Context, key string) (int64, error) {
+	return c.client.LLen(ctx, key).Result()
+}
+
 func (c *RedisCache) Get(ctx context.Context, key string) (string, error) {
 	return c.client.Get(ctx, key).Result()
 }
 
+// SetNX записывает значение, только если ключа нет, и сообщает, была ли запись
+func (c *RedisCache) SetNX(ctx context.Context, key string, value interface{}, expiration time.Duration) (bool, error) {
+	return c.client.SetNX(ctx, key, value, expiration).Result()
+}
+
+// enqueueUniqueScript добавляет значение в очередь KEYS[1], только если ключа
+// дедупликации KEYS[2] нет, и записывает его на ARGV[3] мс. Проверка и LPUSH
+// выполняются атомарно. Результат - {1, длина очереди} или {0, значение ключа}.
+var enqueueUniqueScript = redis.NewScript(`
+local existing = redis.call('GET', KEYS[2])
+if existing then
+	return {0, existing}
+end
+redis.call('SET', KEYS[2], ARGV[2], 'PX', ARGV[3])
+return {1, redis.call('LPUSH', KEYS[1], ARGV[1])}
+`)
+
+// EnqueueUnique добавляет value в очередь queueKey, если ключа dedupKey нет, и
+// сохраняет в нём dedupValue на время ttl. Если ключ уже есть, очередь не
+// меняется и возвращается его значение.
+func (c *RedisCache) EnqueueUnique(ctx context.Context, queueKey string, value interface{}, dedupKey, dedupValue string, ttl time.Duration) (enqueued bool, length int64, existing string, err error) {
+	result, err := enqueueUniqueScript.Run(ctx, c.client, []string{queueKey, dedupKey},
+		value, dedupValue, ttlMilliseconds(ttl)).Slice()
+	if err != nil {
+		return false, 0, "", err
+	}
+	if len(result) != 2 {
+		return false, 0, "", fmt.Errorf("неожиданный ответ скрипта: %v", result)
+	}
+	if flag, _ := result[0].(int64); flag == 1 {
+		length, _ = result[1].(int64)
+		return true, length, "", nil
+	}
+	existing, _ = result[1].(string)
+	return false, 0, existing, nil
+}
+
+// ttlMilliseconds переводит время жизни ключа в миллисекунды для PX с округлением
+// вверх: усечение дало бы ключ короче окна, а PX 0 Redis отвергает
+func ttlMilliseconds(ttl time.Duration) int64 {
+	ms := int64((ttl + time.Millisecond - 1) / time.Millisecond)
+	return max(ms, 1)
+}
+
 func (c *RedisCache) Client() *redis.Client {
 	return c.client
 }
@@ -40,3 +133,22 @@ func (c *RedisCache) Client() *redis.Client {
 func (c *RedisCache) Set(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
 	return c.client.Set(ctx, key, value, expiration).Err()
 }
+
+// Entry - значение для записи в кэш вместе с ключом и временем жизни
+type Entry struct {
+	Key   string
+	Value interface{}
+	TTL   time.Duration
+}
+
+// SetMany записывает значения одним конвейером (pipeline): все SET уходят
+// в Redis за один обмен вместо отдельного на каждый ключ
+func (c *RedisCache) SetMany(ctx context.Context, entries []Entry) error {
+	_, err := c.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, entry := range entries {
+			pipe.Set(ctx, entry.Key, entry.Value, entry.TTL)
+		}
+		return nil
+	})
+	return err
+}
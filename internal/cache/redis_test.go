@@ -2,6 +2,9 @@ package cache
 
 import (
 	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -12,6 +15,7 @@ import (
 func TestAll(t *testing.T) {
 	t.Run("RedisCache", TestRedisCache)
 	t.Run("NewRedisCache", TestNewRedisCache)
+	t.Run("TTLMilliseconds", TestTTLMilliseconds)
 }
 
 func TestRedisCache(t *testing.T) {
@@ -93,6 +97,158 @@ func TestRedisCache(t *testing.T) {
 		assert.Equal(t, redis.Nil, result.Err())
 	})
 
+	t.Run("Sorted set ZAdd/ZRangeByScore/ZRem", func(t *testing.T) {
+		key := "test_zset"
+		cache.Delete(ctx, key)
+		defer cache.Delete(ctx, key)
+
+		assert.NoError(t, cache.ZAdd(ctx, key, 10, "early"))
+		assert.NoError(t, cache.ZAdd(ctx, key, 20, "late"))
+
+		due, err := cache.ZRangeByScore(ctx, key, 15, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"early"}, due)
+
+		removed, err := cache.ZRem(ctx, key, "early")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), removed)
+
+		removed, err = cache.ZRem(ctx, key, "early")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(0), removed)
+	})
+
+	t.Run("Список LRange/LLen", func(t *testing.T) {
+		key := "test_range_list"
+		cache.Delete(ctx, key)
+		defer cache.Delete(ctx, key)
+
+		assert.NoError(t, cache.LPush(ctx, key, "a", "b", "c").Err())
+
+		length, err := cache.LLen(ctx, key)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(3), length)
+
+		items, err := cache.LRange(ctx, key, 1, 5)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"b", "a"}, items)
+	})
+
+	t.Run("SetNX", func(t *testing.T) {
+		key := "test_setnx_key"
+		cache.Delete(ctx, key)
+		defer cache.Delete(ctx, key)
+
+		set, err := cache.SetNX(ctx, key, "first", time.Minute)
+		assert.NoError(t, err)
+		assert.True(t, set)
+
+		set, err = cache.SetNX(ctx, key, "second", time.Minute)
+		assert.NoError(t, err)
+		assert.False(t, set)
+
+		value, err := cache.Get(ctx, key)
+		assert.NoError(t, err)
+		assert.Equal(t, "first", value)
+	})
+
+	t.Run("SetMany", func(t *testing.T) {
+		keys := []string{"test_setmany_1", "test_setmany_2"}
+		for _, key := range keys {
+			defer cache.Delete(ctx, key)
+		}
+
+		err := cache.SetMany(ctx, []Entry{
+			{Key: keys[0], Value: "one", TTL: time.Minute},
+			{Key: keys[1], Value: "two", TTL: time.Minute},
+		})
+		assert.NoError(t, err)
+
+		value, err := cache.Get(ctx, keys[1])
+		assert.NoError(t, err)
+		assert.Equal(t, "two", value)
+	})
+
+	t.Run("MoveToQueue", func(t *testing.T) {
+		setKey := "test_move_to_queue_set"
+		queueKey := "test_move_to_queue_list"
+		cache.Delete(ctx, setKey)
+		cache.Delete(ctx, queueKey)
+		defer cache.Delete(ctx, setKey)
+		defer cache.Delete(ctx, queueKey)
+
+		assert.NoError(t, cache.ZAdd(ctx, setKey, 1, "op"))
+
+		// Одновременный перенос: элемент попадает в очередь один раз
+		const callers = 10
+		var wg sync.WaitGroup
+		var movedCount atomic.Int32
+		for range callers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				moved, err := cache.MoveToQueue(ctx, setKey, "op", queueKey)
+				assert.NoError(t, err)
+				if moved {
+					movedCount.Add(1)
+				}
+			}()
+		}
+		wg.Wait()
+
+		assert.Equal(t, int32(1), movedCount.Load())
+		items, err := cache.LRange(ctx, queueKey, 0, -1)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"op"}, items)
+		left, err := cache.ZRangeByScore(ctx, setKey, 10, 10)
+		assert.NoError(t, err)
+		assert.Empty(t, left)
+	})
+
+	t.Run("EnqueueUnique", func(t *testing.T) {
+		queueKey := "test_enqueue_unique_queue"
+		dedupKey := "test_enqueue_unique_dedup"
+		cache.Delete(ctx, queueKey)
+		cache.Delete(ctx, dedupKey)
+		defer cache.Delete(ctx, queueKey)
+		defer cache.Delete(ctx, dedupKey)
+
+		// Одинаковые операции одновременно: в очередь попадает одна
+		const callers = 20
+		var wg sync.WaitGroup
+		var enqueuedCount atomic.Int32
+		for i := range callers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				id := fmt.Sprintf("op-%d", i)
+				enqueued, _, existing, err := cache.EnqueueUnique(ctx, queueKey, id, dedupKey, id, time.Minute)
+				assert.NoError(t, err)
+				if enqueued {
+					enqueuedCount.Add(1)
+				} else {
+					assert.NotEmpty(t, existing)
+				}
+			}()
+		}
+		wg.Wait()
+
+		assert.Equal(t, int32(1), enqueuedCount.Load())
+		items, err := cache.LRange(ctx, queueKey, 0, -1)
+		assert.NoError(t, err)
+		assert.Len(t, items, 1)
+
+		// Повтор возвращает id первой операции
+		enqueued, _, existing, err := cache.EnqueueUnique(ctx, queueKey, "op-late", dedupKey, "op-late", time.Minute)
+		assert.NoError(t, err)
+		assert.False(t, enqueued)
+		assert.Equal(t, items[0], existing)
+
+		ttl, err := cache.client.PTTL(ctx, dedupKey).Result()
+		assert.NoError(t, err)
+		assert.True(t, ttl > 0 && ttl <= time.Minute)
+	})
+
 	t.Run("Delete несуществующий ключ", func(t *testing.T) {
 		// Проверяем удаление несуществующего ключа
 		err := cache.Delete(ctx, "non_existent_key")
@@ -108,3 +264,11 @@ func TestNewRedisCache(t *testing.T) {
 	assert.NotNil(t, cache)
 	assert.NotNil(t, cache.client)
 }
+
+// Время жизни ключа для PX округляется вверх и не бывает нулевым
+func TestTTLMilliseconds(t *testing.T) {
+	assert.Equal(t, int64(60000), ttlMilliseconds(time.Minute))
+	assert.Equal(t, int64(2), ttlMilliseconds(1500*time.Microsecond))
+	assert.Equal(t, int64(1), ttlMilliseconds(time.Microsecond))
+	assert.Equal(t, int64(1), ttlMilliseconds(0))
+}
@@ -0,0 +1,107 @@
+package currency
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrUnknownCurrency = errors.New("неизвестная валюта")
+	ErrInvalidRates    = errors.New("некорректный формат курсов валют")
+)
+
+// RateProvider возвращает курс перевода базовой валюты кошелька в указанную валюту
+type RateProvider interface {
+	Rate(ctx context.Context, currency string) (float64, error)
+}
+
+// StaticRateProvider отдаёт фиксированные курсы из конфигурации
+type StaticRateProvider struct {
+	rates map[string]float64
+}
+
+func NewStaticRateProvider(rates map[string]float64) *StaticRateProvider {
+	normalized := make(map[string]float64, len(rates))
+	for code, rate := range rates {
+		normalized[strings.ToUpper(code)] = rate
+	}
+	return &StaticRateProvider{rates: normalized}
+}
+
+func (p *StaticRateProvider) Rate(_ context.Context, currency string) (float64, error) {
+	rate, ok := p.rates[strings.ToUpper(currency)]
+	if !ok {
+		return 0, fmt.Errorf("%w: %s", ErrUnknownCurrency, currency)
+	}
+	return rate, nil
+}
+
+// ParseRates разбирает курсы в формате "EUR:0.0108,GBP:0.0092"
+func ParseRates(raw string) (map[string]float64, error) {
+	rates := make(map[string]float64)
+	if strings.TrimSpace(raw) == "" {
+		return rates, nil
+	}
+
+	for _, pair := range strings.Split(raw, ",") {
+		code, value, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		if !ok || code == "" {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidRates, pair)
+		}
+		rate, err := strconv.ParseFloat(value, 64)
+		if err != nil || rate <= 0 {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidRates, pair)
+		}
+		rates[strings.ToUpper(code)] = rate
+	}
+	return rates, nil
+}
+
+// HTTPRateProvider - заготовка провайдера, запрашивающего курс у внешнего сервиса:
+// GET <URL>?currency=EUR -> {"rate": 0.0108}
+type HTTPRateProvider struct {
+	URL    string
+	Client *http.Client
+}
+
+func NewHTTPRateProvider(baseURL string) *HTTPRateProvider {
+	return &HTTPRateProvider{
+		URL:    baseURL,
+		Client: &http.Client{Timeout: 2 * time.Second},
+	}
+}
+
+func (p *HTTPRateProvider) Rate(ctx context.Context, currency string) (float64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.URL+"?currency="+url.QueryEscape(currency), nil)
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return 0, fmt.Errorf("%w: %s", ErrUnknownCurrency, currency)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("сервис курсов вернул статус %d", resp.StatusCode)
+	}
+
+	var body struct {
+		Rate float64 `json:"rate"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return 0, err
+	}
+	return body.Rate, nil
+}
@@ -0,0 +1,77 @@
+package currency
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAll(t *testing.T) {
+	t.Run("ParseRates", TestParseRates)
+	t.Run("StaticRateProvider", TestStaticRateProvider)
+	t.Run("HTTPRateProvider", TestHTTPRateProvider)
+	t.Run("Lookup", TestLookup)
+}
+
+func TestParseRates(t *testing.T) {
+	rates, err := ParseRates("eur:0.5, GBP:0.25")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]float64{"EUR": 0.5, "GBP": 0.25}, rates)
+
+	rates, err = ParseRates("")
+	assert.NoError(t, err)
+	assert.Empty(t, rates)
+
+	_, err = ParseRates("EUR=0.5")
+	assert.ErrorIs(t, err, ErrInvalidRates)
+
+	_, err = ParseRates("EUR:-1")
+	assert.ErrorIs(t, err, ErrInvalidRates)
+}
+
+func TestStaticRateProvider(t *testing.T) {
+	provider := NewStaticRateProvider(map[string]float64{"eur": 0.5})
+
+	rate, err := provider.Rate(context.Background(), "EUR")
+	assert.NoError(t, err)
+	assert.Equal(t, 0.5, rate)
+
+	_, err = provider.Rate(context.Background(), "JPY")
+	assert.ErrorIs(t, err, ErrUnknownCurrency)
+}
+
+func TestHTTPRateProvider(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("currency") != "EUR" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"rate": 0.5}`))
+	}))
+	defer ts.Close()
+
+	provider := NewHTTPRateProvider(ts.URL)
+
+	rate, err := provider.Rate(context.Background(), "EUR")
+	assert.NoError(t, err)
+	assert.Equal(t, 0.5, rate)
+
+	_, err = provider.Rate(context.Background(), "JPY")
+	assert.ErrorIs(t, err, ErrUnknownCurrency)
+}
+
+func TestLookup(t *testing.T) {
+	info, err := Lookup("jpy")
+	assert.NoError(t, err)
+	assert.Equal(t, Info{Code: "JPY", Scale: 0, Unit: "¥"}, info)
+
+	info, err = Lookup("USD")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, info.Scale)
+
+	_, err = Lookup("XXX")
+	assert.ErrorIs(t, err, ErrUnknownCurrency)
+}
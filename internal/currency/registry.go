@@ -0,0 +1,38 @@
+package currency
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Info - правила отображения сумм в валюте: код ISO 4217, число знаков
+// после десятичной точки и обозначение для интерфейса
+type Info struct {
+	Code  string
+	Scale int
+	Unit  string
+}
+
+// registry - известные валюты. Число знаков взято из ISO 4217.
+var registry = map[string]Info{
+	"RUB": {Code: "RUB", Scale: 2, Unit: "₽"},
+	"USD": {Code: "USD", Scale: 2, Unit: "$"},
+	"EUR": {Code: "EUR", Scale: 2, Unit: "€"},
+	"GBP": {Code: "GBP", Scale: 2, Unit: "£"},
+	"CHF": {Code: "CHF", Scale: 2, Unit: "CHF"},
+	"CNY": {Code: "CNY", Scale: 2, Unit: "¥"},
+	"KZT": {Code: "KZT", Scale: 2, Unit: "₸"},
+	"JPY": {Code: "JPY", Scale: 0, Unit: "¥"},
+	"KRW": {Code: "KRW", Scale: 0, Unit: "₩"},
+	"KWD": {Code: "KWD", Scale: 3, Unit: "KD"},
+	"BHD": {Code: "BHD", Scale: 3, Unit: "BD"},
+}
+
+// Lookup возвращает правила отображения валюты по коду без учёта регистра
+func Lookup(code string) (Info, error) {
+	info, ok := registry[strings.ToUpper(code)]
+	if !ok {
+		return Info{}, fmt.Errorf("%w: %s", ErrUnknownCurrency, code)
+	}
+	return info, nil
+}
@@ -0,0 +1,39 @@
+package db
+
+import (
+	"database/sql/driver"
+	"errors"
+	"io"
+	"log"
+	"net"
+)
+
+// Сколько раз запрос повторяется на другом соединении, если соединение из пула
+// оказалось разорванным сервером
+const badConnRetries = 2
+
+// isBadConn сообщает, что запрос не выполнился из-за разорванного соединения.
+// database/sql сам повторяет только driver.ErrBadConn, а lib/pq для соединения,
+// закрытого сервером во время простоя, возвращает сетевую ошибку и лишь помечает
+// соединение плохим - пул заменит его при следующем использовании.
+func isBadConn(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr)
+}
+
+// retryBadConn повторяет op, пока она падает на разорванном соединении.
+// Подходит только для операций без побочных эффектов: чтения, пинга и начала транзакции.
+func retryBadConn(op func() error) error {
+	err := op()
+	for i := 0; i < badConnRetries && isBadConn(err); i++ {
+		log.Printf("Соединение из пула разорвано: %v, повтор на другом соединении", err)
+		err = op()
+	}
+	return err
+}
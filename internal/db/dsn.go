@@ -0,0 +1,40 @@
+package db
+
+import (
+	"net"
+	"net/url"
+)
+
+// ConnConfig - параметры подключения к PostgreSQL
+type ConnConfig struct {
+	Host     string
+	Port     string
+	User     string
+	Password string
+	Name     string
+	// Режим TLS; пустой - disable
+	SSLMode string
+}
+
+// URL собирает строку подключения postgres://. Логин, пароль и имя БД
+// экранируются, поэтому пароль может содержать '@', ':', '/' и другие
+// зарезервированные символы.
+func (c ConnConfig) URL() string {
+	host := c.Host
+	if c.Port != "" {
+		host = net.JoinHostPort(c.Host, c.Port)
+	}
+	sslMode := c.SSLMode
+	if sslMode == "" {
+		sslMode = "disable"
+	}
+
+	u := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(c.User, c.Password),
+		Host:     host,
+		Path:     "/" + c.Name,
+		RawQuery: url.Values{"sslmode": {sslMode}}.Encode(),
+	}
+	return u.String()
+}
@@ -3,6 +3,11 @@ package db
 import (
 	"context"
 	"database/sql"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	_ "github.com/lib/pq"
@@ -14,7 +19,42 @@ type DBAdapter struct {
 	*sql.DB
 }
 
+// RetryConfig задаёт повторные попытки подключения при старте.
+// Задержка удваивается после каждой неудачной попытки.
+type RetryConfig struct {
+	Attempts int
+	Delay    time.Duration
+}
+
+// SessionConfig задаёт параметры сессии Postgres для каждого соединения пула.
+// Таймауты не дают зависшему запросу бесконечно удерживать блокировку FOR UPDATE;
+// 0 оставляет значение сервера.
+type SessionConfig struct {
+	StatementTimeout time.Duration
+	LockTimeout      time.Duration
+}
+
+func DefaultSessionConfig() SessionConfig {
+	return SessionConfig{
+		StatementTimeout: 30 * time.Second,
+		LockTimeout:      10 * time.Second,
+	}
+}
+
 func NewPostgresConnection(connStr string) (*DBAdapter, error) {
+	return NewPostgresConnectionWithRetry(connStr, RetryConfig{Attempts: 1})
+}
+
+func NewPostgresConnectionWithRetry(connStr string, cfg RetryConfig) (*DBAdapter, error) {
+	return NewPostgresConnectionWithConfig(connStr, cfg, DefaultSessionConfig())
+}
+
+func NewPostgresConnectionWithConfig(connStr string, cfg RetryConfig, session SessionConfig) (*DBAdapter, error) {
+	connStr, err := withSessionParams(connStr, session)
+	if err != nil {
+		return nil, err
+	}
+
 	db, err := sql.Open("postgres", connStr)
 	if err != nil {
 		return nil, err
@@ -25,13 +65,71 @@ func NewPostgresConnection(connStr string) (*DBAdapter, error) {
 	db.SetConnMaxLifetime(time.Minute * 3)
 	db.SetConnMaxIdleTime(time.Minute * 1)
 
-	if err = db.Ping(); err != nil {
+	if err = WithRetry(cfg, db.Ping); err != nil {
+		db.Close()
 		return nil, err
 	}
 
 	return &DBAdapter{db}, nil
 }
 
+// withSessionParams добавляет параметры сессии в строку подключения. lib/pq
+// передаёт незнакомые ему параметры серверу при открытии каждого соединения.
+// Поддерживаются оба формата: URL (postgres://...) и "key=value".
+func withSessionParams(connStr string, session SessionConfig) (string, error) {
+	params := make([][2]string, 0, 2)
+	if session.StatementTimeout > 0 {
+		params = append(params, [2]string{"statement_timeout", strconv.FormatInt(session.StatementTimeout.Milliseconds(), 10)})
+	}
+	if session.LockTimeout > 0 {
+		params = append(params, [2]string{"lock_timeout", strconv.FormatInt(session.LockTimeout.Milliseconds(), 10)})
+	}
+	if len(params) == 0 {
+		return connStr, nil
+	}
+
+	if strings.HasPrefix(connStr, "postgres://") || strings.HasPrefix(connStr, "postgresql://") {
+		u, err := url.Parse(connStr)
+		if err != nil {
+			return "", err
+		}
+		query := u.Query()
+		for _, param := range params {
+			query.Set(param[0], param[1])
+		}
+		u.RawQuery = query.Encode()
+		return u.String(), nil
+	}
+
+	for _, param := range params {
+		connStr += fmt.Sprintf(" %s=%s", param[0], param[1])
+	}
+	return strings.TrimSpace(connStr), nil
+}
+
+// WithRetry выполняет op, пока она не завершится успешно или не закончатся попытки
+func WithRetry(cfg RetryConfig, op func() error) error {
+	attempts := cfg.Attempts
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	delay := cfg.Delay
+	var err error
+	for i := 1; i <= attempts; i++ {
+		if err = op(); err == nil {
+			return nil
+		}
+		if i == attempts {
+			break
+		}
+		log.Printf("Попытка подключения %d/%d не удалась: %v, повтор через %s", i, attempts, err, delay)
+		time.Sleep(delay)
+		delay *= 2
+	}
+	return err
+}
+
 type TxAdapter struct {
 	*sql.Tx
 }
@@ -41,19 +139,46 @@ func (tx *TxAdapter) ExecContext(ctx context.Context, query string, args ...inte
 }
 
 func (d *DBAdapter) BeginTx(ctx context.Context) (handler.TxInterface, error) {
-	tx, err := d.DB.BeginTx(ctx, nil)
+	var tx *sql.Tx
+	err := retryBadConn(func() (err error) {
+		tx, err = d.DB.BeginTx(ctx, nil)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
 	return &TxAdapter{tx}, nil
 }
 
+func (a *DBAdapter) PingContext(ctx context.Context) error {
+	return retryBadConn(func() error {
+		return a.DB.PingContext(ctx)
+	})
+}
+
 type RowWrapper struct {
 	*sql.Row
 }
 
 func (a *DBAdapter) QueryRowContext(ctx context.Context, query string, args ...interface{}) handler.RowScanner {
-	return &RowWrapper{a.DB.QueryRowContext(ctx, query, args...)}
+	var row *sql.Row
+	retryBadConn(func() error {
+		row = a.DB.QueryRowContext(ctx, query, args...)
+		return row.Err()
+	})
+	return &RowWrapper{row}
+}
+
+func (a *DBAdapter) QueryContext(ctx context.Context, query string, args ...interface{}) (handler.RowsInterface, error) {
+	var rows *sql.Rows
+	err := retryBadConn(func() (err error) {
+		rows, err = a.DB.QueryContext(ctx, query, args...)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Rows{Rows: rows}, nil
 }
 
 func (tx *TxAdapter) QueryRowContext(ctx context.Context, query string, args ...interface{}) handler.RowInterface {
@@ -0,0 +1,60 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+
+	wallet "wallet/internal/model"
+)
+
+// Rows - результат запроса с чтением строки сразу в структуру
+type Rows struct {
+	*sql.Rows
+	columns []string
+}
+
+// transactionField возвращает указатель на поле операции для колонки с этим именем
+func transactionField(t *wallet.Transaction, column string) (interface{}, bool) {
+	switch column {
+	case "id":
+		return &t.ID, true
+	case "wallet_id":
+		return &t.WalletID, true
+	case "amount":
+		return &t.Amount, true
+	case "operation_type":
+		return &t.OperationType, true
+	case "reference":
+		return &t.Reference, true
+	case "created_at":
+		return &t.CreatedAt, true
+	case "voided_at":
+		return &t.VoidedAt, true
+	case "void_of":
+		return &t.VoidOf, true
+	}
+	return nil, false
+}
+
+// ScanTransaction читает текущую строку в t, сопоставляя колонки полям по имени,
+// поэтому порядок колонок в SELECT не важен. Колонка, которой нет в Transaction, -
+// ошибка: значение не должно теряться молча.
+func (r *Rows) ScanTransaction(t *wallet.Transaction) error {
+	if r.columns == nil {
+		columns, err := r.Columns()
+		if err != nil {
+			return err
+		}
+		r.columns = columns
+	}
+
+	dest := make([]interface{}, len(r.columns))
+	for i, column := range r.columns {
+		field, ok := transactionField(t, column)
+		if !ok {
+			return fmt.Errorf("колонка %q не соответствует полю операции", column)
+		}
+		dest[i] = field
+	}
+	return r.Scan(dest...)
+}
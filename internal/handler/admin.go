@@ -0,0 +1,71 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// isAdmin проверяет токен администратора из заголовка Authorization: Bearer <token>.
+// Если токен не задан в конфигурации, административные эндпоинты недоступны.
+func (h *WalletHandler) isAdmin(r *http.Request) bool {
+	if h.config.AdminToken == "" {
+		return false
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(h.config.AdminToken)) == 1
+}
+
+// Заголовок с ключом доверенного внутреннего вызова
+const trustedCallerHeader = "X-Trusted-Caller-Key"
+
+// isTrustedCaller проверяет ключ доверенного вызова; без TrustedCallerKey доверенных вызовов нет
+func (h *WalletHandler) isTrustedCaller(r *http.Request) bool {
+	if h.config.TrustedCallerKey == "" {
+		return false
+	}
+	key := r.Header.Get(trustedCallerHeader)
+	return subtle.ConstantTimeCompare([]byte(key), []byte(h.config.TrustedCallerKey)) == 1
+}
+
+// HandleWalletBlock блокирует (POST) или разблокирует (DELETE) кошелек:
+// /api/v1/admin/wallets/{uuid}/block
+func (h *WalletHandler) HandleWalletBlock(w http.ResponseWriter, r *http.Request) {
+	if !h.isAdmin(r) {
+		h.writeError(w, r, ErrForbidden, http.StatusForbidden)
+		return
+	}
+
+	rawID := strings.TrimPrefix(r.URL.Path, "/api/v1/admin/wallets/")
+	rawID = strings.TrimSuffix(rawID, "/block")
+	var walletID uuid.UUID
+	if !h.validate(w, r, h.pathID(rawID, &walletID, ErrInvalidUUID)) {
+		return
+	}
+
+	var action string
+	var err error
+	switch r.Method {
+	case http.MethodPost:
+		action = AuditActionBlock
+		err = h.blockWallet(r.Context(), walletID.String())
+	case http.MethodDelete:
+		action = AuditActionUnblock
+		err = h.unblockWallet(r.Context(), walletID.String())
+	default:
+		h.writeError(w, r, ErrMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+	h.recordAudit(h.requestAuditEntry(r, action, walletID.String(), err))
+	if err != nil {
+		h.writeError(w, r, ErrBlocklistUpdate, http.StatusServiceUnavailable)
+		return
+	}
+
+	h.sendData(w, r, map[string]interface{}{
+		"wallet_id": walletID,
+		"blocked":   r.Method == http.MethodPost,
+	})
+}
@@ -0,0 +1,108 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	wallet "wallet/internal/model"
+)
+
+// Размер страницы по умолчанию при просмотре очередей; наибольший ограничен MaxListSize
+const defaultPeekCount = 20
+
+// QueuePage - страница элементов очереди; элементы идут от последних добавленных
+type QueuePage struct {
+	Items  []json.RawMessage `json:"items"`
+	Offset int64             `json:"offset"`
+	Count  int64             `json:"count"`
+	Total  int64             `json:"total"`
+}
+
+// HandleQueuePeek показывает операции, ожидающие обработки, в очереди
+// с приоритетом priority (по умолчанию normal):
+// GET /api/v1/admin/queue?priority=high&offset=0&count=20
+func (h *WalletHandler) HandleQueuePeek(w http.ResponseWriter, r *http.Request) {
+	priority := wallet.Priority(r.URL.Query().Get("priority"))
+	if h.isAdmin(r) && !priority.Valid() {
+		h.writeError(w, r, ErrInvalidPriority, http.StatusUnprocessableEntity)
+		return
+	}
+	h.peekList(w, r, operationsQueue(priority))
+}
+
+// HandleDeadLetterPeek показывает операции, исчерпавшие попытки повтора:
+// GET /api/v1/admin/dlq?offset=0&count=20
+func (h *WalletHandler) HandleDeadLetterPeek(w http.ResponseWriter, r *http.Request) {
+	h.peekList(w, r, deadLetterQueueKey)
+}
+
+// peekList отдаёт страницу списка Redis, не изменяя его
+func (h *WalletHandler) peekList(w http.ResponseWriter, r *http.Request, key string) {
+	if !h.isAdmin(r) {
+		h.writeError(w, r, ErrForbidden, http.StatusForbidden)
+		return
+	}
+	if r.Method != http.MethodGet {
+		h.writeError(w, r, ErrMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+
+	var offset, count int64
+	if !h.validate(w, r, peekRange(r, &offset, &count)) {
+		return
+	}
+	size := int(min(count, int64(h.maxListSize())+1))
+	if !h.capListSize(w, r, &size) {
+		return
+	}
+	count = int64(size)
+
+	total, err := h.cache.LLen(r.Context(), key)
+	if err != nil {
+		h.writeError(w, r, ErrQueueRead, http.StatusServiceUnavailable)
+		return
+	}
+
+	page := QueuePage{Items: make([]json.RawMessage, 0), Offset: offset, Count: count, Total: total}
+	if offset < total {
+		items, err := h.cache.LRange(r.Context(), key, offset, offset+count-1)
+		if err != nil {
+			h.writeError(w, r, ErrQueueRead, http.StatusServiceUnavailable)
+			return
+		}
+		for _, item := range items {
+			// Повреждённый элемент отдаётся строкой, чтобы не ломать ответ
+			if !json.Valid([]byte(item)) {
+				quoted, _ := json.Marshal(item)
+				item = string(quoted)
+			}
+			page.Items = append(page.Items, json.RawMessage(item))
+		}
+	}
+
+	if err := h.sendData(w, r, page); err != nil {
+		h.writeError(w, r, ErrSendResponse, http.StatusServiceUnavailable)
+	}
+}
+
+// parsePeekRange разбирает offset (>= 0) и count (>= 1)
+func parsePeekRange(r *http.Request) (int64, int64, bool) {
+	offset, count := int64(0), int64(defaultPeekCount)
+
+	if raw := r.URL.Query().Get("offset"); raw != "" {
+		value, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || value < 0 {
+			return 0, 0, false
+		}
+		offset = value
+	}
+	if raw := r.URL.Query().Get("count"); raw != "" {
+		value, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || value < 1 {
+			return 0, 0, false
+		}
+		count = value
+	}
+	return offset, count, true
+}
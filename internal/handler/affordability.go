@@ -0,0 +1,63 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"wallet/internal/service"
+)
+
+// Affordability - результат предварительной проверки списания
+type Affordability struct {
+	Affordable bool    `json:"affordable"`
+	Available  float64 `json:"available"`
+}
+
+// CanWithdraw проверяет, хватит ли средств на списание, не изменяя баланс:
+// GET /api/v1/wallets/{uuid}/can-withdraw?amount=X
+func (h *WalletHandler) CanWithdraw(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.writeError(w, r, ErrMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx, cancel := h.requestContext(r, defaultReadTimeout)
+	defer cancel()
+
+	rawID := strings.TrimPrefix(r.URL.Path, "/api/v1/wallets/")
+	rawID = strings.TrimSuffix(rawID, "/can-withdraw")
+	var walletID uuid.UUID
+	var amount float64
+	if !h.validate(w, r,
+		h.pathID(rawID, &walletID, ErrInvalidUUID),
+		h.amountParam(r.URL.Query().Get("amount"), &amount),
+	) {
+		return
+	}
+
+	if h.config.BlockReads && !h.checkNotBlocked(ctx, w, r, walletID.String()) {
+		return
+	}
+
+	// Баланс читается из БД: кэш может отставать от последних операций
+	balance, err := h.getBalanceFromDB(ctx, walletID)
+	if err != nil {
+		if errors.Is(err, service.ErrWalletNotFound) {
+			h.writeErr(w, r, err)
+			return
+		}
+		h.writeError(w, r, ErrBalanceRetrievalFail, http.StatusServiceUnavailable)
+		return
+	}
+
+	// С закрытого кошелька списать нельзя при любом балансе
+	if err := h.sendData(w, r, Affordability{
+		Affordable: !balance.closed && h.validator.ValidateBalance(balance.amount, amount) == nil,
+		Available:  balance.amount,
+	}); err != nil {
+		h.writeError(w, r, ErrSendResponse, http.StatusServiceUnavailable)
+	}
+}
@@ -0,0 +1,157 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+const (
+	// Снимок баланса на момент отсечения: текущий баланс за вычетом более поздних операций.
+	// Пишется только для кошельков, у которых есть что переносить в архив.
+	archiveSnapshotQuery = `
+		INSERT INTO balance_snapshots (wallet_id, balance, as_of)
+		SELECT w.id, w.balance - COALESCE((
+			SELECT SUM(t.amount) FROM transactions_all t
+			WHERE t.wallet_id = w.id AND t.created_at > $1
+		), 0), $1
+		FROM wallets w
+		WHERE EXISTS (SELECT 1 FROM transactions t WHERE t.wallet_id = w.id AND t.created_at <= $1)
+		ON CONFLICT (wallet_id, as_of) DO NOTHING`
+	archiveSnapshotLedgerQuery = `
+		INSERT INTO balance_snapshots (wallet_id, balance, as_of)
+		SELECT b.wallet_id, b.balance - COALESCE((
+			SELECT SUM(t.amount) FROM transactions_all t
+			WHERE t.wallet_id = b.wallet_id AND t.created_at > $1
+		), 0), $1
+		FROM wallet_ledger_balances b
+		WHERE EXISTS (SELECT 1 FROM transactions t WHERE t.wallet_id = b.wallet_id AND t.created_at <= $1)
+		ON CONFLICT (wallet_id, as_of) DO NOTHING`
+
+	// Перенос пакета операций старше отсечения. Отменённая операция остаётся, пока
+	// в transactions есть её компенсация: на неё ссылается внешний ключ void_of.
+	// Компенсация переносится раньше, и операция уходит в одном из следующих пакетов.
+	// Сумма перенесённых операций добавляется в ledger_rollups тем же запросом,
+	// поэтому баланс по журналу не меняется ни в какой момент переноса.
+	archiveTransactionsQuery = `
+		WITH moved AS (
+			DELETE FROM transactions
+			WHERE id IN (
+				SELECT t.id FROM transactions t
+				WHERE t.created_at <= $1
+				  AND NOT EXISTS (SELECT 1 FROM transactions v WHERE v.void_of = t.id)
+				LIMIT $2
+			)
+			RETURNING *
+		), archived AS (
+			INSERT INTO transactions_archive SELECT * FROM moved
+		), rolled AS (
+			INSERT INTO ledger_rollups (wallet_id, balance)
+			SELECT wallet_id, SUM(amount) FROM moved GROUP BY wallet_id
+			ON CONFLICT (wallet_id) DO UPDATE SET balance = ledger_rollups.balance + EXCLUDED.balance
+		)
+		SELECT COUNT(*) FROM moved`
+)
+
+// Размер пакета архивации, если ArchiveBatchSize не задан
+const defaultArchiveBatchSize = 1000
+
+// RunArchival периодически переносит операции старше HistoryRetention в архив
+func (h *WalletHandler) RunArchival(ctx context.Context) {
+	if h.config.HistoryRetention <= 0 || h.config.ArchiveInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(h.config.ArchiveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// Во время обслуживания фоновые записи в БД не выполняются
+			if h.maintenance.Load() {
+				continue
+			}
+			archived, err := h.archiveTransactions(ctx, h.clock.Now().Add(-h.config.HistoryRetention))
+			if err != nil {
+				log.Printf("%s: %v", ErrHistoryArchive, err)
+				continue
+			}
+			if archived > 0 {
+				log.Printf("Перенесено в архив операций: %d", archived)
+			}
+		}
+	}
+}
+
+// archiveTransactions переносит операции не новее cutoff в transactions_archive и
+// сохраняет снимок баланса на cutoff. Баланс на момент времени и история с
+// ?archived=true читают transactions_all, поэтому после переноса не меняются.
+//
+// Операции переносятся пакетами по ArchiveBatchSize, каждый в своей транзакции:
+// перенос накопившейся истории одним запросом упирался бы в statement_timeout
+// и всё это время держал бы блокировки строк transactions. Перенос идёт, пока
+// очередной пакет не окажется пустым; при включении режима обслуживания он
+// прерывается, уже перенесённые пакеты остаются в архиве.
+func (h *WalletHandler) archiveTransactions(ctx context.Context, cutoff time.Time) (int64, error) {
+	if err := h.archiveSnapshot(ctx, cutoff); err != nil {
+		return 0, err
+	}
+
+	batchSize := h.config.ArchiveBatchSize
+	if batchSize <= 0 {
+		batchSize = defaultArchiveBatchSize
+	}
+
+	var archived int64
+	for !h.maintenance.Load() {
+		moved, err := h.archiveBatch(ctx, cutoff, batchSize)
+		if err != nil {
+			return archived, err
+		}
+		if moved == 0 {
+			break
+		}
+		archived += moved
+	}
+	return archived, nil
+}
+
+// archiveSnapshot сохраняет снимок баланса на cutoff. Снимок считается по
+// transactions_all, поэтому не зависит от того, сколько пакетов уже перенесено.
+func (h *WalletHandler) archiveSnapshot(ctx context.Context, cutoff time.Time) error {
+	tx, err := h.beginTx(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, h.byBalanceMode(archiveSnapshotQuery, archiveSnapshotLedgerQuery), cutoff); err != nil {
+		return fmt.Errorf("%s: %w", ErrHistoryArchive, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", ErrHistoryArchive, err)
+	}
+	return nil
+}
+
+// archiveBatch переносит в архив не больше batchSize операций
+func (h *WalletHandler) archiveBatch(ctx context.Context, cutoff time.Time, batchSize int) (int64, error) {
+	tx, err := h.beginTx(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var moved int64
+	if err := tx.QueryRowContext(ctx, archiveTransactionsQuery, cutoff, batchSize).Scan(&moved); err != nil {
+		return 0, fmt.Errorf("%s: %w", ErrHistoryArchive, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("%s: %w", ErrHistoryArchive, err)
+	}
+	return moved, nil
+}
@@ -0,0 +1,147 @@
+package handler
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"wallet/internal/audit"
+	wallet "wallet/internal/model"
+)
+
+const (
+	AuditActionOperation  = "operation"
+	AuditActionBlock      = "wallet.block"
+	AuditActionUnblock    = "wallet.unblock"
+	AuditActionVoid       = "transaction.void"
+	AuditActionReset      = "wallet.reset"
+	AuditActionRebuild    = "wallet.rebuild"
+	AuditActionCacheFlush = "wallet.cache_flush"
+	// Операция доверенного вызова, выполненная без валидации запроса
+	AuditActionTrustedOperation = "operation.trusted"
+
+	// Субъект запросов без токена администратора
+	anonymousSubject = "anonymous"
+	adminSubject     = "admin"
+	trustedSubject   = "trusted"
+
+	// Таймаут записи одной записи аудита
+	auditWriteTimeout = 5 * time.Second
+)
+
+// recordAudit передаёт запись фоновому писателю, не задерживая операцию.
+// Если буфер заполнен, запись теряется - это видно в логе.
+func (h *WalletHandler) recordAudit(entry audit.Entry) {
+	if h.config.AuditSink == nil {
+		return
+	}
+	if entry.Time.IsZero() {
+		entry.Time = h.clock.Now().UTC()
+	}
+
+	select {
+	case h.auditEntries <- entry:
+	default:
+		log.Printf("%s: %s %s", ErrAuditDropped, entry.Action, h.logWalletID(entry.WalletID))
+	}
+}
+
+// RunAuditLog пишет записи аудита в AuditSink. После отмены ctx дописывает
+// уже принятые записи, чтобы они не терялись при остановке, - не дольше
+// ShutdownFlushTimeout.
+func (h *WalletHandler) RunAuditLog(ctx context.Context) {
+	if h.config.AuditSink == nil {
+		return
+	}
+	writeCtx, cancel := h.flushContext(ctx)
+	defer cancel()
+
+	for {
+		select {
+		case entry := <-h.auditEntries:
+			h.writeAudit(writeCtx, entry)
+		case <-ctx.Done():
+			for writeCtx.Err() == nil {
+				select {
+				case entry := <-h.auditEntries:
+					h.writeAudit(writeCtx, entry)
+				default:
+					return
+				}
+			}
+			log.Printf("%s: %d", ErrAuditFlush, len(h.auditEntries))
+			return
+		}
+	}
+}
+
+func (h *WalletHandler) writeAudit(ctx context.Context, entry audit.Entry) {
+	ctx, cancel := context.WithTimeout(ctx, auditWriteTimeout)
+	defer cancel()
+	if err := h.config.AuditSink.Write(ctx, entry); err != nil {
+		log.Printf("%s: %v", ErrAuditWrite, err)
+	}
+}
+
+// operationAuditEntry описывает выполнение операции и её результат
+func operationAuditEntry(req *wallet.WalletRequest, walletErr *WalletError) audit.Entry {
+	entry := audit.Entry{
+		Subject:       req.Subject,
+		Action:        AuditActionOperation,
+		WalletID:      req.WalletID,
+		OperationID:   req.ID,
+		OperationType: string(req.OperationType),
+		Amount:        req.Amount,
+		SourceIP:      req.SourceIP,
+		Result:        audit.ResultSuccess,
+	}
+	if entry.Subject == "" {
+		entry.Subject = anonymousSubject
+	}
+	if req.Trusted {
+		entry.Action = AuditActionTrustedOperation
+	}
+	if walletErr != nil {
+		entry.Result = audit.ResultFailure
+		entry.Error = walletErr.Error()
+	}
+	return entry
+}
+
+// requestAuditEntry описывает действие, выполненное в рамках HTTP-запроса
+func (h *WalletHandler) requestAuditEntry(r *http.Request, action, walletID string, err error) audit.Entry {
+	entry := audit.Entry{
+		Subject:  h.auditSubject(r),
+		Action:   action,
+		WalletID: walletID,
+		SourceIP: sourceIP(r),
+		Result:   audit.ResultSuccess,
+	}
+	if err != nil {
+		entry.Result = audit.ResultFailure
+		entry.Error = err.Error()
+	}
+	return entry
+}
+
+// auditSubject определяет, кто выполняет запрос
+func (h *WalletHandler) auditSubject(r *http.Request) string {
+	if h.isAdmin(r) {
+		return adminSubject
+	}
+	if h.isTrustedCaller(r) {
+		return trustedSubject
+	}
+	return anonymousSubject
+}
+
+// sourceIP возвращает адрес клиента без порта
+func sourceIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,98 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"wallet/internal/service"
+)
+
+const (
+	// Время ожидания долгого опроса по умолчанию и наибольшее допустимое
+	defaultPollTimeout = 30 * time.Second
+	maxPollTimeout     = 60 * time.Second
+	// Запас на чтение баланса и отправку ответа после ожидания
+	pollWriteMargin = 10 * time.Second
+)
+
+// HandleBalancePoll - долгий опрос баланса для клиентов без WebSocket:
+// GET /api/v1/wallets/{uuid}/balance/poll?since_version=N&timeout=30s.
+// Отвечает, как только версия баланса станет больше N, а по истечении
+// timeout - текущим балансом; версия передаётся в заголовке X-Balance-Version.
+func (h *WalletHandler) HandleBalancePoll(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.writeError(w, r, ErrMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+
+	rawID := strings.TrimPrefix(r.URL.Path, "/api/v1/wallets/")
+	rawID = strings.TrimSuffix(rawID, "/balance/poll")
+	var walletID uuid.UUID
+	var since int64
+	timeout := defaultPollTimeout
+	if !h.validate(w, r,
+		h.pathID(rawID, &walletID, ErrInvalidUUID),
+		sinceVersionParam(r, &since),
+		pollTimeoutParam(r, &timeout),
+	) {
+		return
+	}
+
+	if h.config.BlockReads && !h.checkNotBlocked(r.Context(), w, r, walletID.String()) {
+		return
+	}
+
+	// Общий WriteTimeout сервера короче ожидания: срок записи продлевается для этого ответа
+	http.NewResponseController(w).SetWriteDeadline(time.Now().Add(timeout + pollWriteMargin))
+
+	// Подписка оформляется до первого чтения, чтобы не пропустить изменение
+	// между ними; по истечении timeout канал событий закрывается
+	wait, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	events, unsubscribe, err := h.events.Subscribe(wait, walletID.String())
+	if err != nil {
+		h.writeError(w, r, ErrEventSubscribe, http.StatusServiceUnavailable)
+		return
+	}
+	defer unsubscribe()
+
+	balance, err := h.awaitBalanceVersion(r.Context(), walletID, since, events)
+	if err != nil {
+		if errors.Is(err, service.ErrWalletNotFound) {
+			h.writeErr(w, r, err)
+			return
+		}
+		h.writeReadError(r.Context(), w, r, err, ErrBalanceRetrievalFail)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	setBalanceVersion(w, balance.version)
+	if err := h.sendData(w, r, h.balanceResponse(balance)); err != nil {
+		h.writeError(w, r, ErrSendResponse, http.StatusServiceUnavailable)
+	}
+}
+
+// awaitBalanceVersion читает баланс после каждого события баланса, пока его
+// версия не превысит since или не закроется канал events
+func (h *WalletHandler) awaitBalanceVersion(ctx context.Context, walletID uuid.UUID, since int64, events <-chan WalletEvent) (walletBalance, error) {
+	balance, err := h.loadBalance(ctx, walletID)
+	if err != nil || balance.version > since {
+		return balance, err
+	}
+	for event := range events {
+		if event.Type != EventBalance {
+			continue
+		}
+		balance, err = h.loadBalance(ctx, walletID)
+		if err != nil || balance.version > since {
+			break
+		}
+	}
+	return balance, err
+}
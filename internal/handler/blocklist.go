@@ -0,0 +1,37 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Префикс ключей Redis с заблокированными кошельками
+const blockedWalletKeyPrefix = "blocked_wallet:"
+
+func blockedWalletKey(walletID string) string {
+	return blockedWalletKeyPrefix + walletID
+}
+
+// isWalletBlocked проверяет наличие кошелька в списке блокировки.
+// При недоступности Redis возвращается ошибка: операции не пропускаются без проверки.
+func (h *WalletHandler) isWalletBlocked(ctx context.Context, walletID string) (bool, error) {
+	_, err := h.cache.Get(ctx, blockedWalletKey(walletID))
+	if err == nil {
+		return true, nil
+	}
+	if errors.Is(err, redis.Nil) {
+		return false, nil
+	}
+	return false, fmt.Errorf("%s: %w", ErrBlocklistCheck, err)
+}
+
+func (h *WalletHandler) blockWallet(ctx context.Context, walletID string) error {
+	return h.cache.Set(ctx, blockedWalletKey(walletID), "1", 0)
+}
+
+func (h *WalletHandler) unblockWallet(ctx context.Context, walletID string) error {
+	return h.cache.Delete(ctx, blockedWalletKey(walletID))
+}
@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// CacheFlushResult - итог сброса кэша баланса: Existed сообщает, была ли запись
+type CacheFlushResult struct {
+	WalletID uuid.UUID `json:"wallet_id"`
+	Existed  bool      `json:"existed"`
+}
+
+// HandleCacheFlush удаляет закэшированный баланс кошелька:
+// POST /api/v1/admin/wallets/{uuid}/cache/flush. Следующее чтение возьмёт
+// баланс из БД и закэширует его заново.
+func (h *WalletHandler) HandleCacheFlush(w http.ResponseWriter, r *http.Request) {
+	if !h.isAdmin(r) {
+		h.writeError(w, r, ErrForbidden, http.StatusForbidden)
+		return
+	}
+	if r.Method != http.MethodPost {
+		h.writeError(w, r, ErrMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+
+	rawID := strings.TrimPrefix(r.URL.Path, "/api/v1/admin/wallets/")
+	rawID = strings.TrimSuffix(rawID, "/cache/flush")
+	var walletID uuid.UUID
+	if !h.validate(w, r, h.pathID(rawID, &walletID, ErrInvalidUUID)) {
+		return
+	}
+
+	result, err := h.flushBalanceCache(r, walletID)
+	entry := h.requestAuditEntry(r, AuditActionCacheFlush, walletID.String(), err)
+	h.recordAudit(entry)
+	if err != nil {
+		h.writeError(w, r, ErrCacheFlush, http.StatusServiceUnavailable)
+		return
+	}
+
+	if err := h.sendData(w, r, result); err != nil {
+		h.writeError(w, r, ErrSendResponse, http.StatusServiceUnavailable)
+	}
+}
+
+// flushBalanceCache удаляет ключ balance:{uuid}. Кэш не сообщает, был ли
+// удалён ключ, поэтому наличие проверяется чтением перед удалением; запись,
+// сделанная между ними, тоже удаляется, но в ответе не учитывается.
+func (h *WalletHandler) flushBalanceCache(r *http.Request, walletID uuid.UUID) (CacheFlushResult, error) {
+	result := CacheFlushResult{WalletID: walletID}
+	key := fmt.Sprintf("balance:%s", walletID)
+
+	_, err := h.cache.Get(r.Context(), key)
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return result, fmt.Errorf("%s: %w", ErrCacheFlush, err)
+	}
+	result.Existed = err == nil
+
+	if err := h.cache.Delete(r.Context(), key); err != nil {
+		return result, fmt.Errorf("%s: %w", ErrCacheFlush, err)
+	}
+	h.invalidateLocalBalance(walletID.String())
+	return result, nil
+}
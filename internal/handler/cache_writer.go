@@ -0,0 +1,131 @@
+package handler
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"wallet/internal/cache"
+)
+
+const (
+	// Время жизни закэшированного баланса
+	balanceCacheTTL = 30 * time.Second
+	// Таймаут одной фоновой записи в кэш
+	cacheWriteTimeout = time.Second
+)
+
+// cacheWrite - запись в кэш, отложенная до отправки ответа клиенту
+type cacheWrite struct {
+	key   string
+	value interface{}
+	ttl   time.Duration
+}
+
+// enqueueCacheWrite передаёт запись фоновому писателю. Если очередь заполнена,
+// запись пропускается: значение попадёт в кэш при следующем чтении из БД.
+// Фоновые записи всегда истекают: запись без положительного ttl не выполняется,
+// чтобы ключи не копились в Redis бессрочно.
+func (h *WalletHandler) enqueueCacheWrite(key string, value interface{}, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+	select {
+	case h.cacheWrites <- cacheWrite{key: key, value: value, ttl: ttl}:
+	default:
+	}
+}
+
+// RunCacheWriter выполняет отложенные записи в кэш пулом из CacheWriteWorkers
+// обработчиков и возвращается, когда после отмены ctx все они завершатся.
+// При CacheWriteBatchWindow обработчик собирает записи, поступившие за это
+// время после первой, и отправляет их одним конвейером.
+// После отмены ctx обработчики дописывают оставшиеся в очереди записи, но не
+// дольше ShutdownFlushTimeout: тогда незаконченные записи прерываются.
+func (h *WalletHandler) RunCacheWriter(ctx context.Context) {
+	workers := h.config.CacheWriteWorkers
+	if workers <= 0 {
+		workers = 1
+	}
+	writeCtx, cancel := h.flushContext(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					h.flushCacheWrites(writeCtx)
+					return
+				case write := <-h.cacheWrites:
+					h.writeCache(writeCtx, h.collectCacheWrites(ctx, write))
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// cacheWriteBatchSize - наибольшее число записей в одном конвейере; без
+// CacheWriteBatchWindow записи не объединяются
+func (h *WalletHandler) cacheWriteBatchSize() int {
+	if h.config.CacheWriteBatchWindow <= 0 || h.config.CacheWriteBatchSize <= 1 {
+		return 1
+	}
+	return h.config.CacheWriteBatchSize
+}
+
+// collectCacheWrites дополняет first записями, поступившими в очередь
+// за CacheWriteBatchWindow, пока пакет не заполнится
+func (h *WalletHandler) collectCacheWrites(ctx context.Context, first cacheWrite) []cacheWrite {
+	size := h.cacheWriteBatchSize()
+	batch := []cacheWrite{first}
+	if size == 1 {
+		return batch
+	}
+
+	timer := time.NewTimer(h.config.CacheWriteBatchWindow)
+	defer timer.Stop()
+	for len(batch) < size {
+		select {
+		case write := <-h.cacheWrites:
+			batch = append(batch, write)
+		case <-timer.C:
+			return batch
+		case <-ctx.Done():
+			return batch
+		}
+	}
+	return batch
+}
+
+// flushCacheWrites дописывает записи, оставшиеся в очереди, пока не истечёт ctx
+func (h *WalletHandler) flushCacheWrites(ctx context.Context) {
+	for ctx.Err() == nil {
+		select {
+		case write := <-h.cacheWrites:
+			h.writeCache(ctx, h.collectCacheWrites(ctx, write))
+		default:
+			return
+		}
+	}
+}
+
+// writeCache выполняет пакет записей: одиночную - обычным Set, несколько - через SetMany
+func (h *WalletHandler) writeCache(ctx context.Context, batch []cacheWrite) {
+	ctx, cancel := context.WithTimeout(ctx, cacheWriteTimeout)
+	defer cancel()
+
+	if len(batch) == 1 {
+		h.cache.Set(ctx, batch[0].key, batch[0].value, batch[0].ttl)
+		return
+	}
+	entries := make([]cache.Entry, 0, len(batch))
+	for _, write := range batch {
+		entries = append(entries, cache.Entry{Key: write.key, Value: write.value, TTL: write.ttl})
+	}
+	h.cache.SetMany(ctx, entries)
+}
@@ -0,0 +1,17 @@
+package handler
+
+import "time"
+
+// Clock - источник текущего времени. Время операций, снимков, повторов и
+// аудита берётся из него, а не из NOW() базы данных, поэтому в тестах его
+// можно подменить.
+type Clock interface {
+	Now() time.Time
+}
+
+// systemClock - системное время
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
@@ -0,0 +1,77 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"strings"
+
+	"wallet/internal/currency"
+)
+
+// ConvertedBalance - баланс в другой валюте. Значение ориентировочное
+// и не может использоваться для расчётов.
+type ConvertedBalance struct {
+	Currency   string  `json:"currency"`
+	Amount     float64 `json:"amount"`
+	Rate       float64 `json:"rate"`
+	Indicative bool    `json:"indicative"`
+}
+
+// parseConvertTo разбирает параметр ?convert_to=EUR,GBP
+func parseConvertTo(r *http.Request) []string {
+	raw := r.URL.Query().Get("convert_to")
+	if raw == "" {
+		return nil
+	}
+
+	var codes []string
+	for _, code := range strings.Split(raw, ",") {
+		if code = strings.ToUpper(strings.TrimSpace(code)); code != "" {
+			codes = append(codes, code)
+		}
+	}
+	return codes
+}
+
+func (h *WalletHandler) convertBalance(ctx context.Context, balance float64, codes []string) ([]ConvertedBalance, error) {
+	converted := make([]ConvertedBalance, 0, len(codes))
+	for _, code := range codes {
+		rate, err := h.config.RateProvider.Rate(ctx, code)
+		if err != nil {
+			return nil, err
+		}
+		converted = append(converted, ConvertedBalance{
+			Currency:   code,
+			Amount:     math.Round(balance*rate*100) / 100,
+			Rate:       rate,
+			Indicative: true,
+		})
+	}
+	return converted, nil
+}
+
+func (h *WalletHandler) sendConvertedBalance(ctx context.Context, w http.ResponseWriter, r *http.Request, balance walletBalance, codes []string) {
+	if h.config.RateProvider == nil {
+		h.writeError(w, r, ErrConversionDisabled, http.StatusNotImplemented)
+		return
+	}
+
+	converted, err := h.convertBalance(ctx, balance.amount, codes)
+	if err != nil {
+		if errors.Is(err, currency.ErrUnknownCurrency) {
+			h.writeError(w, r, ErrUnknownCurrency, http.StatusBadRequest)
+			return
+		}
+		h.writeError(w, r, ErrRatesUnavailable, http.StatusServiceUnavailable)
+		return
+	}
+
+	if err := h.sendData(w, r, struct {
+		Balance
+		Converted []ConvertedBalance `json:"converted"`
+	}{h.balanceResponse(balance), converted}); err != nil {
+		h.writeError(w, r, ErrSendResponse, http.StatusServiceUnavailable)
+	}
+}
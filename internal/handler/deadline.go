@@ -0,0 +1,39 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+const (
+	// Заголовок, которым клиент ограничивает время ожидания ответа: длительность вида "2s", "500ms"
+	requestTimeoutHeader = "X-Request-Timeout"
+	// Срок запросов на чтение, если клиент его не задал
+	defaultReadTimeout = 5 * time.Second
+	// Наибольший срок запроса, если MaxRequestTimeout не задан
+	defaultMaxRequestTimeout = 30 * time.Second
+)
+
+// requestTimeout - срок запроса из заголовка X-Request-Timeout. Без заголовка,
+// с неверным или неположительным значением берётся fallback; срок больше
+// MaxRequestTimeout сокращается до него.
+func (h *WalletHandler) requestTimeout(r *http.Request, fallback time.Duration) time.Duration {
+	timeout := fallback
+	if raw := r.Header.Get(requestTimeoutHeader); raw != "" {
+		if parsed, err := time.ParseDuration(raw); err == nil && parsed > 0 {
+			timeout = parsed
+		}
+	}
+
+	maxTimeout := h.config.MaxRequestTimeout
+	if maxTimeout <= 0 {
+		maxTimeout = defaultMaxRequestTimeout
+	}
+	return min(timeout, maxTimeout)
+}
+
+// requestContext - контекст запроса со сроком из requestTimeout
+func (h *WalletHandler) requestContext(r *http.Request, fallback time.Duration) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(r.Context(), h.requestTimeout(r, fallback))
+}
@@ -0,0 +1,60 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+)
+
+// writeDecodeError сообщает клиенту, что именно не так с телом запроса:
+// пустое тело, обрыв JSON, синтаксическая ошибка (с позицией) или неверный тип поля
+func (h *WalletHandler) writeDecodeError(w http.ResponseWriter, r *http.Request, err error) {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+
+	switch {
+	case errors.Is(err, io.EOF):
+		h.writeError(w, r, ErrEmptyBody, http.StatusBadRequest)
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		h.writeError(w, r, ErrJSONTruncated, http.StatusBadRequest)
+	case errors.As(err, &syntaxErr):
+		h.writeErrorf(w, r, ErrJSONSyntax, http.StatusBadRequest, syntaxErr.Offset)
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		h.writeErrorf(w, r, ErrJSONFieldType, http.StatusBadRequest, typeErr.Field, jsonTypeName(typeErr.Type), typeErr.Value)
+	default:
+		h.writeError(w, r, ErrParseRequest, http.StatusBadRequest)
+	}
+}
+
+// writeErrorf переводит шаблон ошибки и подставляет в него значения
+func (h *WalletHandler) writeErrorf(w http.ResponseWriter, r *http.Request, format string, status int, args ...interface{}) {
+	code, ok := errorCodes[format]
+	if ok {
+		format = h.messages.Translate(h.language(r), code)
+	}
+	h.writeErrorResponse(w, r, status, ErrorResponse{Error: fmt.Sprintf(format, args...), Code: code})
+}
+
+// jsonTypeName возвращает название JSON-типа, в который декодируется поле типа t
+func jsonTypeName(t reflect.Type) string {
+	if t == nil {
+		return "unknown"
+	}
+	switch t.Kind() {
+	case reflect.Float32, reflect.Float64,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "number"
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	default:
+		return "object"
+	}
+}
@@ -0,0 +1,77 @@
+package handler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	wallet "wallet/internal/model"
+)
+
+const (
+	operationDedupKeyPrefix = "operation_dedup:"
+	// Срок постановки операции в очередь: при недоступном Redis запрос
+	// завершается ошибкой, а не висит до таймаута сервера
+	queuePushTimeout = 2 * time.Second
+)
+
+// operationDedupKey - ключ Redis для операции: хеш вызывающего, кошелька, типа,
+// суммы и комментария. Одинаковые операции разных вызывающих не объединяются.
+func operationDedupKey(req *wallet.WalletRequest) string {
+	hash := sha256.New()
+	for _, part := range []string{
+		req.Subject,
+		req.WalletID,
+		string(req.OperationType),
+		strconv.FormatFloat(req.Amount, 'f', -1, 64),
+		req.Reference,
+	} {
+		hash.Write([]byte(part))
+		hash.Write([]byte{0})
+	}
+	return operationDedupKeyPrefix + hex.EncodeToString(hash.Sum(nil))
+}
+
+// pushOperation ставит операцию в очередь и возвращает длину очереди. При
+// включённом OperationDedupWindow проверка повтора и постановка выполняются
+// в Redis одним скриптом, поэтому одинаковые операции, пришедшие на разные
+// экземпляры сервиса одновременно, не попадут в очередь дважды. Для повтора
+// очередь не меняется и возвращается id операции, поставленной первой.
+//
+// Анонимные запросы без комментария не дедуплицируются: все они имеют один
+// субъект, и одинаковые пополнения разных клиентов слились бы в одно.
+func (h *WalletHandler) pushOperation(ctx context.Context, req *wallet.WalletRequest, payload []byte) (length int64, priorID string, err error) {
+	window := h.settings().OperationDedupWindow
+	if window <= 0 || (req.Subject == anonymousSubject && req.Reference == "") {
+		length, err = h.cache.LPush(ctx, operationsQueue(req.Priority), payload).Result()
+		return length, "", err
+	}
+
+	enqueued, length, existing, err := h.cache.EnqueueUnique(ctx, operationsQueue(req.Priority), payload, operationDedupKey(req), req.ID, window)
+	if err != nil {
+		return 0, "", err
+	}
+	if !enqueued {
+		return 0, existing, nil
+	}
+	return length, "", nil
+}
+
+// queueNotReached сообщает, что команда постановки в очередь точно не ушла в
+// Redis: соединение не было установлено или клиент закрыт. Только тогда
+// операцию можно выполнить синхронно. После таймаута или обрыва уже открытого
+// соединения Redis мог сохранить операцию, и обработчик очереди применил бы её
+// повторно.
+func queueNotReached(err error) bool {
+	if errors.Is(err, redis.ErrClosed) {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
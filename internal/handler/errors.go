@@ -0,0 +1,105 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"wallet/internal/service"
+)
+
+// ErrorResponse - тело любого ответа с ошибкой: текст на языке клиента,
+// стабильный машинный код и идентификатор запроса, если он известен
+type ErrorResponse struct {
+	Error     string `json:"error"`
+	Code      string `json:"code,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+	// Ошибки отдельных полей запроса для ответа 422
+	Fields []FieldError `json:"fields,omitempty"`
+}
+
+// FieldError - ошибка одного поля запроса. Code стабилен (amount.negative,
+// wallet_id.empty), по нему клиент выбирает реакцию, не разбирая Message.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// errorStatuses сопоставляет известные ошибки статусу ответа и сообщению.
+// Порядок важен: побеждает первое совпадение.
+var errorStatuses = []struct {
+	err     error
+	status  int
+	message string
+}{
+	{service.ErrWalletNotFound, http.StatusNotFound, ErrWalletNotFound},
+	{sql.ErrNoRows, http.StatusNotFound, ErrWalletNotFound},
+	{service.ErrWalletClosed, http.StatusConflict, ErrWalletClosed},
+	{service.ErrWalletBlocked, http.StatusForbidden, ErrWalletBlocked},
+	{context.DeadlineExceeded, http.StatusGatewayTimeout, ErrTimeout},
+	{service.ErrInsufficientFunds, http.StatusBadRequest, ""},
+	{service.ErrNilRequest, http.StatusBadRequest, ""},
+	{service.ErrInvalidWalletID, http.StatusUnprocessableEntity, ""},
+	{service.ErrUnknownOperationType, http.StatusUnprocessableEntity, ""},
+	{service.ErrEmptyWalletID, http.StatusUnprocessableEntity, ""},
+	{service.ErrNegativeAmount, http.StatusUnprocessableEntity, ""},
+	{service.ErrInvalidAmount, http.StatusUnprocessableEntity, ""},
+	{service.ErrAmountTooSmall, http.StatusUnprocessableEntity, ""},
+	{service.ErrPrecisionExceedsCurrency, http.StatusUnprocessableEntity, ""},
+	{service.ErrValidation, http.StatusUnprocessableEntity, ""},
+}
+
+// errorStatus возвращает статус ответа для ошибки; неизвестные ошибки - 500
+func errorStatus(err error) (int, string) {
+	for _, entry := range errorStatuses {
+		if errors.Is(err, entry.err) {
+			return entry.status, entry.message
+		}
+	}
+	return http.StatusInternalServerError, ErrInternal
+}
+
+// writeErr отвечает на ошибку по её типу: WalletError несёт статус сам,
+// ошибки валидатора переводятся по коду, блокировка кошелька скрывается по
+// ConcealForbiddenWallets, остальные ищутся в errorStatuses
+func (h *WalletHandler) writeErr(w http.ResponseWriter, r *http.Request, err error) {
+	var walletErr *WalletError
+	if errors.As(err, &walletErr) {
+		h.writeWalletError(w, r, walletErr)
+		return
+	}
+
+	if errors.Is(err, service.ErrWalletBlocked) {
+		h.writeWalletForbidden(w, r)
+		return
+	}
+
+	status, message := errorStatus(err)
+	if _, _, ok := service.ErrorCode(err); ok {
+		h.writeValidationError(w, r, err, status)
+		return
+	}
+	h.writeError(w, r, message, status)
+}
+
+// writeErrorResponse записывает тело ошибки в JSON. Идентификатор запроса
+// берётся из уже выставленного заголовка ответа или из заголовка запроса.
+func (h *WalletHandler) writeErrorResponse(w http.ResponseWriter, r *http.Request, status int, body ErrorResponse) {
+	if body.RequestID == "" {
+		body.RequestID = w.Header().Get("X-Request-ID")
+	}
+	if body.RequestID == "" {
+		body.RequestID = r.Header.Get("X-Request-ID")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(body)
+}
@@ -0,0 +1,174 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	wallet "wallet/internal/model"
+)
+
+// Типы событий кошелька
+const (
+	EventBalance   = "balance"
+	EventOperation = "operation"
+)
+
+// WalletEvent - событие кошелька для подписчиков: новый баланс после
+// операции или итог обработки операции из очереди
+type WalletEvent struct {
+	Type     string                 `json:"type"`
+	WalletID string                 `json:"wallet_id"`
+	Balance  *Balance               `json:"balance,omitempty"`
+	ID       string                 `json:"id,omitempty"`
+	Status   wallet.OperationStatus `json:"status,omitempty"`
+	Time     time.Time              `json:"time"`
+}
+
+// EventBus доставляет события кошельков подписчикам. Subscribe возвращает канал
+// событий и функцию отписки; канал закрывается после отписки или отмены ctx.
+type EventBus interface {
+	Publish(ctx context.Context, event WalletEvent) error
+	Subscribe(ctx context.Context, walletID string) (<-chan WalletEvent, func(), error)
+}
+
+// Размер буфера канала подписчика
+const subscriberBuffer = 64
+
+// publishEvent отправляет событие без ожидания подписчиков; ошибка только логируется
+func (h *WalletHandler) publishEvent(event WalletEvent) {
+	if event.Time.IsZero() {
+		event.Time = h.clock.Now().UTC()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := h.events.Publish(ctx, event); err != nil {
+		log.Printf("%s: %v", ErrEventPublish, err)
+	}
+}
+
+// LocalEvents - EventBus в памяти процесса: события видят только подписчики
+// этого экземпляра сервиса
+type LocalEvents struct {
+	mu          sync.Mutex
+	subscribers map[string]map[chan WalletEvent]struct{}
+}
+
+func NewLocalEvents() *LocalEvents {
+	return &LocalEvents{subscribers: make(map[string]map[chan WalletEvent]struct{})}
+}
+
+// Publish не ждёт медленных подписчиков: событие для переполненного канала отбрасывается
+func (e *LocalEvents) Publish(_ context.Context, event WalletEvent) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for ch := range e.subscribers[event.WalletID] {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+	return nil
+}
+
+func (e *LocalEvents) Subscribe(ctx context.Context, walletID string) (<-chan WalletEvent, func(), error) {
+	ch := make(chan WalletEvent, subscriberBuffer)
+	e.mu.Lock()
+	if e.subscribers[walletID] == nil {
+		e.subscribers[walletID] = make(map[chan WalletEvent]struct{})
+	}
+	e.subscribers[walletID][ch] = struct{}{}
+	e.mu.Unlock()
+
+	var once sync.Once
+	unsubscribe := func() {
+		once.Do(func() {
+			e.mu.Lock()
+			defer e.mu.Unlock()
+			delete(e.subscribers[walletID], ch)
+			if len(e.subscribers[walletID]) == 0 {
+				delete(e.subscribers, walletID)
+			}
+			close(ch)
+		})
+	}
+	go func() {
+		<-ctx.Done()
+		unsubscribe()
+	}()
+	return ch, unsubscribe, nil
+}
+
+// redisEvents - EventBus через Redis pub/sub: события доходят до подписчиков
+// всех экземпляров сервиса
+type redisEvents struct {
+	client redis.UniversalClient
+}
+
+// NewRedisEvents возвращает EventBus, публикующий события в канал events:{wallet_id}
+func NewRedisEvents(client redis.UniversalClient) EventBus {
+	return &redisEvents{client: client}
+}
+
+func eventsChannel(walletID string) string {
+	return "events:" + walletID
+}
+
+func (e *redisEvents) Publish(ctx context.Context, event WalletEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return e.client.Publish(ctx, eventsChannel(event.WalletID), data).Err()
+}
+
+func (e *redisEvents) Subscribe(ctx context.Context, walletID string) (<-chan WalletEvent, func(), error) {
+	pubsub := e.client.Subscribe(ctx, eventsChannel(walletID))
+	// Дожидаемся подтверждения подписки, чтобы не пропустить события сразу после неё
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return nil, nil, err
+	}
+
+	ch := make(chan WalletEvent, subscriberBuffer)
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		defer close(ch)
+		defer pubsub.Close()
+		messages := pubsub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-messages:
+				if !ok {
+					return
+				}
+				var event WalletEvent
+				if err := json.Unmarshal([]byte(msg.Payload), &event); err != nil {
+					continue
+				}
+				select {
+				case ch <- event:
+				default:
+				}
+			}
+		}
+	}()
+	return ch, cancel, nil
+}
+
+// balanceEvent - событие об изменении баланса кошелька
+func balanceEvent(walletID string, balance float64) WalletEvent {
+	b := newBalance(balance)
+	return WalletEvent{Type: EventBalance, WalletID: walletID, Balance: &b}
+}
+
+// operationEvent - событие об итоге обработки операции из очереди
+func operationEvent(op wallet.WalletRequest, status wallet.OperationStatus) WalletEvent {
+	return WalletEvent{Type: EventOperation, WalletID: op.WalletID, ID: op.ID, Status: status}
+}
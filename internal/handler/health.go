@@ -0,0 +1,45 @@
+package handler
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// RunHealthCheck периодически проверяет доступность БД. Пока БД недоступна,
+// обработчики очереди не забирают операции, и они остаются в Redis.
+func (h *WalletHandler) RunHealthCheck(ctx context.Context) {
+	if h.config.HealthCheckInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(h.config.HealthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			h.checkHealth(ctx)
+		}
+	}
+}
+
+func (h *WalletHandler) checkHealth(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, h.config.HealthCheckInterval)
+	defer cancel()
+
+	healthy := h.db.PingContext(ctx) == nil
+	if wasUnhealthy := h.dbUnhealthy.Swap(!healthy); wasUnhealthy == healthy {
+		if healthy {
+			log.Println("БД снова доступна, обработка очереди возобновлена")
+		} else {
+			log.Println("БД недоступна, обработка очереди приостановлена")
+		}
+	}
+}
+
+func (h *WalletHandler) isDBHealthy() bool {
+	return !h.dbUnhealthy.Load()
+}
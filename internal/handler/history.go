@@ -0,0 +1,144 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	wallet "wallet/internal/model"
+)
+
+// Количество операций на странице истории по умолчанию
+const historyLimit = 100
+
+// Страницы истории идут в порядке ?sort_by и ?order (по умолчанию по (created_at, id)
+// по убыванию); $3 и $4 - курсор последней операции предыдущей страницы, NULL для
+// первой страницы. Подставляются только имена таблиц, условия и выражения из
+// historySortFields.
+const historyQueryTemplate = `
+		SELECT id, wallet_id, amount, operation_type, reference, created_at, voided_at, void_of
+		FROM %s
+		WHERE wallet_id = $1%s
+		  AND ($3::timestamptz IS NULL OR (%s, id) %s ($3::timestamptz, $4::uuid))
+		ORDER BY %s
+		LIMIT $2`
+
+var (
+	// Обычная история: без отменённых операций и их компенсаций
+	historyQuery = buildHistoryQuery(false, false, defaultHistorySort)
+	// Журнал аудита (?audit=true): все записи, включая отменённые и компенсирующие
+	auditHistoryQuery = buildHistoryQuery(true, false, defaultHistorySort)
+	// Те же запросы с архивными операциями (?archived=true)
+	archivedHistoryQuery      = buildHistoryQuery(false, true, defaultHistorySort)
+	archivedAuditHistoryQuery = buildHistoryQuery(true, true, defaultHistorySort)
+)
+
+func buildHistoryQuery(audit, archived bool, order sortOrder) string {
+	table := "transactions"
+	if archived {
+		table = "transactions_all"
+	}
+	filter := " AND voided_at IS NULL AND void_of IS NULL"
+	if audit {
+		filter = ""
+	}
+	return fmt.Sprintf(historyQueryTemplate, table, filter, order.Column, order.after(), order)
+}
+
+func (h *WalletHandler) GetTransactionHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.writeError(w, r, ErrMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx, cancel := h.requestContext(r, defaultReadTimeout)
+	defer cancel()
+
+	rawID := strings.TrimPrefix(r.URL.Path, "/api/v1/wallets/")
+	rawID = strings.TrimSuffix(rawID, "/transactions")
+	var walletID uuid.UUID
+	var limit int
+	var cursor *timeCursor
+	order := defaultHistorySort
+	// Неизвестное поле или порядок сортировки - ошибка запроса, а не значения
+	if err := sortParam(r, historySortFields, &order)(); err != nil {
+		h.writeError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !h.validate(w, r,
+		h.pathID(rawID, &walletID, ErrInvalidUUID),
+		pageLimit(r, min(historyLimit, h.maxListSize()), &limit),
+		pageCursor(r, &cursor),
+	) {
+		return
+	}
+	if !h.capListSize(w, r, &limit) {
+		return
+	}
+
+	// Запрашиваем на одну операцию больше, чтобы узнать, есть ли следующая страница
+	query := r.URL.Query()
+	history, err := h.getTransactionHistory(ctx, walletID, query.Get("audit") == "true", query.Get("archived") == "true", order, limit+1, cursor)
+	if err != nil {
+		h.writeError(w, r, ErrHistoryGet, http.StatusServiceUnavailable)
+		return
+	}
+
+	page := newPage(history, limit, func(t wallet.Transaction) string {
+		return timeCursor{At: t.CreatedAt, ID: t.ID}.String()
+	})
+	if err := h.sendData(w, r, page); err != nil {
+		h.writeError(w, r, ErrSendResponse, http.StatusServiceUnavailable)
+		return
+	}
+}
+
+func (h *WalletHandler) getTransactionHistory(ctx context.Context, walletID uuid.UUID, audit, archived bool, order sortOrder, limit int, cursor *timeCursor) ([]wallet.Transaction, error) {
+	query := buildHistoryQuery(audit, archived, order)
+	// Потолок действует и в запросе: с запасом в одну строку для признака следующей страницы
+	limit = min(limit, h.maxListSize()+1)
+
+	var cursorAt *time.Time
+	var cursorID *string
+	if cursor != nil {
+		cursorAt, cursorID = &cursor.At, &cursor.ID
+	}
+
+	rows, err := h.db.QueryContext(ctx, query, walletID, limit, cursorAt, cursorID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", ErrHistoryGet, err)
+	}
+	defer rows.Close()
+
+	history := make([]wallet.Transaction, 0)
+	for rows.Next() {
+		var t wallet.Transaction
+		if err := scanTransaction(rows, &t); err != nil {
+			return nil, fmt.Errorf("%s: %w", ErrHistoryGet, err)
+		}
+		history = append(history, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", ErrHistoryGet, err)
+	}
+
+	return history, nil
+}
+
+// transactionRows - строки, которые сами сопоставляют колонки полям операции (db.Rows)
+type transactionRows interface {
+	ScanTransaction(t *wallet.Transaction) error
+}
+
+// scanTransaction читает строку запроса истории в t. Остальные реализации
+// RowsInterface получают поля в порядке колонок historyQuery.
+func scanTransaction(rows RowsInterface, t *wallet.Transaction) error {
+	if scanner, ok := rows.(transactionRows); ok {
+		return scanner.ScanTransaction(t)
+	}
+	return rows.Scan(&t.ID, &t.WalletID, &t.Amount, &t.OperationType, &t.Reference, &t.CreatedAt, &t.VoidedAt, &t.VoidOf)
+}
@@ -0,0 +1,164 @@
+package handler
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+
+	wallet "wallet/internal/model"
+	"wallet/internal/service"
+)
+
+const (
+	ImportRowQueued  = "queued"
+	ImportRowSkipped = "skipped"
+	ImportRowFailed  = "failed"
+)
+
+// Столбцы CSV для импорта; строка заголовка необязательна
+var importColumns = []string{"wallet_id", "operation_type", "amount", "reference"}
+
+// ImportRowResult - результат импорта одной строки CSV
+type ImportRowResult struct {
+	Line        int    `json:"line"`
+	Status      string `json:"status"`
+	OperationID string `json:"operation_id,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// ImportReport - итог импорта: количество поставленных в очередь и пропущенных строк
+type ImportReport struct {
+	Queued  int               `json:"queued"`
+	Skipped int               `json:"skipped"`
+	Failed  int               `json:"failed"`
+	Rows    []ImportRowResult `json:"rows"`
+}
+
+// HandleImport импортирует операции из CSV: POST /api/v1/admin/import.
+// Строки читаются по одной из тела запроса, проверяются и ставятся в очередь;
+// некорректные строки пропускаются и попадают в отчёт с номером строки.
+func (h *WalletHandler) HandleImport(w http.ResponseWriter, r *http.Request) {
+	if !h.isAdmin(r) {
+		h.writeError(w, r, ErrForbidden, http.StatusForbidden)
+		return
+	}
+	if r.Method != http.MethodPost {
+		h.writeError(w, r, ErrMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+
+	lang := h.language(r)
+	reader := csv.NewReader(r.Body)
+	reader.FieldsPerRecord = len(importColumns)
+	reader.TrimLeadingSpace = true
+
+	report := ImportReport{Rows: make([]ImportRowResult, 0)}
+	for first := true; ; first = false {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			// Строку с неверным числом полей или кавычками пропускаем, остальные читаем дальше
+			var parseErr *csv.ParseError
+			if !errors.As(err, &parseErr) {
+				h.writeError(w, r, ErrImportRead, http.StatusBadRequest)
+				return
+			}
+			report.add(ImportRowResult{Line: parseErr.StartLine, Status: ImportRowSkipped, Error: h.translateMessage(lang, ErrImportMalformedRow)})
+			continue
+		}
+		line, _ := reader.FieldPos(0)
+		if first && isImportHeader(record) {
+			continue
+		}
+
+		req, err := h.parseImportRow(r, record)
+		if err != nil {
+			report.add(ImportRowResult{Line: line, Status: ImportRowSkipped, Error: h.translateImportError(lang, err)})
+			continue
+		}
+
+		if err := h.enqueueOperation(r.Context(), req); err != nil {
+			report.add(ImportRowResult{Line: line, Status: ImportRowFailed, Error: h.translateMessage(lang, ErrQueueAdd)})
+			continue
+		}
+		report.add(ImportRowResult{Line: line, Status: ImportRowQueued, OperationID: req.ID})
+	}
+
+	if err := h.sendData(w, r, report); err != nil {
+		h.writeError(w, r, ErrSendResponse, http.StatusServiceUnavailable)
+	}
+}
+
+func (report *ImportReport) add(row ImportRowResult) {
+	switch row.Status {
+	case ImportRowQueued:
+		report.Queued++
+	case ImportRowSkipped:
+		report.Skipped++
+	case ImportRowFailed:
+		report.Failed++
+	}
+	report.Rows = append(report.Rows, row)
+}
+
+func isImportHeader(record []string) bool {
+	return strings.EqualFold(strings.TrimSpace(record[0]), importColumns[0])
+}
+
+// parseImportRow собирает и проверяет операцию из строки CSV так же, как запрос к /api/v1/wallet
+func (h *WalletHandler) parseImportRow(r *http.Request, record []string) (*wallet.WalletRequest, error) {
+	walletID, err := uuid.Parse(strings.TrimSpace(record[0]))
+	if err != nil {
+		return nil, errors.New(ErrInvalidUUID)
+	}
+	// ParseFloat принимает "NaN" и "Inf": такая сумма не сериализуется в JSON
+	// и строка отклонялась бы ошибкой очереди вместо ошибки суммы
+	amount, err := strconv.ParseFloat(strings.TrimSpace(record[2]), 64)
+	if err != nil || math.IsNaN(amount) || math.IsInf(amount, 0) {
+		return nil, service.ErrInvalidAmount
+	}
+
+	req := &wallet.WalletRequest{
+		WalletID:      walletID.String(),
+		OperationType: wallet.ParseOperationType(record[1]),
+		Amount:        amount,
+		Reference:     record[3],
+		Subject:       h.auditSubject(r),
+		SourceIP:      sourceIP(r),
+		// Массовая загрузка не должна задерживать операции клиентов
+		Priority: wallet.PriorityLow,
+	}
+	if err := h.validator.ValidateWalletRequest(req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// enqueueOperation присваивает операции идентификатор и ставит её в очередь обработки
+func (h *WalletHandler) enqueueOperation(ctx context.Context, req *wallet.WalletRequest) error {
+	req.ID = uuid.New().String()
+	operationJSON, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	return h.cache.LPush(ctx, operationsQueue(req.Priority), operationJSON).Err()
+}
+
+// translateImportError переводит ошибку разбора строки: ошибки валидатора - по их коду
+func (h *WalletHandler) translateImportError(lang string, err error) string {
+	if _, _, ok := service.ErrorCode(err); ok {
+		return h.translateValidationError(lang, err)
+	}
+	return h.translateMessage(lang, err.Error())
+}
@@ -0,0 +1,45 @@
+package handler
+
+import "net/http"
+
+// InFlight - нагрузка в момент запроса
+type InFlight struct {
+	// Операции из очереди, которые сейчас выполняют обработчики
+	Operations int64 `json:"operations"`
+	// Запросы чтения баланса, занявшие слот semaphore
+	Requests int `json:"requests"`
+	// Открытые пишущие транзакции
+	WriteTransactions int `json:"write_transactions"`
+}
+
+// HandleInFlight показывает число выполняющихся операций и запросов:
+// GET /api/v1/admin/inflight
+func (h *WalletHandler) HandleInFlight(w http.ResponseWriter, r *http.Request) {
+	if !h.isAdmin(r) {
+		h.writeError(w, r, ErrForbidden, http.StatusForbidden)
+		return
+	}
+	if r.Method != http.MethodGet {
+		h.writeError(w, r, ErrMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := h.sendData(w, r, h.inFlight()); err != nil {
+		h.writeError(w, r, ErrSendResponse, http.StatusServiceUnavailable)
+	}
+}
+
+func (h *WalletHandler) inFlight() InFlight {
+	return InFlight{
+		Operations:        h.inFlightOperations.Load(),
+		Requests:          len(h.semaphore),
+		WriteTransactions: h.writeTransactions(),
+	}
+}
+
+func (h *WalletHandler) writeTransactions() int {
+	if limit := h.writeLimit.Load(); limit != nil {
+		return len(limit.slots)
+	}
+	return 0
+}
@@ -0,0 +1,166 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/google/uuid"
+
+	"wallet/internal/audit"
+)
+
+// Размер пакета сверки, если InvariantBatchSize не задан
+const defaultInvariantBatchSize = 500
+
+// Пакет кошельков для проверки сохранённых балансов по журналу, по возрастанию id
+const invariantBatchQuery = `
+	SELECT w.id, w.balance, b.balance
+	FROM wallets w
+	JOIN wallet_ledger_balances b ON b.wallet_id = w.id
+	WHERE w.id > $1
+	ORDER BY w.id
+	LIMIT $2`
+
+// InvariantMismatch - кошелек, сохранённый баланс которого расходится с журналом
+type InvariantMismatch struct {
+	WalletID uuid.UUID `json:"wallet_id"`
+	Balance  Balance   `json:"balance"`
+	Ledger   Balance   `json:"ledger"`
+	Delta    Balance   `json:"delta"`
+	Fixed    bool      `json:"fixed,omitempty"`
+}
+
+// InvariantReport - итог проверки balance == SUM(transactions.amount) по всем
+// кошелькам. Mismatched - число расхождений; в Mismatches их не больше MaxListSize.
+type InvariantReport struct {
+	Checked    int                 `json:"checked"`
+	Mismatched int                 `json:"mismatched"`
+	Fixed      int                 `json:"fixed"`
+	Mismatches []InvariantMismatch `json:"mismatches"`
+}
+
+// HandleInvariantCheck сверяет сохранённые балансы всех кошельков с журналом
+// операций: GET /api/v1/admin/invariant только сообщает о расхождениях,
+// POST вдобавок пересчитывает разошедшиеся балансы, как /rebuild.
+func (h *WalletHandler) HandleInvariantCheck(w http.ResponseWriter, r *http.Request) {
+	if !h.isAdmin(r) {
+		h.writeError(w, r, ErrForbidden, http.StatusForbidden)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		h.writeError(w, r, ErrMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+	// В режиме ledger баланс и есть сумма журнала: сверять нечего
+	if h.config.BalanceMode == BalanceModeLedger {
+		h.writeError(w, r, ErrInvariantLedgerMode, http.StatusConflict)
+		return
+	}
+	fix := r.Method == http.MethodPost
+	if fix && h.writeMaintenance(w, r) {
+		return
+	}
+
+	report, err := h.checkInvariant(r.Context(), fix, func(walletID uuid.UUID, result RebuildResult, walletErr *WalletError) {
+		entry := h.requestAuditEntry(r, AuditActionRebuild, walletID.String(), nil)
+		entry.Amount = float64(result.Delta.BalanceMinor) / 100
+		if walletErr != nil {
+			entry.Result = audit.ResultFailure
+			entry.Error = walletErr.Error()
+		}
+		h.recordAudit(entry)
+	})
+	if err != nil {
+		h.writeReadError(r.Context(), w, r, err, ErrInvariantCheck)
+		return
+	}
+
+	if err := h.sendData(w, r, report); err != nil {
+		h.writeError(w, r, ErrSendResponse, http.StatusServiceUnavailable)
+	}
+}
+
+// checkInvariant проходит по кошелькам пакетами по InvariantBatchSize. При fix
+// каждое расхождение пересчитывается rebuildBalance под блокировкой кошелька,
+// а onFix получает итог пересчёта.
+func (h *WalletHandler) checkInvariant(ctx context.Context, fix bool, onFix func(uuid.UUID, RebuildResult, *WalletError)) (InvariantReport, error) {
+	report := InvariantReport{Mismatches: make([]InvariantMismatch, 0)}
+	batchSize := h.config.InvariantBatchSize
+	if batchSize <= 0 {
+		batchSize = defaultInvariantBatchSize
+	}
+
+	after := uuid.Nil
+	for {
+		mismatches, scanned, last, err := h.invariantBatch(ctx, after, batchSize)
+		if err != nil {
+			return report, err
+		}
+		report.Checked += scanned
+
+		for _, mismatch := range mismatches {
+			report.Mismatched++
+			log.Printf("Баланс кошелька %s расходится с журналом: %s, по журналу %s", h.logWalletID(mismatch.WalletID.String()),
+				h.logAmount(float64(mismatch.Balance.BalanceMinor)/100), h.logAmount(float64(mismatch.Ledger.BalanceMinor)/100))
+			if fix {
+				release := h.acquireWriteSlot()
+				fixCtx, cancel := context.WithTimeout(ctx, h.config.OperationTimeout)
+				result, walletErr := h.rebuildBalance(fixCtx, mismatch.WalletID)
+				cancel()
+				release()
+				onFix(mismatch.WalletID, result, walletErr)
+				if walletErr == nil && result.Corrected {
+					mismatch.Fixed = true
+					report.Fixed++
+				}
+			}
+			if len(report.Mismatches) < h.maxListSize() {
+				report.Mismatches = append(report.Mismatches, mismatch)
+			}
+		}
+
+		if scanned < batchSize {
+			return report, nil
+		}
+		after = last
+	}
+}
+
+// invariantBatch читает пакет кошельков после after и возвращает расхождения,
+// число прочитанных кошельков и id последнего из них
+func (h *WalletHandler) invariantBatch(ctx context.Context, after uuid.UUID, size int) ([]InvariantMismatch, int, uuid.UUID, error) {
+	rows, err := h.db.QueryContext(ctx, invariantBatchQuery, after, size)
+	if err != nil {
+		return nil, 0, after, fmt.Errorf("%s: %w", ErrInvariantCheck, err)
+	}
+	defer rows.Close()
+
+	var mismatches []InvariantMismatch
+	scanned := 0
+	for rows.Next() {
+		var walletID uuid.UUID
+		var stored, ledger float64
+		if err := rows.Scan(&walletID, &stored, &ledger); err != nil {
+			return nil, 0, after, fmt.Errorf("%s: %w", ErrInvariantCheck, err)
+		}
+		scanned++
+		after = walletID
+
+		// Сравнение в копейках, чтобы погрешность float не выглядела расхождением
+		balance, sum := newBalance(stored), newBalance(ledger)
+		if delta := sum.BalanceMinor - balance.BalanceMinor; delta != 0 {
+			mismatches = append(mismatches, InvariantMismatch{
+				WalletID: walletID,
+				Balance:  balance,
+				Ledger:   sum,
+				Delta:    newBalanceMinor(delta),
+			})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, after, fmt.Errorf("%s: %w", ErrInvariantCheck, err)
+	}
+	return mismatches, scanned, after, nil
+}
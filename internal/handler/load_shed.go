@@ -0,0 +1,58 @@
+package handler
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// Вес нового замера в скользящей средней задержки БД
+	latencyEWMAAlpha = 0.2
+	// Число попыток чтения баланса из БД в обычном режиме
+	dbReadAttempts = 3
+)
+
+// latencyTracker считает экспоненциально взвешенную скользящую среднюю задержки запросов к БД
+type latencyTracker struct {
+	mu   sync.Mutex
+	ewma time.Duration
+}
+
+func (t *latencyTracker) observe(latency time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.ewma == 0 {
+		t.ewma = latency
+		return
+	}
+	t.ewma = time.Duration(latencyEWMAAlpha*float64(latency) + (1-latencyEWMAAlpha)*float64(t.ewma))
+}
+
+func (t *latencyTracker) value() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.ewma
+}
+
+// dbSaturated сообщает, что средняя задержка чтения из БД выше DBLatencyThreshold
+func (h *WalletHandler) dbSaturated() bool {
+	return h.config.DBLatencyThreshold > 0 && h.dbLatency.value() > h.config.DBLatencyThreshold
+}
+
+// acquireDBRead решает, допустить ли чтение баланса из БД, и возвращает число попыток.
+// При перегрузке БД повторы отключаются, а чтения сверх SaturatedDBReads отклоняются.
+// Если ok, вызывающий обязан вызвать release.
+func (h *WalletHandler) acquireDBRead() (attempts int, release func(), ok bool) {
+	if !h.dbSaturated() {
+		return dbReadAttempts, func() {}, true
+	}
+	if h.config.SaturatedDBReads <= 0 {
+		return 1, func() {}, true
+	}
+	select {
+	case h.saturatedReads <- struct{}{}:
+		return 1, func() { <-h.saturatedReads }, true
+	default:
+		return 0, nil, false
+	}
+}
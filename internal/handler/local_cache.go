@@ -0,0 +1,260 @@
+package handler
+
+import (
+	"container/list"
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Время жизни записи локального кэша балансов по умолчанию
+const defaultLocalCacheTTL = time.Second
+
+// localCache - ограниченный LRU-кэш значений баланса в памяти процесса перед
+// Redis. Каждый сброс увеличивает поколение: значение, прочитанное из Redis до
+// сброса, не попадёт в кэш, даже если запись придёт после него.
+type localCache struct {
+	mu         sync.Mutex
+	size       int
+	ttl        time.Duration
+	entries    map[string]*list.Element
+	order      *list.List
+	generation uint64
+}
+
+type localCacheEntry struct {
+	key       string
+	value     string
+	expiresAt time.Time
+}
+
+func newLocalCache(size int, ttl time.Duration) *localCache {
+	if ttl <= 0 {
+		ttl = defaultLocalCacheTTL
+	}
+	return &localCache{size: size, ttl: ttl, entries: make(map[string]*list.Element), order: list.New()}
+}
+
+// get возвращает значение, если оно есть и не истекло, а также текущее поколение
+// для последующего put
+func (c *localCache) get(key string, now time.Time) (value string, ok bool, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, found := c.entries[key]
+	if !found {
+		return "", false, c.generation
+	}
+	entry := el.Value.(*localCacheEntry)
+	if !now.Before(entry.expiresAt) {
+		c.order.Remove(el)
+		delete(c.entries, key)
+		return "", false, c.generation
+	}
+	c.order.MoveToFront(el)
+	return entry.value, true, c.generation
+}
+
+// put сохраняет значение, если с момента get кэш не сбрасывался; самая давно
+// использованная запись вытесняется при превышении размера
+func (c *localCache) put(key, value string, generation uint64, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if generation != c.generation {
+		return
+	}
+	expiresAt := now.Add(c.ttl)
+	if el, found := c.entries[key]; found {
+		entry := el.Value.(*localCacheEntry)
+		entry.value, entry.expiresAt = value, expiresAt
+		c.order.MoveToFront(el)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&localCacheEntry{key: key, value: value, expiresAt: expiresAt})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*localCacheEntry).key)
+	}
+}
+
+// invalidate удаляет запись и начинает новое поколение
+func (c *localCache) invalidate(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	if el, found := c.entries[key]; found {
+		c.order.Remove(el)
+		delete(c.entries, key)
+	}
+}
+
+func (c *localCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// getCachedBalance читает значение баланса сначала из локального кэша, затем из Redis.
+// Значение из Redis запоминается локально.
+func (h *WalletHandler) getCachedBalance(ctx context.Context, key string) (string, error) {
+	if h.localCache == nil {
+		return h.cache.Get(ctx, key)
+	}
+	value, ok, generation := h.localCache.get(key, h.clock.Now())
+	if ok {
+		return value, nil
+	}
+	value, err := h.cache.Get(ctx, key)
+	if err == nil {
+		h.localCache.put(key, value, generation, h.clock.Now())
+	}
+	return value, err
+}
+
+// invalidateLocalBalance сбрасывает баланс кошелька в локальном кэше этого
+// экземпляра сразу, а в остальных - через BalanceInvalidations
+func (h *WalletHandler) invalidateLocalBalance(walletID string) {
+	if h.localCache == nil {
+		return
+	}
+	h.localCache.invalidate(balanceCacheKey(walletID))
+	if h.config.BalanceInvalidations == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := h.config.BalanceInvalidations.Publish(ctx, walletID); err != nil {
+		log.Printf("%s: %v", ErrEventPublish, err)
+	}
+}
+
+// RunLocalCacheInvalidation сбрасывает локальный кэш по уведомлениям других
+// экземпляров до отмены ctx
+func (h *WalletHandler) RunLocalCacheInvalidation(ctx context.Context) {
+	if h.localCache == nil || h.config.BalanceInvalidations == nil {
+		return
+	}
+	walletIDs, unsubscribe, err := h.config.BalanceInvalidations.Subscribe(ctx)
+	if err != nil {
+		log.Printf("%s: %v", ErrEventSubscribe, err)
+		return
+	}
+	defer unsubscribe()
+	for walletID := range walletIDs {
+		h.localCache.invalidate(balanceCacheKey(walletID))
+	}
+}
+
+func balanceCacheKey(walletID string) string {
+	return "balance:" + walletID
+}
+
+// BalanceInvalidations рассылает всем экземплярам сервиса идентификаторы
+// кошельков, баланс которых изменился. Subscribe возвращает канал
+// идентификаторов и функцию отписки; канал закрывается после отписки или отмены ctx.
+type BalanceInvalidations interface {
+	Publish(ctx context.Context, walletID string) error
+	Subscribe(ctx context.Context) (<-chan string, func(), error)
+}
+
+// LocalInvalidations - BalanceInvalidations в памяти процесса
+type LocalInvalidations struct {
+	mu          sync.Mutex
+	subscribers map[chan string]struct{}
+}
+
+func NewLocalInvalidations() *LocalInvalidations {
+	return &LocalInvalidations{subscribers: make(map[chan string]struct{})}
+}
+
+// Publish ждёт, пока уведомление примут все подписчики, или отмены ctx:
+// пропущенный сброс оставил бы устаревший баланс в кэше
+func (i *LocalInvalidations) Publish(ctx context.Context, walletID string) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	for ch := range i.subscribers {
+		select {
+		case ch <- walletID:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func (i *LocalInvalidations) Subscribe(ctx context.Context) (<-chan string, func(), error) {
+	ch := make(chan string, subscriberBuffer)
+	i.mu.Lock()
+	i.subscribers[ch] = struct{}{}
+	i.mu.Unlock()
+
+	var once sync.Once
+	unsubscribe := func() {
+		once.Do(func() {
+			i.mu.Lock()
+			defer i.mu.Unlock()
+			delete(i.subscribers, ch)
+			close(ch)
+		})
+	}
+	go func() {
+		<-ctx.Done()
+		unsubscribe()
+	}()
+	return ch, unsubscribe, nil
+}
+
+// Канал Redis pub/sub для сброса локальных кэшей балансов
+const balanceInvalidationsChannel = "balance_invalidations"
+
+// redisInvalidations - BalanceInvalidations через Redis pub/sub
+type redisInvalidations struct {
+	client redis.UniversalClient
+}
+
+// NewRedisInvalidations возвращает BalanceInvalidations, публикующий
+// идентификаторы кошельков в канал balance_invalidations
+func NewRedisInvalidations(client redis.UniversalClient) BalanceInvalidations {
+	return &redisInvalidations{client: client}
+}
+
+func (i *redisInvalidations) Publish(ctx context.Context, walletID string) error {
+	return i.client.Publish(ctx, balanceInvalidationsChannel, walletID).Err()
+}
+
+func (i *redisInvalidations) Subscribe(ctx context.Context) (<-chan string, func(), error) {
+	pubsub := i.client.Subscribe(ctx, balanceInvalidationsChannel)
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return nil, nil, err
+	}
+
+	ch := make(chan string, subscriberBuffer)
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		defer close(ch)
+		defer pubsub.Close()
+		messages := pubsub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-messages:
+				if !ok {
+					return
+				}
+				// Пропущенное уведомление нельзя отбросить: запись осталась бы
+				// в кэше до истечения времени жизни
+				select {
+				case ch <- msg.Payload:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return ch, cancel, nil
+}
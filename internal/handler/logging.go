@@ -0,0 +1,39 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+)
+
+// LogLevel определяет подробность лога
+type LogLevel string
+
+const (
+	// LogLevelInfo - идентификаторы кошельков в логе заменяются хешем, суммы и балансы скрываются
+	LogLevelInfo LogLevel = "info"
+	// LogLevelDebug - идентификаторы и суммы пишутся в лог полностью
+	LogLevelDebug LogLevel = "debug"
+)
+
+// Замена скрытой суммы в логе
+const redactedAmount = "***"
+
+// logWalletID - идентификатор кошелька для лога. На уровне info вместо него
+// пишется начало SHA-256: по нему можно связать записи об одном кошельке,
+// но нельзя восстановить идентификатор.
+func (h *WalletHandler) logWalletID(walletID string) string {
+	if h.config.LogLevel == LogLevelDebug {
+		return walletID
+	}
+	sum := sha256.Sum256([]byte(walletID))
+	return "wallet:" + hex.EncodeToString(sum[:6])
+}
+
+// logAmount - сумма или баланс для лога; на уровне info точное значение не пишется
+func (h *WalletHandler) logAmount(amount float64) string {
+	if h.config.LogLevel == LogLevelDebug {
+		return strconv.FormatFloat(amount, 'f', 2, 64)
+	}
+	return redactedAmount
+}
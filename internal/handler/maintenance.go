@@ -0,0 +1,51 @@
+package handler
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+)
+
+// RejectWritesInMaintenance оборачивает пишущий эндпоинт: в режиме обслуживания
+// запрос отклоняется с 503 и Retry-After, чтение продолжает работать
+func (h *WalletHandler) RejectWritesInMaintenance(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if h.writeMaintenance(w, r) {
+			return
+		}
+		next(w, r)
+	}
+}
+
+// writeMaintenance отвечает 503 с Retry-After, если включён режим обслуживания,
+// и возвращает true, если ответ отправлен
+func (h *WalletHandler) writeMaintenance(w http.ResponseWriter, r *http.Request) bool {
+	if !h.maintenance.Load() {
+		return false
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(h.config.MaintenanceRetryAfter.Seconds()))))
+	h.writeError(w, r, ErrMaintenance, http.StatusServiceUnavailable)
+	return true
+}
+
+// HandleMaintenance включает (POST) или выключает (DELETE) режим обслуживания:
+// /api/v1/admin/maintenance
+func (h *WalletHandler) HandleMaintenance(w http.ResponseWriter, r *http.Request) {
+	if !h.isAdmin(r) {
+		h.writeError(w, r, ErrForbidden, http.StatusForbidden)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		h.maintenance.Store(true)
+	case http.MethodDelete:
+		h.maintenance.Store(false)
+	case http.MethodGet:
+	default:
+		h.writeError(w, r, ErrMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+
+	h.sendData(w, r, map[string]bool{"maintenance": h.maintenance.Load()})
+}
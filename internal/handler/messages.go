@@ -0,0 +1,246 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"wallet/internal/i18n"
+	wallet "wallet/internal/model"
+	"wallet/internal/service"
+)
+
+// Стабильные машинные коды сообщений об успехе. Клиенты должны опираться
+// на них, а не на текст, который зависит от языка.
+const (
+	CodeOperationSuccess = "operation.success"
+	CodeOperationQueued  = "operation.queued"
+	CodeDepositSuccess   = "deposit.success"
+	CodeWithdrawSuccess  = "withdraw.success"
+	CodeVoidSuccess      = "void.success"
+	CodeDryRun           = "operation.dry_run"
+)
+
+// errorCodes сопоставляет тексты ошибок обработчика со стабильными кодами.
+// Русские тексты констант служат переводом по умолчанию.
+var errorCodes = map[string]string{
+	ErrInsufficientFunds:    "wallet.insufficient_funds",
+	ErrWalletNotFound:       "wallet.not_found",
+	ErrMethodNotAllowed:     "request.method_not_allowed",
+	ErrInvalidUUID:          "request.invalid_wallet_id",
+	ErrBalanceRetrievalFail: "balance.retrieval_failed",
+	ErrJSONParseFail:        "request.invalid_json",
+	ErrInvalidOperation:     "operation.invalid_type",
+	ErrTransactionCommit:    "transaction.commit_failed",
+	ErrServerBusy:           "server.busy",
+	ErrParseRequest:         "request.parse_failed",
+	ErrTooManyRequests:      "server.too_many_requests",
+	ErrSerialization:        "server.serialization_failed",
+	ErrQueueAdd:             "queue.add_failed",
+	ErrSendResponse:         "server.response_failed",
+	ErrTxCreate:             "transaction.create_failed",
+	ErrBalanceGet:           "balance.get_failed",
+	ErrBalanceUpdate:        "balance.update_failed",
+	ErrTxRecord:             "transaction.record_failed",
+	ErrTxCommit:             "transaction.commit_error",
+	ErrHistoryGet:           "history.get_failed",
+	ErrInvalidTimestamp:     "request.invalid_timestamp",
+	ErrSnapshotCreate:       "snapshot.create_failed",
+	ErrHistoryArchive:       "history.archive_failed",
+	ErrEventPublish:         "events.publish_failed",
+	ErrEventSubscribe:       "events.subscribe_failed",
+	ErrWebSocketUpgrade:     "websocket.upgrade_required",
+	ErrWebSocketHijack:      "websocket.hijack_failed",
+	ErrInvalidSetting:       "settings.invalid_value",
+	ErrInvalidPriority:      "request.invalid_priority",
+	ErrPriorityNotAllowed:   "request.priority_not_allowed",
+	ErrUnsupportedMediaType: "request.unsupported_media_type",
+	ErrWalletLock:           "wallet.lock_failed",
+	ErrWalletBlocked:        "wallet.blocked",
+	ErrBlocklistCheck:       "blocklist.check_failed",
+	ErrBlocklistUpdate:      "blocklist.update_failed",
+	ErrForbidden:            "auth.forbidden",
+	ErrUnknownCurrency:      "currency.unknown",
+	ErrRatesUnavailable:     "currency.rates_unavailable",
+	ErrConversionDisabled:   "currency.conversion_disabled",
+	ErrWalletCreate:         "wallet.create_failed",
+	ErrInvalidAmountParam:   "request.invalid_amount",
+	ErrEmptyBody:            "request.empty_body",
+	ErrJSONTruncated:        "request.json_truncated",
+	ErrJSONSyntax:           "request.json_syntax",
+	ErrJSONFieldType:        "request.json_field_type",
+	ErrInvalidTransactionID: "request.invalid_transaction_id",
+	ErrTransactionNotFound:  "transaction.not_found",
+	ErrTransactionGet:       "transaction.get_failed",
+	ErrTransactionVoided:    "transaction.already_voided",
+	ErrTransactionIsVoid:    "transaction.void_not_voidable",
+	ErrTransactionVoid:      "transaction.void_failed",
+	ErrInvalidRange:         "request.invalid_range",
+	ErrQueueRead:            "queue.read_failed",
+	ErrMaintenance:          "server.maintenance",
+	ErrWalletClosed:         "wallet.closed",
+	ErrImportMalformedRow:   "import.malformed_row",
+	ErrImportRead:           "import.read_failed",
+	ErrOperationNotAllowed:  "wallet.operation_not_allowed",
+	ErrBalanceVersionStale:  "balance.version_stale",
+	ErrInvalidVersionHeader: "request.invalid_version_header",
+	ErrInvalidPageLimit:     "request.invalid_page_limit",
+	ErrInvalidPageCursor:    "request.invalid_page_cursor",
+	ErrInvalidSortField:     "request.invalid_sort_field",
+	ErrInvalidSortOrder:     "request.invalid_sort_order",
+	ErrInternal:             "server.internal_error",
+	ErrTotalsGet:            "totals.get_failed",
+	ErrResetDisabled:        "reset.disabled",
+	ErrResetReasonRequired:  "reset.reason_required",
+	ErrBalanceRebuild:       "balance.rebuild_failed",
+	ErrTimeout:              "server.timeout",
+	ErrOperationTimeout:     "operation.timeout",
+	ErrInvalidExpiresAt:     "request.invalid_expires_at",
+	ErrExpiresAtTooFar:      "request.expires_at_too_far",
+	ErrOperationNotFound:    "operation.not_found",
+	ErrOperationStatusGone:  "operation.status_gone",
+	ErrOperationStatusGet:   "operation.status_failed",
+	ErrListSizeExceeded:     "request.list_size_exceeded",
+	ErrInvalidSinceVersion:  "request.invalid_since_version",
+	ErrInvalidPollTimeout:   "request.invalid_poll_timeout",
+	ErrInvariantCheck:       "invariant.check_failed",
+	ErrInvariantLedgerMode:  "invariant.ledger_mode",
+	ErrCacheFlush:           "cache.flush_failed",
+	ErrReceiptsDisabled:     "receipt.disabled",
+	ErrInvalidReceipt:       "receipt.invalid",
+}
+
+// DefaultMessages возвращает встроенные русские тексты. Переводы на другие языки
+// загружаются из файлов каталога locales при старте.
+func DefaultMessages() *i18n.Bundle {
+	ru := map[string]string{
+		CodeOperationSuccess: SuccessOperation,
+		CodeOperationQueued:  SuccessQueueAdd,
+		CodeDepositSuccess:   SuccessDeposit,
+		CodeWithdrawSuccess:  SuccessWithdraw,
+		CodeVoidSuccess:      SuccessVoid,
+		CodeDryRun:           SuccessDryRun,
+	}
+	for message, code := range errorCodes {
+		ru[code] = message
+	}
+	for code, message := range service.ErrorMessages() {
+		ru[code] = message
+	}
+
+	bundle := i18n.NewBundle("ru")
+	bundle.Add("ru", ru)
+	return bundle
+}
+
+// successCode возвращает код сообщения об успешном выполнении операции
+func successCode(opType wallet.OperationType) string {
+	switch opType {
+	case wallet.DEPOSIT:
+		return CodeDepositSuccess
+	case wallet.WITHDRAW:
+		return CodeWithdrawSuccess
+	default:
+		return CodeOperationSuccess
+	}
+}
+
+// language выбирает язык ответа по заголовку Accept-Language
+func (h *WalletHandler) language(r *http.Request) string {
+	return h.messages.Negotiate(r.Header.Get("Accept-Language"))
+}
+
+// sendStatus отправляет статус операции: машинный код и текст на языке из Accept-Language.
+// Поле status сохранено для совместимости со старыми клиентами.
+func (h *WalletHandler) sendStatus(w http.ResponseWriter, r *http.Request, httpCode int, code string, extra map[string]interface{}) error {
+	body := map[string]interface{}{
+		"code":   code,
+		"status": h.messages.Translate(h.language(r), code),
+	}
+	for key, value := range extra {
+		body[key] = value
+	}
+	payload, err := h.applyNaming(body)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(httpCode)
+	return json.NewEncoder(w).Encode(payload)
+}
+
+// writeError отправляет ошибку обработчика на языке клиента
+func (h *WalletHandler) writeError(w http.ResponseWriter, r *http.Request, message string, status int) {
+	h.writeErrorResponse(w, r, status, ErrorResponse{
+		Error: h.translateMessage(h.language(r), message),
+		Code:  errorCodes[message],
+	})
+}
+
+// translateMessage переводит сообщение обработчика, если для него есть код
+func (h *WalletHandler) translateMessage(lang, message string) string {
+	if code, ok := errorCodes[message]; ok {
+		return h.messages.Translate(lang, code)
+	}
+	return message
+}
+
+// writeValidationError отправляет ошибку валидатора на языке клиента; ошибка
+// одного поля дополнительно описывается в Fields кодом вида amount.negative
+func (h *WalletHandler) writeValidationError(w http.ResponseWriter, r *http.Request, err error, status int) {
+	code, _, _ := service.ErrorCode(err)
+	body := ErrorResponse{
+		Error: h.translateValidationError(h.language(r), err),
+		Code:  code,
+	}
+	if field, fieldCode, ok := service.FieldErrorCode(err); ok {
+		body.Fields = []FieldError{{Field: field, Code: fieldCode, Message: body.Error}}
+	}
+	h.writeErrorResponse(w, r, status, body)
+}
+
+// writeWalletError отправляет ошибку выполнения операции
+func (h *WalletHandler) writeWalletError(w http.ResponseWriter, r *http.Request, err *WalletError) {
+	if _, _, ok := service.ErrorCode(err.Err); ok {
+		h.writeValidationError(w, r, err.Err, err.Code)
+		return
+	}
+	if errors.Is(err.Err, service.ErrWalletBlocked) {
+		h.writeWalletForbidden(w, r)
+		return
+	}
+	h.writeError(w, r, err.Message, err.Code)
+}
+
+// writeWalletForbidden отвечает на доступ к заблокированному кошельку: 403 с
+// причиной или, при ConcealForbiddenWallets, 404 как для несуществующего
+// кошелька. Прочие 403 (например, запрещённый для кошелька тип операции)
+// не скрываются: клиенту нельзя сообщать, что существующего кошелька нет.
+func (h *WalletHandler) writeWalletForbidden(w http.ResponseWriter, r *http.Request) {
+	if h.config.ConcealForbiddenWallets {
+		h.writeError(w, r, ErrWalletNotFound, http.StatusNotFound)
+		return
+	}
+	h.writeError(w, r, ErrWalletBlocked, http.StatusForbidden)
+}
+
+// translateValidationError переводит ошибку валидатора по её коду, сохраняя подробности
+// (например, недопустимое значение), которые идут после текста ошибки
+func (h *WalletHandler) translateValidationError(lang string, err error) string {
+	code, sentinel, ok := service.ErrorCode(err)
+	if !ok {
+		return err.Error()
+	}
+
+	full := err.Error()
+	text := h.messages.Translate(lang, code)
+	if i := strings.Index(full, sentinel.Error()); i >= 0 {
+		text += full[i+len(sentinel.Error()):]
+	}
+	if errors.Is(err, service.ErrValidation) {
+		text = h.messages.Translate(lang, service.CodeValidationFailed) + ": " + text
+	}
+	return text
+}
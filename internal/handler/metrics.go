@@ -0,0 +1,34 @@
+package handler
+
+import (
+	"time"
+
+	"wallet/internal/audit"
+	"wallet/internal/metrics"
+	wallet "wallet/internal/model"
+)
+
+const (
+	// Число выполненных операций с метками operation_type и result
+	MetricOperationsTotal = "wallet_operations_total"
+	// Длительность операции в секундах, включая ожидание блокировки и повторы
+	MetricOperationDuration = "wallet_operation_duration_seconds"
+	// Длина очереди операций после последней постановки, с меткой queue
+	MetricQueueLength = "wallet_queue_length"
+)
+
+// observeOperation учитывает итог и длительность выполненной операции
+func (h *WalletHandler) observeOperation(req *wallet.WalletRequest, walletErr *WalletError, elapsed time.Duration) {
+	result := audit.ResultSuccess
+	if walletErr != nil {
+		result = audit.ResultFailure
+	}
+	labels := metrics.Labels{"operation_type": string(req.OperationType), "result": result}
+	h.metrics.IncCounter(MetricOperationsTotal, labels)
+	h.metrics.ObserveHistogram(MetricOperationDuration, elapsed.Seconds(), labels)
+}
+
+// observeQueueLength запоминает длину очереди, в которую поставлена операция
+func (h *WalletHandler) observeQueueLength(priority wallet.Priority, length int64) {
+	h.metrics.SetGauge(MetricQueueLength, float64(length), metrics.Labels{"queue": operationsQueue(priority)})
+}
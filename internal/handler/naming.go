@@ -0,0 +1,74 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+)
+
+// JSONNaming определяет, как называются поля в теле успешного ответа
+type JSONNaming string
+
+const (
+	// JSONNamingSnake - имена полей как в тегах структур: wallet_id
+	JSONNamingSnake JSONNaming = "snake_case"
+	// JSONNamingCamel - имена полей в camelCase: walletId
+	JSONNamingCamel JSONNaming = "camelCase"
+)
+
+// applyNaming приводит имена полей ответа к JSONNaming из конфигурации. Теги
+// структур задают snake_case, поэтому для camelCase данные проходят через
+// JSON в обобщённый вид и ключи объектов переименовываются на всех уровнях.
+// Числа читаются как json.Number, чтобы не терять точность целых.
+func (h *WalletHandler) applyNaming(data interface{}) (interface{}, error) {
+	if h.config.JSONNaming != JSONNamingCamel {
+		return data, nil
+	}
+
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return renameKeys(value, camelCase), nil
+}
+
+func renameKeys(value interface{}, rename func(string) string) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		renamed := make(map[string]interface{}, len(value))
+		for key, item := range value {
+			renamed[rename(key)] = renameKeys(item, rename)
+		}
+		return renamed
+	case []interface{}:
+		for i, item := range value {
+			value[i] = renameKeys(item, rename)
+		}
+		return value
+	}
+	return value
+}
+
+// camelCase переводит имя из snake_case: balance_minor -> balanceMinor
+func camelCase(name string) string {
+	if !strings.Contains(name, "_") {
+		return name
+	}
+	parts := strings.Split(name, "_")
+	var b strings.Builder
+	b.WriteString(parts[0])
+	for _, part := range parts[1:] {
+		if part == "" {
+			continue
+		}
+		b.WriteString(strings.ToUpper(part[:1]))
+		b.WriteString(part[1:])
+	}
+	return b.String()
+}
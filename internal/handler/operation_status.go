@@ -0,0 +1,82 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	wallet "wallet/internal/model"
+)
+
+// Сколько запись о статусе хранится в кэше после истечения OperationStatusTTL:
+// в это время на запрос отвечается 410, а не 404, как для неизвестной операции
+const operationStatusGrace = 24 * time.Hour
+
+// storedOperationStatus - итог операции в кэше с моментом записи в миллисекундах
+type storedOperationStatus struct {
+	wallet.OperationResult
+	RecordedAt int64 `json:"recorded_at"`
+}
+
+func operationStatusKey(id string) string {
+	return "operation:" + id
+}
+
+// recordOperationStatus сохраняет итог операции из очереди. Запись идёт через
+// фоновый писатель кэша: потеря статуса не должна задерживать очередь.
+func (h *WalletHandler) recordOperationStatus(result wallet.OperationResult) {
+	if h.config.OperationStatusTTL <= 0 || result.ID == "" {
+		return
+	}
+	value, err := json.Marshal(storedOperationStatus{
+		OperationResult: result,
+		RecordedAt:      h.clock.Now().UnixMilli(),
+	})
+	if err != nil {
+		return
+	}
+	h.enqueueCacheWrite(operationStatusKey(result.ID), string(value), h.config.OperationStatusTTL+operationStatusGrace)
+}
+
+// HandleOperationStatus возвращает итог операции из очереди:
+// GET /api/v1/operations/{id}. Статус, срок хранения которого истёк,
+// отвечает 410, а никогда не существовавший - 404.
+func (h *WalletHandler) HandleOperationStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.writeError(w, r, ErrMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := r.PathValue("id")
+	if id == "" {
+		h.writeError(w, r, ErrOperationNotFound, http.StatusNotFound)
+		return
+	}
+
+	raw, err := h.cache.Get(r.Context(), operationStatusKey(id))
+	if errors.Is(err, redis.Nil) {
+		h.writeError(w, r, ErrOperationNotFound, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		h.writeReadError(r.Context(), w, r, err, ErrOperationStatusGet)
+		return
+	}
+
+	var stored storedOperationStatus
+	if err := json.Unmarshal([]byte(raw), &stored); err != nil {
+		h.writeError(w, r, ErrOperationStatusGet, http.StatusServiceUnavailable)
+		return
+	}
+	if h.clock.Now().Sub(time.UnixMilli(stored.RecordedAt)) > h.config.OperationStatusTTL {
+		h.writeError(w, r, ErrOperationStatusGone, http.StatusGone)
+		return
+	}
+
+	if err := h.sendData(w, r, stored.OperationResult); err != nil {
+		h.writeError(w, r, ErrSendResponse, http.StatusServiceUnavailable)
+	}
+}
@@ -0,0 +1,121 @@
+package handler
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Потолок размера списка в ответе, если MaxListSize не задан
+const defaultMaxListSize = 100
+
+// Page - общий формат ответа списочных эндпоинтов
+type Page[T any] struct {
+	Items []T      `json:"items"`
+	Page  PageInfo `json:"page"`
+}
+
+// PageInfo - положение страницы: курсор следующей страницы пуст, если она последняя
+type PageInfo struct {
+	NextCursor string `json:"next_cursor,omitempty"`
+	HasMore    bool   `json:"has_more"`
+	Limit      int    `json:"limit"`
+}
+
+// newPage строит страницу из items, запрошенных с запасом в один элемент сверх limit:
+// лишний элемент означает, что есть следующая страница, и в ответ не попадает.
+// cursor возвращает курсор, с которого продолжается список после элемента.
+func newPage[T any](items []T, limit int, cursor func(T) string) Page[T] {
+	page := Page[T]{Items: items, Page: PageInfo{Limit: limit}}
+	if page.Items == nil {
+		page.Items = make([]T, 0)
+	}
+	if len(items) > limit {
+		page.Items = items[:limit]
+		page.Page.HasMore = true
+		page.Page.NextCursor = cursor(page.Items[limit-1])
+	}
+	return page
+}
+
+// pageLimit - необязательный размер страницы ?limit от 1; по умолчанию def.
+// Верхняя граница проверяется отдельно, в capListSize.
+func pageLimit(r *http.Request, def int, dest *int) rule {
+	return func() error {
+		*dest = def
+		raw := r.URL.Query().Get("limit")
+		if raw == "" {
+			return nil
+		}
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 {
+			return errors.New(ErrInvalidPageLimit)
+		}
+		*dest = limit
+		return nil
+	}
+}
+
+// maxListSize - потолок числа элементов в ответе списочного эндпоинта
+func (h *WalletHandler) maxListSize() int {
+	if h.config.MaxListSize > 0 {
+		return h.config.MaxListSize
+	}
+	return defaultMaxListSize
+}
+
+// capListSize применяет потолок MaxListSize к запрошенному размеру списка:
+// при ClampListLimit уменьшает его до потолка, иначе отвечает 400.
+// Возвращает false, если ответ уже отправлен.
+func (h *WalletHandler) capListSize(w http.ResponseWriter, r *http.Request, size *int) bool {
+	ceiling := h.maxListSize()
+	if *size <= ceiling {
+		return true
+	}
+	if h.config.ClampListLimit {
+		*size = ceiling
+		return true
+	}
+	h.writeErrorf(w, r, ErrListSizeExceeded, http.StatusBadRequest, ceiling)
+	return false
+}
+
+// timeCursor - позиция в списке, упорядоченном по (created_at, id)
+type timeCursor struct {
+	At time.Time
+	ID string
+}
+
+func (c timeCursor) String() string {
+	raw := c.At.UTC().Format(time.RFC3339Nano) + "|" + c.ID
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// pageCursor - необязательный курсор ?cursor из next_cursor предыдущей страницы
+func pageCursor(r *http.Request, dest **timeCursor) rule {
+	return func() error {
+		raw := r.URL.Query().Get("cursor")
+		if raw == "" {
+			return nil
+		}
+		decoded, err := base64.RawURLEncoding.DecodeString(raw)
+		if err != nil {
+			return errors.New(ErrInvalidPageCursor)
+		}
+		at, id, _ := strings.Cut(string(decoded), "|")
+		parsed, err := time.Parse(time.RFC3339Nano, at)
+		if err != nil {
+			return errors.New(ErrInvalidPageCursor)
+		}
+		if _, err := uuid.Parse(id); err != nil {
+			return errors.New(ErrInvalidPageCursor)
+		}
+		*dest = &timeCursor{At: parsed, ID: id}
+		return nil
+	}
+}
@@ -0,0 +1,71 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	wallet "wallet/internal/model"
+)
+
+var (
+	// Порядок, в котором обработчики опрашивают очереди
+	priorityQueueKeys = []string{highOperationsQueueKey, operationsQueueKey, legacyOperationsQueueKey, lowOperationsQueueKey}
+	// Обратный порядок для каждой LowPriorityEvery-й операции: при постоянном
+	// потоке срочных операций обычные и низкоприоритетные всё равно обрабатываются
+	starvationQueueKeys = []string{lowOperationsQueueKey, legacyOperationsQueueKey, operationsQueueKey, highOperationsQueueKey}
+)
+
+// operationsQueue - очередь для операций с приоритетом p
+func operationsQueue(p wallet.Priority) string {
+	switch p {
+	case wallet.PriorityHigh:
+		return highOperationsQueueKey
+	case wallet.PriorityLow:
+		return lowOperationsQueueKey
+	default:
+		return operationsQueueKey
+	}
+}
+
+// payloadPriority - приоритет сериализованной операции; для нечитаемой - обычный
+func payloadPriority(payload string) wallet.Priority {
+	var op struct {
+		Priority wallet.Priority `json:"priority"`
+	}
+	if err := json.Unmarshal([]byte(payload), &op); err != nil {
+		return wallet.PriorityNormal
+	}
+	return op.Priority
+}
+
+// nextQueueKeys - очереди для очередного BRPOP в порядке опроса
+func (h *WalletHandler) nextQueueKeys() []string {
+	every := int64(h.config.LowPriorityEvery)
+	if every > 0 && h.queuePops.Add(1)%every == 0 {
+		return starvationQueueKeys
+	}
+	return priorityQueueKeys
+}
+
+// priorityField - приоритет операции из запроса. Без явного приоритета операции
+// администратора срочные, остальные - обычные. Высокий приоритет доступен только
+// администратору и доверенным вызовам, понизить приоритет может любой клиент.
+func (h *WalletHandler) priorityField(r *http.Request, raw wallet.Priority, dest *wallet.Priority) rule {
+	return func() error {
+		privileged := h.isAdmin(r) || h.isTrustedCaller(r)
+		switch {
+		case !raw.Valid():
+			return errors.New(ErrInvalidPriority)
+		case raw == "" && h.isAdmin(r):
+			*dest = wallet.PriorityHigh
+		case raw == "":
+			*dest = wallet.PriorityNormal
+		case raw == wallet.PriorityHigh && !privileged:
+			return errors.New(ErrPriorityNotAllowed)
+		default:
+			*dest = raw
+		}
+		return nil
+	}
+}
@@ -0,0 +1,186 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	// Лимитер адреса или субъекта, не использовавшийся дольше этого срока, удаляется
+	keyedLimiterIdleTTL = 10 * time.Minute
+	// При таком числе лимитеров выполняется очистка неиспользуемых, но не чаще
+	// раза в keyedLimiterSweepInterval: полный обход на каждый запрос при потоке
+	// новых ключей сам стал бы нагрузкой
+	keyedLimiterSweepSize     = 10000
+	keyedLimiterSweepInterval = time.Minute
+	// Больше лимитеров не создаётся: новые ключи делят один общий лимитер,
+	// поэтому поток запросов с постоянно новыми ключами не растит память
+	keyedLimiterMaxSize = 100000
+)
+
+type keyedLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// keyedLimiters - отдельный лимитер на каждый ключ (адрес клиента, субъект)
+// с общими лимитом и всплеском
+type keyedLimiters struct {
+	mu        sync.Mutex
+	limit     rate.Limit
+	burst     int
+	maxSize   int
+	limiters  map[string]*keyedLimiter
+	overflow  *rate.Limiter
+	lastSweep time.Time
+}
+
+// newKeyedLimiters возвращает nil при нулевом лимите: ограничение отключено
+func newKeyedLimiters(limit float64, burst int) *keyedLimiters {
+	if limit <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = 1
+	}
+	return &keyedLimiters{
+		limit:    rate.Limit(limit),
+		burst:    burst,
+		maxSize:  keyedLimiterMaxSize,
+		limiters: make(map[string]*keyedLimiter),
+		overflow: rate.NewLimiter(rate.Limit(limit), burst),
+	}
+}
+
+func (l *keyedLimiters) get(key string) *rate.Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if len(l.limiters) >= keyedLimiterSweepSize && now.Sub(l.lastSweep) > keyedLimiterSweepInterval {
+		l.lastSweep = now
+		for k, entry := range l.limiters {
+			if now.Sub(entry.lastSeen) > keyedLimiterIdleTTL {
+				delete(l.limiters, k)
+			}
+		}
+	}
+
+	entry, ok := l.limiters[key]
+	if !ok {
+		if len(l.limiters) >= l.maxSize {
+			return l.overflow
+		}
+		entry = &keyedLimiter{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.limiters[key] = entry
+	}
+	entry.lastSeen = now
+	return entry.limiter
+}
+
+// clientIP - адрес клиента для лимита. Это RemoteAddr, а X-Forwarded-For
+// учитывается, только если запрос пришёл от доверенного прокси: заголовок
+// читается справа налево и берётся первый адрес не из TrustedProxies.
+// Левые записи заголовка клиент задаёт сам, поэтому им не доверяем.
+func (h *WalletHandler) clientIP(r *http.Request) string {
+	remote := sourceIP(r)
+	if !h.isTrustedProxy(remote) {
+		return remote
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	client := remote
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		client = hop
+		if !h.isTrustedProxy(hop) {
+			break
+		}
+	}
+	return client
+}
+
+func (h *WalletHandler) isTrustedProxy(raw string) bool {
+	addr, err := netip.ParseAddr(raw)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range h.config.TrustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseTrustedProxies разбирает список адресов и подсетей через запятую:
+// "10.0.0.0/8, 192.168.1.10"
+func ParseTrustedProxies(raw string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, item := range strings.Split(raw, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if strings.Contains(item, "/") {
+			prefix, err := netip.ParsePrefix(item)
+			if err != nil {
+				return nil, fmt.Errorf("%q: %w", item, err)
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(item)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %w", item, err)
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+	}
+	return prefixes, nil
+}
+
+// reserveRateLimit резервирует токены глобального лимитера, лимитера адреса
+// клиента и, для аутентифицированного запроса, лимитера субъекта. Запрос
+// проходит, только если токен доступен сразу во всех; иначе резервы отменяются
+// и возвращается наибольшее из ожиданий - побеждает самый строгий лимитер.
+func (h *WalletHandler) reserveRateLimit(r *http.Request) (time.Duration, bool) {
+	limiters := []*rate.Limiter{h.rateLimiter}
+	if h.ipLimiters != nil {
+		limiters = append(limiters, h.ipLimiters.get(h.clientIP(r)))
+	}
+	if subject := h.auditSubject(r); subject != anonymousSubject && h.subjectLimiters != nil {
+		limiters = append(limiters, h.subjectLimiters.get(subject))
+	}
+
+	// Резервы и их отмена выполняются на один момент: иначе Cancel не вернёт
+	// токен резерва, который уже можно было использовать
+	now := time.Now()
+	var delay time.Duration
+	reservations := make([]*rate.Reservation, 0, len(limiters))
+	for _, limiter := range limiters {
+		reservation := limiter.ReserveN(now, 1)
+		reservations = append(reservations, reservation)
+		if !reservation.OK() {
+			delay = max(delay, time.Second)
+			continue
+		}
+		delay = max(delay, reservation.DelayFrom(now))
+	}
+
+	if delay > 0 {
+		for _, reservation := range reservations {
+			reservation.CancelAt(now)
+		}
+		return delay, false
+	}
+	return 0, true
+}
@@ -0,0 +1,140 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"wallet/internal/audit"
+)
+
+// RebuildResult - итог пересчёта баланса; ненулевой Delta означает, что
+// сохранённый баланс расходился с журналом операций
+type RebuildResult struct {
+	WalletID        uuid.UUID `json:"wallet_id"`
+	PreviousBalance Balance   `json:"previous_balance"`
+	Balance         Balance   `json:"balance"`
+	Delta           Balance   `json:"delta"`
+	Corrected       bool      `json:"corrected"`
+}
+
+// HandleWalletRebuild пересчитывает баланс кошелька по журналу операций:
+// POST /api/v1/admin/wallets/{uuid}/rebuild. Нужен для восстановления после сбоя,
+// когда колонка wallets.balance разошлась с историей. Закрытые кошельки тоже
+// пересчитываются.
+func (h *WalletHandler) HandleWalletRebuild(w http.ResponseWriter, r *http.Request) {
+	if !h.isAdmin(r) {
+		h.writeError(w, r, ErrForbidden, http.StatusForbidden)
+		return
+	}
+	if r.Method != http.MethodPost {
+		h.writeError(w, r, ErrMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+
+	rawID := strings.TrimPrefix(r.URL.Path, "/api/v1/admin/wallets/")
+	rawID = strings.TrimSuffix(rawID, "/rebuild")
+	var walletID uuid.UUID
+	if !h.validate(w, r, h.pathID(rawID, &walletID, ErrInvalidUUID)) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), h.config.OperationTimeout)
+	defer cancel()
+
+	release := h.acquireWriteSlot()
+	result, walletErr := h.rebuildBalance(ctx, walletID)
+	release()
+
+	entry := h.requestAuditEntry(r, AuditActionRebuild, walletID.String(), nil)
+	entry.Amount = float64(result.Delta.BalanceMinor) / 100
+	if walletErr != nil {
+		entry.Result = audit.ResultFailure
+		entry.Error = walletErr.Error()
+	}
+	h.recordAudit(entry)
+
+	if walletErr != nil {
+		h.writeWalletError(w, r, walletErr)
+		return
+	}
+
+	h.sendData(w, r, result)
+}
+
+// rebuildBalance под блокировкой кошелька суммирует журнал тем же запросом, что и
+// режим BalanceModeLedger, и записывает сумму в wallets.balance, если она отличается
+func (h *WalletHandler) rebuildBalance(ctx context.Context, walletID uuid.UUID) (RebuildResult, *WalletError) {
+	result := RebuildResult{WalletID: walletID}
+
+	dbTx, err := h.beginTx(ctx)
+	if err != nil {
+		return result, &WalletError{
+			Code:    http.StatusInternalServerError,
+			Message: ErrTxCreate,
+			Err:     err,
+		}
+	}
+	defer dbTx.Rollback()
+
+	// Сохранённый баланс читается из колонки при любом BalanceMode
+	tx := newPostgresTx(h.repository, dbTx, h.config.LockStrategy)
+	stored, err := tx.LockWallet(ctx, walletID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return result, &WalletError{Code: http.StatusNotFound, Message: ErrWalletNotFound, Err: err}
+		}
+		return result, &WalletError{
+			Code:    http.StatusInternalServerError,
+			Message: ErrBalanceGet,
+			Err:     err,
+		}
+	}
+
+	var rebuilt float64
+	if err := dbTx.QueryRowContext(ctx, ledgerBalanceQuery, walletID).Scan(&rebuilt); err != nil {
+		return result, &WalletError{
+			Code:    http.StatusInternalServerError,
+			Message: ErrBalanceRebuild,
+			Err:     err,
+		}
+	}
+
+	// Разница считается в копейках, чтобы погрешность float не выглядела расхождением
+	result.PreviousBalance = newBalance(stored.Balance)
+	result.Balance = newBalance(rebuilt)
+	result.Delta = newBalanceMinor(result.Balance.BalanceMinor - result.PreviousBalance.BalanceMinor)
+	if result.Delta.BalanceMinor == 0 {
+		return result, nil
+	}
+
+	if err := h.updateBalance(ctx, tx, walletID, rebuilt); err != nil {
+		return result, &WalletError{
+			Code:    http.StatusInternalServerError,
+			Message: ErrBalanceUpdate,
+			Err:     err,
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return result, &WalletError{
+			Code:    http.StatusInternalServerError,
+			Message: ErrTxCommit,
+			Err:     err,
+		}
+	}
+	result.Corrected = true
+
+	log.Printf("Баланс кошелька %s пересчитан по журналу: %s -> %s", h.logWalletID(walletID.String()), h.logAmount(stored.Balance), h.logAmount(rebuilt))
+	// Закэшированный баланс устарел
+	h.cache.Delete(ctx, fmt.Sprintf("balance:%s", walletID))
+	h.invalidateLocalBalance(walletID.String())
+	h.publishEvent(balanceEvent(walletID.String(), rebuilt))
+
+	return result, nil
+}
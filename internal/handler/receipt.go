@@ -0,0 +1,119 @@
+package handler
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	wallet "wallet/internal/model"
+)
+
+// errInvalidReceipt - квитанция повреждена или подписана другим ключом
+var errInvalidReceipt = errors.New("invalid receipt")
+
+// Receipt - подтверждение выполненной операции. Клиент может предъявить его
+// позже как доказательство: подпись HMAC-SHA256 ключом ReceiptKey не даёт
+// изменить ни одно поле.
+type Receipt struct {
+	OperationID   string               `json:"operation_id,omitempty"`
+	WalletID      string               `json:"wallet_id"`
+	OperationType wallet.OperationType `json:"operation_type"`
+	Amount        float64              `json:"amount"`
+	Balance       float64              `json:"balance"`
+	IssuedAt      time.Time            `json:"issued_at"`
+}
+
+// ReceiptVerifyRequest - тело POST /api/v1/receipts/verify
+type ReceiptVerifyRequest struct {
+	Receipt string `json:"receipt"`
+}
+
+// issueReceipt подписывает квитанцию о выполненной операции. Без ReceiptKey
+// квитанции не выдаются и возвращается пустая строка.
+func (h *WalletHandler) issueReceipt(req *wallet.WalletRequest, balance float64) string {
+	if h.config.ReceiptKey == "" || req.DryRun {
+		return ""
+	}
+	payload, err := json.Marshal(Receipt{
+		OperationID:   req.ID,
+		WalletID:      req.WalletID,
+		OperationType: req.OperationType,
+		Amount:        req.Amount,
+		Balance:       balance,
+		IssuedAt:      h.clock.Now().UTC(),
+	})
+	if err != nil {
+		return ""
+	}
+	encoding := base64.RawURLEncoding
+	return encoding.EncodeToString(payload) + "." + encoding.EncodeToString(h.signReceipt(payload))
+}
+
+// verifyReceipt проверяет подпись квитанции и возвращает её содержимое
+func (h *WalletHandler) verifyReceipt(token string) (Receipt, error) {
+	var receipt Receipt
+	rawPayload, rawSignature, ok := strings.Cut(token, ".")
+	if !ok {
+		return receipt, errInvalidReceipt
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(rawPayload)
+	if err != nil {
+		return receipt, errInvalidReceipt
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(rawSignature)
+	if err != nil {
+		return receipt, errInvalidReceipt
+	}
+	if !hmac.Equal(signature, h.signReceipt(payload)) {
+		return receipt, errInvalidReceipt
+	}
+	if err := json.Unmarshal(payload, &receipt); err != nil {
+		return receipt, errInvalidReceipt
+	}
+	return receipt, nil
+}
+
+func (h *WalletHandler) signReceipt(payload []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(h.config.ReceiptKey))
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// HandleReceiptVerify проверяет квитанцию операции: POST /api/v1/receipts/verify.
+// Подлинная квитанция возвращается расшифрованной, поддельная или изменённая
+// отклоняется с 422.
+func (h *WalletHandler) HandleReceiptVerify(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.writeError(w, r, ErrMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+	if h.config.ReceiptKey == "" {
+		h.writeError(w, r, ErrReceiptsDisabled, http.StatusNotImplemented)
+		return
+	}
+	if !isJSONContentType(r.Header.Get("Content-Type")) {
+		h.writeError(w, r, ErrUnsupportedMediaType, http.StatusUnsupportedMediaType)
+		return
+	}
+
+	var req ReceiptVerifyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeDecodeError(w, r, err)
+		return
+	}
+
+	receipt, err := h.verifyReceipt(strings.TrimSpace(req.Receipt))
+	if err != nil {
+		h.writeError(w, r, ErrInvalidReceipt, http.StatusUnprocessableEntity)
+		return
+	}
+
+	if err := h.sendData(w, r, receipt); err != nil {
+		h.writeError(w, r, ErrSendResponse, http.StatusServiceUnavailable)
+	}
+}
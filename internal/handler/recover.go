@@ -0,0 +1,42 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+	"runtime/debug"
+)
+
+// RecoverPanics оборачивает сервер: паника в обработчике записывается в журнал
+// со стеком и идентификатором запроса, а клиент получает 500 в JSON вместо
+// оборванного соединения. http.ErrAbortHandler пропускается дальше - это
+// штатный способ прервать ответ.
+func (h *WalletHandler) RecoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+			if recovered == http.ErrAbortHandler {
+				panic(recovered)
+			}
+
+			id := requestID(r)
+			h.panics.Add(1)
+			log.Printf("Паника при обработке %s %s, request_id=%s: %v\n%s", r.Method, r.URL.Path, id, recovered, debug.Stack())
+
+			w.Header().Set("X-Request-ID", id)
+			h.writeErrorResponse(w, r, http.StatusInternalServerError, ErrorResponse{
+				Error:     h.translateMessage(h.language(r), ErrInternal),
+				Code:      errorCodes[ErrInternal],
+				RequestID: id,
+			})
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RecoveredPanics возвращает число паник, перехваченных RecoverPanics
+func (h *WalletHandler) RecoveredPanics() int64 {
+	return h.panics.Load()
+}
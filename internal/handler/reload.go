@@ -0,0 +1,186 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Settings - настройки, которые меняются без перезапуска сервиса через
+// /api/v1/admin/config. Начальные значения берутся из Config.
+type Settings struct {
+	RateLimit            float64
+	RateBurst            int
+	MaxWriteTransactions int
+	BalanceSoftTTL       time.Duration
+	OperationDedupWindow time.Duration
+}
+
+func settingsFromConfig(config Config) Settings {
+	return Settings{
+		RateLimit:            config.RateLimit,
+		RateBurst:            config.RateBurst,
+		MaxWriteTransactions: config.MaxWriteTransactions,
+		BalanceSoftTTL:       config.BalanceSoftTTL,
+		OperationDedupWindow: config.OperationDedupWindow,
+	}
+}
+
+// writeLimit - слоты пишущих транзакций одного размера
+type writeLimit struct {
+	slots chan struct{}
+}
+
+func newWriteLimit(size int) *writeLimit {
+	if size <= 0 {
+		return nil
+	}
+	return &writeLimit{slots: make(chan struct{}, size)}
+}
+
+// settings возвращает текущие настройки; значение не меняется после чтения
+func (h *WalletHandler) settings() Settings {
+	return *h.currentSettings.Load()
+}
+
+// ApplySettings изменяет настройки работающего обработчика: update получает
+// копию текущих настроек и меняет нужные поля. Чтение, изменение и применение
+// выполняются под reloadMu, поэтому одновременные частичные изменения не
+// затирают друг друга. Ошибка update оставляет настройки прежними и возвращается.
+//
+// Лимитер запросов меняется на месте. Слоты пишущих транзакций создаются
+// заново: уже занятые освобождаются в прежний набор, поэтому до их завершения
+// одновременных транзакций может быть больше нового предела.
+func (h *WalletHandler) ApplySettings(update func(*Settings) error) error {
+	h.reloadMu.Lock()
+	defer h.reloadMu.Unlock()
+
+	previous := h.currentSettings.Load()
+	var settings Settings
+	if previous != nil {
+		settings = *previous
+	}
+	if err := update(&settings); err != nil {
+		return err
+	}
+
+	h.rateLimiter.SetLimit(rate.Limit(settings.RateLimit))
+	h.rateLimiter.SetBurst(settings.RateBurst)
+	if previous == nil || previous.MaxWriteTransactions != settings.MaxWriteTransactions {
+		h.writeLimit.Store(newWriteLimit(settings.MaxWriteTransactions))
+	}
+	h.currentSettings.Store(&settings)
+	return nil
+}
+
+// settingsRequest - изменение настроек; отсутствующие поля сохраняют текущие
+// значения, длительности задаются строкой вида "30s"
+type settingsRequest struct {
+	RateLimit            *float64 `json:"rate_limit"`
+	RateBurst            *int     `json:"rate_burst"`
+	MaxWriteTransactions *int     `json:"max_write_transactions"`
+	BalanceSoftTTL       *string  `json:"balance_soft_ttl"`
+	OperationDedupWindow *string  `json:"operation_dedup_window"`
+}
+
+// settingsResponse - текущие настройки в ответе эндпоинта
+type settingsResponse struct {
+	RateLimit            float64 `json:"rate_limit"`
+	RateBurst            int     `json:"rate_burst"`
+	MaxWriteTransactions int     `json:"max_write_transactions"`
+	BalanceSoftTTL       string  `json:"balance_soft_ttl"`
+	OperationDedupWindow string  `json:"operation_dedup_window"`
+}
+
+func newSettingsResponse(settings Settings) settingsResponse {
+	return settingsResponse{
+		RateLimit:            settings.RateLimit,
+		RateBurst:            settings.RateBurst,
+		MaxWriteTransactions: settings.MaxWriteTransactions,
+		BalanceSoftTTL:       settings.BalanceSoftTTL.String(),
+		OperationDedupWindow: settings.OperationDedupWindow.String(),
+	}
+}
+
+// HandleSettings возвращает (GET) или изменяет (POST) настройки, применяемые
+// без перезапуска: /api/v1/admin/config
+func (h *WalletHandler) HandleSettings(w http.ResponseWriter, r *http.Request) {
+	if !h.isAdmin(r) {
+		h.writeError(w, r, ErrForbidden, http.StatusForbidden)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var req settingsRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			h.writeDecodeError(w, r, err)
+			return
+		}
+		err := h.ApplySettings(func(settings *Settings) error {
+			return checkRules(
+				positiveSetting(req.RateLimit, &settings.RateLimit),
+				positiveSetting(req.RateBurst, &settings.RateBurst),
+				nonNegativeSetting(req.MaxWriteTransactions, &settings.MaxWriteTransactions),
+				durationSetting(req.BalanceSoftTTL, &settings.BalanceSoftTTL),
+				durationSetting(req.OperationDedupWindow, &settings.OperationDedupWindow),
+			)
+		})
+		if err != nil {
+			h.writeError(w, r, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+	default:
+		h.writeError(w, r, ErrMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+
+	h.sendData(w, r, newSettingsResponse(h.settings()))
+}
+
+// positiveSetting - необязательное положительное значение настройки
+func positiveSetting[T int | float64](value *T, dest *T) rule {
+	return func() error {
+		if value == nil {
+			return nil
+		}
+		if *value <= 0 {
+			return errors.New(ErrInvalidSetting)
+		}
+		*dest = *value
+		return nil
+	}
+}
+
+// nonNegativeSetting - необязательное значение настройки, где 0 снимает ограничение
+func nonNegativeSetting(value *int, dest *int) rule {
+	return func() error {
+		if value == nil {
+			return nil
+		}
+		if *value < 0 {
+			return errors.New(ErrInvalidSetting)
+		}
+		*dest = *value
+		return nil
+	}
+}
+
+// durationSetting - необязательная неотрицательная длительность, 0 отключает функцию
+func durationSetting(value *string, dest *time.Duration) rule {
+	return func() error {
+		if value == nil {
+			return nil
+		}
+		d, err := time.ParseDuration(*value)
+		if err != nil || d < 0 {
+			return errors.New(ErrInvalidSetting)
+		}
+		*dest = d
+		return nil
+	}
+}
@@ -0,0 +1,79 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	wallet "wallet/internal/model"
+
+	"github.com/google/uuid"
+)
+
+const (
+	selectBalanceForUpdateQuery = "SELECT balance, closed_at IS NOT NULL, NOT allow_deposit, NOT allow_withdraw FROM wallets WHERE id = $1 FOR UPDATE"
+	selectBalanceQuery          = "SELECT balance, closed_at IS NOT NULL, NOT allow_deposit, NOT allow_withdraw FROM wallets WHERE id = $1"
+	advisoryLockQuery           = "SELECT pg_advisory_xact_lock(hashtext($1))"
+	createWalletQuery           = "INSERT INTO wallets (id, balance) VALUES ($1, 0) ON CONFLICT (id) DO NOTHING"
+	// Каждое изменение баланса увеличивает его версию
+	updateBalanceQuery = "UPDATE wallets SET balance = $1, version = version + 1 WHERE id = $2"
+	// Чтение баланса вне транзакции
+	selectWalletBalanceQuery = "SELECT balance, closed_at IS NOT NULL, version FROM wallets WHERE id = $1"
+	insertTransactionQuery   = `
+		INSERT INTO transactions (wallet_id, amount, operation_type, reference, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`
+)
+
+// WalletRepository - SQL-запросы к таблицам wallets и transactions. Чтение
+// вне транзакции идёт через DBInterface, остальные методы выполняются в
+// переданной транзакции. Отсутствующий кошелек обозначается ошибкой sql.ErrNoRows.
+type WalletRepository struct {
+	db DBInterface
+}
+
+func NewWalletRepository(db DBInterface) *WalletRepository {
+	return &WalletRepository{db: db}
+}
+
+// GetBalance читает баланс, версию и признак закрытия кошелька без блокировки
+func (r *WalletRepository) GetBalance(ctx context.Context, walletID uuid.UUID) (StoredWallet, error) {
+	var stored StoredWallet
+	err := r.db.QueryRowContext(ctx, selectWalletBalanceQuery, walletID).Scan(&stored.Balance, &stored.Closed, &stored.Version)
+	return stored, err
+}
+
+// GetBalanceForUpdate блокирует кошелек до конца транзакции tx способом
+// lockStrategy и читает его баланс и ограничения операций
+func (r *WalletRepository) GetBalanceForUpdate(ctx context.Context, tx TxInterface, walletID uuid.UUID, lockStrategy LockStrategy) (StoredWallet, error) {
+	query := selectBalanceForUpdateQuery
+	if lockStrategy == LockStrategyAdvisory {
+		// Блокировка держится до конца транзакции, строка при чтении не блокируется
+		if _, err := tx.ExecContext(ctx, advisoryLockQuery, walletID.String()); err != nil {
+			return StoredWallet{}, fmt.Errorf("%s: %w", ErrWalletLock, err)
+		}
+		query = selectBalanceQuery
+	}
+
+	var stored StoredWallet
+	err := tx.QueryRowContext(ctx, query, walletID).Scan(&stored.Balance, &stored.Closed, &stored.DepositDisabled, &stored.WithdrawDisabled)
+	return stored, err
+}
+
+// CreateWallet создаёт кошелек с нулевым балансом; существующий кошелек не меняется
+func (r *WalletRepository) CreateWallet(ctx context.Context, tx TxInterface, walletID uuid.UUID) error {
+	_, err := tx.ExecContext(ctx, createWalletQuery, walletID)
+	return err
+}
+
+// UpdateBalance записывает новый баланс и увеличивает версию кошелька
+func (r *WalletRepository) UpdateBalance(ctx context.Context, tx TxInterface, walletID uuid.UUID, balance float64) error {
+	_, err := tx.ExecContext(ctx, updateBalanceQuery, balance, walletID)
+	return err
+}
+
+// InsertTransaction добавляет операцию в журнал transactions
+func (r *WalletRepository) InsertTransaction(ctx context.Context, tx TxInterface, walletID uuid.UUID, amount float64, operationType wallet.OperationType, reference string, createdAt time.Time) error {
+	_, err := tx.ExecContext(ctx, insertTransactionQuery, walletID, amount, operationType, reference, createdAt)
+	return err
+}
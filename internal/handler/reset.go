@@ -0,0 +1,158 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"wallet/internal/audit"
+	wallet "wallet/internal/model"
+	"wallet/internal/service"
+)
+
+// ResetRequest - тело запроса обнуления баланса
+type ResetRequest struct {
+	Reason string `json:"reason"`
+}
+
+// HandleWalletReset обнуляет баланс кошелька: POST /api/v1/admin/wallets/{uuid}/reset.
+// Прежний баланс списывается записью ADJUSTMENT с причиной в комментарии, поэтому
+// история и баланс на момент времени остаются согласованными; нулевой баланс
+// остаётся без записи. В production
+// эндпоинт отключён, пока не включён AllowBalanceReset.
+func (h *WalletHandler) HandleWalletReset(w http.ResponseWriter, r *http.Request) {
+	if !h.isAdmin(r) {
+		h.writeError(w, r, ErrForbidden, http.StatusForbidden)
+		return
+	}
+	if r.Method != http.MethodPost {
+		h.writeError(w, r, ErrMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+	if h.config.Production && !h.config.AllowBalanceReset {
+		h.writeError(w, r, ErrResetDisabled, http.StatusForbidden)
+		return
+	}
+
+	rawID := strings.TrimPrefix(r.URL.Path, "/api/v1/admin/wallets/")
+	rawID = strings.TrimSuffix(rawID, "/reset")
+	var walletID uuid.UUID
+	if !h.validate(w, r, h.pathID(rawID, &walletID, ErrInvalidUUID)) {
+		return
+	}
+
+	var req ResetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeDecodeError(w, r, err)
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if !h.validate(w, r, h.resetReason(req.Reason)) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), h.config.OperationTimeout)
+	defer cancel()
+
+	release := h.acquireWriteSlot()
+	previous, walletErr := h.resetBalance(ctx, walletID, req.Reason)
+	release()
+
+	entry := h.requestAuditEntry(r, AuditActionReset, walletID.String(), nil)
+	entry.OperationType = string(wallet.ADJUSTMENT)
+	entry.Amount = previous
+	if walletErr != nil {
+		entry.Result = audit.ResultFailure
+		entry.Error = walletErr.Error()
+	}
+	h.recordAudit(entry)
+
+	if walletErr != nil {
+		h.writeWalletError(w, r, walletErr)
+		return
+	}
+
+	h.sendData(w, r, map[string]interface{}{
+		"wallet_id":        walletID,
+		"previous_balance": newBalance(previous),
+		"balance":          newBalance(0),
+	})
+}
+
+// resetReason - обязательная причина обнуления по правилам комментария к операции
+func (h *WalletHandler) resetReason(reason string) rule {
+	return func() error {
+		if reason == "" {
+			return errors.New(ErrResetReasonRequired)
+		}
+		return h.validator.ValidateReference(reason)
+	}
+}
+
+// resetBalance обнуляет баланс в транзакции и возвращает прежний баланс
+func (h *WalletHandler) resetBalance(ctx context.Context, walletID uuid.UUID, reason string) (float64, *WalletError) {
+	tx, err := h.beginStoreTx(ctx)
+	if err != nil {
+		return 0, &WalletError{
+			Code:    http.StatusInternalServerError,
+			Message: ErrTxCreate,
+			Err:     err,
+		}
+	}
+	defer tx.Rollback()
+
+	previous, err := h.getCurrentBalance(ctx, tx, walletID)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrWalletNotFound):
+			return 0, &WalletError{Code: http.StatusNotFound, Message: ErrWalletNotFound, Err: err}
+		case errors.Is(err, service.ErrWalletClosed):
+			return 0, &WalletError{Code: http.StatusConflict, Message: ErrWalletClosed, Err: err}
+		}
+		return 0, &WalletError{
+			Code:    http.StatusInternalServerError,
+			Message: ErrBalanceGet,
+			Err:     err,
+		}
+	}
+	// Баланс уже нулевой: списывать нечего, запись ADJUSTMENT не нужна
+	if previous == 0 {
+		return 0, nil
+	}
+
+	if err := h.updateBalance(ctx, tx, walletID, 0); err != nil {
+		return previous, &WalletError{
+			Code:    http.StatusInternalServerError,
+			Message: ErrBalanceUpdate,
+			Err:     err,
+		}
+	}
+
+	if err := h.recordTransaction(ctx, tx, walletID, -previous, wallet.ADJUSTMENT, reason); err != nil {
+		return previous, &WalletError{
+			Code:    http.StatusInternalServerError,
+			Message: ErrTxRecord,
+			Err:     err,
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return previous, &WalletError{
+			Code:    http.StatusInternalServerError,
+			Message: ErrTxCommit,
+			Err:     err,
+		}
+	}
+
+	// Закэшированный баланс устарел
+	h.cache.Delete(ctx, fmt.Sprintf("balance:%s", walletID))
+	h.invalidateLocalBalance(walletID.String())
+	h.publishEvent(balanceEvent(walletID.String(), 0))
+
+	return previous, nil
+}
@@ -0,0 +1,207 @@
+package handler
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"wallet/internal/currency"
+	"wallet/internal/msgpack"
+)
+
+// Envelope - обёртка успешного ответа с метаданными запроса
+type Envelope struct {
+	Data interface{}  `json:"data"`
+	Meta EnvelopeMeta `json:"meta"`
+}
+
+type EnvelopeMeta struct {
+	RequestID string    `json:"request_id"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Balance - баланс в двух видах: десятичная строка для отображения и целое
+// число минимальных единиц для точных расчётов без погрешностей float.
+// Без валюты отображения единица - копейка; с валютой - её Scale знаков
+// после точки, как и в строке. Closed выставляется для закрытого кошелька.
+type Balance struct {
+	Balance      string `json:"balance"`
+	BalanceMinor int64  `json:"balance_minor"`
+	Closed       bool   `json:"closed,omitempty"`
+	*BalanceDisplay
+}
+
+// BalanceDisplay - валюта баланса и число знаков после точки, с которым его
+// показывать, чтобы клиенты не задавали их у себя
+type BalanceDisplay struct {
+	Currency string `json:"currency"`
+	Unit     string `json:"unit"`
+	Scale    int    `json:"scale"`
+}
+
+// newBalanceDisplay берёт правила отображения из реестра валют; для пустой
+// или неизвестной валюты возвращает nil
+func newBalanceDisplay(code string) *BalanceDisplay {
+	if code == "" {
+		return nil
+	}
+	info, err := currency.Lookup(code)
+	if err != nil {
+		return nil
+	}
+	return &BalanceDisplay{Currency: info.Code, Unit: info.Unit, Scale: info.Scale}
+}
+
+// storageScale - число знаков после точки, с которым суммы хранятся в базе (DECIMAL(20,2))
+const storageScale = 2
+
+// balanceResponse - ответ с балансом кошелька и правилами его отображения
+func (h *WalletHandler) balanceResponse(balance walletBalance) Balance {
+	response := newBalanceScaled(balance.amount, h.displayScale())
+	response.Closed = balance.closed
+	response.BalanceDisplay = h.display
+	return response
+}
+
+// displayScale - число знаков после точки в ответах с балансом: Scale валюты
+// отображения, без неё - как в базе
+func (h *WalletHandler) displayScale() int {
+	if h.display == nil {
+		return storageScale
+	}
+	return h.display.Scale
+}
+
+func newBalance(amount float64) Balance {
+	return newBalanceScaled(amount, storageScale)
+}
+
+// newBalanceScaled - баланс с указанным числом знаков после точки
+func newBalanceScaled(amount float64, scale int) Balance {
+	return newBalanceMinorScaled(int64(math.Round(amount*math.Pow10(scale))), scale)
+}
+
+// newBalanceMinor - баланс из точной суммы в копейках
+func newBalanceMinor(minor int64) Balance {
+	return newBalanceMinorScaled(minor, storageScale)
+}
+
+// newBalanceMinorScaled - баланс из точной суммы в минимальных единицах с scale знаками
+func newBalanceMinorScaled(minor int64, scale int) Balance {
+	return Balance{
+		Balance:      formatMinorUnits(minor, scale),
+		BalanceMinor: minor,
+	}
+}
+
+// rescaleMinor переводит сумму из минимальных единиц с from знаками в единицы
+// с to знаками; при уменьшении точности половина округляется от нуля
+func rescaleMinor(minor int64, from, to int) int64 {
+	for ; from < to; from++ {
+		minor *= 10
+	}
+	for ; from > to; from-- {
+		remainder := minor % 10
+		minor /= 10
+		if remainder >= 5 {
+			minor++
+		} else if remainder <= -5 {
+			minor--
+		}
+	}
+	return minor
+}
+
+// formatMinorUnits записывает сумму в минимальных единицах десятичной строкой
+// с scale знаками после точки. Форматирование целочисленное, поэтому при любой
+// величине суммы не бывает ни экспоненциальной записи (1e+06), ни погрешностей float.
+func formatMinorUnits(minor int64, scale int) string {
+	sign := ""
+	units := uint64(minor)
+	if minor < 0 {
+		sign = "-"
+		units = ^units + 1
+	}
+	digits := strconv.FormatUint(units, 10)
+	if scale <= 0 {
+		return sign + digits
+	}
+	if len(digits) <= scale {
+		digits = strings.Repeat("0", scale-len(digits)+1) + digits
+	}
+	point := len(digits) - scale
+	return sign + digits[:point] + "." + digits[point:]
+}
+
+const (
+	// Версия отданного баланса; увеличивается при каждом изменении баланса
+	balanceVersionHeader = "X-Balance-Version"
+	// Требование к версии: баланс отдаётся, только если его версия больше указанной
+	ifVersionGtHeader = "If-Version-Gt"
+)
+
+func setBalanceVersion(w http.ResponseWriter, version int64) {
+	w.Header().Set(balanceVersionHeader, strconv.FormatInt(version, 10))
+}
+
+// requestID берёт идентификатор из заголовка X-Request-ID или генерирует новый
+func requestID(r *http.Request) string {
+	if id := r.Header.Get("X-Request-ID"); id != "" {
+		return id
+	}
+	return uuid.New().String()
+}
+
+// sendData отправляет данные успешного ответа, при включённом ResponseEnvelope - в обёртке.
+// Клиенту с Accept: application/msgpack ответ кодируется в MessagePack из тех же структур.
+// Имена полей приводятся к JSONNaming в обоих форматах.
+func (h *WalletHandler) sendData(w http.ResponseWriter, r *http.Request, data interface{}) error {
+	payload := data
+	if h.config.ResponseEnvelope {
+		id := requestID(r)
+		w.Header().Set("X-Request-ID", id)
+		payload = Envelope{
+			Data: data,
+			Meta: EnvelopeMeta{
+				RequestID: id,
+				Timestamp: h.clock.Now().UTC(),
+			},
+		}
+	}
+
+	payload, err := h.applyNaming(payload)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Add("Vary", "Accept")
+	if acceptsMsgPack(r) {
+		return h.sendMsgPack(w, payload)
+	}
+	return h.sendResponse(w, payload)
+}
+
+// acceptsMsgPack сообщает, перечислен ли MessagePack в заголовке Accept
+func acceptsMsgPack(r *http.Request) bool {
+	for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, _ := strings.Cut(accepted, ";")
+		if strings.EqualFold(strings.TrimSpace(mediaType), msgpack.ContentType) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *WalletHandler) sendMsgPack(w http.ResponseWriter, data interface{}) error {
+	body, err := msgpack.Marshal(data)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", msgpack.ContentType)
+	_, err = w.Write(body)
+	return err
+}
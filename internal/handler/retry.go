@@ -0,0 +1,111 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	wallet "wallet/internal/model"
+)
+
+const (
+	// Очереди операций по приоритетам; обработчики забирают операции из более
+	// приоритетной очереди первыми
+	highOperationsQueueKey = "wallet_operations:high"
+	operationsQueueKey     = "wallet_operations:normal"
+	lowOperationsQueueKey  = "wallet_operations:low"
+	// Общая очередь до появления приоритетов; операции из неё обрабатываются как обычные
+	legacyOperationsQueueKey = "wallet_operations"
+	// Отложенная очередь повторов: sorted set, score - unix-время следующей попытки
+	retryQueueKey = "wallet_operations_retry"
+	// Очередь недоставленных (DLQ): операции, исчерпавшие попытки повтора
+	deadLetterQueueKey = "wallet_operations_dead"
+)
+
+// isTransient сообщает, имеет ли смысл повторить операцию позже.
+// Ошибки клиента (4xx) не повторяются: повтор даст тот же результат.
+func isTransient(err error) bool {
+	var walletErr *WalletError
+	if errors.As(err, &walletErr) {
+		return walletErr.Code >= http.StatusInternalServerError
+	}
+	return false
+}
+
+// retryDelay - экспоненциальная задержка перед попыткой с номером attempt (начиная с 1)
+func (h *WalletHandler) retryDelay(attempt int) time.Duration {
+	return h.config.RetryBaseDelay << (attempt - 1)
+}
+
+// scheduleRetry откладывает операцию в очередь повторов. Возвращает false,
+// если попытки исчерпаны.
+func (h *WalletHandler) scheduleRetry(ctx context.Context, op wallet.WalletRequest) (bool, error) {
+	op.Attempts++
+	if op.Attempts > h.config.MaxOperationRetries {
+		return false, nil
+	}
+
+	payload, err := json.Marshal(op)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", ErrSerialization, err)
+	}
+
+	nextAttempt := h.clock.Now().Add(h.retryDelay(op.Attempts))
+	if err := h.cache.ZAdd(ctx, retryQueueKey, float64(nextAttempt.UnixMilli()), string(payload)); err != nil {
+		return false, fmt.Errorf("%s: %w", ErrQueueAdd, err)
+	}
+	return true, nil
+}
+
+// deadLetter сохраняет операцию, исчерпавшую попытки, для разбора администратором
+func (h *WalletHandler) deadLetter(ctx context.Context, op wallet.WalletRequest) error {
+	payload, err := json.Marshal(op)
+	if err != nil {
+		return fmt.Errorf("%s: %w", ErrSerialization, err)
+	}
+	return h.cache.LPush(ctx, deadLetterQueueKey, payload).Err()
+}
+
+// RunRetryScheduler периодически возвращает в основную очередь операции,
+// время повтора которых наступило. За один тик переносится не больше RetryBatchSize операций.
+func (h *WalletHandler) RunRetryScheduler(ctx context.Context) {
+	ticker := time.NewTicker(h.config.RetryPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := h.drainRetryQueue(ctx, h.clock.Now()); err != nil {
+				log.Printf("Ошибка переноса операций из очереди повторов: %v", err)
+			}
+		}
+	}
+}
+
+// drainRetryQueue переносит готовые к повтору операции в основную очередь
+func (h *WalletHandler) drainRetryQueue(ctx context.Context, now time.Time) (int, error) {
+	due, err := h.cache.ZRangeByScore(ctx, retryQueueKey, float64(now.UnixMilli()), int64(h.config.RetryBatchSize))
+	if err != nil {
+		return 0, err
+	}
+
+	moved := 0
+	for _, payload := range due {
+		// Перенос атомарный: операцию забирает только один экземпляр сервиса, и
+		// она не пропадает из обеих очередей при сбое между удалением и добавлением
+		ok, err := h.cache.MoveToQueue(ctx, retryQueueKey, payload, operationsQueue(payloadPriority(payload)))
+		if err != nil {
+			return moved, err
+		}
+		if ok {
+			moved++
+		}
+	}
+	return moved, nil
+}
@@ -0,0 +1,36 @@
+package handler
+
+import (
+	"errors"
+	"math/rand/v2"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Коды SQLSTATE, после которых транзакцию можно повторить целиком
+const (
+	sqlStateSerializationFailure = "40001"
+	sqlStateDeadlockDetected     = "40P01"
+)
+
+// Задержка перед первым повтором транзакции после конфликта; удваивается с каждой попыткой
+const serializationRetryBaseDelay = 10 * time.Millisecond
+
+// isSerializationFailure сообщает, что транзакция откатилась из-за конкурентной
+// транзакции: сбой сериализации, взаимная блокировка или, для хранилища в памяти,
+// изменение кошелька после чтения. Повтор транзакции с начала обычно проходит.
+func isSerializationFailure(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == sqlStateSerializationFailure || pqErr.Code == sqlStateDeadlockDetected
+	}
+	return errors.Is(err, ErrStoreConflict)
+}
+
+// serializationRetryDelay - задержка перед повтором с номером attempt (начиная с 1).
+// Случайная добавка разводит по времени повторы транзакций, заблокировавших друг друга.
+func serializationRetryDelay(attempt int) time.Duration {
+	delay := serializationRetryBaseDelay << (attempt - 1)
+	return delay + rand.N(delay)
+}
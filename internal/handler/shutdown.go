@@ -0,0 +1,28 @@
+package handler
+
+import (
+	"context"
+	"time"
+)
+
+// Время дозаписи буферов при остановке, если ShutdownFlushTimeout не задан
+const defaultShutdownFlushTimeout = 5 * time.Second
+
+// flushContext возвращает контекст для фоновых записей: он не отменяется вместе
+// с ctx, а живёт ещё ShutdownFlushTimeout после его отмены - столько даётся на
+// дозапись принятых буфером значений. cancel освобождает контекст раньше.
+func (h *WalletHandler) flushContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	timeout := h.config.ShutdownFlushTimeout
+	if timeout <= 0 {
+		timeout = defaultShutdownFlushTimeout
+	}
+
+	flushCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	stop := context.AfterFunc(ctx, func() {
+		time.AfterFunc(timeout, cancel)
+	})
+	return flushCtx, func() {
+		stop()
+		cancel()
+	}
+}
@@ -0,0 +1,137 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+
+	"wallet/internal/service"
+)
+
+// Суммы считаются в NUMERIC и возвращаются целым числом копеек, чтобы
+// сложение множества операций не накапливало погрешность float.
+// Операции читаются из transactions_all, чтобы учитывать и архивные.
+const (
+	// Баланс по ближайшему снимку плюс операции между снимком и запрошенным моментом
+	balanceAtFromSnapshotQuery = `
+		SELECT ((s.balance + COALESCE((
+			SELECT SUM(t.amount) FROM transactions_all t
+			WHERE t.wallet_id = s.wallet_id AND t.created_at > s.as_of AND t.created_at <= $2
+		), 0)) * 100)::BIGINT
+		FROM balance_snapshots s
+		WHERE s.wallet_id = $1 AND s.as_of <= $2
+		ORDER BY s.as_of DESC
+		LIMIT 1`
+
+	// Снимка ещё нет: откатываем текущий баланс на операции после запрошенного момента
+	balanceAtFromCurrentQuery = `
+		SELECT ((w.balance - COALESCE((
+			SELECT SUM(t.amount) FROM transactions_all t
+			WHERE t.wallet_id = w.id AND t.created_at > $2
+		), 0)) * 100)::BIGINT
+		FROM wallets w
+		WHERE w.id = $1`
+	balanceAtFromCurrentLedgerQuery = `
+		SELECT ((b.balance - COALESCE((
+			SELECT SUM(t.amount) FROM transactions_all t
+			WHERE t.wallet_id = b.wallet_id AND t.created_at > $2
+		), 0)) * 100)::BIGINT
+		FROM wallet_ledger_balances b
+		WHERE b.wallet_id = $1`
+
+	createSnapshotsQuery = `
+		INSERT INTO balance_snapshots (wallet_id, balance, as_of)
+		SELECT id, balance, $1 FROM wallets`
+	createLedgerSnapshotsQuery = `
+		INSERT INTO balance_snapshots (wallet_id, balance, as_of)
+		SELECT wallet_id, balance, $1 FROM wallet_ledger_balances`
+)
+
+func (h *WalletHandler) sendBalanceAt(ctx context.Context, w http.ResponseWriter, r *http.Request, walletID uuid.UUID, rawAt string) {
+	var at time.Time
+	if !h.validate(w, r, timestampParam(rawAt, &at)) {
+		return
+	}
+
+	balance, err := h.getBalanceAt(ctx, walletID, at)
+	if err != nil {
+		if errors.Is(err, service.ErrWalletNotFound) {
+			h.writeErr(w, r, err)
+			return
+		}
+		h.writeError(w, r, ErrBalanceRetrievalFail, http.StatusServiceUnavailable)
+		return
+	}
+
+	if err := h.sendData(w, r, struct {
+		Balance
+		AsOf time.Time `json:"as_of"`
+	}{newBalanceMinor(balance), at}); err != nil {
+		h.writeError(w, r, ErrSendResponse, http.StatusServiceUnavailable)
+	}
+}
+
+// getBalanceAt возвращает баланс кошелька на момент at в копейках
+func (h *WalletHandler) getBalanceAt(ctx context.Context, walletID uuid.UUID, at time.Time) (int64, error) {
+	var balance int64
+	err := h.db.QueryRowContext(ctx, balanceAtFromSnapshotQuery, walletID, at).Scan(&balance)
+	if err == nil {
+		return balance, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return 0, fmt.Errorf("%s: %w", ErrBalanceGetDB, err)
+	}
+
+	err = h.db.QueryRowContext(ctx, h.byBalanceMode(balanceAtFromCurrentQuery, balanceAtFromCurrentLedgerQuery), walletID, at).Scan(&balance)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, service.ErrWalletNotFound
+		}
+		return 0, fmt.Errorf("%s: %w", ErrBalanceGetDB, err)
+	}
+	return balance, nil
+}
+
+// RunSnapshots периодически сохраняет снимки балансов всех кошельков
+func (h *WalletHandler) RunSnapshots(ctx context.Context) {
+	if h.config.SnapshotInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(h.config.SnapshotInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// Во время обслуживания фоновые записи в БД не выполняются
+			if h.maintenance.Load() {
+				continue
+			}
+			if err := h.createSnapshots(ctx); err != nil {
+				log.Printf("%s: %v", ErrSnapshotCreate, err)
+			}
+		}
+	}
+}
+
+func (h *WalletHandler) createSnapshots(ctx context.Context) error {
+	tx, err := h.beginTx(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, h.byBalanceMode(createSnapshotsQuery, createLedgerSnapshotsQuery), h.clock.Now()); err != nil {
+		return fmt.Errorf("%s: %w", ErrSnapshotCreate, err)
+	}
+	return tx.Commit()
+}
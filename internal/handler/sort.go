@@ -0,0 +1,73 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// sortFields - допустимые поля сортировки списка: имя в ?sort_by -> выражение
+// для ORDER BY. В текст запроса попадают только выражения из этого списка,
+// значение параметра клиента - никогда.
+type sortFields map[string]string
+
+// Поля сортировки истории. Курсор страницы хранит время операции, поэтому
+// допустимы только поля со временем.
+var historySortFields = sortFields{
+	"created_at": "created_at",
+}
+
+// sortOrder - порядок списка; id добавляется вторым ключом, чтобы порядок
+// операций с одинаковым значением поля был однозначным
+type sortOrder struct {
+	Column string
+	Desc   bool
+}
+
+var defaultHistorySort = sortOrder{Column: "created_at", Desc: true}
+
+func (o sortOrder) direction() string {
+	if o.Desc {
+		return "DESC"
+	}
+	return "ASC"
+}
+
+// String возвращает выражение для ORDER BY
+func (o sortOrder) String() string {
+	return fmt.Sprintf("%s %s, id %s", o.Column, o.direction(), o.direction())
+}
+
+// after - сравнение, выбирающее элементы после курсора
+func (o sortOrder) after() string {
+	if o.Desc {
+		return "<"
+	}
+	return ">"
+}
+
+// sortParam разбирает необязательные ?sort_by=<поле> и ?order=asc|desc;
+// без параметров остаётся порядок по умолчанию из dest
+func sortParam(r *http.Request, fields sortFields, dest *sortOrder) rule {
+	return func() error {
+		query := r.URL.Query()
+		if field := query.Get("sort_by"); field != "" {
+			column, ok := fields[field]
+			if !ok {
+				return errors.New(ErrInvalidSortField)
+			}
+			dest.Column = column
+		}
+		switch strings.ToLower(query.Get("order")) {
+		case "":
+		case "asc":
+			dest.Desc = false
+		case "desc":
+			dest.Desc = true
+		default:
+			return errors.New(ErrInvalidSortOrder)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,88 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// cachedBalance - баланс кошелька в кэше с его версией. StaleAt (Unix, мс)
+// задаётся при включённом BalanceSoftTTL: после него значение считается устаревшим.
+// CachedAt (Unix, мс) - момент записи при включённом BalanceCacheHeaders.
+type cachedBalance struct {
+	Balance  float64 `json:"balance"`
+	Version  int64   `json:"version"`
+	StaleAt  int64   `json:"stale_at,omitempty"`
+	CachedAt int64   `json:"cached_at,omitempty"`
+}
+
+// cachedBalanceValue формирует значение баланса для кэша
+func (h *WalletHandler) cachedBalanceValue(balance walletBalance) string {
+	value := cachedBalance{Balance: balance.amount, Version: balance.version}
+	if softTTL := h.settings().BalanceSoftTTL; softTTL > 0 {
+		value.StaleAt = h.clock.Now().Add(softTTL).UnixMilli()
+	}
+	if h.config.BalanceCacheHeaders {
+		value.CachedAt = h.clock.Now().UnixMilli()
+	}
+	encoded, _ := json.Marshal(value)
+	return string(encoded)
+}
+
+// parseCachedBalance разбирает значение кэша и сообщает, устарело ли оно.
+// Значение без момента устаревания всегда считается свежим. Число без версии,
+// записанное прежними версиями сервиса, читается как баланс версии 0.
+func parseCachedBalance(value string, now time.Time) (cachedBalance, bool, error) {
+	var cached cachedBalance
+	if !strings.HasPrefix(value, "{") {
+		balance, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return cachedBalance{}, false, err
+		}
+		return cachedBalance{Balance: balance}, false, nil
+	}
+
+	if err := json.Unmarshal([]byte(value), &cached); err != nil {
+		return cachedBalance{}, false, err
+	}
+	return cached, cached.StaleAt > 0 && now.UnixMilli() >= cached.StaleAt, nil
+}
+
+// setBalanceCacheHeaders разрешает прокси кэшировать баланс на то же время, что и
+// Redis. Age - сколько значение уже пролежало в кэше; cachedAt (Unix, мс) равен 0
+// для значения из БД и для записей без момента записи.
+func (h *WalletHandler) setBalanceCacheHeaders(w http.ResponseWriter, cachedAt int64) {
+	if !h.config.BalanceCacheHeaders {
+		return
+	}
+	maxAge := int64(balanceCacheTTL / time.Second)
+	var age int64
+	if cachedAt > 0 {
+		age = max(0, min(maxAge, (h.clock.Now().UnixMilli()-cachedAt)/1000))
+	}
+	w.Header().Set("Cache-Control", "max-age="+strconv.FormatInt(maxAge, 10))
+	w.Header().Set("Age", strconv.FormatInt(age, 10))
+}
+
+// refreshBalance обновляет устаревший баланс в фоне, пока клиенту отдаётся значение из кэша.
+// Для кошелька одновременно выполняется не больше одного обновления.
+func (h *WalletHandler) refreshBalance(walletID uuid.UUID) {
+	key := walletID.String()
+	if _, running := h.balanceRefreshes.LoadOrStore(key, struct{}{}); running {
+		return
+	}
+
+	go func() {
+		defer h.balanceRefreshes.Delete(key)
+
+		ctx, cancel := context.WithTimeout(context.Background(), h.config.OperationTimeout)
+		defer cancel()
+		// Ошибку не возвращаем: до жёсткого TTL клиенты получают прежнее значение
+		h.loadBalance(ctx, walletID)
+	}()
+}
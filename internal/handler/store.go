@@ -0,0 +1,101 @@
+package handler
+
+import (
+	"context"
+	"time"
+
+	wallet "wallet/internal/model"
+
+	"github.com/google/uuid"
+)
+
+// Store - хранилище балансов кошельков. Операции и чтение баланса работают
+// только через него, поэтому PostgreSQL можно заменить другой БД или хранилищем
+// в памяти. История, снимки и отмена операций по-прежнему используют DBInterface.
+// Отсутствующий кошелек обозначается ошибкой sql.ErrNoRows.
+type Store interface {
+	GetBalance(ctx context.Context, walletID uuid.UUID) (StoredWallet, error)
+	BeginTx(ctx context.Context) (StoreTx, error)
+}
+
+// StoreTx - транзакция хранилища. LockWallet блокирует кошелек до Commit или Rollback.
+// Rollback после Commit ничего не делает.
+type StoreTx interface {
+	LockWallet(ctx context.Context, walletID uuid.UUID) (StoredWallet, error)
+	// CreateWallet создаёт кошелек с нулевым балансом; существующий кошелек не меняется
+	CreateWallet(ctx context.Context, walletID uuid.UUID) error
+	// UpdateBalance записывает новый баланс и увеличивает версию кошелька
+	UpdateBalance(ctx context.Context, walletID uuid.UUID, balance float64) error
+	// RecordTransaction записывает операцию со временем createdAt
+	RecordTransaction(ctx context.Context, walletID uuid.UUID, amount float64, operationType wallet.OperationType, reference string, createdAt time.Time) error
+	Commit() error
+	Rollback() error
+}
+
+// StoredWallet - состояние кошелька в хранилище
+type StoredWallet struct {
+	Balance          float64
+	Version          int64
+	Closed           bool
+	DepositDisabled  bool
+	WithdrawDisabled bool
+}
+
+// postgresStore - Store поверх WalletRepository
+type postgresStore struct {
+	db           DBInterface
+	repository   *WalletRepository
+	lockStrategy LockStrategy
+}
+
+// NewPostgresStore возвращает Store для PostgreSQL; lockStrategy задаёт способ блокировки кошелька
+func NewPostgresStore(db DBInterface, lockStrategy LockStrategy) Store {
+	return &postgresStore{db: db, repository: NewWalletRepository(db), lockStrategy: lockStrategy}
+}
+
+func (s *postgresStore) GetBalance(ctx context.Context, walletID uuid.UUID) (StoredWallet, error) {
+	return s.repository.GetBalance(ctx, walletID)
+}
+
+func (s *postgresStore) BeginTx(ctx context.Context) (StoreTx, error) {
+	tx, err := s.db.BeginTx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return newPostgresTx(s.repository, tx, s.lockStrategy), nil
+}
+
+// postgresTx - StoreTx поверх транзакции TxInterface и запросов WalletRepository
+type postgresTx struct {
+	tx           TxInterface
+	repository   *WalletRepository
+	lockStrategy LockStrategy
+}
+
+func newPostgresTx(repository *WalletRepository, tx TxInterface, lockStrategy LockStrategy) *postgresTx {
+	return &postgresTx{tx: tx, repository: repository, lockStrategy: lockStrategy}
+}
+
+func (t *postgresTx) LockWallet(ctx context.Context, walletID uuid.UUID) (StoredWallet, error) {
+	return t.repository.GetBalanceForUpdate(ctx, t.tx, walletID, t.lockStrategy)
+}
+
+func (t *postgresTx) CreateWallet(ctx context.Context, walletID uuid.UUID) error {
+	return t.repository.CreateWallet(ctx, t.tx, walletID)
+}
+
+func (t *postgresTx) UpdateBalance(ctx context.Context, walletID uuid.UUID, balance float64) error {
+	return t.repository.UpdateBalance(ctx, t.tx, walletID, balance)
+}
+
+func (t *postgresTx) RecordTransaction(ctx context.Context, walletID uuid.UUID, amount float64, operationType wallet.OperationType, reference string, createdAt time.Time) error {
+	return t.repository.InsertTransaction(ctx, t.tx, walletID, amount, operationType, reference, createdAt)
+}
+
+func (t *postgresTx) Commit() error {
+	return t.tx.Commit()
+}
+
+func (t *postgresTx) Rollback() error {
+	return t.tx.Rollback()
+}
@@ -0,0 +1,94 @@
+package handler
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+)
+
+// BalanceMode определяет, где хранится баланс кошелька
+type BalanceMode string
+
+const (
+	// BalanceModeColumn - баланс в колонке wallets.balance, операции пишутся в журнал отдельно
+	BalanceModeColumn BalanceMode = "column"
+	// BalanceModeLedger - баланс выводится из журнала transactions: сумма операций
+	// плюс свёртка уже перенесённых в архив. Колонка wallets.balance не меняется.
+	BalanceModeLedger BalanceMode = "ledger"
+)
+
+const (
+	// Баланс по журналу. Отдельный запрос после блокировки кошелька: в READ COMMITTED
+	// он получает свежий снимок и видит операции транзакций, завершившихся за время ожидания.
+	ledgerBalanceQuery = `
+		SELECT COALESCE((SELECT balance FROM ledger_rollups WHERE wallet_id = $1), 0)
+		     + COALESCE((SELECT SUM(amount) FROM transactions WHERE wallet_id = $1), 0)`
+	selectLedgerWalletBalanceQuery = `
+		SELECT b.balance, w.closed_at IS NOT NULL, w.version
+		FROM wallets w
+		JOIN wallet_ledger_balances b ON b.wallet_id = w.id
+		WHERE w.id = $1`
+	// Баланс выводится из журнала, поэтому запись меняет только версию кошелька
+	bumpWalletVersionQuery = "UPDATE wallets SET version = version + 1 WHERE id = $1"
+)
+
+// ledgerStore - Store для PostgreSQL, в котором баланс - сумма операций журнала.
+// Операция и изменение баланса - одна запись в transactions, поэтому баланс не
+// может разойтись с историей.
+type ledgerStore struct {
+	db           DBInterface
+	repository   *WalletRepository
+	lockStrategy LockStrategy
+}
+
+// NewLedgerStore возвращает Store для PostgreSQL с балансом по журналу операций
+func NewLedgerStore(db DBInterface, lockStrategy LockStrategy) Store {
+	return &ledgerStore{db: db, repository: NewWalletRepository(db), lockStrategy: lockStrategy}
+}
+
+func (s *ledgerStore) GetBalance(ctx context.Context, walletID uuid.UUID) (StoredWallet, error) {
+	var stored StoredWallet
+	err := s.db.QueryRowContext(ctx, selectLedgerWalletBalanceQuery, walletID).Scan(&stored.Balance, &stored.Closed, &stored.Version)
+	return stored, err
+}
+
+func (s *ledgerStore) BeginTx(ctx context.Context) (StoreTx, error) {
+	tx, err := s.db.BeginTx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return newLedgerTx(s.repository, tx, s.lockStrategy), nil
+}
+
+// ledgerTx - StoreTx с балансом по журналу; создание кошелька и запись операций
+// такие же, как у postgresTx
+type ledgerTx struct {
+	*postgresTx
+}
+
+func newLedgerTx(repository *WalletRepository, tx TxInterface, lockStrategy LockStrategy) *ledgerTx {
+	return &ledgerTx{postgresTx: newPostgresTx(repository, tx, lockStrategy)}
+}
+
+func (t *ledgerTx) LockWallet(ctx context.Context, walletID uuid.UUID) (StoredWallet, error) {
+	stored, err := t.postgresTx.LockWallet(ctx, walletID)
+	if err != nil {
+		return stored, err
+	}
+	err = t.tx.QueryRowContext(ctx, ledgerBalanceQuery, walletID).Scan(&stored.Balance)
+	return stored, err
+}
+
+// UpdateBalance только увеличивает версию: новый баланс получится из записи операции
+func (t *ledgerTx) UpdateBalance(ctx context.Context, walletID uuid.UUID, _ float64) error {
+	_, err := t.tx.ExecContext(ctx, bumpWalletVersionQuery, walletID)
+	return err
+}
+
+// byBalanceMode выбирает вариант запроса, читающего балансы, для текущего режима
+func (h *WalletHandler) byBalanceMode(column, ledger string) string {
+	if h.config.BalanceMode == BalanceModeLedger {
+		return ledger
+	}
+	return column
+}
@@ -0,0 +1,216 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+
+	wallet "wallet/internal/model"
+
+	"github.com/google/uuid"
+)
+
+// ErrStoreConflict - кошелек изменён другой транзакцией после чтения в LockWallet
+var ErrStoreConflict = errors.New("кошелек изменён параллельной транзакцией")
+
+// StoredTransaction - запись об операции в MemoryStore
+type StoredTransaction struct {
+	WalletID      uuid.UUID
+	Amount        float64
+	OperationType wallet.OperationType
+	Reference     string
+	CreatedAt     time.Time
+}
+
+// MemoryStore - Store в памяти процесса для тестов и локального запуска.
+// Транзакция копит изменения и применяет их целиком при Commit; если кошелек
+// успели изменить после LockWallet, Commit возвращает ErrStoreConflict.
+type MemoryStore struct {
+	mu           sync.Mutex
+	wallets      map[uuid.UUID]StoredWallet
+	transactions []StoredTransaction
+	// Баланс - сумма записанных операций, как в BalanceModeLedger
+	ledger bool
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{wallets: make(map[uuid.UUID]StoredWallet)}
+}
+
+// NewMemoryLedgerStore возвращает MemoryStore, в котором баланс выводится из
+// записанных операций; UpdateBalance меняет только версию кошелька
+func NewMemoryLedgerStore() *MemoryStore {
+	store := NewMemoryStore()
+	store.ledger = true
+	return store
+}
+
+// Put создаёт или заменяет кошелек. В режиме журнала ненулевой баланс
+// записывается начальной операцией ADJUSTMENT.
+func (s *MemoryStore) Put(walletID uuid.UUID, stored StoredWallet) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ledger {
+		if delta := stored.Balance - s.ledgerBalance(walletID, nil); delta != 0 {
+			s.transactions = append(s.transactions, StoredTransaction{
+				WalletID:      walletID,
+				Amount:        delta,
+				OperationType: wallet.ADJUSTMENT,
+				CreatedAt:     time.Now(),
+			})
+		}
+		stored.Balance = 0
+	}
+	s.wallets[walletID] = stored
+}
+
+// ledgerBalance суммирует записанные операции кошелька и ещё не применённые
+// операции pending; вызывается под s.mu
+func (s *MemoryStore) ledgerBalance(walletID uuid.UUID, pending []StoredTransaction) float64 {
+	var balance float64
+	for _, list := range [][]StoredTransaction{s.transactions, pending} {
+		for _, t := range list {
+			if t.WalletID == walletID {
+				balance += t.Amount
+			}
+		}
+	}
+	return balance
+}
+
+// Transactions возвращает операции кошелька в порядке записи
+func (s *MemoryStore) Transactions(walletID uuid.UUID) []StoredTransaction {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var result []StoredTransaction
+	for _, t := range s.transactions {
+		if t.WalletID == walletID {
+			result = append(result, t)
+		}
+	}
+	return result
+}
+
+func (s *MemoryStore) GetBalance(_ context.Context, walletID uuid.UUID) (StoredWallet, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, ok := s.wallets[walletID]
+	if !ok {
+		return StoredWallet{}, sql.ErrNoRows
+	}
+	if s.ledger {
+		stored.Balance = s.ledgerBalance(walletID, nil)
+	}
+	return stored, nil
+}
+
+func (s *MemoryStore) BeginTx(_ context.Context) (StoreTx, error) {
+	return &memoryTx{
+		store:   s,
+		read:    make(map[uuid.UUID]int64),
+		wallets: make(map[uuid.UUID]StoredWallet),
+	}, nil
+}
+
+type memoryTx struct {
+	store *MemoryStore
+	// Версии кошельков на момент LockWallet
+	read         map[uuid.UUID]int64
+	wallets      map[uuid.UUID]StoredWallet
+	transactions []StoredTransaction
+	done         bool
+}
+
+// wallet возвращает кошелек с учётом изменений, ещё не применённых транзакцией
+func (t *memoryTx) wallet(walletID uuid.UUID) (StoredWallet, bool) {
+	if stored, ok := t.wallets[walletID]; ok {
+		return stored, true
+	}
+	t.store.mu.Lock()
+	defer t.store.mu.Unlock()
+	stored, ok := t.store.wallets[walletID]
+	return stored, ok
+}
+
+// balance возвращает баланс кошелька с учётом операций транзакции
+func (t *memoryTx) balance(walletID uuid.UUID, stored StoredWallet) float64 {
+	if !t.store.ledger {
+		return stored.Balance
+	}
+	t.store.mu.Lock()
+	defer t.store.mu.Unlock()
+	return t.store.ledgerBalance(walletID, t.transactions)
+}
+
+func (t *memoryTx) LockWallet(_ context.Context, walletID uuid.UUID) (StoredWallet, error) {
+	stored, ok := t.wallet(walletID)
+	if !ok {
+		return StoredWallet{}, sql.ErrNoRows
+	}
+	if _, ok := t.read[walletID]; !ok {
+		t.read[walletID] = stored.Version
+	}
+	stored.Balance = t.balance(walletID, stored)
+	return stored, nil
+}
+
+func (t *memoryTx) CreateWallet(_ context.Context, walletID uuid.UUID) error {
+	if _, ok := t.wallet(walletID); !ok {
+		t.wallets[walletID] = StoredWallet{}
+	}
+	return nil
+}
+
+func (t *memoryTx) UpdateBalance(_ context.Context, walletID uuid.UUID, balance float64) error {
+	stored, ok := t.wallet(walletID)
+	if !ok {
+		return sql.ErrNoRows
+	}
+	if !t.store.ledger {
+		stored.Balance = balance
+	}
+	stored.Version++
+	t.wallets[walletID] = stored
+	return nil
+}
+
+func (t *memoryTx) RecordTransaction(_ context.Context, walletID uuid.UUID, amount float64, operationType wallet.OperationType, reference string, createdAt time.Time) error {
+	t.transactions = append(t.transactions, StoredTransaction{
+		WalletID:      walletID,
+		Amount:        amount,
+		OperationType: operationType,
+		Reference:     reference,
+		CreatedAt:     createdAt,
+	})
+	return nil
+}
+
+func (t *memoryTx) Commit() error {
+	if t.done {
+		return sql.ErrTxDone
+	}
+	t.done = true
+
+	s := t.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for walletID := range t.wallets {
+		version, locked := t.read[walletID]
+		current, exists := s.wallets[walletID]
+		if locked && exists && current.Version != version {
+			return ErrStoreConflict
+		}
+	}
+	for walletID, stored := range t.wallets {
+		s.wallets[walletID] = stored
+	}
+	s.transactions = append(s.transactions, t.transactions...)
+	return nil
+}
+
+func (t *memoryTx) Rollback() error {
+	t.done = true
+	return nil
+}
@@ -0,0 +1,54 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// Сумма балансов считается в NUMERIC и отдаётся целым числом копеек: сложение
+// миллионов балансов во float накапливало бы погрешность
+const (
+	totalsQuery       = `SELECT COUNT(*), (COALESCE(SUM(balance), 0) * 100)::BIGINT FROM wallets`
+	ledgerTotalsQuery = `SELECT COUNT(*), (COALESCE(SUM(balance), 0) * 100)::BIGINT FROM wallet_ledger_balances`
+)
+
+// Totals - число кошельков и точная сумма их балансов
+type Totals struct {
+	Wallets int64   `json:"wallets"`
+	Total   Balance `json:"total"`
+}
+
+// HandleTotals показывает сумму балансов всех кошельков: GET /api/v1/admin/totals
+func (h *WalletHandler) HandleTotals(w http.ResponseWriter, r *http.Request) {
+	if !h.isAdmin(r) {
+		h.writeError(w, r, ErrForbidden, http.StatusForbidden)
+		return
+	}
+	if r.Method != http.MethodGet {
+		h.writeError(w, r, ErrMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+
+	totals, err := h.getTotals(r.Context())
+	if err != nil {
+		h.writeError(w, r, ErrTotalsGet, http.StatusServiceUnavailable)
+		return
+	}
+
+	if err := h.sendData(w, r, totals); err != nil {
+		h.writeError(w, r, ErrSendResponse, http.StatusServiceUnavailable)
+	}
+}
+
+func (h *WalletHandler) getTotals(ctx context.Context) (Totals, error) {
+	var totals Totals
+	var minor int64
+	if err := h.db.QueryRowContext(ctx, h.byBalanceMode(totalsQuery, ledgerTotalsQuery)).Scan(&totals.Wallets, &minor); err != nil {
+		return Totals{}, fmt.Errorf("%s: %w", ErrTotalsGet, err)
+	}
+	scale := h.displayScale()
+	totals.Total = newBalanceMinorScaled(rescaleMinor(minor, storageScale, scale), scale)
+	totals.Total.BalanceDisplay = h.display
+	return totals, nil
+}
@@ -0,0 +1,19 @@
+package handler
+
+import (
+	"fmt"
+	"log"
+
+	wallet "wallet/internal/model"
+)
+
+// logOperation пишет в лог сообщение об операции с идентификатором трассировки,
+// общим для постановки в очередь и обработки. У операций без TraceID (импорт,
+// сообщения из очереди до появления поля) вместо него выводится id операции.
+func (h *WalletHandler) logOperation(op *wallet.WalletRequest, format string, args ...interface{}) {
+	traceID := op.TraceID
+	if traceID == "" {
+		traceID = op.ID
+	}
+	log.Printf("[trace %s] %s", traceID, fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,221 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+
+	wallet "wallet/internal/model"
+	"wallet/internal/service"
+)
+
+// rule проверяет один входной параметр запроса и при успехе сохраняет разобранное
+// значение. Ошибка - текст из констант Err* или ошибка валидатора service.
+type rule func() error
+
+// validate выполняет правила по порядку и на первой ошибке отвечает 422.
+// Эндпоинты проверяют через него обязательные поля, типы и диапазоны до
+// бизнес-логики; синтаксически неверное тело запроса по-прежнему даёт 400.
+func (h *WalletHandler) validate(w http.ResponseWriter, r *http.Request, rules ...rule) bool {
+	err := checkRules(rules...)
+	if err == nil {
+		return true
+	}
+	if _, _, ok := service.ErrorCode(err); ok {
+		h.writeValidationError(w, r, err, http.StatusUnprocessableEntity)
+	} else {
+		h.writeError(w, r, err.Error(), http.StatusUnprocessableEntity)
+	}
+	return false
+}
+
+// checkRules выполняет правила по порядку и возвращает первую ошибку
+func checkRules(rules ...rule) error {
+	for _, check := range rules {
+		if err := check(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pathID - идентификатор из пути запроса в канонической форме UUID
+func (h *WalletHandler) pathID(raw string, dest *uuid.UUID, message string) rule {
+	return func() error {
+		id, err := h.parseWalletID(raw)
+		if err != nil {
+			return errors.New(message)
+		}
+		*dest = id
+		return nil
+	}
+}
+
+// walletIDField - обязательный идентификатор кошелька из тела запроса; в dest
+// записывается каноническая форма
+func walletIDField(raw string, dest *string) rule {
+	return func() error {
+		if raw == "" {
+			return service.ErrEmptyWalletID
+		}
+		id, err := uuid.Parse(raw)
+		if err != nil {
+			return errors.New(ErrInvalidUUID)
+		}
+		*dest = id.String()
+		return nil
+	}
+}
+
+// walletRequest - сумма, тип операции и комментарий по правилам валидатора
+func (h *WalletHandler) walletRequest(req *wallet.WalletRequest) rule {
+	return func() error {
+		return h.validator.ValidateWalletRequest(req)
+	}
+}
+
+// trustedOperation - проверки, которые не пропускает и доверенный вызов:
+// отрицательное списание увеличило бы баланс, а отрицательное зачисление
+// уменьшило бы его в обход проверки баланса
+func trustedOperation(req *wallet.WalletRequest) rule {
+	return func() error {
+		if !(req.Amount > 0) {
+			return service.ErrNegativeAmount
+		}
+		// Реестр типов проверяется напрямую: настроенный валидатор доверенный
+		// вызов пропускает
+		if _, ok := wallet.LookupOperationType(req.OperationType); !ok {
+			return fmt.Errorf("%w: %s", service.ErrUnknownOperationType, req.OperationType)
+		}
+		return nil
+	}
+}
+
+// strictOperationType отклоняет незарегистрированный тип операции при
+// StrictOperationTypes; отсутствующий тип остаётся валидации
+func (h *WalletHandler) strictOperationType(opType wallet.OperationType) error {
+	if !h.config.StrictOperationTypes || opType == "" {
+		return nil
+	}
+	if _, ok := wallet.LookupOperationType(opType); !ok {
+		return fmt.Errorf("%w: %s", service.ErrUnknownOperationType, opType)
+	}
+	return nil
+}
+
+// amountParam - обязательная положительная сумма в параметре запроса
+func (h *WalletHandler) amountParam(raw string, dest *float64) rule {
+	return func() error {
+		amount, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return errors.New(ErrInvalidAmountParam)
+		}
+		if err := h.validator.ValidateAmount(amount); err != nil {
+			return err
+		}
+		if amount == 0 {
+			return service.ErrNegativeAmount
+		}
+		*dest = amount
+		return nil
+	}
+}
+
+// timestampParam - момент времени в формате RFC3339
+func timestampParam(raw string, dest *time.Time) rule {
+	return func() error {
+		at, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return errors.New(ErrInvalidTimestamp)
+		}
+		*dest = at
+		return nil
+	}
+}
+
+// peekRange - offset и count для просмотра очередей
+func peekRange(r *http.Request, offset, count *int64) rule {
+	return func() error {
+		var ok bool
+		*offset, *count, ok = parsePeekRange(r)
+		if !ok {
+			return errors.New(ErrInvalidRange)
+		}
+		return nil
+	}
+}
+
+// versionHeader - необязательный заголовок If-Version-Gt с неотрицательной версией баланса
+func versionHeader(r *http.Request, dest *int64, present *bool) rule {
+	return func() error {
+		raw := r.Header.Get(ifVersionGtHeader)
+		if raw == "" {
+			return nil
+		}
+		version, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || version < 0 {
+			return errors.New(ErrInvalidVersionHeader)
+		}
+		*dest, *present = version, true
+		return nil
+	}
+}
+
+// sinceVersionParam - обязательная версия баланса ?since_version, после которой ждать изменения
+func sinceVersionParam(r *http.Request, dest *int64) rule {
+	return func() error {
+		version, err := strconv.ParseInt(r.URL.Query().Get("since_version"), 10, 64)
+		if err != nil || version < 0 {
+			return errors.New(ErrInvalidSinceVersion)
+		}
+		*dest = version
+		return nil
+	}
+}
+
+// pollTimeoutParam - необязательное время ожидания ?timeout вида "30s", не больше maxPollTimeout
+func pollTimeoutParam(r *http.Request, dest *time.Duration) rule {
+	return func() error {
+		raw := r.URL.Query().Get("timeout")
+		if raw == "" {
+			return nil
+		}
+		timeout, err := time.ParseDuration(raw)
+		if err != nil || timeout <= 0 || timeout > maxPollTimeout {
+			return errors.New(ErrInvalidPollTimeout)
+		}
+		*dest = timeout
+		return nil
+	}
+}
+
+// Пределы срока действия операции по умолчанию: не дальше года вперёд и
+// допустимое отставание часов клиента
+const (
+	defaultMaxScheduleHorizon = 365 * 24 * time.Hour
+	defaultClockSkew          = 2 * time.Second
+)
+
+// expiresAtField - необязательный срок действия операции; он должен быть в будущем
+// с допуском ClockSkew и не дальше MaxScheduleHorizon
+func (h *WalletHandler) expiresAtField(raw *time.Time, dest **time.Time) rule {
+	return func() error {
+		if raw == nil {
+			return nil
+		}
+		now := h.clock.Now()
+		if !raw.After(now.Add(-h.config.ClockSkew)) {
+			return errors.New(ErrInvalidExpiresAt)
+		}
+		if h.config.MaxScheduleHorizon > 0 && raw.After(now.Add(h.config.MaxScheduleHorizon)) {
+			return errors.New(ErrExpiresAtTooFar)
+		}
+		expiresAt := raw.UTC()
+		*dest = &expiresAt
+		return nil
+	}
+}
@@ -0,0 +1,187 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"wallet/internal/audit"
+	wallet "wallet/internal/model"
+	"wallet/internal/service"
+)
+
+const (
+	selectTransactionForVoidQuery = `
+		SELECT wallet_id, amount, voided_at IS NOT NULL, void_of IS NOT NULL
+		FROM transactions
+		WHERE id = $1
+		FOR UPDATE`
+
+	markTransactionVoidedQuery = "UPDATE transactions SET voided_at = $2 WHERE id = $1"
+
+	insertVoidTransactionQuery = `
+		INSERT INTO transactions (wallet_id, amount, operation_type, void_of, created_at)
+		VALUES ($1, $2, $3, $4, $5)`
+)
+
+// VoidTransaction отменяет операцию: POST /api/v1/transactions/{id}/void.
+// Запись не удаляется - она помечается отменённой, а баланс компенсируется
+// записью VOID, поэтому история на момент времени остаётся верной. Отмена
+// меняет баланс, поэтому доступна только администратору.
+func (h *WalletHandler) VoidTransaction(w http.ResponseWriter, r *http.Request) {
+	if !h.isAdmin(r) {
+		h.writeError(w, r, ErrForbidden, http.StatusForbidden)
+		return
+	}
+	if r.Method != http.MethodPost {
+		h.writeError(w, r, ErrMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+
+	rawID := strings.TrimPrefix(r.URL.Path, "/api/v1/transactions/")
+	rawID = strings.TrimSuffix(rawID, "/void")
+	var transactionID uuid.UUID
+	if !h.validate(w, r, h.pathID(rawID, &transactionID, ErrInvalidTransactionID)) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), h.config.OperationTimeout)
+	defer cancel()
+
+	release := h.acquireWriteSlot()
+	balance, walletErr := h.voidTransaction(ctx, transactionID)
+	release()
+
+	entry := h.requestAuditEntry(r, AuditActionVoid, "", nil)
+	entry.OperationID = transactionID.String()
+	if walletErr != nil {
+		entry.Result = audit.ResultFailure
+		entry.Error = walletErr.Error()
+	}
+	h.recordAudit(entry)
+
+	if walletErr != nil {
+		h.writeWalletError(w, r, walletErr)
+		return
+	}
+
+	h.sendStatus(w, r, http.StatusOK, CodeVoidSuccess, map[string]interface{}{
+		"transaction_id": transactionID.String(),
+		"balance":        balance,
+	})
+}
+
+// voidTransaction помечает операцию отменённой и возвращает новый баланс кошелька
+func (h *WalletHandler) voidTransaction(ctx context.Context, transactionID uuid.UUID) (float64, *WalletError) {
+	tx, err := h.beginTx(ctx)
+	if err != nil {
+		return 0, &WalletError{
+			Code:    http.StatusInternalServerError,
+			Message: ErrTxCreate,
+			Err:     err,
+		}
+	}
+	defer tx.Rollback()
+
+	var walletID uuid.UUID
+	var amount float64
+	var voided, isVoid bool
+	err = tx.QueryRowContext(ctx, selectTransactionForVoidQuery, transactionID).Scan(&walletID, &amount, &voided, &isVoid)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, &WalletError{
+				Code:    http.StatusNotFound,
+				Message: ErrTransactionNotFound,
+			}
+		}
+		return 0, &WalletError{
+			Code:    http.StatusInternalServerError,
+			Message: ErrTransactionGet,
+			Err:     err,
+		}
+	}
+
+	if voided {
+		return 0, &WalletError{
+			Code:    http.StatusConflict,
+			Message: ErrTransactionVoided,
+		}
+	}
+	if isVoid {
+		return 0, &WalletError{
+			Code:    http.StatusConflict,
+			Message: ErrTransactionIsVoid,
+		}
+	}
+
+	currentBalance, err := h.getCurrentBalance(ctx, h.postgresTx(tx), walletID)
+	if err != nil && errors.Is(err, service.ErrWalletClosed) {
+		return 0, &WalletError{
+			Code:    http.StatusConflict,
+			Message: ErrWalletClosed,
+			Err:     err,
+		}
+	}
+	if err != nil {
+		return 0, &WalletError{
+			Code:    http.StatusInternalServerError,
+			Message: ErrBalanceGet,
+			Err:     err,
+		}
+	}
+
+	// Отмена зачисления не должна уводить баланс в минус
+	newBalance := currentBalance - amount
+	if newBalance < 0 {
+		return 0, &WalletError{
+			Code:    http.StatusConflict,
+			Message: ErrInsufficientFunds,
+		}
+	}
+
+	if err := h.updateBalance(ctx, h.postgresTx(tx), walletID, newBalance); err != nil {
+		return 0, &WalletError{
+			Code:    http.StatusInternalServerError,
+			Message: ErrBalanceUpdate,
+			Err:     err,
+		}
+	}
+
+	// Отметка об отмене и компенсирующая запись получают одно и то же время
+	now := h.clock.Now()
+	if _, err := tx.ExecContext(ctx, markTransactionVoidedQuery, transactionID, now); err != nil {
+		return 0, &WalletError{
+			Code:    http.StatusInternalServerError,
+			Message: ErrTransactionVoid,
+			Err:     err,
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, insertVoidTransactionQuery, walletID, -amount, wallet.VOID, transactionID, now); err != nil {
+		return 0, &WalletError{
+			Code:    http.StatusInternalServerError,
+			Message: ErrTxRecord,
+			Err:     fmt.Errorf("%s: %w", ErrTransactionVoid, err),
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, &WalletError{
+			Code:    http.StatusInternalServerError,
+			Message: ErrTxCommit,
+			Err:     err,
+		}
+	}
+
+	// Закэшированный баланс устарел
+	h.cache.Delete(ctx, fmt.Sprintf("balance:%s", walletID))
+	h.invalidateLocalBalance(walletID.String())
+	h.publishEvent(balanceEvent(walletID.String(), newBalance))
+
+	return newBalance, nil
+}
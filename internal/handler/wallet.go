@@ -6,15 +6,27 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"math"
+	"mime"
 	"net/http"
+	"net/netip"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"errors"
 
 	"github.com/google/uuid"
 	"github.com/redis/go-redis/v9"
+	"golang.org/x/sync/singleflight"
 	"golang.org/x/time/rate"
 
+	"wallet/internal/audit"
+	"wallet/internal/cache"
+	"wallet/internal/currency"
+	"wallet/internal/i18n"
+	"wallet/internal/metrics"
 	wallet "wallet/internal/model"
 	"wallet/internal/service"
 )
@@ -38,12 +50,103 @@ const (
 	ErrSendResponse         = "Ошибка при отправке ответа"
 	SuccessQueueAdd         = "Операция добавлена в очередь"
 	SuccessOperation        = "Операция выполнена успешно"
+	SuccessVoid             = "Операция отменена"
+	SuccessDryRun           = "Проверки пройдены, операция не выполнялась"
 	ErrTxCreate             = "ошибка при создании транзакции"
 	ErrBalanceGet           = "ошибка при получении баланса"
 	ErrBalanceUpdate        = "ошибка при обновлении баланса"
 	ErrTxRecord             = "ошибка при записи транзакции"
 	ErrTxCommit             = "ошибка при подтверждении транзакции"
 	ErrBalanceGetDB         = "ошибка при получении баланса"
+	ErrHistoryGet           = "ошибка при получении истории операций"
+	ErrInvalidTimestamp     = "Неверный формат времени, ожидается RFC3339"
+	ErrSnapshotCreate       = "ошибка при создании снимка балансов"
+	ErrHistoryArchive       = "ошибка при архивации истории операций"
+	ErrEventPublish         = "ошибка публикации события кошелька"
+	ErrEventSubscribe       = "Ошибка подписки на события кошелька"
+	ErrWebSocketUpgrade     = "Ожидается запрос на установку WebSocket-соединения"
+	ErrWebSocketHijack      = "Не удалось установить WebSocket-соединение"
+	ErrInvalidSetting       = "Неверное значение настройки"
+	ErrInvalidPriority      = "Неверный приоритет операции"
+	ErrPriorityNotAllowed   = "Высокий приоритет доступен только администратору"
+	ErrUnsupportedMediaType = "Ожидается Content-Type: application/json"
+	ErrWalletLock           = "ошибка при блокировке кошелька"
+	ErrWalletBlocked        = "кошелек заблокирован"
+	ErrBlocklistCheck       = "ошибка при проверке списка блокировки"
+	ErrBlocklistUpdate      = "Ошибка при обновлении списка блокировки"
+	ErrForbidden            = "Доступ запрещен"
+	ErrUnknownCurrency      = "Неизвестная валюта"
+	ErrRatesUnavailable     = "Курсы валют недоступны"
+	ErrConversionDisabled   = "Конвертация валют не настроена"
+	ErrWalletCreate         = "ошибка при создании кошелька"
+	ErrInvalidAmountParam   = "Неверная сумма в параметре amount"
+	ErrEmptyBody            = "Пустое тело запроса"
+	ErrJSONTruncated        = "Тело запроса обрывается до конца JSON"
+	ErrJSONSyntax           = "Синтаксическая ошибка JSON в позиции %d"
+	ErrJSONFieldType        = "Поле %s должно быть типа %s, получено: %s"
+	ErrInvalidTransactionID = "Неверный формат UUID операции"
+	ErrTransactionNotFound  = "операция не найдена"
+	ErrTransactionGet       = "ошибка при получении операции"
+	ErrTransactionVoided    = "операция уже отменена"
+	ErrTransactionIsVoid    = "компенсирующую запись отменить нельзя"
+	ErrTransactionVoid      = "ошибка при отмене операции"
+	ErrAuditDropped         = "буфер журнала аудита заполнен, запись потеряна"
+	ErrAuditFlush           = "не дописаны записи журнала аудита при остановке"
+	ErrAuditWrite           = "ошибка записи в журнал аудита"
+	ErrInvalidRange         = "Неверные параметры offset/count"
+	ErrQueueRead            = "Ошибка чтения очереди"
+	ErrMaintenance          = "Сервис на обслуживании, запись временно недоступна"
+	ErrWalletClosed         = "кошелек закрыт"
+	ErrImportMalformedRow   = "Неверный формат строки CSV"
+	ErrImportRead           = "Ошибка чтения CSV"
+	ErrOperationNotAllowed  = "операция запрещена для этого кошелька"
+	ErrBalanceVersionStale  = "Версия баланса не новее запрошенной в If-Version-Gt"
+	ErrInvalidVersionHeader = "Неверное значение заголовка If-Version-Gt"
+	ErrInvalidPageLimit     = "Неверный размер страницы"
+	ErrInvalidPageCursor    = "Неверный курсор страницы"
+	ErrInvalidSortField     = "Недопустимое поле сортировки"
+	ErrInvalidSortOrder     = "Неверный порядок сортировки"
+	ErrInternal             = "Внутренняя ошибка сервера"
+	ErrTotalsGet            = "ошибка при подсчёте суммы балансов"
+	ErrResetDisabled        = "Обнуление баланса запрещено в production"
+	ErrResetReasonRequired  = "Не указана причина обнуления баланса"
+	ErrBalanceRebuild       = "ошибка при пересчёте баланса по журналу операций"
+	ErrTimeout              = "Превышено время ожидания ответа"
+	ErrOperationTimeout     = "Операция не выполнена за отведённое время"
+	ErrInvalidExpiresAt     = "Срок действия операции уже истёк"
+	ErrExpiresAtTooFar      = "Срок действия операции слишком далеко в будущем"
+	ErrOperationNotFound    = "Операция не найдена"
+	ErrOperationStatusGone  = "Статус операции больше не хранится"
+	ErrOperationStatusGet   = "ошибка при получении статуса операции"
+	ErrListSizeExceeded     = "Размер списка больше допустимого: %d"
+	ErrInvalidSinceVersion  = "Неверное значение since_version"
+	ErrInvalidPollTimeout   = "Неверное время ожидания: допустимо до 60s"
+	ErrInvariantCheck       = "ошибка при сверке балансов с журналом операций"
+	ErrInvariantLedgerMode  = "В режиме ledger баланс вычисляется по журналу, сверка не нужна"
+	ErrCacheFlush           = "Не удалось сбросить кэш баланса"
+	ErrReceiptsDisabled     = "Квитанции операций не настроены"
+	ErrInvalidReceipt       = "Квитанция недействительна"
+)
+
+// LockStrategy определяет, как сериализуются конкурентные операции над одним кошельком
+type LockStrategy string
+
+const (
+	// LockStrategyRow - блокировка строки кошелька через SELECT ... FOR UPDATE
+	LockStrategyRow LockStrategy = "row"
+	// LockStrategyAdvisory - транзакционная advisory-блокировка по идентификатору кошелька
+	LockStrategyAdvisory LockStrategy = "advisory"
+)
+
+// WalletPolicy определяет, что делать с операцией над несуществующим кошельком
+type WalletPolicy string
+
+const (
+	// WalletPolicyStrict - операция над несуществующим кошельком завершается 404
+	WalletPolicyStrict WalletPolicy = "strict"
+	// WalletPolicyAutoCreate - зачисление создаёт кошелек с нулевым балансом.
+	// Списание с несуществующего кошелька всегда завершается 404.
+	WalletPolicyAutoCreate WalletPolicy = "auto_create"
 )
 
 type WalletError struct {
@@ -60,25 +163,272 @@ func (e *WalletError) Error() string {
 	return e.Message
 }
 
+func (e *WalletError) Unwrap() error {
+	return e.Err
+}
+
 type Config struct {
-	MaxRetries       int
+	// Сколько раз транзакция операции повторяется после сбоя сериализации или взаимной блокировки
+	MaxRetries int
+	// Предел времени транзакции операции, включая ожидание блокировки кошелька
 	OperationTimeout time.Duration
-	ConcurrencyLimit int
+	// Наибольший срок, который клиент может задать заголовком X-Request-Timeout
+	MaxRequestTimeout time.Duration
+	ConcurrencyLimit  int
+	// Лимит запросов в секунду и допустимый всплеск для операций
+	RateLimit float64
+	RateBurst int
+	// Лимиты на адрес клиента и на аутентифицированного субъекта (администратор,
+	// доверенный вызов) поверх общего; 0 отключает соответствующий лимит
+	IPRateLimit      float64
+	IPRateBurst      int
+	SubjectRateLimit float64
+	SubjectRateBurst int
+	// Адреса балансировщиков перед сервисом: только от них принимается
+	// X-Forwarded-For при определении адреса клиента для IPRateLimit
+	TrustedProxies []netip.Prefix
+	// Период записи снимков балансов; 0 отключает снимки
+	SnapshotInterval time.Duration
+	// Операции старше HistoryRetention переносятся в архив раз в ArchiveInterval; 0 отключает архивацию
+	HistoryRetention time.Duration
+	ArchiveInterval  time.Duration
+	// Сколько операций переносится в архив одной транзакцией
+	ArchiveBatchSize int
+	// Максимум одновременных пишущих транзакций; 0 снимает ограничение
+	MaxWriteTransactions int
+	LockStrategy         LockStrategy
+	// Где хранится баланс: в колонке кошелька или как сумма журнала операций
+	BalanceMode BalanceMode
+	// Поведение при операции над несуществующим кошельком
+	WalletPolicy WalletPolicy
+	// Оборачивать успешные ответы в {"data": ..., "meta": ...}
+	ResponseEnvelope bool
+	// Имена полей успешных ответов: snake_case (по умолчанию) или camelCase
+	JSONNaming JSONNaming
+	// Запрещать чтение баланса заблокированных кошельков
+	BlockReads bool
+	// Отвечать на запрещённый доступ к кошельку 404, как для несуществующего,
+	// вместо 403 - чтобы перебором нельзя было узнать, какие кошельки существуют
+	ConcealForbiddenWallets bool
+	// Строгий режим: операция незарегистрированного типа отклоняется сразу
+	// после разбора тела, до проверки остальных полей. Действует только на
+	// входящие запросы; операции, уже стоящие в очереди, не затрагиваются.
+	StrictOperationTypes bool
+	// Токен для административных эндпоинтов; пустой токен отключает их
+	AdminToken string
+	// Ключ доверенных внутренних вызовов (заголовок X-Trusted-Caller-Key): операции
+	// пакетных заданий с заранее проверенными данными выполняются без повторной
+	// валидации запроса: минимальная сумма, reference и т.п. не проверяются.
+	// Положительность суммы, тип операции, проверка баланса в транзакции и
+	// блокировка кошелька действуют как обычно. Пустой ключ отключает доверенный путь.
+	TrustedCallerKey string
+	// Ключ подписи квитанций операций (HMAC-SHA256); пустой ключ отключает квитанции
+	ReceiptKey string
+	// Рабочее окружение: в нём обнуление баланса доступно только с AllowBalanceReset
+	Production        bool
+	AllowBalanceReset bool
+	// Источник курсов для ?convert_to; nil отключает конвертацию
+	RateProvider currency.RateProvider
+	// Валюта кошельков (код ISO 4217): её обозначение и число знаков после точки
+	// добавляются к ответам с балансом. Пустая или неизвестная валюта их не добавляет.
+	DisplayCurrency string
+	// Отклонять суммы точнее дробных единиц DisplayCurrency (например, 10.005 для USD);
+	// больше двух знаков, с которыми суммы хранятся в базе, не принимается ни для какой валюты
+	EnforceCurrencyPrecision bool
+	// Повторы операций, упавших с временной ошибкой
+	MaxOperationRetries int
+	RetryBaseDelay      time.Duration
+	RetryPollInterval   time.Duration
+	RetryBatchSize      int
+	// Минимальные суммы по типам операций
+	MinAmounts map[wallet.OperationType]float64
+	// Период проверки доступности БД; он же - таймаут ожидания операции в очереди
+	HealthCheckInterval time.Duration
+	// Переводы сообщений; nil - встроенные DefaultMessages
+	Messages *i18n.Bundle
+	// Проверки запросов; nil - service.WalletValidator с MinAmounts
+	Validator service.Validator
+	// Хранилище балансов; nil - PostgreSQL через DBInterface с LockStrategy и BalanceMode
+	Store Store
+	// Источник событий кошельков для WebSocket; nil - LocalEvents в памяти процесса
+	Events EventBus
+	// Источник текущего времени; nil - системное время
+	Clock Clock
+	// Локальный LRU-кэш балансов перед Redis: число кошельков (0 отключает) и
+	// время жизни записи. Изменение баланса сбрасывает запись сразу в этом
+	// экземпляре, а в остальных - через BalanceInvalidations (nil - только в этом).
+	LocalCacheSize       int
+	LocalCacheTTL        time.Duration
+	BalanceInvalidations BalanceInvalidations
+	// Приёмник метрик операций; nil - metrics.Nop, измерения отбрасываются
+	Metrics metrics.Metrics
+	// Период ping для WebSocket-подписчиков; соединение без ответа за два периода закрывается, 0 отключает ping
+	WebSocketPingInterval time.Duration
+	// Фоновые записи баланса в кэш: число обработчиков и размер очереди
+	CacheWriteWorkers   int
+	CacheWriteQueueSize int
+	// Записи, поступившие за CacheWriteBatchWindow после первой, отправляются
+	// вместе одним конвейером, но не больше CacheWriteBatchSize; 0 - по одной
+	CacheWriteBatchWindow time.Duration
+	CacheWriteBatchSize   int
+	// Сколько при остановке дописываются отложенные записи в кэш и журнал аудита
+	ShutdownFlushTimeout time.Duration
+	// Stale-while-revalidate для баланса: по истечении BalanceSoftTTL значение
+	// из кэша ещё отдаётся до удаления по balanceCacheTTL, а баланс обновляется
+	// в фоне; 0 отключает
+	BalanceSoftTTL time.Duration
+	// Отдавать с балансом Cache-Control: max-age по времени жизни кэша и Age -
+	// возраст значения, чтобы баланс могли кэшировать прокси и CDN
+	BalanceCacheHeaders bool
+	// Защита БД от перегрузки: пока средняя задержка чтения баланса выше
+	// DBLatencyThreshold, чтение из БД выполняется без повторов и одновременно
+	// допускается не больше SaturatedDBReads чтений. Нулевой порог отключает
+	// защиту, нулевой SaturatedDBReads оставляет только отключение повторов
+	DBLatencyThreshold time.Duration
+	SaturatedDBReads   int
+	// Окно, в течение которого повторная постановка в очередь той же операции
+	// (вызывающий, кошелек, тип, сумма, комментарий) пропускается и возвращает
+	// id первой; анонимные операции без комментария не дедуплицируются; 0 отключает
+	OperationDedupWindow time.Duration
+	// Сколько итог операции из очереди доступен по GET /api/v1/operations/{id};
+	// 0 отключает запись статусов
+	OperationStatusTTL time.Duration
+	// Насколько далеко в будущем может быть срок действия операции (expires_at);
+	// 0 снимает ограничение. Срок, уже прошедший не более чем на ClockSkew,
+	// принимается: часы клиента могут отставать от часов сервера.
+	MaxScheduleHorizon time.Duration
+	ClockSkew          time.Duration
+	// Наибольшее число элементов в ответе списочного эндпоинта. Запрос большего
+	// размера получает 400, а при ClampListLimit - список, урезанный до предела.
+	MaxListSize    int
+	ClampListLimit bool
+	// Ответ 204 без тела на пустую операцию, выполненную в рамках запроса
+	// (режим отладки, пробный запуск, QueueFallback): баланс в копейках до и
+	// после операции совпадает, например при зачислении нулевой суммы.
+	// Операция из очереди по-прежнему получает 202 - её итог ещё неизвестен.
+	NoContentOnNoOp bool
+	// Сколько кошельков читается за один запрос при сверке балансов с журналом
+	InvariantBatchSize int
+	// Журнал аудита операций и административных действий; nil отключает аудит
+	AuditSink       audit.Sink
+	AuditBufferSize int
+	// Подробность лога: на уровне info идентификаторы кошельков и суммы скрываются
+	LogLevel LogLevel
+	// Каждая LowPriorityEvery-я операция берётся сначала из низкоприоритетной
+	// очереди, чтобы её не вытеснили срочные; 0 - строго по приоритету
+	LowPriorityEvery int
+	// Сколько операций из очереди одновременно выполняются над одним кошельком;
+	// остальные возвращаются в конец очереди, чтобы горячий кошелек не занял
+	// всех обработчиков. 0 снимает ограничение.
+	MaxWalletOperations int
+	// Выполнять операцию синхронно, как в режиме отладки, если соединиться
+	// с очередью не удалось: сервис деградирует, но не отклоняет записи.
+	// Ошибка после отправки команды даёт 500 - операция могла попасть в очередь.
+	QueueFallback bool
+	// Начальное состояние режима обслуживания и значение Retry-After для отклонённых записей
+	MaintenanceMode       bool
+	MaintenanceRetryAfter time.Duration
+}
+
+func DefaultConfig() Config {
+	return Config{
+		MaxRetries:            3,
+		OperationTimeout:      5 * time.Second,
+		MaxRequestTimeout:     defaultMaxRequestTimeout,
+		ConcurrencyLimit:      100,
+		RateLimit:             2000,
+		RateBurst:             1000,
+		SnapshotInterval:      time.Hour,
+		ArchiveInterval:       24 * time.Hour,
+		ArchiveBatchSize:      defaultArchiveBatchSize,
+		MaxWriteTransactions:  200,
+		LockStrategy:          LockStrategyRow,
+		BalanceMode:           BalanceModeColumn,
+		JSONNaming:            JSONNamingSnake,
+		LogLevel:              LogLevelInfo,
+		LowPriorityEvery:      10,
+		WalletPolicy:          WalletPolicyStrict,
+		MaxOperationRetries:   5,
+		RetryBaseDelay:        time.Second,
+		RetryPollInterval:     time.Second,
+		RetryBatchSize:        100,
+		HealthCheckInterval:   5 * time.Second,
+		CacheWriteWorkers:     10,
+		CacheWriteQueueSize:   1000,
+		CacheWriteBatchSize:   100,
+		ShutdownFlushTimeout:  defaultShutdownFlushTimeout,
+		AuditBufferSize:       1000,
+		MaintenanceRetryAfter: time.Minute,
+		DBLatencyThreshold:    500 * time.Millisecond,
+		SaturatedDBReads:      50,
+		WebSocketPingInterval: 30 * time.Second,
+		OperationStatusTTL:    time.Hour,
+		LocalCacheTTL:         defaultLocalCacheTTL,
+		MaxScheduleHorizon:    defaultMaxScheduleHorizon,
+		ClockSkew:             defaultClockSkew,
+		MaxListSize:           defaultMaxListSize,
+		InvariantBatchSize:    defaultInvariantBatchSize,
+	}
 }
 
 type WalletHandler struct {
 	db          DBInterface
+	repository  *WalletRepository
+	store       Store
+	events      EventBus
+	clock       Clock
+	metrics     metrics.Metrics
 	cache       CacheInterface
-	validator   *service.WalletValidator
+	localCache  *localCache
+	validator   service.Validator
 	config      Config
 	rateLimiter *rate.Limiter
-	debugMode   bool
-	semaphore   chan struct{}
+	// Лимитеры по адресу клиента и по субъекту; nil, если лимит отключён
+	ipLimiters      *keyedLimiters
+	subjectLimiters *keyedLimiters
+	debugMode       bool
+	semaphore       chan struct{}
+	// Правила отображения баланса из DisplayCurrency; nil - не добавляются
+	display *BalanceDisplay
+	// Ограничивает число одновременных транзакций с FOR UPDATE, отдельно от semaphore для чтения;
+	// nil снимает ограничение
+	writeLimit atomic.Pointer[writeLimit]
+	// Настройки, изменяемые без перезапуска; reloadMu упорядочивает их применение
+	currentSettings atomic.Pointer[Settings]
+	reloadMu        sync.Mutex
+	// Выставляется проверкой RunHealthCheck, пока БД не отвечает
+	dbUnhealthy atomic.Bool
+	// Режим обслуживания: запись отклоняется, очередь не обрабатывается
+	maintenance atomic.Bool
+	messages    *i18n.Bundle
+	// Очередь записей в кэш для RunCacheWriter
+	cacheWrites chan cacheWrite
+	// Объединяет одновременные чтения баланса одного кошелька из БД
+	balanceFlight singleflight.Group
+	// Кошельки, для которых выполняется фоновое обновление баланса
+	balanceRefreshes sync.Map
+	// Средняя задержка чтения баланса из БД и слоты чтений при перегрузке БД
+	dbLatency      latencyTracker
+	saturatedReads chan struct{}
+	// Буфер записей для RunAuditLog
+	auditEntries chan audit.Entry
+	// Число паник, перехваченных RecoverPanics
+	panics atomic.Int64
+	// Операции из очереди, которые сейчас выполняются
+	inFlightOperations atomic.Int64
+	// Число опросов очереди - для защиты от голодания низкого приоритета
+	queuePops atomic.Int64
+	// Операции из очереди, выполняющиеся над каждым кошельком
+	walletSlots walletSlots
+	// Число операций, отброшенных из очереди по истечении срока действия
+	expiredOperations atomic.Int64
 }
 
 type DBInterface interface {
 	QueryRowContext(ctx context.Context, query string, args ...interface{}) RowScanner
+	QueryContext(ctx context.Context, query string, args ...interface{}) (RowsInterface, error)
 	BeginTx(ctx context.Context) (TxInterface, error)
+	PingContext(ctx context.Context) error
 }
 
 type RowScanner interface {
@@ -89,6 +439,13 @@ type RowInterface interface {
 	Scan(dest ...interface{}) error
 }
 
+type RowsInterface interface {
+	Next() bool
+	Scan(dest ...interface{}) error
+	Close() error
+	Err() error
+}
+
 type ResultInterface interface {
 	LastInsertId() (int64, error)
 	RowsAffected() (int64, error)
@@ -104,20 +461,86 @@ type TxInterface interface {
 type CacheInterface interface {
 	LPush(ctx context.Context, key string, values ...interface{}) *redis.IntCmd
 	BRPop(ctx context.Context, timeout time.Duration, keys ...string) *redis.StringSliceCmd
+	ZAdd(ctx context.Context, key string, score float64, member string) error
+	ZRangeByScore(ctx context.Context, key string, max float64, limit int64) ([]string, error)
+	// MoveToQueue атомарно переносит member из множества setKey в очередь
+	// queueKey; false - элемента в множестве уже нет
+	MoveToQueue(ctx context.Context, setKey, member, queueKey string) (bool, error)
+	LRange(ctx context.Context, key string, start, stop int64) ([]string, error)
+	LLen(ctx context.Context, key string) (int64, error)
 	Delete(ctx context.Context, key string) error
 	Get(ctx context.Context, key string) (string, error)
 	Set(ctx context.Context, key string, value interface{}, expiration time.Duration) error
+	// SetMany записывает несколько значений за один обмен с кэшем
+	SetMany(ctx context.Context, entries []cache.Entry) error
+	SetNX(ctx context.Context, key string, value interface{}, expiration time.Duration) (bool, error)
+	// EnqueueUnique атомарно добавляет value в очередь, если ключа dedupKey нет,
+	// и записывает в него dedupValue на ttl; иначе возвращает значение ключа
+	EnqueueUnique(ctx context.Context, queueKey string, value interface{}, dedupKey, dedupValue string, ttl time.Duration) (enqueued bool, length int64, existing string, err error)
 }
 
 func NewWalletHandler(db DBInterface, cache CacheInterface, debugMode bool) *WalletHandler {
-	return &WalletHandler{
-		db:          db,
-		cache:       cache,
-		validator:   service.NewWalletValidator(),
-		rateLimiter: rate.NewLimiter(rate.Limit(2000), 1000),
-		debugMode:   debugMode,
-		semaphore:   make(chan struct{}, 1000),
+	return NewWalletHandlerWithConfig(db, cache, debugMode, DefaultConfig())
+}
+
+func NewWalletHandlerWithConfig(db DBInterface, cache CacheInterface, debugMode bool, config Config) *WalletHandler {
+	h := &WalletHandler{
+		db:              db,
+		repository:      NewWalletRepository(db),
+		cache:           cache,
+		config:          config,
+		rateLimiter:     rate.NewLimiter(rate.Limit(config.RateLimit), config.RateBurst),
+		ipLimiters:      newKeyedLimiters(config.IPRateLimit, config.IPRateBurst),
+		subjectLimiters: newKeyedLimiters(config.SubjectRateLimit, config.SubjectRateBurst),
+		debugMode:       debugMode,
+		semaphore:       make(chan struct{}, 1000),
+		cacheWrites:     make(chan cacheWrite, config.CacheWriteQueueSize),
+	}
+	if config.AuditSink != nil {
+		h.auditEntries = make(chan audit.Entry, config.AuditBufferSize)
+	}
+	h.maintenance.Store(config.MaintenanceMode)
+	h.display = newBalanceDisplay(config.DisplayCurrency)
+	h.saturatedReads = make(chan struct{}, config.SaturatedDBReads)
+	h.ApplySettings(func(settings *Settings) error {
+		*settings = settingsFromConfig(config)
+		return nil
+	})
+	h.validator = config.Validator
+	if h.validator == nil {
+		validatorConfig := service.ValidatorConfig{MinAmounts: config.MinAmounts}
+		if config.EnforceCurrencyPrecision {
+			validatorConfig.Currency = config.DisplayCurrency
+		}
+		h.validator = service.NewWalletValidatorWithConfig(validatorConfig)
+	}
+	h.messages = config.Messages
+	if h.messages == nil {
+		h.messages = DefaultMessages()
+	}
+	h.store = config.Store
+	if h.store == nil && config.BalanceMode == BalanceModeLedger {
+		h.store = NewLedgerStore(db, config.LockStrategy)
+	}
+	if h.store == nil {
+		h.store = NewPostgresStore(db, config.LockStrategy)
 	}
+	h.events = config.Events
+	if h.events == nil {
+		h.events = NewLocalEvents()
+	}
+	h.clock = config.Clock
+	if h.clock == nil {
+		h.clock = systemClock{}
+	}
+	h.metrics = config.Metrics
+	if h.metrics == nil {
+		h.metrics = metrics.Nop{}
+	}
+	if config.LocalCacheSize > 0 {
+		h.localCache = newLocalCache(config.LocalCacheSize, config.LocalCacheTTL)
+	}
+	return h
 }
 
 func (h *WalletHandler) GetWalletBalance(w http.ResponseWriter, r *http.Request) {
@@ -136,133 +559,312 @@ func (h *WalletHandler) GetWalletBalance(w http.ResponseWriter, r *http.Request)
 	case h.semaphore <- struct{}{}:
 		defer func() { <-h.semaphore }()
 	default:
-		http.Error(w, ErrServerBusy, http.StatusServiceUnavailable)
+		h.writeError(w, r, ErrServerBusy, http.StatusServiceUnavailable)
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	ctx, cancel := h.requestContext(r, defaultReadTimeout)
 	defer cancel()
 
-	walletID, err := uuid.Parse(r.URL.Path[len("/api/v1/wallets/"):])
-	if err != nil {
-		http.Error(w, ErrInvalidUUID, http.StatusBadRequest)
+	var walletID uuid.UUID
+	if !h.validate(w, r, h.pathID(r.URL.Path[len("/api/v1/wallets/"):], &walletID, ErrInvalidUUID)) {
+		return
+	}
+
+	if h.config.BlockReads && !h.checkNotBlocked(ctx, w, r, walletID.String()) {
 		return
 	}
 
+	// Баланс на момент времени не кэшируется
+	if at := r.URL.Query().Get("at"); at != "" {
+		h.sendBalanceAt(ctx, w, r, walletID, at)
+		return
+	}
+
+	convertTo := parseConvertTo(r)
 	cacheKey := fmt.Sprintf("balance:%s", walletID)
 
+	var minVersion int64
+	var hasMinVersion bool
+	if !h.validate(w, r, versionHeader(r, &minVersion, &hasMinVersion)) {
+		return
+	}
+
 	for i := 0; i < 3; i++ {
-		if balance, err := h.cache.Get(ctx, cacheKey); err == nil {
-			if err := h.sendResponse(w, balance); err == nil {
+		if cached, err := h.getCachedBalance(ctx, cacheKey); err == nil {
+			// Повреждённое значение в кэше - читаем баланс из БД
+			balance, stale, err := parseCachedBalance(cached, h.clock.Now())
+			if err != nil {
+				break
+			}
+			// Значение в кэше старше требуемой версии - проверяем БД
+			if hasMinVersion && balance.Version <= minVersion {
+				break
+			}
+			if stale {
+				h.refreshBalance(walletID)
+			}
+			setBalanceVersion(w, balance.Version)
+			h.setBalanceCacheHeaders(w, balance.CachedAt)
+			if len(convertTo) > 0 {
+				h.sendConvertedBalance(ctx, w, r, walletBalance{amount: balance.Balance, version: balance.Version}, convertTo)
+				return
+			}
+			if err := h.sendData(w, r, h.balanceResponse(walletBalance{amount: balance.Balance})); err == nil {
 				return
 			}
 		}
 		time.Sleep(time.Millisecond * 50 * time.Duration(i+1))
 	}
 
-	var balance float64
+	attempts, release, ok := h.acquireDBRead()
+	if !ok {
+		h.writeError(w, r, ErrServerBusy, http.StatusServiceUnavailable)
+		return
+	}
+	defer release()
+
+	var balance walletBalance
 	var dbErr error
-	for i := 0; i < 3; i++ {
-		balance, dbErr = h.getBalanceFromDB(ctx, walletID)
+	for i := 0; i < attempts; i++ {
+		balance, dbErr = h.loadBalance(ctx, walletID)
 		if dbErr == nil {
 			break
 		}
-		if dbErr.Error() == ErrWalletNotFound {
-			http.Error(w, ErrWalletNotFound, http.StatusNotFound)
+		if errors.Is(dbErr, service.ErrWalletNotFound) {
+			h.writeErr(w, r, dbErr)
 			return
 		}
+		// Таймаут истёк или клиент отключился - повтор не поможет
+		if ctx.Err() != nil || errors.Is(dbErr, context.DeadlineExceeded) || errors.Is(dbErr, context.Canceled) {
+			break
+		}
 		time.Sleep(time.Millisecond * 50 * time.Duration(i+1))
 	}
 
 	if dbErr != nil {
-		http.Error(w, ErrBalanceRetrievalFail, http.StatusServiceUnavailable)
+		h.writeReadError(ctx, w, r, dbErr, ErrBalanceRetrievalFail)
 		return
 	}
 
-	go func() {
-		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
-		defer cancel()
-		h.cache.Set(ctx, cacheKey, balance, 30*time.Second)
-	}()
+	setBalanceVersion(w, balance.version)
+	if hasMinVersion && balance.version <= minVersion {
+		h.writeError(w, r, ErrBalanceVersionStale, http.StatusConflict)
+		return
+	}
+
+	// Значение только что прочитано из БД и попадёт в кэш с полным временем жизни
+	h.setBalanceCacheHeaders(w, 0)
+	if len(convertTo) > 0 {
+		h.sendConvertedBalance(ctx, w, r, balance, convertTo)
+		return
+	}
 
-	if err := h.sendResponse(w, map[string]float64{"balance": balance}); err != nil {
-		http.Error(w, ErrSendResponse, http.StatusServiceUnavailable)
+	if err := h.sendData(w, r, h.balanceResponse(balance)); err != nil {
+		h.writeError(w, r, ErrSendResponse, http.StatusServiceUnavailable)
 		return
 	}
 }
 
+// writeReadError отвечает на ошибку чтения. Истёкший таймаут - 504; если клиент
+// отключился, ответ не пишется; остальные ошибки - 503 с сообщением message.
+// Драйвер БД не всегда оборачивает ошибку контекста, поэтому проверяется и сам ctx.
+func (h *WalletHandler) writeReadError(ctx context.Context, w http.ResponseWriter, r *http.Request, err error, message string) {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		err = ctxErr
+	}
+	switch {
+	case errors.Is(err, context.Canceled):
+		log.Printf("Клиент отключился до ответа: %s %s", r.Method, r.URL.Path)
+	case errors.Is(err, context.DeadlineExceeded):
+		h.writeError(w, r, ErrTimeout, http.StatusGatewayTimeout)
+	default:
+		h.writeError(w, r, message, http.StatusServiceUnavailable)
+	}
+}
+
 func (h *WalletHandler) HandleWalletOperation(w http.ResponseWriter, r *http.Request) {
-	if !h.rateLimiter.Allow() {
-		http.Error(w, ErrTooManyRequests, http.StatusTooManyRequests)
+	// Ответы на запись не кэшируются ни клиентом, ни прокси
+	w.Header().Set("Cache-Control", "no-store")
+	if delay, ok := h.reserveRateLimit(r); !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+		h.writeError(w, r, ErrTooManyRequests, http.StatusTooManyRequests)
 		return
 	}
 
 	if r.Method != http.MethodPost {
-		http.Error(w, ErrMethodNotAllowed, http.StatusMethodNotAllowed)
+		h.writeError(w, r, ErrMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !isJSONContentType(r.Header.Get("Content-Type")) {
+		h.writeError(w, r, ErrUnsupportedMediaType, http.StatusUnsupportedMediaType)
 		return
 	}
 
+	// Клиент может сократить время ожидания ответа, в том числе синхронного выполнения
+	ctx, cancel := h.requestContext(r, h.config.OperationTimeout)
+	defer cancel()
+	r = r.WithContext(ctx)
+
 	// Декодируем запрос
 	var request wallet.WalletRequest
 	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
-		http.Error(w, ErrParseRequest, http.StatusBadRequest)
+		h.writeDecodeError(w, r, err)
 		return
 	}
-
-	// Преобразуем string в uuid.UUID после декодирования
-	walletUUID, err := uuid.Parse(request.WalletID)
-	if err != nil {
-		http.Error(w, ErrInvalidUUID, http.StatusBadRequest)
+	if err := h.strictOperationType(request.OperationType); err != nil {
+		h.writeValidationError(w, r, err, http.StatusUnprocessableEntity)
 		return
 	}
 
-	// Создаем новый запрос с правильным UUID
+	// Новый запрос с UUID в канонической форме
 	validatedRequest := wallet.WalletRequest{
-		WalletID:      walletUUID.String(),
 		OperationType: request.OperationType,
 		Amount:        request.Amount,
+		Reference:     request.Reference,
+		// Для журнала аудита; значения из тела запроса не принимаются
+		Subject:  h.auditSubject(r),
+		SourceIP: sourceIP(r),
+		Trusted:  h.isTrustedCaller(r),
+		TraceID:  requestID(r),
+		DryRun:   request.DryRun,
 	}
+	w.Header().Set("X-Request-ID", validatedRequest.TraceID)
 
-	// Валидируем запрос перед обработкой
-	if err := h.validator.ValidateWalletRequest(&validatedRequest); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	// Валидируем запрос перед обработкой; данные доверенного вызова уже проверены
+	rules := []rule{
+		walletIDField(request.WalletID, &validatedRequest.WalletID),
+		h.priorityField(r, request.Priority, &validatedRequest.Priority),
+		h.expiresAtField(request.ExpiresAt, &validatedRequest.ExpiresAt),
+	}
+	if validatedRequest.Trusted {
+		rules = append(rules, trustedOperation(&validatedRequest))
+	} else {
+		rules = append(rules, h.walletRequest(&validatedRequest))
+	}
+	if !h.validate(w, r, rules...) {
 		return
 	}
 
-	// В режиме отладки обрабатываем операцию напрямую
-	if h.debugMode {
-		if err := h.handleOperation(r.Context(), &validatedRequest); err != nil {
-			http.Error(w, err.Message, err.Code)
-			return
-		}
-		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(map[string]string{
-			"status": SuccessOperation,
-		})
+	if !h.checkNotBlocked(r.Context(), w, r, validatedRequest.WalletID) {
+		return
+	}
+
+	// В режиме отладки обрабатываем операцию напрямую; пробный запуск
+	// в очередь не ставится - клиент сразу получает итоговый баланс
+	if h.debugMode || validatedRequest.DryRun {
+		h.processOperationNow(w, r, &validatedRequest)
 		return
 	}
 
 	// Стандартная обработка через очередь
+	validatedRequest.ID = uuid.New().String()
 	operationJSON, err := json.Marshal(validatedRequest)
 	if err != nil {
-		http.Error(w, ErrSerialization, http.StatusInternalServerError)
+		h.writeError(w, r, ErrSerialization, http.StatusInternalServerError)
 		return
 	}
 
-	// Отправляем в очередь
-	ctx := context.Background()
-	err = h.cache.LPush(ctx, "wallet_operations", operationJSON).Err()
+	// Отправляем в очередь. LPUSH возвращает длину очереди после добавления,
+	// а обработчики забирают операции с другого конца - это и есть позиция операции.
+	// Отключение клиента не прерывает постановку: иначе операция могла бы
+	// попасть в очередь, а клиент получить ошибку
+	pushCtx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), queuePushTimeout)
+	queueLength, priorID, err := h.pushOperation(pushCtx, &validatedRequest, operationJSON)
+	cancel()
+	if err == nil && priorID == "" {
+		h.observeQueueLength(validatedRequest.Priority, queueLength)
+	}
 	if err != nil {
-		http.Error(w, ErrQueueAdd, http.StatusInternalServerError)
+		if h.config.QueueFallback && queueNotReached(err) {
+			h.logOperation(&validatedRequest, "Очередь недоступна, операция %s выполняется синхронно: %v", validatedRequest.ID, err)
+			h.processOperationNow(w, r, &validatedRequest)
+			return
+		}
+		h.writeError(w, r, ErrQueueAdd, http.StatusInternalServerError)
 		return
 	}
+	if priorID != "" {
+		h.sendStatus(w, r, http.StatusAccepted, CodeOperationQueued, map[string]interface{}{
+			"operation_id": priorID,
+			"deduplicated": true,
+		})
+		return
+	}
+	h.logOperation(&validatedRequest, "Операция %s поставлена в очередь", validatedRequest.ID)
 
-	w.WriteHeader(http.StatusAccepted)
-	json.NewEncoder(w).Encode(map[string]string{
-		"status": SuccessQueueAdd,
+	h.sendStatus(w, r, http.StatusAccepted, CodeOperationQueued, map[string]interface{}{
+		"operation_id":   validatedRequest.ID,
+		"queue_position": queueLength,
 	})
 }
 
+// processOperationNow выполняет операцию в рамках запроса, минуя очередь:
+// в режиме отладки и при недоступной очереди с включённым QueueFallback
+func (h *WalletHandler) processOperationNow(w http.ResponseWriter, r *http.Request, req *wallet.WalletRequest) {
+	release, ok := h.tryAcquireWriteSlot()
+	if !ok {
+		h.writeError(w, r, ErrServerBusy, http.StatusServiceUnavailable)
+		return
+	}
+	defer release()
+
+	balanceBefore, balanceAfter, err := h.executeOperation(r.Context(), req)
+	if err != nil {
+		h.writeWalletError(w, r, err)
+		return
+	}
+	// Успешный ответ с неизменным балансом вводил бы клиента в заблуждение;
+	// операция при этом записана в журнал как обычно
+	if h.config.NoContentOnNoOp && isNoOp(balanceBefore, balanceAfter) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if req.DryRun {
+		h.sendStatus(w, r, http.StatusOK, CodeDryRun, map[string]interface{}{
+			"dry_run":        true,
+			"balance_before": newBalance(balanceBefore),
+			"balance_after":  newBalance(balanceAfter),
+		})
+		return
+	}
+	response := map[string]interface{}{
+		"balance_before": newBalance(balanceBefore),
+		"balance_after":  newBalance(balanceAfter),
+	}
+	if receipt := h.issueReceipt(req, balanceAfter); receipt != "" {
+		response["receipt"] = receipt
+	}
+	h.sendStatus(w, r, http.StatusOK, successCode(req.OperationType), response)
+}
+
+// isNoOp сообщает, что операция не изменила баланс: суммы сравниваются в
+// копейках, как их видит клиент, поэтому изменение меньше копейки тоже пустое
+func isNoOp(balanceBefore, balanceAfter float64) bool {
+	return newBalance(balanceBefore).BalanceMinor == newBalance(balanceAfter).BalanceMinor
+}
+
+// isJSONContentType проверяет, что тип содержимого - application/json (параметры вроде charset допускаются)
+func isJSONContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && mediaType == "application/json"
+}
+
+// Длина UUID в канонической форме xxxxxxxx-xxxx-xxxx-xxxx-xxxxxxxxxxxx
+const canonicalUUIDLength = 36
+
+// parseWalletID разбирает идентификатор кошелька из пути запроса. Принимается
+// только каноническая форма длиной ровно 36 символов: более длинные сегменты
+// отсекаются до разбора, а формы, которые допускает uuid.Parse (urn:uuid:,
+// фигурные скобки, без дефисов), не принимаются.
+func (h *WalletHandler) parseWalletID(raw string) (uuid.UUID, error) {
+	if len(raw) != canonicalUUIDLength {
+		return uuid.Nil, errors.New(ErrInvalidUUID)
+	}
+	return uuid.Parse(raw)
+}
+
 func (h *WalletHandler) ProcessQueue(ctx context.Context) {
 	// Добавляем worker pool
 	workers := make(chan struct{}, h.config.ConcurrencyLimit)
@@ -280,9 +882,23 @@ func (h *WalletHandler) ProcessQueue(ctx context.Context) {
 	}
 }
 
+// ExpiredOperations возвращает число операций, отброшенных из очереди по истечении срока действия
+func (h *WalletHandler) ExpiredOperations() int64 {
+	return h.expiredOperations.Load()
+}
+
 func (h *WalletHandler) processQueueItem(ctx context.Context) {
-	// Ожидаем новую операцию из очереди с таймаутом
-	result := h.cache.BRPop(ctx, 0, "wallet_operations")
+	// Пока БД недоступна или идёт обслуживание, операции остаются в очереди
+	if !h.isDBHealthy() || h.maintenance.Load() {
+		select {
+		case <-ctx.Done():
+		case <-time.After(h.config.HealthCheckInterval):
+		}
+		return
+	}
+
+	// Ожидаем новую операцию из очереди с таймаутом, чтобы периодически перепроверять состояние БД
+	result := h.cache.BRPop(ctx, h.config.HealthCheckInterval, h.nextQueueKeys()...)
 	if result.Err() != nil {
 		return
 	}
@@ -293,15 +909,127 @@ func (h *WalletHandler) processQueueItem(ctx context.Context) {
 		return
 	}
 
-	// Обрабатываем операцию
-	h.ProcessQueueOperation(operation)
+	// Операция, пролежавшая в очереди дольше срока действия, не выполняется
+	if operation.Expired(h.clock.Now()) {
+		h.expiredOperations.Add(1)
+		h.logOperation(&operation, "Операция %s отброшена: срок действия истёк %s", operation.ID, operation.ExpiresAt.Format(time.RFC3339))
+		h.publishEvent(operationEvent(operation, wallet.OperationExpired))
+		h.recordOperationStatus(wallet.OperationResult{ID: operation.ID, Status: wallet.OperationExpired})
+		return
+	}
+
+	// Горячий кошелек не занимает больше MaxWalletOperations обработчиков
+	release, ok := h.walletSlots.tryAcquire(operation.WalletID, h.config.MaxWalletOperations)
+	if !ok {
+		err := h.deferOperation(ctx, result.Val()[0], result.Val()[1])
+		if err == nil {
+			return
+		}
+		// Вернуть операцию в очередь не удалось: выполняем сверх лимита, чтобы её не потерять
+		h.logOperation(&operation, "Ошибка возврата операции %s в очередь: %v", operation.ID, err)
+		release = func() {}
+	}
+	defer release()
+
+	// Обрабатываем операцию; временные ошибки откладываются в очередь повторов
+	h.logOperation(&operation, "Обработка операции %s", operation.ID)
+	h.inFlightOperations.Add(1)
+	opResult, err := h.ProcessQueueOperation(operation)
+	h.inFlightOperations.Add(-1)
+	if err == nil {
+		h.logOperation(&operation, "Операция %s над кошельком %s выполнена, баланс: %s -> %s", opResult.ID,
+			h.logWalletID(operation.WalletID), h.logAmount(opResult.BalanceBefore), h.logAmount(opResult.BalanceAfter))
+		h.publishEvent(operationEvent(operation, wallet.OperationCompleted))
+		h.recordOperationStatus(opResult)
+		return
+	}
+	if !isTransient(err) {
+		h.logOperation(&operation, "Операция %s отклонена: %v", operation.ID, err)
+		h.publishEvent(operationEvent(operation, wallet.OperationFailed))
+		h.recordOperationStatus(opResult)
+		return
+	}
+	scheduled, retryErr := h.scheduleRetry(ctx, operation)
+	if retryErr != nil {
+		h.logOperation(&operation, "Ошибка откладывания операции %s: %v", operation.ID, retryErr)
+	} else if !scheduled {
+		h.logOperation(&operation, "Операция %s не выполнена после %d попыток: %v", operation.ID, operation.Attempts, err)
+		if err := h.deadLetter(ctx, operation); err != nil {
+			h.logOperation(&operation, "Ошибка переноса операции %s в очередь недоставленных: %v", operation.ID, err)
+		}
+		h.publishEvent(operationEvent(operation, wallet.OperationFailed))
+		h.recordOperationStatus(opResult)
+	}
 }
 
-func (h *WalletHandler) ProcessQueueOperation(op wallet.WalletRequest) error {
-	if err := h.handleOperation(context.Background(), &op); err != nil {
-		return err.Err
+// ProcessQueueOperation выполняет операцию из очереди. Результат содержит
+// новый баланс кошелька, если операция выполнена.
+func (h *WalletHandler) ProcessQueueOperation(op wallet.WalletRequest) (wallet.OperationResult, error) {
+	result := wallet.OperationResult{ID: op.ID, Status: wallet.OperationFailed}
+
+	// Кошелек мог быть заблокирован, пока операция ждала в очереди
+	blocked, err := h.isWalletBlocked(context.Background(), op.WalletID)
+	if err != nil {
+		return result, err
 	}
-	return nil
+	if blocked {
+		return result, service.ErrWalletBlocked
+	}
+
+	// Операции из очереди ждут освобождения слота, а не отклоняются
+	release := h.acquireWriteSlot()
+	defer release()
+
+	balanceBefore, balanceAfter, walletErr := h.executeOperation(context.Background(), &op)
+	if walletErr != nil {
+		return result, walletErr
+	}
+
+	result.BalanceBefore = balanceBefore
+	result.BalanceAfter = balanceAfter
+	result.Status = wallet.OperationCompleted
+	result.Receipt = h.issueReceipt(&op, balanceAfter)
+	return result, nil
+}
+
+// checkNotBlocked отвечает 403 для заблокированного кошелька и возвращает false
+func (h *WalletHandler) checkNotBlocked(ctx context.Context, w http.ResponseWriter, r *http.Request, walletID string) bool {
+	blocked, err := h.isWalletBlocked(ctx, walletID)
+	if err != nil {
+		h.writeError(w, r, ErrBlocklistCheck, http.StatusServiceUnavailable)
+		return false
+	}
+	if blocked {
+		h.writeWalletForbidden(w, r)
+		return false
+	}
+	return true
+}
+
+// tryAcquireWriteSlot занимает слот пишущей транзакции без ожидания и
+// возвращает функцию его освобождения
+func (h *WalletHandler) tryAcquireWriteSlot() (release func(), ok bool) {
+	limit := h.writeLimit.Load()
+	if limit == nil {
+		return func() {}, true
+	}
+	select {
+	case limit.slots <- struct{}{}:
+		return func() { <-limit.slots }, true
+	default:
+		return nil, false
+	}
+}
+
+// acquireWriteSlot ждёт слот пишущей транзакции и возвращает функцию его освобождения.
+// Слот освобождается в тот набор, из которого занят, даже если настройки изменились.
+func (h *WalletHandler) acquireWriteSlot() (release func()) {
+	limit := h.writeLimit.Load()
+	if limit == nil {
+		return func() {}
+	}
+	limit.slots <- struct{}{}
+	return func() { <-limit.slots }
 }
 
 func (h *WalletHandler) beginTx(ctx context.Context) (TxInterface, error) {
@@ -312,58 +1040,176 @@ func (h *WalletHandler) beginTx(ctx context.Context) (TxInterface, error) {
 	return tx, nil
 }
 
-func (h *WalletHandler) getCurrentBalance(tx TxInterface, walletID uuid.UUID) (float64, error) {
-	var currentBalance float64
-	err := tx.QueryRowContext(context.Background(), "SELECT balance FROM wallets WHERE id = $1 FOR UPDATE", walletID).Scan(&currentBalance)
+// beginStoreTx открывает транзакцию хранилища балансов
+func (h *WalletHandler) beginStoreTx(ctx context.Context) (StoreTx, error) {
+	tx, err := h.store.BeginTx(ctx)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return 0, errors.New(ErrWalletNotFound)
-		}
-		return 0, fmt.Errorf("%s: %w", ErrBalanceGet, err)
+		return nil, fmt.Errorf("%s: %w", ErrTxCreate, err)
+	}
+	return tx, nil
+}
+
+// postgresTx позволяет использовать транзакцию DBInterface там, где нужен StoreTx
+func (h *WalletHandler) postgresTx(tx TxInterface) StoreTx {
+	if h.config.BalanceMode == BalanceModeLedger {
+		return newLedgerTx(h.repository, tx, h.config.LockStrategy)
 	}
-	return currentBalance, nil
+	return newPostgresTx(h.repository, tx, h.config.LockStrategy)
+}
+
+// lockedWallet - состояние кошелька, прочитанное под блокировкой.
+// Флаги хранят запреты, чтобы нулевое значение разрешало обе операции, как и в БД по умолчанию.
+type lockedWallet struct {
+	balance          float64
+	depositDisabled  bool
+	withdrawDisabled bool
+}
+
+// allows сообщает, разрешены ли для кошелька операции в направлении direction
+func (w lockedWallet) allows(direction wallet.Direction) bool {
+	switch direction {
+	case wallet.Credit:
+		return !w.depositDisabled
+	case wallet.Debit:
+		return !w.withdrawDisabled
+	default:
+		return true
+	}
+}
+
+func (h *WalletHandler) getCurrentBalance(ctx context.Context, tx StoreTx, walletID uuid.UUID) (float64, error) {
+	locked, err := h.lockWallet(ctx, tx, walletID)
+	return locked.balance, err
 }
 
-func (h *WalletHandler) updateBalance(tx TxInterface, walletID uuid.UUID, newBalance float64) error {
-	_, err := tx.ExecContext(context.Background(), "UPDATE wallets SET balance = $1 WHERE id = $2", newBalance, walletID)
+// lockWallet блокирует кошелек до конца транзакции и читает его состояние
+func (h *WalletHandler) lockWallet(ctx context.Context, tx StoreTx, walletID uuid.UUID) (lockedWallet, error) {
+	stored, err := tx.LockWallet(ctx, walletID)
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return lockedWallet{}, service.ErrWalletNotFound
+		}
+		return lockedWallet{}, fmt.Errorf("%s: %w", ErrBalanceGet, err)
+	}
+	// Закрытый кошелек не принимает никаких операций
+	if stored.Closed {
+		return lockedWallet{}, service.ErrWalletClosed
+	}
+	return lockedWallet{
+		balance:          stored.Balance,
+		depositDisabled:  stored.DepositDisabled,
+		withdrawDisabled: stored.WithdrawDisabled,
+	}, nil
+}
+
+// shouldCreateWallet сообщает, создаётся ли отсутствующий кошелек для операции.
+// Списание никогда не создаёт кошелек, независимо от WalletPolicy.
+func (h *WalletHandler) shouldCreateWallet(direction wallet.Direction) bool {
+	return h.config.WalletPolicy == WalletPolicyAutoCreate && direction == wallet.Credit
+}
+
+// createWallet создаёт кошелек с нулевым балансом и блокирует его до конца транзакции.
+// Если кошелек параллельно создала другая транзакция, возвращается его текущий баланс.
+func (h *WalletHandler) createWallet(ctx context.Context, tx StoreTx, walletID uuid.UUID) (lockedWallet, error) {
+	if err := tx.CreateWallet(ctx, walletID); err != nil {
+		return lockedWallet{}, fmt.Errorf("%s: %w", ErrWalletCreate, err)
+	}
+	return h.lockWallet(ctx, tx, walletID)
+}
+
+func (h *WalletHandler) updateBalance(ctx context.Context, tx StoreTx, walletID uuid.UUID, newBalance float64) error {
+	if err := tx.UpdateBalance(ctx, walletID, newBalance); err != nil {
 		return fmt.Errorf("%s: %w", ErrBalanceUpdate, err)
 	}
 	return nil
 }
 
-func (h *WalletHandler) recordTransaction(tx TxInterface, walletID uuid.UUID, amount float64, operationType wallet.OperationType) error {
-	_, err := tx.ExecContext(context.Background(), `
-		INSERT INTO transactions (wallet_id, amount, operation_type, created_at)
-		VALUES ($1, $2, $3, NOW())
-	`, walletID, amount, operationType)
-	if err != nil {
+func (h *WalletHandler) recordTransaction(ctx context.Context, tx StoreTx, walletID uuid.UUID, amount float64, operationType wallet.OperationType, reference string) error {
+	if err := tx.RecordTransaction(ctx, walletID, amount, operationType, reference, h.clock.Now()); err != nil {
 		return fmt.Errorf("%s: %w", ErrTxRecord, err)
 	}
 	return nil
 }
 
 func (h *WalletHandler) handleOperation(ctx context.Context, req *wallet.WalletRequest) *WalletError {
-	// Валидация перед операцией
-	if err := h.validator.ValidateAmount(req.Amount); err != nil {
-		return &WalletError{
-			Code:    http.StatusBadRequest,
-			Message: err.Error(),
-			Err:     err,
+	_, _, walletErr := h.executeOperation(ctx, req)
+	return walletErr
+}
+
+// executeOperation выполняет операцию в транзакции и возвращает баланс до и после неё
+func (h *WalletHandler) executeOperation(ctx context.Context, req *wallet.WalletRequest) (balanceBefore, newBalance float64, walletErr *WalletError) {
+	// Пробный запуск ничего не меняет, в журнал аудита и метрики он не попадает
+	start := h.clock.Now()
+	defer func() {
+		if !req.DryRun {
+			h.recordAudit(operationAuditEntry(req, walletErr))
+			h.observeOperation(req, walletErr, h.clock.Now().Sub(start))
+		}
+	}()
+
+	// Валидация перед операцией; доверенный вызов пропускает только
+	// дополнительные проверки, сумма и тип операции проверяются всегда
+	if req.Trusted {
+		if err := trustedOperation(req)(); err != nil {
+			return 0, 0, &WalletError{
+				Code:    http.StatusBadRequest,
+				Message: err.Error(),
+				Err:     err,
+			}
+		}
+	} else {
+		if err := h.validator.ValidateAmount(req.Amount); err != nil {
+			return 0, 0, &WalletError{
+				Code:    http.StatusBadRequest,
+				Message: err.Error(),
+				Err:     err,
+			}
+		}
+
+		if err := h.validator.ValidateOperationType(req.OperationType); err != nil {
+			return 0, 0, &WalletError{
+				Code:    http.StatusBadRequest,
+				Message: err.Error(),
+				Err:     err,
+			}
 		}
 	}
 
-	if err := h.validator.ValidateOperationType(req.OperationType); err != nil {
-		return &WalletError{
-			Code:    http.StatusBadRequest,
-			Message: err.Error(),
-			Err:     err,
+	// Ожидание блокировки и вся транзакция ограничены OperationTimeout: по его
+	// истечении транзакция откатывается и блокировка кошелька снимается
+	ctx, cancel := context.WithTimeout(ctx, h.config.OperationTimeout)
+	defer cancel()
+	defer func() {
+		if walletErr != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			walletErr = &WalletError{
+				Code:    http.StatusGatewayTimeout,
+				Message: ErrOperationTimeout,
+				Err:     walletErr,
+			}
+		}
+	}()
+
+	// Сбой сериализации или взаимная блокировка откатывают всю транзакцию:
+	// она повторяется с начала до MaxRetries раз в пределах OperationTimeout
+	for attempt := 1; ; attempt++ {
+		balanceBefore, newBalance, walletErr = h.runOperationTx(ctx, req)
+		if walletErr == nil || !isSerializationFailure(walletErr) || attempt > h.config.MaxRetries {
+			return balanceBefore, newBalance, walletErr
+		}
+		h.logOperation(req, "Конфликт транзакции операции %s, повтор %d: %v", req.ID, attempt, walletErr.Err)
+		select {
+		case <-ctx.Done():
+			return balanceBefore, newBalance, walletErr
+		case <-time.After(serializationRetryDelay(attempt)):
 		}
 	}
+}
 
-	tx, err := h.beginTx(ctx)
+// runOperationTx выполняет одну попытку транзакции операции
+func (h *WalletHandler) runOperationTx(ctx context.Context, req *wallet.WalletRequest) (balanceBefore, newBalance float64, walletErr *WalletError) {
+	tx, err := h.beginStoreTx(ctx)
 	if err != nil {
-		return &WalletError{
+		return 0, 0, &WalletError{
 			Code:    http.StatusInternalServerError,
 			Message: ErrTxCreate,
 			Err:     err,
@@ -373,133 +1219,110 @@ func (h *WalletHandler) handleOperation(ctx context.Context, req *wallet.WalletR
 
 	walletUUID, err := uuid.Parse(req.WalletID)
 	if err != nil {
-		return &WalletError{
+		return 0, 0, &WalletError{
 			Code:    http.StatusBadRequest,
 			Message: ErrInvalidUUID,
 			Err:     err,
 		}
 	}
 
-	currentBalance, err := h.getCurrentBalance(tx, walletUUID)
+	direction, _ := wallet.LookupOperationType(req.OperationType)
+
+	locked, err := h.lockWallet(ctx, tx, walletUUID)
+	if err != nil && errors.Is(err, service.ErrWalletNotFound) && h.shouldCreateWallet(direction) {
+		locked, err = h.createWallet(ctx, tx, walletUUID)
+	}
 	if err != nil {
-		if err.Error() == ErrWalletNotFound {
-			return &WalletError{
+		if errors.Is(err, service.ErrWalletNotFound) {
+			return 0, 0, &WalletError{
 				Code:    http.StatusNotFound,
 				Message: ErrWalletNotFound,
 				Err:     err,
 			}
 		}
-		return &WalletError{
+		if errors.Is(err, service.ErrWalletClosed) {
+			return 0, 0, &WalletError{
+				Code:    http.StatusConflict,
+				Message: ErrWalletClosed,
+				Err:     err,
+			}
+		}
+		return 0, 0, &WalletError{
 			Code:    http.StatusInternalServerError,
 			Message: ErrBalanceGet,
 			Err:     err,
 		}
 	}
 
-	// Проверяем достаточно ли средств
-	if err := h.validator.ValidateBalance(currentBalance, req.Amount); err != nil {
-		return &WalletError{
-			Code:    http.StatusBadRequest,
-			Message: err.Error(),
-			Err:     err,
+	if !locked.allows(direction) {
+		return 0, 0, &WalletError{
+			Code:    http.StatusForbidden,
+			Message: ErrOperationNotAllowed,
 		}
 	}
+	currentBalance := locked.balance
 
-	switch req.OperationType {
-	case wallet.DEPOSIT:
-		newBalance := currentBalance + req.Amount
-		if err := h.updateBalance(tx, walletUUID, newBalance); err != nil {
-			return &WalletError{
-				Code:    http.StatusInternalServerError,
-				Message: ErrBalanceUpdate,
-				Err:     err,
-			}
-		}
-	case wallet.WITHDRAW:
-		if err := h.handleWithdraw(nil, req); err != nil {
-			return &WalletError{
-				Code:    http.StatusInternalServerError,
+	// Проверяем достаточно ли средств; зачисление баланс не уменьшает
+	if direction == wallet.Debit {
+		if err := h.validator.ValidateBalance(currentBalance, req.Amount); err != nil {
+			return 0, 0, &WalletError{
+				Code:    http.StatusBadRequest,
 				Message: err.Error(),
 				Err:     err,
 			}
 		}
+	}
+
+	switch direction {
+	case wallet.Credit:
+		newBalance = currentBalance + req.Amount
+	case wallet.Debit:
+		newBalance = currentBalance - req.Amount
 	default:
-		return &WalletError{
+		return 0, 0, &WalletError{
 			Code:    http.StatusBadRequest,
 			Message: ErrInvalidOperation,
 		}
 	}
 
-	if err := h.recordTransaction(tx, walletUUID, req.Amount, req.OperationType); err != nil {
-		return &WalletError{
-			Code:    http.StatusInternalServerError,
-			Message: ErrTxRecord,
-			Err:     err,
-		}
+	// Пробный запуск: проверки пройдены, транзакция откатывается без изменений
+	if req.DryRun {
+		return currentBalance, newBalance, nil
 	}
 
-	// Пдтвеждаем транзакцию
-	if err = tx.Commit(); err != nil {
-		return &WalletError{
+	// Зачисление и списание проходят один путь: баланс обновляется, а в журнал
+	// пишется ровно одна запись с суммой со знаком направления операции
+	if err := h.updateBalance(ctx, tx, walletUUID, newBalance); err != nil {
+		return 0, 0, &WalletError{
 			Code:    http.StatusInternalServerError,
-			Message: ErrTxCommit,
+			Message: ErrBalanceUpdate,
 			Err:     err,
 		}
 	}
 
-	return nil
-}
-
-func (h *WalletHandler) handleWithdraw(w http.ResponseWriter, req *wallet.WalletRequest) error {
-	tx, err := h.beginTx(context.Background())
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return err
-	}
-	defer tx.Rollback()
-
-	walletUUID, err := uuid.Parse(req.WalletID)
-	if err != nil {
-		return &WalletError{
-			Code:    http.StatusBadRequest,
-			Message: ErrInvalidUUID,
+	if err := h.recordTransaction(ctx, tx, walletUUID, float64(direction)*req.Amount, req.OperationType, req.Reference); err != nil {
+		return 0, 0, &WalletError{
+			Code:    http.StatusInternalServerError,
+			Message: ErrTxRecord,
 			Err:     err,
 		}
 	}
 
-	currentBalance, err := h.getCurrentBalance(tx, walletUUID)
-	if err != nil {
-		if err.Error() == ErrWalletNotFound {
-			http.Error(w, err.Error(), http.StatusNotFound)
-		} else {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-		}
-		return err
-	}
-
-	// Проверяем достаточно ли средств
-	if err := h.validator.ValidateBalance(currentBalance, req.Amount); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return err
-	}
-
-	newBalance := currentBalance - req.Amount
-	if err := h.updateBalance(tx, walletUUID, newBalance); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return err
-	}
-
-	if err := h.recordTransaction(tx, walletUUID, -req.Amount, req.OperationType); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return err
-	}
-
+	// Пдтвеждаем транзакцию
 	if err = tx.Commit(); err != nil {
-		http.Error(w, ErrTransactionCommit, http.StatusInternalServerError)
-		return err
+		return 0, 0, &WalletError{
+			Code:    http.StatusInternalServerError,
+			Message: ErrTxCommit,
+			Err:     err,
+		}
 	}
 
-	return h.sendSuccessResponse(w, SuccessWithdraw)
+	// Ключ Redis удаляется до сброса локального кэша: иначе следующее чтение
+	// взяло бы из Redis баланс до записи и снова положило его в локальный кэш
+	h.cache.Delete(ctx, balanceCacheKey(req.WalletID))
+	h.invalidateLocalBalance(req.WalletID)
+	h.publishEvent(balanceEvent(req.WalletID, newBalance))
+	return currentBalance, newBalance, nil
 }
 
 func (h *WalletHandler) sendResponse(w http.ResponseWriter, data interface{}) error {
@@ -507,31 +1330,58 @@ func (h *WalletHandler) sendResponse(w http.ResponseWriter, data interface{}) er
 	return json.NewEncoder(w).Encode(data)
 }
 
-func (h *WalletHandler) sendSuccessResponse(w http.ResponseWriter, message string) error {
-	w.WriteHeader(http.StatusOK)
-	return json.NewEncoder(w).Encode(map[string]string{
-		"status": message,
+// walletBalance - баланс кошелька, его версия и признак того, что кошелек закрыт
+type walletBalance struct {
+	amount  float64
+	version int64
+	closed  bool
+}
+
+// loadBalance читает баланс из БД и кладёт его в кэш. Одновременные запросы
+// одного кошелька объединяются в один запрос к БД. Баланс закрытого кошелька
+// не кэшируется, поэтому значение из кэша всегда относится к открытому кошельку.
+//
+// Общий запрос не зависит от отмены ctx запроса, который его начал: отключение
+// этого клиента не должно возвращать ошибку остальным ожидающим. Каждый
+// вызывающий перестаёт ждать при отмене своего ctx.
+func (h *WalletHandler) loadBalance(ctx context.Context, walletID uuid.UUID) (walletBalance, error) {
+	results := h.balanceFlight.DoChan(walletID.String(), func() (interface{}, error) {
+		fetchCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), defaultReadTimeout)
+		defer cancel()
+
+		balance, err := h.getBalanceFromDB(fetchCtx, walletID)
+		if err == nil && !balance.closed {
+			h.enqueueCacheWrite(fmt.Sprintf("balance:%s", walletID), h.cachedBalanceValue(balance), balanceCacheTTL)
+		}
+		return balance, err
 	})
+
+	select {
+	case result := <-results:
+		if result.Err != nil {
+			return walletBalance{}, result.Err
+		}
+		return result.Val.(walletBalance), nil
+	case <-ctx.Done():
+		return walletBalance{}, ctx.Err()
+	}
 }
 
-func (h *WalletHandler) getBalanceFromDB(ctx context.Context, walletID uuid.UUID) (float64, error) {
-	var balance float64
-	log.Printf("Получение баланса для кошелька: %s", walletID)
+func (h *WalletHandler) getBalanceFromDB(ctx context.Context, walletID uuid.UUID) (walletBalance, error) {
+	log.Printf("Получение баланса для кошелька: %s", h.logWalletID(walletID.String()))
 
-	err := h.db.QueryRowContext(
-		ctx,
-		"SELECT balance FROM wallets WHERE id = $1",
-		walletID,
-	).Scan(&balance)
+	start := time.Now()
+	stored, err := h.store.GetBalance(ctx, walletID)
+	h.dbLatency.observe(time.Since(start))
 
 	if err != nil {
 		log.Printf("Ошибка при получении баланса: %v", err)
-		if err == sql.ErrNoRows {
-			return 0, errors.New(ErrWalletNotFound)
+		if errors.Is(err, sql.ErrNoRows) {
+			return walletBalance{}, service.ErrWalletNotFound
 		}
-		return 0, fmt.Errorf("%s: %w", ErrBalanceGetDB, err)
+		return walletBalance{}, fmt.Errorf("%s: %w", ErrBalanceGetDB, err)
 	}
 
-	log.Printf("Получен баланс: %f", balance)
-	return balance, nil
+	log.Printf("Получен баланс: %s", h.logAmount(stored.Balance))
+	return walletBalance{amount: stored.Balance, version: stored.Version, closed: stored.Closed}, nil
 }
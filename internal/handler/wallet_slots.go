@@ -0,0 +1,56 @@
+package handler
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Пауза обработчика после возврата операции в очередь: если в очереди остались
+// только операции горячего кошелька, обработчики не крутятся вхолостую
+const walletDeferDelay = 50 * time.Millisecond
+
+// walletSlots - число операций из очереди, выполняющихся сейчас над каждым кошельком
+type walletSlots struct {
+	mu     sync.Mutex
+	active map[string]int
+}
+
+// tryAcquire занимает слот кошелька, если над ним выполняется меньше limit
+// операций; limit <= 0 снимает ограничение
+func (s *walletSlots) tryAcquire(walletID string, limit int) (release func(), ok bool) {
+	if limit <= 0 {
+		return func() {}, true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.active == nil {
+		s.active = make(map[string]int)
+	}
+	if s.active[walletID] >= limit {
+		return nil, false
+	}
+	s.active[walletID]++
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.active[walletID]--; s.active[walletID] <= 0 {
+			delete(s.active, walletID)
+		}
+	}, true
+}
+
+// deferOperation возвращает операцию в конец её очереди, пока над кошельком
+// выполняется MaxWalletOperations других. Порядок операций одного кошелька при
+// этом может измениться, как и при нескольких обработчиках.
+func (h *WalletHandler) deferOperation(ctx context.Context, queueKey, payload string) error {
+	if err := h.cache.LPush(ctx, queueKey, payload).Err(); err != nil {
+		return err
+	}
+	select {
+	case <-ctx.Done():
+	case <-time.After(walletDeferDelay):
+	}
+	return nil
+}
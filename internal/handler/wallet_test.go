@@ -1,23 +1,44 @@
 package handler
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"database/sql"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"wallet/internal/audit"
+	"wallet/internal/cache"
+	"wallet/internal/currency"
+	"wallet/internal/metrics"
 	wallet "wallet/internal/model"
+	"wallet/internal/msgpack"
 	"wallet/internal/service"
 
+	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 	"github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 	"golang.org/x/time/rate"
 )
 
@@ -27,8 +48,7 @@ type MockRow struct {
 
 func (m *MockRow) Scan(dest ...interface{}) error {
 	args := m.Called(dest...)
-	if args.Get(0) != nil {
-		balance := dest[0].(*float64)
+	if balance, ok := dest[0].(*float64); ok && args.Get(0) != nil {
 		*balance = 100.0
 	}
 	return args.Error(0)
@@ -39,10 +59,25 @@ type MockDB struct {
 }
 
 func (m *MockDB) QueryRowContext(ctx context.Context, query string, args ...interface{}) RowScanner {
-	called := m.Called(ctx, query, args[0])
+	// Ожидания задаются по первому аргументу запроса; запрос без аргументов - nil
+	var arg interface{}
+	if len(args) > 0 {
+		arg = args[0]
+	}
+	called := m.Called(ctx, query, arg)
 	return called.Get(0).(RowScanner)
 }
 
+func (m *MockDB) QueryContext(ctx context.Context, query string, args ...interface{}) (RowsInterface, error) {
+	called := m.Called(ctx, query, args)
+	return called.Get(0).(RowsInterface), called.Error(1)
+}
+
+func (m *MockDB) PingContext(ctx context.Context) error {
+	args := m.Called(ctx)
+	return args.Error(0)
+}
+
 func (m *MockDB) BeginTx(ctx context.Context) (TxInterface, error) {
 	args := m.Called(ctx)
 	return args.Get(0).(TxInterface), args.Error(1)
@@ -72,6 +107,37 @@ func (m *MockTx) Commit() error {
 	return args.Error(0)
 }
 
+// MockRows отдаёт заранее подготовленные строки результата
+type MockRows struct {
+	rows [][]interface{}
+	pos  int
+	err  error
+}
+
+func (m *MockRows) Next() bool {
+	if m.pos >= len(m.rows) {
+		return false
+	}
+	m.pos++
+	return true
+}
+
+func (m *MockRows) Scan(dest ...interface{}) error {
+	row := m.rows[m.pos-1]
+	for i, d := range dest {
+		reflect.ValueOf(d).Elem().Set(reflect.ValueOf(row[i]))
+	}
+	return nil
+}
+
+func (m *MockRows) Close() error {
+	return nil
+}
+
+func (m *MockRows) Err() error {
+	return m.err
+}
+
 type MockCache struct {
 	mock.Mock
 }
@@ -91,6 +157,31 @@ func (m *MockCache) BRPop(ctx context.Context, timeout time.Duration, keys ...st
 	return args.Get(0).(*redis.StringSliceCmd)
 }
 
+func (m *MockCache) ZAdd(ctx context.Context, key string, score float64, member string) error {
+	args := m.Called(ctx, key, score, member)
+	return args.Error(0)
+}
+
+func (m *MockCache) ZRangeByScore(ctx context.Context, key string, max float64, limit int64) ([]string, error) {
+	args := m.Called(ctx, key, max, limit)
+	return args.Get(0).([]string), args.Error(1)
+}
+
+func (m *MockCache) MoveToQueue(ctx context.Context, setKey, member, queueKey string) (bool, error) {
+	args := m.Called(ctx, setKey, member, queueKey)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockCache) LRange(ctx context.Context, key string, start, stop int64) ([]string, error) {
+	args := m.Called(ctx, key, start, stop)
+	return args.Get(0).([]string), args.Error(1)
+}
+
+func (m *MockCache) LLen(ctx context.Context, key string) (int64, error) {
+	args := m.Called(ctx, key)
+	return args.Get(0).(int64), args.Error(1)
+}
+
 func (m *MockCache) Get(ctx context.Context, key string) (string, error) {
 	args := m.Called(ctx, key)
 	return args.String(0), args.Error(1)
@@ -101,10 +192,173 @@ func (m *MockCache) Set(ctx context.Context, key string, value interface{}, expi
 	return args.Error(0)
 }
 
+func (m *MockCache) SetMany(ctx context.Context, entries []cache.Entry) error {
+	args := m.Called(ctx, entries)
+	return args.Error(0)
+}
+
+func (m *MockCache) SetNX(ctx context.Context, key string, value interface{}, expiration time.Duration) (bool, error) {
+	args := m.Called(ctx, key, value, expiration)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockCache) EnqueueUnique(ctx context.Context, queueKey string, value interface{}, dedupKey, dedupValue string, ttl time.Duration) (bool, int64, string, error) {
+	args := m.Called(ctx, queueKey, value, dedupKey, dedupValue, ttl)
+	return args.Bool(0), args.Get(1).(int64), args.String(2), args.Error(3)
+}
+
+// newJSONRequest создаёт POST-запрос операции с JSON-телом
+func newJSONRequest(body []byte) *http.Request {
+	req := httptest.NewRequest("POST", "/api/v1/wallet", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	return req
+}
+
+// errorMessage возвращает текст ошибки из JSON-тела ответа
+func errorMessage(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+	var body ErrorResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	return body.Error
+}
+
+// Тест разбора идентификатора в пути: только каноническая форма из 36 символов
+func TestParseWalletID(t *testing.T) {
+	id := uuid.New()
+
+	handler := NewWalletHandler(new(MockDB), new(MockCache), false)
+	parsed, err := handler.parseWalletID(id.String())
+	assert.NoError(t, err)
+	assert.Equal(t, id, parsed)
+
+	_, err = handler.parseWalletID(strings.ToUpper(id.String()))
+	assert.NoError(t, err)
+
+	for _, raw := range []string{
+		id.String() + strings.Repeat("0", 1000),
+		"urn:uuid:" + id.String(),
+		"{" + id.String() + "}",
+		strings.ReplaceAll(id.String(), "-", ""),
+	} {
+		_, err = handler.parseWalletID(raw)
+		assert.EqualError(t, err, ErrInvalidUUID, raw)
+	}
+}
+
+// newLocalizedHandler создаёт обработчик с переводами из каталога locales
+func newLocalizedHandler(t *testing.T, db DBInterface, cache CacheInterface) *WalletHandler {
+	config := DefaultConfig()
+	config.Messages = DefaultMessages()
+	assert.NoError(t, config.Messages.LoadDir("../../locales"))
+	return NewWalletHandlerWithConfig(db, cache, false, config)
+}
+
+// expectNotBlocked настраивает кэш так, что кошельки не заблокированы
+func expectNotBlocked(cache *MockCache) {
+	cache.On("Get", mock.Anything, mock.MatchedBy(func(key string) bool {
+		return strings.HasPrefix(key, blockedWalletKeyPrefix)
+	})).Return("", redis.Nil).Maybe()
+}
+
+// newWriteCache - кэш для тестов записи, разрешающий удаление ключей балансов
+// после подтверждения операции
+func newWriteCache() *MockCache {
+	cache := new(MockCache)
+	cache.On("Delete", mock.Anything, mock.MatchedBy(func(key string) bool {
+		return strings.HasPrefix(key, "balance:")
+	})).Return(nil).Maybe()
+	return cache
+}
+
 func TestAll(t *testing.T) {
 	// Основные тесты обработчиков HTTP
 	t.Run("GetWalletBalance", TestGetWalletBalance)
 	t.Run("HandleWalletOperation", TestHandleWalletOperation)
+	t.Run("DepositWithReference", TestDepositWithReference)
+	t.Run("RateLimitRetryAfter", TestRateLimitRetryAfter)
+	t.Run("ContentType", TestContentType)
+	t.Run("WriteSemaphore", TestWriteSemaphore)
+	t.Run("GetTransactionHistory", TestGetTransactionHistory)
+	t.Run("BalanceAt", TestBalanceAt)
+	t.Run("ResponseEnvelope", TestResponseEnvelope)
+	t.Run("BlockedWallets", TestBlockedWallets)
+	t.Run("CurrencyConversion", TestCurrencyConversion)
+	t.Run("CustomOperationType", TestCustomOperationType)
+	t.Run("RetryQueue", TestRetryQueue)
+	t.Run("QueuePosition", TestQueuePosition)
+	t.Run("HealthGate", TestHealthGate)
+	t.Run("SuccessCodes", TestSuccessCodes)
+	t.Run("LocalizedErrors", TestLocalizedErrors)
+	t.Run("ParseWalletID", TestParseWalletID)
+	t.Run("CustomValidator", TestCustomValidator)
+	t.Run("CacheWriter", TestCacheWriter)
+	t.Run("WalletPolicy", TestWalletPolicy)
+	t.Run("CanWithdraw", TestCanWithdraw)
+	t.Run("DecodeErrors", TestDecodeErrors)
+	t.Run("VoidTransaction", TestVoidTransaction)
+	t.Run("BalanceSingleFlight", TestBalanceSingleFlight)
+	t.Run("BalanceSingleFlightLeaderCancelled", TestBalanceSingleFlightLeaderCancelled)
+	t.Run("AuditLog", TestAuditLog)
+	t.Run("QueuePeek", TestQueuePeek)
+	t.Run("BalanceMinorUnits", TestBalanceMinorUnits)
+	t.Run("MaintenanceMode", TestMaintenanceMode)
+	t.Run("OperationResult", TestOperationResult)
+	t.Run("ClosedWallet", TestClosedWallet)
+	t.Run("StaleWhileRevalidate", TestStaleWhileRevalidate)
+	t.Run("Import", TestImport)
+	t.Run("OperationToggles", TestOperationToggles)
+	t.Run("LoadShedding", TestLoadShedding)
+	t.Run("RequestValidation", TestRequestValidation)
+	t.Run("OperationDedup", TestOperationDedup)
+	t.Run("BalanceVersion", TestBalanceVersion)
+	t.Run("Stores", TestStores)
+	t.Run("ConcealForbiddenWallets", TestConcealForbiddenWallets)
+	t.Run("RecoverPanics", TestRecoverPanics)
+	t.Run("InFlight", TestInFlight)
+	t.Run("Totals", TestTotals)
+	t.Run("WalletReset", TestWalletReset)
+	t.Run("Archive", TestArchive)
+	t.Run("WalletEvents", TestWalletEvents)
+	t.Run("TrustedCaller", TestTrustedCaller)
+	t.Run("Clock", TestClock)
+	t.Run("BalanceBeforeAfter", TestBalanceBeforeAfter)
+	t.Run("QueueFallback", TestQueueFallback)
+	t.Run("OperationTracing", TestOperationTracing)
+	t.Run("SettingsReload", TestSettingsReload)
+	t.Run("LogRedaction", TestLogRedaction)
+	t.Run("DryRun", TestDryRun)
+	t.Run("OperationPriority", TestOperationPriority)
+	t.Run("WalletOperationLimit", TestWalletOperationLimit)
+	t.Run("OperationExpiry", TestOperationExpiry)
+	t.Run("ErrorMapping", TestErrorMapping)
+	t.Run("OperationStatus", TestOperationStatus)
+	t.Run("LayeredRateLimit", TestLayeredRateLimit)
+	t.Run("SerializationRetry", TestSerializationRetry)
+	t.Run("ListSizeCeiling", TestListSizeCeiling)
+	t.Run("BalancePoll", TestBalancePoll)
+	t.Run("InvariantCheck", TestInvariantCheck)
+	t.Run("JSONNaming", TestJSONNaming)
+	t.Run("ShutdownFlush", TestShutdownFlush)
+	t.Run("ValidationFieldCodes", TestValidationFieldCodes)
+	t.Run("BalanceDisplay", TestBalanceDisplay)
+	t.Run("CacheWritePipelining", TestCacheWritePipelining)
+	t.Run("RequestTimeoutHeader", TestRequestTimeoutHeader)
+	t.Run("CacheFlush", TestCacheFlush)
+	t.Run("OperationJournal", TestOperationJournal)
+	t.Run("Metrics", TestMetrics)
+	t.Run("StrictOperationTypes", TestStrictOperationTypes)
+	t.Run("Receipts", TestReceipts)
+	t.Run("ScheduleHorizon", TestScheduleHorizon)
+	t.Run("WalletRepository", TestWalletRepository)
+	t.Run("CurrencyPrecision", TestCurrencyPrecision)
+	t.Run("LocalCache", TestLocalCache)
+	t.Run("NoOpOperations", TestNoOpOperations)
+	t.Run("LedgerBalance", TestLedgerBalance)
+	t.Run("WalletRebuild", TestWalletRebuild)
+	t.Run("MsgPackResponse", TestMsgPackResponse)
+	t.Run("BalanceContextErrors", TestBalanceContextErrors)
+	t.Run("BalanceCacheHeaders", TestBalanceCacheHeaders)
+	t.Run("OperationTimeout", TestOperationTimeout)
 
 	// Тесты обработки очереди
 	t.Run("ProcessQueue", TestProcessQueue)
@@ -134,7 +388,35 @@ func TestGetWalletBalance(t *testing.T) {
 		{
 			name:          "Неверный UUID",
 			walletID:      "invalid-uuid",
-			expectedCode:  http.StatusBadRequest,
+			expectedCode:  http.StatusUnprocessableEntity,
+			expectedError: ErrInvalidUUID,
+			mockSetup:     nil,
+		},
+		{
+			name:          "Слишком длинный сегмент пути",
+			walletID:      strings.Repeat("a", 10000),
+			expectedCode:  http.StatusUnprocessableEntity,
+			expectedError: ErrInvalidUUID,
+			mockSetup:     nil,
+		},
+		{
+			name:          "UUID без дефисов",
+			walletID:      strings.ReplaceAll(uuid.New().String(), "-", ""),
+			expectedCode:  http.StatusUnprocessableEntity,
+			expectedError: ErrInvalidUUID,
+			mockSetup:     nil,
+		},
+		{
+			name:          "UUID в фигурных скобках",
+			walletID:      "{" + uuid.New().String() + "}",
+			expectedCode:  http.StatusUnprocessableEntity,
+			expectedError: ErrInvalidUUID,
+			mockSetup:     nil,
+		},
+		{
+			name:          "UUID с префиксом urn",
+			walletID:      "urn:uuid:" + uuid.New().String(),
+			expectedCode:  http.StatusUnprocessableEntity,
 			expectedError: ErrInvalidUUID,
 			mockSetup:     nil,
 		},
@@ -156,12 +438,12 @@ func TestGetWalletBalance(t *testing.T) {
 				cache.On("Get", mock.Anything, cacheKey).Return("", redis.Nil).Times(3)
 
 				mockRow := new(MockRow)
-				mockRow.On("Scan", mock.Anything).Return(nil).Once()
+				mockRow.On("Scan", mock.Anything, mock.Anything, mock.Anything).Return(nil).Once()
 
 				parsedUUID, _ := uuid.Parse(walletID)
 				db.On("QueryRowContext",
 					mock.Anything,
-					"SELECT balance FROM wallets WHERE id = $1",
+					selectWalletBalanceQuery,
 					parsedUUID,
 				).Return(mockRow).Once()
 
@@ -184,12 +466,12 @@ func TestGetWalletBalance(t *testing.T) {
 				cache.On("Get", mock.Anything, cacheKey).Return("", redis.Nil).Times(3)
 
 				mockRow := new(MockRow)
-				mockRow.On("Scan", mock.Anything).Return(sql.ErrNoRows).Once()
+				mockRow.On("Scan", mock.Anything, mock.Anything, mock.Anything).Return(sql.ErrNoRows).Once()
 
 				parsedUUID, _ := uuid.Parse(walletID)
 				db.On("QueryRowContext",
 					mock.Anything,
-					"SELECT balance FROM wallets WHERE id = $1",
+					selectWalletBalanceQuery,
 					parsedUUID,
 				).Return(mockRow).Once()
 			},
@@ -243,7 +525,8 @@ func TestHandleWalletOperation(t *testing.T) {
 			},
 			expectedCode: http.StatusAccepted,
 			mockSetup: func(db *MockDB, cache *MockCache) {
-				cache.On("LPush", mock.Anything, "wallet_operations", mock.Anything).
+				expectNotBlocked(cache)
+				cache.On("LPush", mock.Anything, operationsQueueKey, mock.Anything).
 					Return(redis.NewIntCmd(context.Background())).Once()
 			},
 		},
@@ -254,7 +537,7 @@ func TestHandleWalletOperation(t *testing.T) {
 				OperationType: wallet.DEPOSIT,
 				Amount:        -100,
 			},
-			expectedCode:  http.StatusBadRequest,
+			expectedCode:  http.StatusUnprocessableEntity,
 			expectedError: fmt.Errorf(service.ErrValidationPrefix, service.ErrNegativeAmount).Error(),
 		},
 		{
@@ -264,7 +547,7 @@ func TestHandleWalletOperation(t *testing.T) {
 				OperationType: wallet.DEPOSIT,
 				Amount:        100,
 			},
-			expectedCode:  http.StatusBadRequest,
+			expectedCode:  http.StatusUnprocessableEntity,
 			expectedError: ErrInvalidUUID,
 		},
 	}
@@ -281,7 +564,7 @@ func TestHandleWalletOperation(t *testing.T) {
 			handler := NewWalletHandler(mockDB, mockCache, false)
 
 			body, _ := json.Marshal(tt.request)
-			req := httptest.NewRequest("POST", "/api/v1/wallet", bytes.NewBuffer(body))
+			req := newJSONRequest(body)
 			w := httptest.NewRecorder()
 
 			handler.HandleWalletOperation(w, req)
@@ -297,192 +580,7039 @@ func TestHandleWalletOperation(t *testing.T) {
 	}
 }
 
-// Тесты для ProcessQueue
-func TestProcessQueue(t *testing.T) {
-	tests := []struct {
-		name      string
-		operation wallet.WalletRequest
-		mockSetup func(*MockDB, *MockCache)
-	}{
-		{
-			name: "Успешная обработка операции",
-			operation: wallet.WalletRequest{
-				WalletID:      uuid.New().String(),
-				OperationType: wallet.DEPOSIT,
-				Amount:        100,
-			},
-			mockSetup: func(db *MockDB, cache *MockCache) {
-				opJSON, _ := json.Marshal(wallet.WalletRequest{
-					WalletID:      uuid.New().String(),
-					OperationType: wallet.DEPOSIT,
-					Amount:        100,
-				})
+// Тест депозита с комментарием: комментарий должен попасть в запись транзакции
+func TestDepositWithReference(t *testing.T) {
+	mockDB := new(MockDB)
+	mockTx := new(MockTx)
+	mockRow := new(MockRow)
+	walletID := uuid.New()
+	reference := "invoice #123"
+	now := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
 
-				// Настраиваем первый ответ очереди
-				successCmd := redis.NewStringSliceCmd(context.Background())
-				successCmd.SetVal([]string{"wallet_operations", string(opJSON)})
-				cache.On("BRPop", mock.Anything, time.Duration(0), []string{"wallet_operations"}).
-					Return(successCmd).Once()
+	mockDB.On("BeginTx", mock.Anything).Return(mockTx, nil).Once()
+	mockTx.On("QueryRowContext", mock.Anything, mock.Anything, mock.Anything).Return(mockRow).Once()
+	mockRow.On("Scan", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		*args.Get(0).(*float64) = 500
+	}).Return(nil).Once()
+	mockTx.On("ExecContext", mock.Anything, updateBalanceQuery, mock.Anything).
+		Return(&MockResult{}, nil).Once()
+	mockTx.On("ExecContext",
+		mock.Anything,
+		mock.MatchedBy(func(query string) bool { return strings.Contains(query, "INSERT INTO transactions") }),
+		[]interface{}{walletID, 100.0, wallet.DEPOSIT, reference, now},
+	).Return(&MockResult{}, nil).Once()
+	mockTx.On("Commit").Return(nil).Once()
+	mockTx.On("Rollback").Return(nil).Maybe()
 
-				// Настраиваем второй ответ с ошибкой для завершения цикла
-				errorCmd := redis.NewStringSliceCmd(context.Background())
-				errorCmd.SetErr(context.Canceled)
-				cache.On("BRPop", mock.Anything, time.Duration(0), []string{"wallet_operations"}).
-					Return(errorCmd).Maybe()
+	mockCache := newWriteCache()
+	expectNotBlocked(mockCache)
+	config := DefaultConfig()
+	config.Clock = newFakeClock(now)
+	handler := NewWalletHandlerWithConfig(mockDB, mockCache, true, config)
 
-				// Настраиваем транзакцию
-				mockTx := new(MockTx)
-				db.On("BeginTx", mock.Anything).Return(mockTx, nil).Once()
+	body, _ := json.Marshal(wallet.WalletRequest{
+		WalletID:      walletID.String(),
+		OperationType: wallet.DEPOSIT,
+		Amount:        100,
+		Reference:     reference,
+	})
+	req := newJSONRequest(body)
+	w := httptest.NewRecorder()
 
-				// Настраиваем получение баланса
-				mockRow := new(MockRow)
-				mockRow.On("Scan", mock.Anything).Return(nil).Once()
-				mockTx.On("QueryRowContext", mock.Anything, mock.Anything, mock.Anything).
-					Return(mockRow).Once()
+	handler.HandleWalletOperation(w, req)
 
-				// Настраиваем обновление баланса и запись транзакции
-				mockResult := &MockResult{}
-				mockTx.On("ExecContext", mock.Anything, mock.Anything, mock.Anything, mock.Anything).
-					Return(mockResult, nil).Times(2)
+	assert.Equal(t, http.StatusOK, w.Code)
+	mockDB.AssertExpectations(t)
+	mockTx.AssertExpectations(t)
+}
 
-				// Настраиваем Rollback и Commit
-				mockTx.On("Rollback").Return(nil).Maybe()
-				mockTx.On("Commit").Return(nil).Once()
-			},
-		},
+// При превышении лимита ответ содержит заголовок Retry-After
+func TestRateLimitRetryAfter(t *testing.T) {
+	mockCache := new(MockCache)
+	expectNotBlocked(mockCache)
+	mockCache.On("LPush", mock.Anything, operationsQueueKey, mock.Anything).
+		Return(redis.NewIntCmd(context.Background())).Once()
+
+	handler := NewWalletHandler(new(MockDB), mockCache, false)
+	handler.rateLimiter = rate.NewLimiter(rate.Every(2*time.Second), 1)
+
+	body, _ := json.Marshal(wallet.WalletRequest{
+		WalletID:      uuid.New().String(),
+		OperationType: wallet.DEPOSIT,
+		Amount:        100,
+	})
+
+	w := httptest.NewRecorder()
+	handler.HandleWalletOperation(w, newJSONRequest(body))
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Empty(t, w.Header().Get("Retry-After"))
+
+	w = httptest.NewRecorder()
+	handler.HandleWalletOperation(w, newJSONRequest(body))
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "2", w.Header().Get("Retry-After"))
+	assert.Contains(t, w.Body.String(), ErrTooManyRequests)
+
+	mockCache.AssertExpectations(t)
+}
+
+// Тесты проверки Content-Type
+func TestContentType(t *testing.T) {
+	body, _ := json.Marshal(wallet.WalletRequest{
+		WalletID:      uuid.New().String(),
+		OperationType: wallet.DEPOSIT,
+		Amount:        100,
+	})
+
+	tests := []struct {
+		name         string
+		contentType  string
+		expectedCode int
+	}{
+		{name: "Без Content-Type", contentType: "", expectedCode: http.StatusUnsupportedMediaType},
+		{name: "Форма", contentType: "application/x-www-form-urlencoded", expectedCode: http.StatusUnsupportedMediaType},
+		{name: "Текст", contentType: "text/plain", expectedCode: http.StatusUnsupportedMediaType},
+		{name: "JSON с charset", contentType: "application/json; charset=utf-8", expectedCode: http.StatusAccepted},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			mockDB := new(MockDB)
 			mockCache := new(MockCache)
+			expectNotBlocked(mockCache)
+			mockCache.On("LPush", mock.Anything, operationsQueueKey, mock.Anything).
+				Return(redis.NewIntCmd(context.Background())).Maybe()
 
-			if tt.mockSetup != nil {
-				tt.mockSetup(mockDB, mockCache)
-			}
-
-			handler := &WalletHandler{
-				db:          mockDB,
-				cache:       mockCache,
-				validator:   &service.WalletValidator{},
-				config:      Config{ConcurrencyLimit: 1},
-				rateLimiter: rate.NewLimiter(rate.Limit(100), 1),
+			handler := NewWalletHandler(new(MockDB), mockCache, false)
+			req := httptest.NewRequest("POST", "/api/v1/wallet", bytes.NewBuffer(body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
 			}
+			w := httptest.NewRecorder()
 
-			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
-			defer cancel()
-
-			handler.ProcessQueue(ctx)
+			handler.HandleWalletOperation(w, req)
 
-			mockDB.AssertExpectations(t)
-			mockCache.AssertExpectations(t)
+			assert.Equal(t, tt.expectedCode, w.Code)
+			if tt.expectedCode == http.StatusUnsupportedMediaType {
+				assert.Contains(t, w.Body.String(), ErrUnsupportedMediaType)
+				mockCache.AssertNotCalled(t, "LPush", mock.Anything, mock.Anything, mock.Anything)
+			}
 		})
 	}
 }
 
-// Добавляем MockResult
-type MockResult struct {
-	mock.Mock
-}
+// При заполненном семафоре пишущих транзакций операция отклоняется с 503
+func TestWriteSemaphore(t *testing.T) {
+	config := DefaultConfig()
+	config.MaxWriteTransactions = 2
+	mockDB := new(MockDB)
+	mockCache := new(MockCache)
+	expectNotBlocked(mockCache)
+	handler := NewWalletHandlerWithConfig(mockDB, mockCache, true, config)
 
-func (m *MockResult) LastInsertId() (int64, error) {
-	args := m.Called()
-	return args.Get(0).(int64), args.Error(1)
-}
+	// Занимаем все слоты
+	release, ok := handler.tryAcquireWriteSlot()
+	assert.True(t, ok)
+	_, ok = handler.tryAcquireWriteSlot()
+	assert.True(t, ok)
+	_, ok = handler.tryAcquireWriteSlot()
+	assert.False(t, ok)
 
-func (m *MockResult) RowsAffected() (int64, error) {
-	args := m.Called()
-	return args.Get(0).(int64), args.Error(1)
-}
+	body, _ := json.Marshal(wallet.WalletRequest{
+		WalletID:      uuid.New().String(),
+		OperationType: wallet.DEPOSIT,
+		Amount:        100,
+	})
+	w := httptest.NewRecorder()
+	handler.HandleWalletOperation(w, newJSONRequest(body))
 
-// Тесты для вспомоательных методов
-func TestHelperMethods(t *testing.T) {
-	mockDB := new(MockDB)
-	handler := NewWalletHandler(mockDB, nil, false)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), ErrServerBusy)
+	// До БД запрос не доходит
+	mockDB.AssertNotCalled(t, "BeginTx", mock.Anything)
 
-	t.Run("getCurrentBalance", func(t *testing.T) {
-		mockTx := new(MockTx)
-		mockRow := new(MockRow)
+	// После освобождения слот снова доступен
+	release()
+	_, ok = handler.tryAcquireWriteSlot()
+	assert.True(t, ok)
+}
+
+// Тесты для GetTransactionHistory
+func TestGetTransactionHistory(t *testing.T) {
+	t.Run("Комментарий возвращается в истории", func(t *testing.T) {
+		mockDB := new(MockDB)
 		walletID := uuid.New()
-		expectedBalance := 100.0
+		createdAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
 
-		mockTx.On("QueryRowContext",
-			mock.Anything,
-			"SELECT balance FROM wallets WHERE id = $1 FOR UPDATE",
-			mock.Anything,
-		).Return(mockRow).Once()
+		rows := &MockRows{rows: [][]interface{}{
+			{uuid.New().String(), walletID.String(), 100.0, wallet.DEPOSIT, "invoice #123", createdAt, (*time.Time)(nil), (*string)(nil)},
+			{uuid.New().String(), walletID.String(), -50.0, wallet.WITHDRAW, "", createdAt, (*time.Time)(nil), (*string)(nil)},
+		}}
+		mockDB.On("QueryContext", mock.Anything, historyQuery, []interface{}{walletID, historyLimit + 1, (*time.Time)(nil), (*string)(nil)}).
+			Return(rows, nil).Once()
 
-		mockRow.On("Scan", mock.Anything).Run(func(args mock.Arguments) {
-			balance := args.Get(0).(*float64)
-			*balance = expectedBalance
-		}).Return(nil).Once()
+		handler := NewWalletHandler(mockDB, new(MockCache), false)
+		req := httptest.NewRequest("GET", "/api/v1/wallets/"+walletID.String()+"/transactions", nil)
+		w := httptest.NewRecorder()
 
-		balance, err := handler.getCurrentBalance(mockTx, walletID)
-		assert.NoError(t, err)
-		assert.Equal(t, expectedBalance, balance)
+		handler.GetTransactionHistory(w, req)
 
-		mockTx.AssertExpectations(t)
-		mockRow.AssertExpectations(t)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var page Page[wallet.Transaction]
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&page))
+		history := page.Items
+		assert.Len(t, history, 2)
+		assert.Equal(t, "invoice #123", history[0].Reference)
+		assert.Equal(t, wallet.DEPOSIT, history[0].OperationType)
+		assert.Empty(t, history[1].Reference)
+
+		mockDB.AssertExpectations(t)
 	})
 
-	t.Run("beginTx", func(t *testing.T) {
-		mockTx := new(MockTx)
-		mockDB.On("BeginTx", mock.Anything).Return(mockTx, nil).Once()
+	t.Run("Журнал аудита включает отменённые операции", func(t *testing.T) {
+		mockDB := new(MockDB)
+		walletID := uuid.New()
+		originalID := uuid.New().String()
+		createdAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+		voidedAt := createdAt.Add(time.Hour)
 
-		tx, err := handler.beginTx(context.Background())
-		assert.NoError(t, err)
-		assert.NotNil(t, tx)
+		rows := &MockRows{rows: [][]interface{}{
+			{uuid.New().String(), walletID.String(), -100.0, wallet.VOID, "", voidedAt, (*time.Time)(nil), &originalID},
+			{originalID, walletID.String(), 100.0, wallet.DEPOSIT, "", createdAt, &voidedAt, (*string)(nil)},
+		}}
+		mockDB.On("QueryContext", mock.Anything, auditHistoryQuery, []interface{}{walletID, historyLimit + 1, (*time.Time)(nil), (*string)(nil)}).
+			Return(rows, nil).Once()
 
+		handler := NewWalletHandler(mockDB, new(MockCache), false)
+		req := httptest.NewRequest("GET", "/api/v1/wallets/"+walletID.String()+"/transactions?audit=true", nil)
+		w := httptest.NewRecorder()
+
+		handler.GetTransactionHistory(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var page Page[wallet.Transaction]
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&page))
+		history := page.Items
+		assert.Len(t, history, 2)
+		assert.Equal(t, originalID, *history[0].VoidOf)
+		assert.True(t, voidedAt.Equal(*history[1].VoidedAt))
 		mockDB.AssertExpectations(t)
 	})
-}
 
-func testMockRow(t *testing.T) {
-	mockRow := new(MockRow)
-	var balance float64
-	mockRow.On("Scan", mock.Anything).Return(nil).Once()
-	err := mockRow.Scan(&balance)
-	assert.NoError(t, err)
-	mockRow.AssertExpectations(t)
-}
+	t.Run("Постраничный вывод", func(t *testing.T) {
+		mockDB := new(MockDB)
+		walletID := uuid.New()
+		createdAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+		ids := []string{uuid.New().String(), uuid.New().String(), uuid.New().String()}
 
-func testMockDB(t *testing.T) {
-	mockDB := new(MockDB)
-	ctx := context.Background()
-	mockRow := new(MockRow)
+		row := func(id string, at time.Time) []interface{} {
+			return []interface{}{id, walletID.String(), 10.0, wallet.DEPOSIT, "", at, (*time.Time)(nil), (*string)(nil)}
+		}
+		// Первая страница: запрошено на одну операцию больше лимита
+		mockDB.On("QueryContext", mock.Anything, historyQuery, []interface{}{walletID, 3, (*time.Time)(nil), (*string)(nil)}).
+			Return(&MockRows{rows: [][]interface{}{
+				row(ids[0], createdAt.Add(2*time.Second)),
+				row(ids[1], createdAt.Add(time.Second)),
+				row(ids[2], createdAt),
+			}}, nil).Once()
 
-	mockDB.On("QueryRowContext", mock.Anything, mock.Anything, mock.Anything).Return(mockRow).Once()
-	row := mockDB.QueryRowContext(ctx, "query", "arg")
-	assert.NotNil(t, row)
+		handler := NewWalletHandler(mockDB, new(MockCache), false)
+		w := httptest.NewRecorder()
+		handler.GetTransactionHistory(w, httptest.NewRequest("GET", "/api/v1/wallets/"+walletID.String()+"/transactions?limit=2", nil))
 
-	mockDB.AssertExpectations(t)
-}
+		assert.Equal(t, http.StatusOK, w.Code)
+		var page map[string]json.RawMessage
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+		assert.Len(t, page, 2)
+		assert.Contains(t, page, "items")
 
-func testMockTx(t *testing.T) {
-	mockTx := new(MockTx)
-	mockRow := new(MockRow)
+		var first Page[wallet.Transaction]
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &first))
+		assert.Len(t, first.Items, 2)
+		assert.Equal(t, ids[1], first.Items[1].ID)
+		assert.True(t, first.Page.HasMore)
+		assert.Equal(t, 2, first.Page.Limit)
+		assert.Equal(t, timeCursor{At: createdAt.Add(time.Second), ID: ids[1]}.String(), first.Page.NextCursor)
 
-	mockTx.On("QueryRowContext", mock.Anything, mock.Anything, mock.Anything).Return(mockRow).Once()
-}
+		// Последняя страница продолжается с курсора и не содержит next_cursor
+		cursorAt := createdAt.Add(time.Second)
+		mockDB.On("QueryContext", mock.Anything, historyQuery, []interface{}{walletID, 3, &cursorAt, &ids[1]}).
+			Return(&MockRows{rows: [][]interface{}{row(ids[2], createdAt)}}, nil).Once()
 
-func testMockCache(t *testing.T) {
-	mockCache := new(MockCache)
-	ctx := context.Background()
+		w = httptest.NewRecorder()
+		handler.GetTransactionHistory(w, httptest.NewRequest("GET", "/api/v1/wallets/"+walletID.String()+"/transactions?limit=2&cursor="+first.Page.NextCursor, nil))
 
-	mockCache.On("Get", mock.Anything, mock.Anything).Return("", nil).Once()
-	_, err := mockCache.Get(ctx, "test-key")
-	assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, w.Code)
+		var last Page[wallet.Transaction]
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &last))
+		assert.Len(t, last.Items, 1)
+		assert.Equal(t, PageInfo{HasMore: false, Limit: 2}, last.Page)
+		mockDB.AssertExpectations(t)
+	})
 
-	mockCache.AssertExpectations(t)
-}
+	t.Run("Пустая история - пустой список", func(t *testing.T) {
+		mockDB := new(MockDB)
+		walletID := uuid.New()
+		mockDB.On("QueryContext", mock.Anything, historyQuery, mock.Anything).Return(&MockRows{}, nil).Once()
 
-func testMockResult(t *testing.T) {
-	mockResult := new(MockResult)
+		handler := NewWalletHandler(mockDB, new(MockCache), false)
+		w := httptest.NewRecorder()
+		handler.GetTransactionHistory(w, httptest.NewRequest("GET", "/api/v1/wallets/"+walletID.String()+"/transactions", nil))
 
-	mockResult.On("RowsAffected").Return(int64(1), nil).Once()
-	rows, err := mockResult.RowsAffected()
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"items":[],"page":{"has_more":false,"limit":100}}`, w.Body.String())
+	})
 
-	assert.NoError(t, err)
-	assert.Equal(t, int64(1), rows)
-	mockResult.AssertExpectations(t)
+	t.Run("Сортировка по возрастанию из списка допустимых полей", func(t *testing.T) {
+		mockDB := new(MockDB)
+		walletID := uuid.New()
+		ascending := buildHistoryQuery(false, false, sortOrder{Column: "created_at"})
+		mockDB.On("QueryContext", mock.Anything, ascending, mock.Anything).Return(&MockRows{}, nil).Once()
+
+		handler := NewWalletHandler(mockDB, new(MockCache), false)
+		w := httptest.NewRecorder()
+		handler.GetTransactionHistory(w, httptest.NewRequest("GET", "/api/v1/wallets/"+walletID.String()+"/transactions?sort_by=created_at&order=asc", nil))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, ascending, "ORDER BY created_at ASC, id ASC")
+		assert.Contains(t, ascending, "(created_at, id) > ($3::timestamptz, $4::uuid)")
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("Попытка внедрить SQL в сортировку - 400", func(t *testing.T) {
+		walletID := uuid.New()
+		for _, params := range []string{
+			"sort_by=" + url.QueryEscape("created_at; DROP TABLE wallets --"),
+			"sort_by=amount",
+			"sort_by=created_at&order=" + url.QueryEscape("desc, (SELECT pg_sleep(10))"),
+		} {
+			mockDB := new(MockDB)
+			handler := NewWalletHandler(mockDB, new(MockCache), false)
+			w := httptest.NewRecorder()
+			handler.GetTransactionHistory(w, httptest.NewRequest("GET", "/api/v1/wallets/"+walletID.String()+"/transactions?"+params, nil))
+
+			assert.Equal(t, http.StatusBadRequest, w.Code, params)
+			mockDB.AssertNotCalled(t, "QueryContext", mock.Anything, mock.Anything, mock.Anything)
+		}
+	})
+
+	t.Run("Неверные параметры страницы - 422", func(t *testing.T) {
+		walletID := uuid.New()
+		cases := map[string]string{
+			"limit=0":   ErrInvalidPageLimit,
+			"limit=abc": ErrInvalidPageLimit,
+			"cursor=!!": ErrInvalidPageCursor,
+			"cursor=" + base64.RawURLEncoding.EncodeToString([]byte("2024-01-02T03:04:05Z|x")): ErrInvalidPageCursor,
+		}
+		for query, message := range cases {
+			mockDB := new(MockDB)
+			handler := NewWalletHandler(mockDB, new(MockCache), false)
+			w := httptest.NewRecorder()
+			handler.GetTransactionHistory(w, httptest.NewRequest("GET", "/api/v1/wallets/"+walletID.String()+"/transactions?"+query, nil))
+
+			assert.Equal(t, http.StatusUnprocessableEntity, w.Code, query)
+			assert.Contains(t, w.Body.String(), message, query)
+			mockDB.AssertNotCalled(t, "QueryContext", mock.Anything, mock.Anything, mock.Anything)
+		}
+	})
+
+	t.Run("Неверный UUID", func(t *testing.T) {
+		handler := NewWalletHandler(new(MockDB), new(MockCache), false)
+		req := httptest.NewRequest("GET", "/api/v1/wallets/invalid-uuid/transactions", nil)
+		w := httptest.NewRecorder()
+
+		handler.GetTransactionHistory(w, req)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		assert.Contains(t, w.Body.String(), ErrInvalidUUID)
+	})
+}
+
+// Тесты баланса на момент времени
+func TestBalanceAt(t *testing.T) {
+	walletID := uuid.New()
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	scanInto := func(minor int64) func(mock.Arguments) {
+		return func(args mock.Arguments) {
+			*args.Get(0).(*int64) = minor
+		}
+	}
+
+	t.Run("Снимок плюс операции после него", func(t *testing.T) {
+		mockDB := new(MockDB)
+		snapshotRow := new(MockRow)
+		snapshotRow.On("Scan", mock.Anything).Run(scanInto(125000)).Return(nil).Once()
+		mockDB.On("QueryRowContext", mock.Anything, balanceAtFromSnapshotQuery, walletID).
+			Return(snapshotRow).Once()
+
+		handler := NewWalletHandler(mockDB, new(MockCache), false)
+		req := httptest.NewRequest("GET", "/api/v1/wallets/"+walletID.String()+"?at="+at.Format(time.RFC3339), nil)
+		w := httptest.NewRecorder()
+
+		handler.GetWalletBalance(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var body struct {
+			Balance
+			AsOf time.Time `json:"as_of"`
+		}
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&body))
+		assert.Equal(t, Balance{Balance: "1250.00", BalanceMinor: 125000}, body.Balance)
+		assert.True(t, at.Equal(body.AsOf))
+
+		// Запрос по текущему балансу не выполняется, если найден снимок
+		mockDB.AssertNotCalled(t, "QueryRowContext", mock.Anything, balanceAtFromCurrentQuery, walletID)
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("Без снимка используется текущий баланс", func(t *testing.T) {
+		mockDB := new(MockDB)
+		snapshotRow := new(MockRow)
+		snapshotRow.On("Scan", mock.Anything).Return(sql.ErrNoRows).Once()
+		currentRow := new(MockRow)
+		currentRow.On("Scan", mock.Anything).Run(scanInto(90000)).Return(nil).Once()
+		mockDB.On("QueryRowContext", mock.Anything, balanceAtFromSnapshotQuery, walletID).
+			Return(snapshotRow).Once()
+		mockDB.On("QueryRowContext", mock.Anything, balanceAtFromCurrentQuery, walletID).
+			Return(currentRow).Once()
+
+		handler := NewWalletHandler(mockDB, new(MockCache), false)
+		balance, err := handler.getBalanceAt(context.Background(), walletID, at)
+
+		assert.NoError(t, err)
+		assert.Equal(t, int64(90000), balance)
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("Неверный формат времени", func(t *testing.T) {
+		handler := NewWalletHandler(new(MockDB), new(MockCache), false)
+		req := httptest.NewRequest("GET", "/api/v1/wallets/"+walletID.String()+"?at=yesterday", nil)
+		w := httptest.NewRecorder()
+
+		handler.GetWalletBalance(w, req)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		assert.Contains(t, w.Body.String(), ErrInvalidTimestamp)
+	})
+
+	t.Run("Создание снимков", func(t *testing.T) {
+		mockDB := new(MockDB)
+		mockTx := new(MockTx)
+		mockDB.On("BeginTx", mock.Anything).Return(mockTx, nil).Once()
+		mockTx.On("ExecContext", mock.Anything, createSnapshotsQuery, mock.Anything).
+			Return(&MockResult{}, nil).Once()
+		mockTx.On("Commit").Return(nil).Once()
+		mockTx.On("Rollback").Return(nil).Maybe()
+
+		handler := NewWalletHandler(mockDB, new(MockCache), false)
+		assert.NoError(t, handler.createSnapshots(context.Background()))
+
+		mockDB.AssertExpectations(t)
+		mockTx.AssertExpectations(t)
+	})
+}
+
+// Тесты обёртки ответа
+func TestResponseEnvelope(t *testing.T) {
+	walletID := uuid.New()
+	cacheKey := fmt.Sprintf("balance:%s", walletID)
+
+	newBalanceRequest := func() *http.Request {
+		req := httptest.NewRequest("GET", "/api/v1/wallets/"+walletID.String(), nil)
+		req.Header.Set("X-Request-ID", "req-42")
+		return req
+	}
+
+	t.Run("Баланс в обёртке", func(t *testing.T) {
+		mockCache := new(MockCache)
+		mockCache.On("Get", mock.Anything, cacheKey).Return("", redis.Nil).Times(3)
+		mockCache.On("Set", mock.Anything, cacheKey, mock.Anything, mock.Anything).Return(nil).Maybe()
+
+		mockDB := new(MockDB)
+		mockRow := new(MockRow)
+		mockRow.On("Scan", mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+			*args.Get(0).(*float64) = 250
+		}).Return(nil).Once()
+		mockDB.On("QueryRowContext", mock.Anything, selectWalletBalanceQuery, walletID).
+			Return(mockRow).Once()
+
+		config := DefaultConfig()
+		config.ResponseEnvelope = true
+		handler := NewWalletHandlerWithConfig(mockDB, mockCache, false, config)
+		w := httptest.NewRecorder()
+
+		handler.GetWalletBalance(w, newBalanceRequest())
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "req-42", w.Header().Get("X-Request-ID"))
+
+		var envelope struct {
+			Data Balance      `json:"data"`
+			Meta EnvelopeMeta `json:"meta"`
+		}
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&envelope))
+		assert.Equal(t, Balance{Balance: "250.00", BalanceMinor: 25000}, envelope.Data)
+		assert.Equal(t, "req-42", envelope.Meta.RequestID)
+		assert.False(t, envelope.Meta.Timestamp.IsZero())
+
+		time.Sleep(100 * time.Millisecond)
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("Обёртка выключена по умолчанию", func(t *testing.T) {
+		mockCache := new(MockCache)
+		mockCache.On("Get", mock.Anything, cacheKey).Return("100.0", nil).Once()
+
+		handler := NewWalletHandler(new(MockDB), mockCache, false)
+		w := httptest.NewRecorder()
+
+		handler.GetWalletBalance(w, newBalanceRequest())
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NotContains(t, w.Body.String(), `"meta"`)
+		mockCache.AssertExpectations(t)
+	})
+}
+
+// Тесты списка заблокированных кошельков
+func TestBlockedWallets(t *testing.T) {
+	walletID := uuid.New()
+	blockedKey := blockedWalletKeyPrefix + walletID.String()
+
+	t.Run("Депозит на заблокированный кошелек отклоняется", func(t *testing.T) {
+		mockDB := new(MockDB)
+		mockCache := new(MockCache)
+		mockCache.On("Get", mock.Anything, blockedKey).Return("1", nil).Once()
+
+		handler := NewWalletHandler(mockDB, mockCache, true)
+		body, _ := json.Marshal(wallet.WalletRequest{
+			WalletID:      walletID.String(),
+			OperationType: wallet.DEPOSIT,
+			Amount:        100,
+		})
+		w := httptest.NewRecorder()
+
+		handler.HandleWalletOperation(w, newJSONRequest(body))
+
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Contains(t, w.Body.String(), ErrWalletBlocked)
+		mockDB.AssertNotCalled(t, "BeginTx", mock.Anything)
+		mockCache.AssertExpectations(t)
+	})
+
+	t.Run("Операция из очереди для заблокированного кошелька", func(t *testing.T) {
+		mockDB := new(MockDB)
+		mockCache := new(MockCache)
+		mockCache.On("Get", mock.Anything, blockedKey).Return("1", nil).Once()
+
+		handler := NewWalletHandler(mockDB, mockCache, false)
+		_, err := handler.ProcessQueueOperation(wallet.WalletRequest{
+			WalletID:      walletID.String(),
+			OperationType: wallet.DEPOSIT,
+			Amount:        100,
+		})
+
+		assert.EqualError(t, err, ErrWalletBlocked)
+		mockDB.AssertNotCalled(t, "BeginTx", mock.Anything)
+	})
+
+	t.Run("Чтение баланса при BlockReads", func(t *testing.T) {
+		mockCache := new(MockCache)
+		mockCache.On("Get", mock.Anything, blockedKey).Return("1", nil).Once()
+
+		config := DefaultConfig()
+		config.BlockReads = true
+		handler := NewWalletHandlerWithConfig(new(MockDB), mockCache, false, config)
+		w := httptest.NewRecorder()
+
+		handler.GetWalletBalance(w, httptest.NewRequest("GET", "/api/v1/wallets/"+walletID.String(), nil))
+
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		mockCache.AssertExpectations(t)
+	})
+
+	t.Run("Блокировка и разблокировка администратором", func(t *testing.T) {
+		mockCache := new(MockCache)
+		mockCache.On("Set", mock.Anything, blockedKey, "1", time.Duration(0)).Return(nil).Once()
+		mockCache.On("Delete", mock.Anything, blockedKey).Return(nil).Once()
+
+		config := DefaultConfig()
+		config.AdminToken = "secret"
+		handler := NewWalletHandlerWithConfig(new(MockDB), mockCache, false, config)
+		path := "/api/v1/admin/wallets/" + walletID.String() + "/block"
+
+		for _, method := range []string{"POST", "DELETE"} {
+			req := httptest.NewRequest(method, path, nil)
+			req.Header.Set("Authorization", "Bearer secret")
+			w := httptest.NewRecorder()
+
+			handler.HandleWalletBlock(w, req)
+			assert.Equal(t, http.StatusOK, w.Code)
+		}
+
+		mockCache.AssertExpectations(t)
+	})
+
+	t.Run("Администрирование без токена запрещено", func(t *testing.T) {
+		config := DefaultConfig()
+		config.AdminToken = "secret"
+		handler := NewWalletHandlerWithConfig(new(MockDB), new(MockCache), false, config)
+		w := httptest.NewRecorder()
+
+		handler.HandleWalletBlock(w, httptest.NewRequest("POST", "/api/v1/admin/wallets/"+walletID.String()+"/block", nil))
+
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+}
+
+// Зарегистрированный тип с зачислением увеличивает баланс, как DEPOSIT
+func TestCustomOperationType(t *testing.T) {
+	adjustment := wallet.OperationType("ADJUSTMENT")
+	wallet.RegisterOperationType(adjustment, wallet.Credit)
+	defer wallet.UnregisterOperationType(adjustment)
+
+	mockDB := new(MockDB)
+	mockTx := new(MockTx)
+	mockRow := new(MockRow)
+	walletID := uuid.New()
+
+	mockDB.On("BeginTx", mock.Anything).Return(mockTx, nil).Once()
+	mockTx.On("QueryRowContext", mock.Anything, mock.Anything, mock.Anything).Return(mockRow).Once()
+	mockRow.On("Scan", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		*args.Get(0).(*float64) = 500
+	}).Return(nil).Once()
+	mockTx.On("ExecContext", mock.Anything, updateBalanceQuery,
+		[]interface{}{550.0, walletID}).Return(&MockResult{}, nil).Once()
+	mockTx.On("ExecContext", mock.Anything, mock.Anything,
+		recordedTransaction(walletID, 50.0, adjustment, "")).Return(&MockResult{}, nil).Once()
+	mockTx.On("Commit").Return(nil).Once()
+	mockTx.On("Rollback").Return(nil).Maybe()
+
+	handler := NewWalletHandler(mockDB, newWriteCache(), false)
+	err := handler.handleOperation(context.Background(), &wallet.WalletRequest{
+		WalletID:      walletID.String(),
+		OperationType: adjustment,
+		Amount:        50,
+	})
+
+	assert.Nil(t, err)
+	mockTx.AssertExpectations(t)
+}
+
+// Тесты политики для несуществующих кошельков
+func TestWalletPolicy(t *testing.T) {
+	newPolicyHandler := func(policy WalletPolicy, mockTx *MockTx) *WalletHandler {
+		mockDB := new(MockDB)
+		mockDB.On("BeginTx", mock.Anything).Return(mockTx, nil).Once()
+		mockTx.On("Rollback").Return(nil).Maybe()
+
+		config := DefaultConfig()
+		config.WalletPolicy = policy
+		return NewWalletHandlerWithConfig(mockDB, newWriteCache(), false, config)
+	}
+
+	missingRow := func() *MockRow {
+		mockRow := new(MockRow)
+		mockRow.On("Scan", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(sql.ErrNoRows).Once()
+		return mockRow
+	}
+
+	t.Run("strict: зачисление на несуществующий кошелек - 404", func(t *testing.T) {
+		mockTx := new(MockTx)
+		mockTx.On("QueryRowContext", mock.Anything, selectBalanceForUpdateQuery, mock.Anything).Return(missingRow()).Once()
+		handler := newPolicyHandler(WalletPolicyStrict, mockTx)
+
+		err := handler.handleOperation(context.Background(), &wallet.WalletRequest{
+			WalletID:      uuid.New().String(),
+			OperationType: wallet.DEPOSIT,
+			Amount:        100,
+		})
+
+		assert.NotNil(t, err)
+		assert.Equal(t, http.StatusNotFound, err.Code)
+		mockTx.AssertNotCalled(t, "ExecContext", mock.Anything, createWalletQuery, mock.Anything)
+	})
+
+	t.Run("auto_create: зачисление создаёт кошелек", func(t *testing.T) {
+		walletID := uuid.New()
+		createdRow := new(MockRow)
+		createdRow.On("Scan", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+			*args.Get(0).(*float64) = 0
+		}).Return(nil).Once()
+
+		mockTx := new(MockTx)
+		mockTx.On("QueryRowContext", mock.Anything, selectBalanceForUpdateQuery, mock.Anything).Return(missingRow()).Once()
+		mockTx.On("ExecContext", mock.Anything, createWalletQuery, []interface{}{walletID}).Return(&MockResult{}, nil).Once()
+		mockTx.On("QueryRowContext", mock.Anything, selectBalanceForUpdateQuery, mock.Anything).Return(createdRow).Once()
+		mockTx.On("ExecContext", mock.Anything, updateBalanceQuery,
+			[]interface{}{100.0, walletID}).Return(&MockResult{}, nil).Once()
+		mockTx.On("ExecContext", mock.Anything, mock.Anything,
+			recordedTransaction(walletID, 100.0, wallet.DEPOSIT, "")).Return(&MockResult{}, nil).Once()
+		mockTx.On("Commit").Return(nil).Once()
+		handler := newPolicyHandler(WalletPolicyAutoCreate, mockTx)
+
+		err := handler.handleOperation(context.Background(), &wallet.WalletRequest{
+			WalletID:      walletID.String(),
+			OperationType: wallet.DEPOSIT,
+			Amount:        100,
+		})
+
+		assert.Nil(t, err)
+		mockTx.AssertExpectations(t)
+	})
+
+	t.Run("auto_create: списание с несуществующего кошелька - 404", func(t *testing.T) {
+		mockTx := new(MockTx)
+		mockTx.On("QueryRowContext", mock.Anything, selectBalanceForUpdateQuery, mock.Anything).Return(missingRow()).Once()
+		handler := newPolicyHandler(WalletPolicyAutoCreate, mockTx)
+
+		err := handler.handleOperation(context.Background(), &wallet.WalletRequest{
+			WalletID:      uuid.New().String(),
+			OperationType: wallet.WITHDRAW,
+			Amount:        100,
+		})
+
+		assert.NotNil(t, err)
+		assert.Equal(t, http.StatusNotFound, err.Code)
+		mockTx.AssertNotCalled(t, "ExecContext", mock.Anything, createWalletQuery, mock.Anything)
+	})
+}
+
+// Тесты предварительной проверки списания
+func TestCanWithdraw(t *testing.T) {
+	walletID := uuid.New()
+
+	tests := []struct {
+		name         string
+		amount       string
+		expectedCode int
+		expected     *Affordability
+	}{
+		{
+			name:         "Средств достаточно",
+			amount:       "150",
+			expectedCode: http.StatusOK,
+			expected:     &Affordability{Affordable: true, Available: 200},
+		},
+		{
+			name:         "Средств недостаточно",
+			amount:       "250.50",
+			expectedCode: http.StatusOK,
+			expected:     &Affordability{Affordable: false, Available: 200},
+		},
+		{
+			name:         "Сумма не число",
+			amount:       "abc",
+			expectedCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:         "Отрицательная сумма",
+			amount:       "-5",
+			expectedCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:         "Нулевая сумма",
+			amount:       "0",
+			expectedCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:         "NaN",
+			amount:       "NaN",
+			expectedCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:         "Бесконечность",
+			amount:       "Inf",
+			expectedCode: http.StatusUnprocessableEntity,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB := new(MockDB)
+			if tt.expected != nil {
+				mockRow := new(MockRow)
+				mockRow.On("Scan", mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+					*args.Get(0).(*float64) = 200
+				}).Return(nil).Once()
+				mockDB.On("QueryRowContext", mock.Anything, selectWalletBalanceQuery, walletID).
+					Return(mockRow).Once()
+			}
+			handler := NewWalletHandler(mockDB, new(MockCache), false)
+			w := httptest.NewRecorder()
+
+			handler.CanWithdraw(w, httptest.NewRequest("GET",
+				"/api/v1/wallets/"+walletID.String()+"/can-withdraw?amount="+tt.amount, nil))
+
+			assert.Equal(t, tt.expectedCode, w.Code)
+			if tt.expected != nil {
+				var result Affordability
+				assert.NoError(t, json.NewDecoder(w.Body).Decode(&result))
+				assert.Equal(t, *tt.expected, result)
+			}
+			mockDB.AssertExpectations(t)
+			mockDB.AssertNotCalled(t, "BeginTx", mock.Anything)
+		})
+	}
+}
+
+// Тесты подробных ошибок разбора тела запроса
+func TestDecodeErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		expected string
+	}{
+		{
+			name:     "Пустое тело",
+			body:     "",
+			expected: ErrEmptyBody,
+		},
+		{
+			name:     "Оборванный JSON",
+			body:     `{"walletId": "`,
+			expected: ErrJSONTruncated,
+		},
+		{
+			name:     "Синтаксическая ошибка",
+			body:     `{"amount": 10,}`,
+			expected: fmt.Sprintf(ErrJSONSyntax, 15),
+		},
+		{
+			name:     "Сумма строкой",
+			body:     `{"amount": "100"}`,
+			expected: fmt.Sprintf(ErrJSONFieldType, "amount", "number", "string"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := NewWalletHandler(new(MockDB), new(MockCache), false)
+			w := httptest.NewRecorder()
+
+			handler.HandleWalletOperation(w, newJSONRequest([]byte(tt.body)))
+
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			assert.Equal(t, tt.expected, errorMessage(t, w))
+		})
+	}
+
+	t.Run("Перевод на en", func(t *testing.T) {
+		handler := newLocalizedHandler(t, new(MockDB), new(MockCache))
+		req := newJSONRequest([]byte(`{"amount": "100"}`))
+		req.Header.Set("Accept-Language", "en")
+		w := httptest.NewRecorder()
+
+		handler.HandleWalletOperation(w, req)
+
+		assert.Equal(t, "Field amount must be of type number, got: string", errorMessage(t, w))
+	})
+}
+
+// Тесты отмены операций
+func TestVoidTransaction(t *testing.T) {
+	transactionID := uuid.New()
+	walletID := uuid.New()
+	now := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+
+	transactionRow := func(voided, isVoid bool) *MockRow {
+		mockRow := new(MockRow)
+		mockRow.On("Scan", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+			*args.Get(0).(*uuid.UUID) = walletID
+			*args.Get(1).(*float64) = 100
+			*args.Get(2).(*bool) = voided
+			*args.Get(3).(*bool) = isVoid
+		}).Return(nil).Once()
+		return mockRow
+	}
+
+	newVoidHandler := func(mockTx *MockTx, mockCache *MockCache) *WalletHandler {
+		mockDB := new(MockDB)
+		mockDB.On("BeginTx", mock.Anything).Return(mockTx, nil).Once()
+		mockTx.On("Rollback").Return(nil).Maybe()
+		config := DefaultConfig()
+		config.Clock = newFakeClock(now)
+		config.AdminToken = "secret"
+		return NewWalletHandlerWithConfig(mockDB, mockCache, false, config)
+	}
+
+	voidRequest := func() *http.Request {
+		req := httptest.NewRequest("POST", "/api/v1/transactions/"+transactionID.String()+"/void", nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		return req
+	}
+
+	t.Run("Отмена зачисления компенсирует баланс", func(t *testing.T) {
+		balanceRow := new(MockRow)
+		balanceRow.On("Scan", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+			*args.Get(0).(*float64) = 300
+		}).Return(nil).Once()
+
+		mockTx := new(MockTx)
+		mockTx.On("QueryRowContext", mock.Anything, selectTransactionForVoidQuery, []interface{}{transactionID}).
+			Return(transactionRow(false, false)).Once()
+		mockTx.On("QueryRowContext", mock.Anything, selectBalanceForUpdateQuery, []interface{}{walletID}).
+			Return(balanceRow).Once()
+		mockTx.On("ExecContext", mock.Anything, updateBalanceQuery,
+			[]interface{}{200.0, walletID}).Return(&MockResult{}, nil).Once()
+		mockTx.On("ExecContext", mock.Anything, markTransactionVoidedQuery,
+			[]interface{}{transactionID, now}).Return(&MockResult{}, nil).Once()
+		mockTx.On("ExecContext", mock.Anything, insertVoidTransactionQuery,
+			[]interface{}{walletID, -100.0, wallet.VOID, transactionID, now}).Return(&MockResult{}, nil).Once()
+		mockTx.On("Commit").Return(nil).Once()
+
+		mockCache := new(MockCache)
+		mockCache.On("Delete", mock.Anything, "balance:"+walletID.String()).Return(nil).Once()
+
+		w := httptest.NewRecorder()
+		newVoidHandler(mockTx, mockCache).VoidTransaction(w, voidRequest())
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var body map[string]interface{}
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&body))
+		assert.Equal(t, CodeVoidSuccess, body["code"])
+		assert.Equal(t, 200.0, body["balance"])
+		mockTx.AssertExpectations(t)
+		mockCache.AssertExpectations(t)
+	})
+
+	t.Run("Отмена завершает долгий опрос баланса", func(t *testing.T) {
+		store := NewMemoryStore()
+		store.Put(walletID, StoredWallet{Balance: 300, Version: 3})
+
+		balanceRow := new(MockRow)
+		balanceRow.On("Scan", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+			*args.Get(0).(*float64) = 300
+		}).Return(nil).Once()
+
+		mockTx := new(MockTx)
+		mockTx.On("QueryRowContext", mock.Anything, selectTransactionForVoidQuery, []interface{}{transactionID}).
+			Return(transactionRow(false, false)).Once()
+		mockTx.On("QueryRowContext", mock.Anything, selectBalanceForUpdateQuery, []interface{}{walletID}).
+			Return(balanceRow).Once()
+		mockTx.On("ExecContext", mock.Anything, mock.Anything, mock.Anything).Return(&MockResult{}, nil)
+		// Подтверждённая отмена видна при следующем чтении баланса
+		mockTx.On("Commit").Run(func(mock.Arguments) {
+			store.Put(walletID, StoredWallet{Balance: 200, Version: 4})
+		}).Return(nil).Once()
+		mockTx.On("Rollback").Return(nil).Maybe()
+
+		mockDB := new(MockDB)
+		mockDB.On("BeginTx", mock.Anything).Return(mockTx, nil).Once()
+		mockCache := new(MockCache)
+		mockCache.On("Delete", mock.Anything, "balance:"+walletID.String()).Return(nil).Once()
+		config := DefaultConfig()
+		config.Clock = newFakeClock(now)
+		config.AdminToken = "secret"
+		config.Store = store
+		handler := NewWalletHandlerWithConfig(mockDB, mockCache, false, config)
+
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			handler.VoidTransaction(httptest.NewRecorder(), voidRequest())
+		}()
+		start := time.Now()
+		w := httptest.NewRecorder()
+		handler.HandleBalancePoll(w, httptest.NewRequest(http.MethodGet,
+			"/api/v1/wallets/"+walletID.String()+"/balance/poll?since_version=3&timeout=5s", nil))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Less(t, time.Since(start), time.Second)
+		assert.Equal(t, "4", w.Header().Get(balanceVersionHeader))
+		assert.JSONEq(t, `{"balance": "200.00", "balance_minor": 20000}`, w.Body.String())
+	})
+
+	t.Run("Повторная отмена - 409", func(t *testing.T) {
+		mockTx := new(MockTx)
+		mockTx.On("QueryRowContext", mock.Anything, selectTransactionForVoidQuery, []interface{}{transactionID}).
+			Return(transactionRow(true, false)).Once()
+
+		w := httptest.NewRecorder()
+		newVoidHandler(mockTx, new(MockCache)).VoidTransaction(w, voidRequest())
+
+		assert.Equal(t, http.StatusConflict, w.Code)
+		assert.Contains(t, w.Body.String(), ErrTransactionVoided)
+		mockTx.AssertNotCalled(t, "ExecContext", mock.Anything, mock.Anything, mock.Anything)
+		mockTx.AssertNotCalled(t, "Commit")
+	})
+
+	t.Run("Отмена компенсирующей записи - 409", func(t *testing.T) {
+		mockTx := new(MockTx)
+		mockTx.On("QueryRowContext", mock.Anything, selectTransactionForVoidQuery, []interface{}{transactionID}).
+			Return(transactionRow(false, true)).Once()
+
+		w := httptest.NewRecorder()
+		newVoidHandler(mockTx, new(MockCache)).VoidTransaction(w, voidRequest())
+
+		assert.Equal(t, http.StatusConflict, w.Code)
+		assert.Contains(t, w.Body.String(), ErrTransactionIsVoid)
+		mockTx.AssertNotCalled(t, "Commit")
+	})
+
+	t.Run("Операция не найдена - 404", func(t *testing.T) {
+		mockTx := new(MockTx)
+		mockTx.On("QueryRowContext", mock.Anything, selectTransactionForVoidQuery, []interface{}{transactionID}).
+			Return(errRow{err: sql.ErrNoRows}).Once()
+
+		w := httptest.NewRecorder()
+		newVoidHandler(mockTx, new(MockCache)).VoidTransaction(w, voidRequest())
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.Contains(t, w.Body.String(), ErrTransactionNotFound)
+	})
+
+	t.Run("Без токена администратора - 403", func(t *testing.T) {
+		store := NewMemoryStore()
+		store.Put(walletID, StoredWallet{Balance: 300})
+		mockDB := new(MockDB)
+		config := DefaultConfig()
+		config.Store = store
+		config.AdminToken = "secret"
+		handler := NewWalletHandlerWithConfig(mockDB, new(MockCache), false, config)
+
+		for _, token := range []string{"", "Bearer wrong"} {
+			req := httptest.NewRequest("POST", "/api/v1/transactions/"+transactionID.String()+"/void", nil)
+			if token != "" {
+				req.Header.Set("Authorization", token)
+			}
+			w := httptest.NewRecorder()
+			handler.VoidTransaction(w, req)
+
+			assert.Equal(t, http.StatusForbidden, w.Code)
+			assert.Contains(t, w.Body.String(), ErrForbidden)
+		}
+		mockDB.AssertNotCalled(t, "BeginTx", mock.Anything)
+		stored, err := store.GetBalance(context.Background(), walletID)
+		require.NoError(t, err)
+		assert.Equal(t, 300.0, stored.Balance)
+	})
+}
+
+// memoryAuditSink собирает записи аудита в памяти
+type memoryAuditSink struct {
+	entries chan audit.Entry
+}
+
+func (s *memoryAuditSink) Write(_ context.Context, entry audit.Entry) error {
+	s.entries <- entry
+	return nil
+}
+
+// Тесты журнала аудита
+func TestAuditLog(t *testing.T) {
+	newAuditHandler := func(db DBInterface, cache CacheInterface) (*WalletHandler, *memoryAuditSink) {
+		sink := &memoryAuditSink{entries: make(chan audit.Entry, 10)}
+		config := DefaultConfig()
+		config.AuditSink = sink
+		config.AdminToken = "secret"
+		return NewWalletHandlerWithConfig(db, cache, false, config), sink
+	}
+
+	nextEntry := func(t *testing.T, sink *memoryAuditSink) audit.Entry {
+		select {
+		case entry := <-sink.entries:
+			return entry
+		case <-time.After(time.Second):
+			t.Fatal("запись аудита не получена")
+			return audit.Entry{}
+		}
+	}
+
+	t.Run("Запись на каждую операцию", func(t *testing.T) {
+		walletID := uuid.New()
+		mockDB := new(MockDB)
+		mockTx := new(MockTx)
+		mockRow := new(MockRow)
+		mockDB.On("BeginTx", mock.Anything).Return(mockTx, nil).Twice()
+		mockTx.On("QueryRowContext", mock.Anything, mock.Anything, mock.Anything).Return(mockRow)
+		mockRow.On("Scan", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil).Once()
+		mockRow.On("Scan", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(sql.ErrNoRows).Once()
+		mockTx.On("ExecContext", mock.Anything, mock.Anything, mock.Anything).Return(&MockResult{}, nil)
+		mockTx.On("Commit").Return(nil).Once()
+		mockTx.On("Rollback").Return(nil).Maybe()
+
+		handler, sink := newAuditHandler(mockDB, newWriteCache())
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go handler.RunAuditLog(ctx)
+
+		req := &wallet.WalletRequest{
+			ID:            uuid.New().String(),
+			WalletID:      walletID.String(),
+			OperationType: wallet.DEPOSIT,
+			Amount:        100,
+			Subject:       "admin",
+			SourceIP:      "10.0.0.1",
+		}
+		assert.Nil(t, handler.handleOperation(context.Background(), req))
+		assert.NotNil(t, handler.handleOperation(context.Background(), req))
+
+		entry := nextEntry(t, sink)
+		assert.Equal(t, AuditActionOperation, entry.Action)
+		assert.Equal(t, "admin", entry.Subject)
+		assert.Equal(t, "10.0.0.1", entry.SourceIP)
+		assert.Equal(t, walletID.String(), entry.WalletID)
+		assert.Equal(t, req.ID, entry.OperationID)
+		assert.Equal(t, 100.0, entry.Amount)
+		assert.Equal(t, audit.ResultSuccess, entry.Result)
+		assert.False(t, entry.Time.IsZero())
+
+		entry = nextEntry(t, sink)
+		assert.Equal(t, audit.ResultFailure, entry.Result)
+		assert.Contains(t, entry.Error, ErrWalletNotFound)
+	})
+
+	t.Run("Источник операции берётся из запроса, а не из тела", func(t *testing.T) {
+		mockCache := new(MockCache)
+		expectNotBlocked(mockCache)
+		mockCache.On("LPush", mock.Anything, operationsQueueKey, mock.MatchedBy(func(values []interface{}) bool {
+			var op wallet.WalletRequest
+			json.Unmarshal(values[0].([]byte), &op)
+			return op.Subject == anonymousSubject && op.SourceIP == "192.0.2.1"
+		})).Return(redis.NewIntResult(1, nil)).Once()
+		handler, _ := newAuditHandler(new(MockDB), mockCache)
+
+		body := []byte(`{"wallet_id": "` + uuid.New().String() + `", "operation_type": "DEPOSIT", "amount": 10, "subject": "admin", "source_ip": "1.1.1.1"}`)
+		w := httptest.NewRecorder()
+		handler.HandleWalletOperation(w, newJSONRequest(body))
+
+		assert.Equal(t, http.StatusAccepted, w.Code)
+		mockCache.AssertExpectations(t)
+	})
+
+	t.Run("Действия администратора", func(t *testing.T) {
+		walletID := uuid.New()
+		mockCache := new(MockCache)
+		mockCache.On("Set", mock.Anything, blockedWalletKey(walletID.String()), "1", time.Duration(0)).Return(nil).Once()
+		handler, sink := newAuditHandler(new(MockDB), mockCache)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go handler.RunAuditLog(ctx)
+
+		req := httptest.NewRequest("POST", "/api/v1/admin/wallets/"+walletID.String()+"/block", nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		handler.HandleWalletBlock(httptest.NewRecorder(), req)
+
+		entry := nextEntry(t, sink)
+		assert.Equal(t, AuditActionBlock, entry.Action)
+		assert.Equal(t, adminSubject, entry.Subject)
+		assert.Equal(t, walletID.String(), entry.WalletID)
+		assert.Equal(t, "192.0.2.1", entry.SourceIP)
+		assert.Equal(t, audit.ResultSuccess, entry.Result)
+	})
+
+	t.Run("Переполненный буфер не блокирует операцию", func(t *testing.T) {
+		config := DefaultConfig()
+		config.AuditSink = &memoryAuditSink{}
+		config.AuditBufferSize = 1
+		handler := NewWalletHandlerWithConfig(new(MockDB), new(MockCache), false, config)
+
+		handler.recordAudit(audit.Entry{Action: AuditActionOperation})
+		handler.recordAudit(audit.Entry{Action: AuditActionOperation})
+
+		assert.Len(t, handler.auditEntries, 1)
+	})
+}
+
+// Тесты постраничного просмотра очередей администратором
+func TestQueuePeek(t *testing.T) {
+	config := DefaultConfig()
+	config.AdminToken = "secret"
+
+	newPeekRequest := func(path string) *http.Request {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		return req
+	}
+
+	t.Run("Постраничный просмотр DLQ", func(t *testing.T) {
+		dead := make([]string, 5)
+		for i := range dead {
+			dead[i] = fmt.Sprintf(`{"id":"op-%d"}`, i)
+		}
+
+		mockCache := new(MockCache)
+		mockCache.On("LLen", mock.Anything, deadLetterQueueKey).Return(int64(len(dead)), nil)
+		// LRANGE обрезает stop по длине списка
+		mockCache.On("LRange", mock.Anything, deadLetterQueueKey, int64(0), int64(1)).Return(dead[0:2], nil).Once()
+		mockCache.On("LRange", mock.Anything, deadLetterQueueKey, int64(2), int64(3)).Return(dead[2:4], nil).Once()
+		mockCache.On("LRange", mock.Anything, deadLetterQueueKey, int64(4), int64(5)).Return(dead[4:], nil).Once()
+		handler := NewWalletHandlerWithConfig(new(MockDB), mockCache, false, config)
+
+		var ids []string
+		for offset := 0; offset < len(dead); offset += 2 {
+			w := httptest.NewRecorder()
+			handler.HandleDeadLetterPeek(w, newPeekRequest(fmt.Sprintf("/api/v1/admin/dlq?offset=%d&count=2", offset)))
+			assert.Equal(t, http.StatusOK, w.Code)
+
+			var page struct {
+				Items []wallet.WalletRequest `json:"items"`
+				Total int64                  `json:"total"`
+			}
+			assert.NoError(t, json.NewDecoder(w.Body).Decode(&page))
+			assert.Equal(t, int64(5), page.Total)
+			for _, item := range page.Items {
+				ids = append(ids, item.ID)
+			}
+		}
+		assert.Equal(t, []string{"op-0", "op-1", "op-2", "op-3", "op-4"}, ids)
+
+		// За пределами списка - пустая страница без обращения к LRange
+		w := httptest.NewRecorder()
+		handler.HandleDeadLetterPeek(w, newPeekRequest("/api/v1/admin/dlq?offset=10"))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"items": [], "offset": 10, "count": 20, "total": 5}`, w.Body.String())
+		mockCache.AssertNumberOfCalls(t, "LRange", 3)
+	})
+
+	t.Run("Основная очередь", func(t *testing.T) {
+		mockCache := new(MockCache)
+		mockCache.On("LLen", mock.Anything, operationsQueueKey).Return(int64(1), nil).Once()
+		mockCache.On("LRange", mock.Anything, operationsQueueKey, int64(0), int64(19)).
+			Return([]string{`{"id":"queued"}`}, nil).Once()
+		handler := NewWalletHandlerWithConfig(new(MockDB), mockCache, false, config)
+		w := httptest.NewRecorder()
+
+		handler.HandleQueuePeek(w, newPeekRequest("/api/v1/admin/queue"))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"items": [{"id":"queued"}], "offset": 0, "count": 20, "total": 1}`, w.Body.String())
+	})
+
+	t.Run("Неверный диапазон", func(t *testing.T) {
+		handler := NewWalletHandlerWithConfig(new(MockDB), new(MockCache), false, config)
+		for _, query := range []string{"offset=-1", "offset=abc", "count=0"} {
+			w := httptest.NewRecorder()
+			handler.HandleDeadLetterPeek(w, newPeekRequest("/api/v1/admin/dlq?"+query))
+			assert.Equal(t, http.StatusUnprocessableEntity, w.Code, query)
+		}
+	})
+
+	t.Run("Без токена администратора", func(t *testing.T) {
+		handler := NewWalletHandlerWithConfig(new(MockDB), new(MockCache), false, config)
+		w := httptest.NewRecorder()
+
+		handler.HandleQueuePeek(w, httptest.NewRequest("GET", "/api/v1/admin/queue", nil))
+
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+}
+
+// Баланс отдаётся десятичной строкой и целым числом копеек
+func TestBalanceMinorUnits(t *testing.T) {
+	t.Run("Согласованность полей", func(t *testing.T) {
+		tests := []struct {
+			amount   float64
+			expected Balance
+		}{
+			{amount: 0, expected: Balance{Balance: "0.00", BalanceMinor: 0}},
+			{amount: 0.1 + 0.2, expected: Balance{Balance: "0.30", BalanceMinor: 30}},
+			{amount: 1000.5, expected: Balance{Balance: "1000.50", BalanceMinor: 100050}},
+			{amount: 19.99, expected: Balance{Balance: "19.99", BalanceMinor: 1999}},
+			{amount: 123456789012.34, expected: Balance{Balance: "123456789012.34", BalanceMinor: 12345678901234}},
+		}
+
+		for _, tt := range tests {
+			assert.Equal(t, tt.expected, newBalance(tt.amount))
+		}
+	})
+
+	t.Run("Без экспоненциальной записи", func(t *testing.T) {
+		tests := []struct {
+			amount   float64
+			expected string
+		}{
+			{amount: 1000000, expected: "1000000.00"},
+			{amount: 0.01, expected: "0.01"},
+			{amount: 1e15, expected: "1000000000000000.00"},
+			{amount: 0.000001, expected: "0.00"},
+			{amount: -2500000.5, expected: "-2500000.50"},
+			{amount: -0.01, expected: "-0.01"},
+		}
+
+		for _, tt := range tests {
+			balance := newBalance(tt.amount)
+			assert.Equal(t, tt.expected, balance.Balance)
+
+			encoded, err := json.Marshal(balance)
+			assert.NoError(t, err)
+			assert.NotContains(t, string(encoded), "e+")
+			assert.NotContains(t, string(encoded), "e-")
+		}
+	})
+
+	t.Run("Форматирование копеек", func(t *testing.T) {
+		assert.Equal(t, "0.00", formatMinorUnits(0, 2))
+		assert.Equal(t, "0.05", formatMinorUnits(5, 2))
+		assert.Equal(t, "100000000.00", formatMinorUnits(10000000000, 2))
+		assert.Equal(t, "-92233720368547758.08", formatMinorUnits(math.MinInt64, 2))
+		assert.Equal(t, "1500", formatMinorUnits(1500, 0))
+		assert.Equal(t, "-0.005", formatMinorUnits(-5, 3))
+	})
+
+	t.Run("Баланс из кэша", func(t *testing.T) {
+		walletID := uuid.New()
+		mockCache := new(MockCache)
+		mockCache.On("Get", mock.Anything, "balance:"+walletID.String()).Return("75.5", nil).Once()
+		handler := NewWalletHandler(new(MockDB), mockCache, false)
+		w := httptest.NewRecorder()
+
+		handler.GetWalletBalance(w, httptest.NewRequest("GET", "/api/v1/wallets/"+walletID.String(), nil))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"balance": "75.50", "balance_minor": 7550}`, w.Body.String())
+	})
+}
+
+// Тесты режима обслуживания
+func TestMaintenanceMode(t *testing.T) {
+	walletID := uuid.New()
+	config := DefaultConfig()
+	config.MaintenanceMode = true
+	config.MaintenanceRetryAfter = 30 * time.Second
+	config.AdminToken = "secret"
+
+	t.Run("Чтение баланса работает", func(t *testing.T) {
+		mockCache := new(MockCache)
+		mockCache.On("Get", mock.Anything, "balance:"+walletID.String()).Return("10", nil).Once()
+		handler := NewWalletHandlerWithConfig(new(MockDB), mockCache, false, config)
+		w := httptest.NewRecorder()
+
+		handler.GetWalletBalance(w, httptest.NewRequest("GET", "/api/v1/wallets/"+walletID.String(), nil))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("Запись отклоняется с Retry-After", func(t *testing.T) {
+		mockCache := new(MockCache)
+		handler := NewWalletHandlerWithConfig(new(MockDB), mockCache, false, config)
+		body, _ := json.Marshal(wallet.WalletRequest{
+			WalletID:      walletID.String(),
+			OperationType: wallet.DEPOSIT,
+			Amount:        10,
+		})
+
+		for _, write := range []struct {
+			handler http.HandlerFunc
+			req     *http.Request
+		}{
+			{handler.HandleWalletOperation, newJSONRequest(body)},
+			{handler.VoidTransaction, httptest.NewRequest("POST", "/api/v1/transactions/"+uuid.New().String()+"/void", nil)},
+		} {
+			w := httptest.NewRecorder()
+			handler.RejectWritesInMaintenance(write.handler)(w, write.req)
+
+			assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+			assert.Equal(t, "30", w.Header().Get("Retry-After"))
+			assert.Contains(t, w.Body.String(), ErrMaintenance)
+		}
+		mockCache.AssertNotCalled(t, "LPush", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("Администратор выключает режим", func(t *testing.T) {
+		mockCache := new(MockCache)
+		expectNotBlocked(mockCache)
+		mockCache.On("LPush", mock.Anything, operationsQueueKey, mock.Anything).Return(redis.NewIntResult(1, nil)).Once()
+		handler := NewWalletHandlerWithConfig(new(MockDB), mockCache, false, config)
+
+		req := httptest.NewRequest("DELETE", "/api/v1/admin/maintenance", nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		handler.HandleMaintenance(w, req)
+		assert.JSONEq(t, `{"maintenance": false}`, w.Body.String())
+
+		body, _ := json.Marshal(wallet.WalletRequest{
+			WalletID:      walletID.String(),
+			OperationType: wallet.DEPOSIT,
+			Amount:        10,
+		})
+		w = httptest.NewRecorder()
+		handler.RejectWritesInMaintenance(handler.HandleWalletOperation)(w, newJSONRequest(body))
+		assert.Equal(t, http.StatusAccepted, w.Code)
+	})
+
+	t.Run("Очередь не обрабатывается", func(t *testing.T) {
+		config := config
+		config.HealthCheckInterval = 10 * time.Millisecond
+		mockCache := new(MockCache)
+		handler := NewWalletHandlerWithConfig(new(MockDB), mockCache, false, config)
+
+		handler.processQueueItem(context.Background())
+
+		mockCache.AssertNotCalled(t, "BRPop", mock.Anything, mock.Anything, mock.Anything)
+	})
+}
+
+// Тесты отложенной очереди повторов
+func TestRetryQueue(t *testing.T) {
+	op := wallet.WalletRequest{
+		ID:            uuid.New().String(),
+		WalletID:      uuid.New().String(),
+		OperationType: wallet.DEPOSIT,
+		Amount:        100,
+	}
+	opJSON, _ := json.Marshal(op)
+
+	retried := op
+	retried.Attempts = 1
+	retriedJSON, _ := json.Marshal(retried)
+
+	t.Run("Временная ошибка переносит операцию в очередь повторов", func(t *testing.T) {
+		config := DefaultConfig()
+		config.RetryBaseDelay = time.Minute
+		mockDB := new(MockDB)
+		mockDB.On("BeginTx", mock.Anything).Return((*MockTx)(nil), errors.New("connection reset")).Once()
+
+		mockCache := new(MockCache)
+		expectNotBlocked(mockCache)
+		popCmd := redis.NewStringSliceCmd(context.Background())
+		popCmd.SetVal([]string{operationsQueueKey, string(opJSON)})
+		mockCache.On("BRPop", mock.Anything, config.HealthCheckInterval, priorityQueueKeys).Return(popCmd).Once()
+
+		var score float64
+		mockCache.On("ZAdd", mock.Anything, retryQueueKey, mock.Anything, string(retriedJSON)).
+			Run(func(args mock.Arguments) { score = args.Get(2).(float64) }).
+			Return(nil).Once()
+
+		handler := NewWalletHandlerWithConfig(mockDB, mockCache, false, config)
+
+		before := time.Now()
+		handler.processQueueItem(context.Background())
+
+		mockCache.AssertExpectations(t)
+		// Повтор запланирован не раньше чем через базовую задержку
+		assert.GreaterOrEqual(t, score, float64(before.Add(time.Minute).UnixMilli()))
+	})
+
+	t.Run("Ошибка клиента не повторяется", func(t *testing.T) {
+		config := DefaultConfig()
+		mockCache := new(MockCache)
+		expectNotBlocked(mockCache)
+		invalid := op
+		invalid.Amount = -1
+		invalidJSON, _ := json.Marshal(invalid)
+		popCmd := redis.NewStringSliceCmd(context.Background())
+		popCmd.SetVal([]string{operationsQueueKey, string(invalidJSON)})
+		mockCache.On("BRPop", mock.Anything, config.HealthCheckInterval, priorityQueueKeys).Return(popCmd).Once()
+
+		handler := NewWalletHandlerWithConfig(new(MockDB), mockCache, false, config)
+		handler.processQueueItem(context.Background())
+
+		mockCache.AssertNotCalled(t, "ZAdd", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("Попытки исчерпаны", func(t *testing.T) {
+		config := DefaultConfig()
+		config.MaxOperationRetries = 1
+		handler := NewWalletHandlerWithConfig(new(MockDB), new(MockCache), false, config)
+
+		scheduled, err := handler.scheduleRetry(context.Background(), retried)
+		assert.NoError(t, err)
+		assert.False(t, scheduled)
+	})
+
+	t.Run("Исчерпавшая попытки операция попадает в DLQ", func(t *testing.T) {
+		config := DefaultConfig()
+		config.MaxOperationRetries = 1
+		mockDB := new(MockDB)
+		mockDB.On("BeginTx", mock.Anything).Return((*MockTx)(nil), errors.New("connection reset")).Once()
+
+		mockCache := new(MockCache)
+		expectNotBlocked(mockCache)
+		popCmd := redis.NewStringSliceCmd(context.Background())
+		popCmd.SetVal([]string{operationsQueueKey, string(retriedJSON)})
+		mockCache.On("BRPop", mock.Anything, config.HealthCheckInterval, priorityQueueKeys).Return(popCmd).Once()
+		mockCache.On("LPush", mock.Anything, deadLetterQueueKey, mock.Anything).
+			Return(redis.NewIntResult(1, nil)).Once()
+
+		handler := NewWalletHandlerWithConfig(mockDB, mockCache, false, config)
+		handler.processQueueItem(context.Background())
+
+		mockCache.AssertExpectations(t)
+		mockCache.AssertNotCalled(t, "ZAdd", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("Готовые операции возвращаются в основную очередь", func(t *testing.T) {
+		now := time.Now()
+		mockCache := new(MockCache)
+		mockCache.On("ZRangeByScore", mock.Anything, retryQueueKey, float64(now.UnixMilli()), int64(100)).
+			Return([]string{string(retriedJSON), "already-taken"}, nil).Once()
+		mockCache.On("MoveToQueue", mock.Anything, retryQueueKey, string(retriedJSON), operationsQueueKey).Return(true, nil).Once()
+		// Операцию уже забрал другой экземпляр сервиса
+		mockCache.On("MoveToQueue", mock.Anything, retryQueueKey, "already-taken", operationsQueueKey).Return(false, nil).Once()
+
+		handler := NewWalletHandler(new(MockDB), mockCache, false)
+		moved, err := handler.drainRetryQueue(context.Background(), now)
+
+		assert.NoError(t, err)
+		assert.Equal(t, 1, moved)
+		mockCache.AssertExpectations(t)
+	})
+
+	t.Run("Ошибка переноса прерывает проход", func(t *testing.T) {
+		now := time.Now()
+		mockCache := new(MockCache)
+		mockCache.On("ZRangeByScore", mock.Anything, retryQueueKey, float64(now.UnixMilli()), int64(100)).
+			Return([]string{string(retriedJSON), "next"}, nil).Once()
+		mockCache.On("MoveToQueue", mock.Anything, retryQueueKey, string(retriedJSON), operationsQueueKey).
+			Return(false, errors.New("connection reset")).Once()
+
+		handler := NewWalletHandler(new(MockDB), mockCache, false)
+		moved, err := handler.drainRetryQueue(context.Background(), now)
+
+		assert.Error(t, err)
+		assert.Equal(t, 0, moved)
+		mockCache.AssertExpectations(t)
+		mockCache.AssertNotCalled(t, "LPush", mock.Anything, mock.Anything, mock.Anything)
+	})
+}
+
+// Ответ 202 содержит идентификатор операции и позицию в очереди
+func TestQueuePosition(t *testing.T) {
+	mockCache := new(MockCache)
+	expectNotBlocked(mockCache)
+	lengthCmd := redis.NewIntCmd(context.Background())
+	lengthCmd.SetVal(7)
+	mockCache.On("LPush", mock.Anything, operationsQueueKey, mock.Anything).Return(lengthCmd).Once()
+
+	handler := NewWalletHandler(new(MockDB), mockCache, false)
+	body, _ := json.Marshal(wallet.WalletRequest{
+		WalletID:      uuid.New().String(),
+		OperationType: wallet.DEPOSIT,
+		Amount:        100,
+	})
+	w := httptest.NewRecorder()
+
+	handler.HandleWalletOperation(w, newJSONRequest(body))
+
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	var response struct {
+		Status        string `json:"status"`
+		OperationID   string `json:"operation_id"`
+		QueuePosition int64  `json:"queue_position"`
+	}
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+	assert.Equal(t, SuccessQueueAdd, response.Status)
+	assert.Equal(t, int64(7), response.QueuePosition)
+	_, err := uuid.Parse(response.OperationID)
+	assert.NoError(t, err)
+	mockCache.AssertExpectations(t)
+}
+
+// Пока БД недоступна, операции не забираются из очереди и применяются после восстановления
+func TestHealthGate(t *testing.T) {
+	config := DefaultConfig()
+	config.HealthCheckInterval = 10 * time.Millisecond
+
+	walletID := uuid.New()
+	opJSON, _ := json.Marshal(wallet.WalletRequest{
+		ID:            uuid.New().String(),
+		WalletID:      walletID.String(),
+		OperationType: wallet.DEPOSIT,
+		Amount:        100,
+	})
+
+	mockDB := new(MockDB)
+	mockCache := newWriteCache()
+	handler := NewWalletHandlerWithConfig(mockDB, mockCache, false, config)
+
+	// БД недоступна: очередь не трогаем
+	mockDB.On("PingContext", mock.Anything).Return(errors.New("connection refused")).Once()
+	handler.checkHealth(context.Background())
+	assert.False(t, handler.isDBHealthy())
+
+	handler.processQueueItem(context.Background())
+	mockCache.AssertNotCalled(t, "BRPop", mock.Anything, mock.Anything, mock.Anything)
+
+	// БД восстановилась: операция забирается и применяется
+	mockDB.On("PingContext", mock.Anything).Return(nil).Once()
+	handler.checkHealth(context.Background())
+	assert.True(t, handler.isDBHealthy())
+
+	popCmd := redis.NewStringSliceCmd(context.Background())
+	popCmd.SetVal([]string{operationsQueueKey, string(opJSON)})
+	mockCache.On("BRPop", mock.Anything, config.HealthCheckInterval, priorityQueueKeys).Return(popCmd).Once()
+	expectNotBlocked(mockCache)
+
+	mockTx := new(MockTx)
+	mockRow := new(MockRow)
+	mockDB.On("BeginTx", mock.Anything).Return(mockTx, nil).Once()
+	mockTx.On("QueryRowContext", mock.Anything, mock.Anything, mock.Anything).Return(mockRow).Once()
+	mockRow.On("Scan", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		*args.Get(0).(*float64) = 500
+	}).Return(nil).Once()
+	mockTx.On("ExecContext", mock.Anything, mock.Anything, mock.Anything).Return(&MockResult{}, nil).Twice()
+	mockTx.On("Commit").Return(nil).Once()
+	mockTx.On("Rollback").Return(nil).Maybe()
+
+	handler.processQueueItem(context.Background())
+
+	mockDB.AssertExpectations(t)
+	mockCache.AssertExpectations(t)
+	mockTx.AssertExpectations(t)
+}
+
+// Машинный код ответа не зависит от языка, текст - зависит
+func TestSuccessCodes(t *testing.T) {
+	tests := []struct {
+		acceptLanguage string
+		expectedStatus string
+	}{
+		{acceptLanguage: "", expectedStatus: SuccessQueueAdd},
+		{acceptLanguage: "ru-RU", expectedStatus: SuccessQueueAdd},
+		{acceptLanguage: "en-US,en;q=0.9", expectedStatus: "Operation added to the queue"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.acceptLanguage, func(t *testing.T) {
+			mockCache := new(MockCache)
+			expectNotBlocked(mockCache)
+			mockCache.On("LPush", mock.Anything, operationsQueueKey, mock.Anything).
+				Return(redis.NewIntCmd(context.Background())).Once()
+
+			handler := newLocalizedHandler(t, new(MockDB), mockCache)
+			body, _ := json.Marshal(wallet.WalletRequest{
+				WalletID:      uuid.New().String(),
+				OperationType: wallet.DEPOSIT,
+				Amount:        100,
+			})
+			req := newJSONRequest(body)
+			req.Header.Set("Accept-Language", tt.acceptLanguage)
+			w := httptest.NewRecorder()
+
+			handler.HandleWalletOperation(w, req)
+
+			var response map[string]interface{}
+			assert.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+			assert.Equal(t, CodeOperationQueued, response["code"])
+			assert.Equal(t, tt.expectedStatus, response["status"])
+		})
+	}
+
+	assert.Equal(t, CodeDepositSuccess, successCode(wallet.DEPOSIT))
+	assert.Equal(t, CodeWithdrawSuccess, successCode(wallet.WITHDRAW))
+	assert.Equal(t, CodeOperationSuccess, successCode("FEE"))
+}
+
+// Тесты перевода ошибок
+func TestLocalizedErrors(t *testing.T) {
+	t.Run("ErrWalletNotFound на en и ru", func(t *testing.T) {
+		tests := []struct {
+			acceptLanguage string
+			expected       string
+		}{
+			{acceptLanguage: "ru", expected: ErrWalletNotFound},
+			{acceptLanguage: "en", expected: "wallet not found"},
+		}
+
+		for _, tt := range tests {
+			walletID := uuid.New()
+			mockCache := new(MockCache)
+			mockCache.On("Get", mock.Anything, mock.Anything).Return("", redis.Nil)
+			mockRow := new(MockRow)
+			mockRow.On("Scan", mock.Anything, mock.Anything, mock.Anything).Return(sql.ErrNoRows).Once()
+			mockDB := new(MockDB)
+			mockDB.On("QueryRowContext", mock.Anything, mock.Anything, walletID).Return(mockRow).Once()
+
+			req := httptest.NewRequest("GET", "/api/v1/wallets/"+walletID.String(), nil)
+			req.Header.Set("Accept-Language", tt.acceptLanguage)
+			w := httptest.NewRecorder()
+
+			newLocalizedHandler(t, mockDB, mockCache).GetWalletBalance(w, req)
+
+			assert.Equal(t, http.StatusNotFound, w.Code)
+			assert.Equal(t, tt.expected, errorMessage(t, w))
+		}
+	})
+
+	t.Run("Ошибка валидации сохраняет подробности", func(t *testing.T) {
+		handler := newLocalizedHandler(t, new(MockDB), new(MockCache))
+		err := handler.validator.ValidateWalletRequest(&wallet.WalletRequest{
+			WalletID:      uuid.New().String(),
+			OperationType: "BOGUS",
+			Amount:        1,
+		})
+
+		assert.Equal(t, err.Error(), handler.translateValidationError("ru", err))
+		assert.Equal(t, "validation error: invalid operation type: BOGUS", handler.translateValidationError("en", err))
+	})
+
+	t.Run("Все коды переведены на en", func(t *testing.T) {
+		data, err := os.ReadFile("../../locales/en.json")
+		assert.NoError(t, err)
+		var en map[string]string
+		assert.NoError(t, json.Unmarshal(data, &en))
+
+		codes := []string{CodeOperationSuccess, CodeOperationQueued, CodeDepositSuccess, CodeWithdrawSuccess}
+		for _, code := range errorCodes {
+			codes = append(codes, code)
+		}
+		for code := range service.ErrorMessages() {
+			codes = append(codes, code)
+		}
+
+		for _, code := range codes {
+			assert.Contains(t, en, code, "нет перевода на en для %s", code)
+		}
+	})
+}
+
+// fakeRateProvider отдаёт курсы из карты без обращения к внешним сервисам
+type fakeRateProvider map[string]float64
+
+func (f fakeRateProvider) Rate(_ context.Context, code string) (float64, error) {
+	rate, ok := f[code]
+	if !ok {
+		return 0, currency.ErrUnknownCurrency
+	}
+	return rate, nil
+}
+
+// Тесты конвертации баланса
+func TestCurrencyConversion(t *testing.T) {
+	walletID := uuid.New()
+	cacheKey := fmt.Sprintf("balance:%s", walletID)
+
+	newHandler := func(cache *MockCache) *WalletHandler {
+		config := DefaultConfig()
+		config.RateProvider = fakeRateProvider{"EUR": 0.5, "GBP": 0.125}
+		return NewWalletHandlerWithConfig(new(MockDB), cache, false, config)
+	}
+
+	t.Run("Конвертация в несколько валют", func(t *testing.T) {
+		mockCache := new(MockCache)
+		mockCache.On("Get", mock.Anything, cacheKey).Return("1000.50", nil).Once()
+
+		w := httptest.NewRecorder()
+		newHandler(mockCache).GetWalletBalance(w, httptest.NewRequest("GET",
+			"/api/v1/wallets/"+walletID.String()+"?convert_to=eur,GBP", nil))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var body struct {
+			Balance
+			Converted []ConvertedBalance `json:"converted"`
+		}
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&body))
+		assert.Equal(t, Balance{Balance: "1000.50", BalanceMinor: 100050}, body.Balance)
+		assert.Equal(t, []ConvertedBalance{
+			{Currency: "EUR", Amount: 500.25, Rate: 0.5, Indicative: true},
+			{Currency: "GBP", Amount: 125.06, Rate: 0.125, Indicative: true},
+		}, body.Converted)
+	})
+
+	t.Run("Неизвестная валюта", func(t *testing.T) {
+		mockCache := new(MockCache)
+		mockCache.On("Get", mock.Anything, cacheKey).Return("100", nil).Once()
+
+		w := httptest.NewRecorder()
+		newHandler(mockCache).GetWalletBalance(w, httptest.NewRequest("GET",
+			"/api/v1/wallets/"+walletID.String()+"?convert_to=JPY", nil))
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), ErrUnknownCurrency)
+	})
+
+	t.Run("Провайдер курсов не настроен", func(t *testing.T) {
+		mockCache := new(MockCache)
+		mockCache.On("Get", mock.Anything, cacheKey).Return("100", nil).Once()
+
+		w := httptest.NewRecorder()
+		NewWalletHandler(new(MockDB), mockCache, false).GetWalletBalance(w, httptest.NewRequest("GET",
+			"/api/v1/wallets/"+walletID.String()+"?convert_to=EUR", nil))
+
+		assert.Equal(t, http.StatusNotImplemented, w.Code)
+	})
+}
+
+// Тесты для ProcessQueue
+func TestProcessQueue(t *testing.T) {
+	tests := []struct {
+		name      string
+		operation wallet.WalletRequest
+		mockSetup func(*MockDB, *MockCache)
+	}{
+		{
+			name: "Успешная обработка операции",
+			operation: wallet.WalletRequest{
+				WalletID:      uuid.New().String(),
+				OperationType: wallet.DEPOSIT,
+				Amount:        100,
+			},
+			mockSetup: func(db *MockDB, cache *MockCache) {
+				opJSON, _ := json.Marshal(wallet.WalletRequest{
+					WalletID:      uuid.New().String(),
+					OperationType: wallet.DEPOSIT,
+					Amount:        100,
+				})
+
+				// Настраиваем первый ответ очереди
+				successCmd := redis.NewStringSliceCmd(context.Background())
+				successCmd.SetVal([]string{operationsQueueKey, string(opJSON)})
+				cache.On("BRPop", mock.Anything, time.Duration(0), priorityQueueKeys).
+					Return(successCmd).Once()
+				expectNotBlocked(cache)
+
+				// Настраиваем второй ответ с ошибкой для завершения цикла
+				errorCmd := redis.NewStringSliceCmd(context.Background())
+				errorCmd.SetErr(context.Canceled)
+				cache.On("BRPop", mock.Anything, time.Duration(0), priorityQueueKeys).
+					Return(errorCmd).Maybe()
+
+				// Настраиваем транзакцию
+				mockTx := new(MockTx)
+				db.On("BeginTx", mock.Anything).Return(mockTx, nil).Once()
+
+				// Настраиваем получение баланса
+				mockRow := new(MockRow)
+				mockRow.On("Scan", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil).Once()
+				mockTx.On("QueryRowContext", mock.Anything, mock.Anything, mock.Anything).
+					Return(mockRow).Once()
+
+				// Настраиваем обновление баланса и запись транзакции
+				mockResult := &MockResult{}
+				mockTx.On("ExecContext", mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+					Return(mockResult, nil).Times(2)
+
+				// Настраиваем Rollback и Commit
+				mockTx.On("Rollback").Return(nil).Maybe()
+				mockTx.On("Commit").Return(nil).Once()
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB := new(MockDB)
+			mockCache := newWriteCache()
+
+			if tt.mockSetup != nil {
+				tt.mockSetup(mockDB, mockCache)
+			}
+
+			handler := &WalletHandler{
+				db:          mockDB,
+				repository:  NewWalletRepository(mockDB),
+				store:       NewPostgresStore(mockDB, LockStrategyRow),
+				events:      NewLocalEvents(),
+				clock:       systemClock{},
+				metrics:     metrics.Nop{},
+				cache:       mockCache,
+				validator:   &service.WalletValidator{},
+				config:      Config{ConcurrencyLimit: 1},
+				rateLimiter: rate.NewLimiter(rate.Limit(100), 1),
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+
+			handler.ProcessQueue(ctx)
+
+			mockDB.AssertExpectations(t)
+			mockCache.AssertExpectations(t)
+		})
+	}
+}
+
+// errRow - строка результата, чтение которой всегда завершается ошибкой
+type errRow struct {
+	err error
+}
+
+func (r errRow) Scan(dest ...interface{}) error {
+	return r.err
+}
+
+// Добавляем MockResult
+// recordedTransaction сопоставляет аргументы insertTransactionQuery без учёта времени записи
+func recordedTransaction(walletID uuid.UUID, amount float64, operationType wallet.OperationType, reference string) interface{} {
+	return mock.MatchedBy(func(args []interface{}) bool {
+		if len(args) != 5 {
+			return false
+		}
+		_, isTime := args[4].(time.Time)
+		return isTime && reflect.DeepEqual(args[:4], []interface{}{walletID, amount, operationType, reference})
+	})
+}
+
+// fakeClock - Clock с управляемым временем
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+	return &fakeClock{now: now}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+type MockResult struct {
+	mock.Mock
+}
+
+func (m *MockResult) LastInsertId() (int64, error) {
+	args := m.Called()
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockResult) RowsAffected() (int64, error) {
+	args := m.Called()
+	return args.Get(0).(int64), args.Error(1)
+}
+
+// MockValidator подменяет service.WalletValidator
+type MockValidator struct {
+	mock.Mock
+}
+
+func (m *MockValidator) ValidateWalletRequest(req *wallet.WalletRequest) error {
+	return m.Called(req).Error(0)
+}
+
+func (m *MockValidator) ValidateWalletID(id uuid.UUID) error {
+	return m.Called(id).Error(0)
+}
+
+func (m *MockValidator) ValidateAmount(amount float64) error {
+	return m.Called(amount).Error(0)
+}
+
+func (m *MockValidator) ValidateMinAmount(opType wallet.OperationType, amount float64) error {
+	return m.Called(opType, amount).Error(0)
+}
+
+func (m *MockValidator) ValidateOperationType(opType wallet.OperationType) error {
+	return m.Called(opType).Error(0)
+}
+
+func (m *MockValidator) ValidateReference(reference string) error {
+	return m.Called(reference).Error(0)
+}
+
+func (m *MockValidator) ValidateBalance(currentBalance, requestAmount float64) error {
+	return m.Called(currentBalance, requestAmount).Error(0)
+}
+
+// Тест подключения собственного валидатора через конфигурацию
+func TestCustomValidator(t *testing.T) {
+	walletID := uuid.New().String()
+	mockValidator := new(MockValidator)
+	mockValidator.On("ValidateWalletRequest", mock.MatchedBy(func(req *wallet.WalletRequest) bool {
+		return req.WalletID == walletID && req.Amount == 50000
+	})).Return(errors.New("сумма требует проверки комплаенса")).Once()
+
+	config := DefaultConfig()
+	config.Validator = mockValidator
+	mockCache := new(MockCache)
+	handler := NewWalletHandlerWithConfig(new(MockDB), mockCache, false, config)
+
+	body, _ := json.Marshal(wallet.WalletRequest{
+		WalletID:      walletID,
+		OperationType: wallet.DEPOSIT,
+		Amount:        50000,
+	})
+	w := httptest.NewRecorder()
+	handler.HandleWalletOperation(w, newJSONRequest(body))
+
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(t, w.Body.String(), "сумма требует проверки комплаенса")
+	mockValidator.AssertExpectations(t)
+	mockCache.AssertNotCalled(t, "LPush", mock.Anything, mock.Anything, mock.Anything)
+}
+
+// Тесты фоновой записи баланса в кэш
+func TestCacheWriter(t *testing.T) {
+	newDBBalanceHandler := func(walletID uuid.UUID, mockCache *MockCache) *WalletHandler {
+		mockCache.On("Get", mock.Anything, "balance:"+walletID.String()).Return("", redis.Nil).Times(3)
+		mockRow := new(MockRow)
+		mockRow.On("Scan", mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+			*args.Get(0).(*float64) = 75
+		}).Return(nil).Once()
+		mockDB := new(MockDB)
+		mockDB.On("QueryRowContext", mock.Anything, selectWalletBalanceQuery, walletID).
+			Return(mockRow).Once()
+		return NewWalletHandler(mockDB, mockCache, false)
+	}
+
+	t.Run("Баланс из БД записывается в кэш", func(t *testing.T) {
+		walletID := uuid.New()
+		written := make(chan struct{})
+		mockCache := new(MockCache)
+		mockCache.On("Set", mock.Anything, "balance:"+walletID.String(), `{"balance":75,"version":0}`, balanceCacheTTL).
+			Run(func(mock.Arguments) { close(written) }).Return(nil).Once()
+		handler := newDBBalanceHandler(walletID, mockCache)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go handler.RunCacheWriter(ctx)
+
+		w := httptest.NewRecorder()
+		handler.GetWalletBalance(w, httptest.NewRequest("GET", "/api/v1/wallets/"+walletID.String(), nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		select {
+		case <-written:
+		case <-time.After(time.Second):
+			t.Fatal("запись в кэш не выполнена")
+		}
+		mockCache.AssertExpectations(t)
+	})
+
+	t.Run("Остановка прерывает зависшую запись по истечении ShutdownFlushTimeout", func(t *testing.T) {
+		walletID := uuid.New()
+		started := make(chan struct{})
+		var setCtx context.Context
+		mockCache := new(MockCache)
+		mockCache.On("Set", mock.Anything, "balance:"+walletID.String(), `{"balance":75,"version":0}`, balanceCacheTTL).
+			Run(func(args mock.Arguments) {
+				setCtx = args.Get(0).(context.Context)
+				close(started)
+				<-setCtx.Done()
+			}).Return(context.Canceled).Once()
+		handler := newDBBalanceHandler(walletID, mockCache)
+		handler.config.ShutdownFlushTimeout = 50 * time.Millisecond
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			handler.RunCacheWriter(ctx)
+		}()
+
+		handler.GetWalletBalance(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/v1/wallets/"+walletID.String(), nil))
+		<-started
+		cancel()
+
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("RunCacheWriter не завершился после отмены")
+		}
+		assert.ErrorIs(t, setCtx.Err(), context.Canceled)
+	})
+
+	t.Run("Переполненная очередь не блокирует ответ", func(t *testing.T) {
+		config := DefaultConfig()
+		config.CacheWriteQueueSize = 1
+		handler := NewWalletHandlerWithConfig(new(MockDB), new(MockCache), false, config)
+
+		handler.enqueueCacheWrite("balance:1", 1.0, balanceCacheTTL)
+		handler.enqueueCacheWrite("balance:2", 2.0, balanceCacheTTL)
+
+		assert.Len(t, handler.cacheWrites, 1)
+	})
+}
+
+// Одновременные чтения холодного кошелька выполняют один запрос к БД
+func TestBalanceSingleFlight(t *testing.T) {
+	const readers = 20
+	walletID := uuid.New()
+
+	mockCache := new(MockCache)
+	mockCache.On("Get", mock.Anything, "balance:"+walletID.String()).Return("", redis.Nil)
+	mockCache.On("Set", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil).Maybe()
+
+	mockRow := new(MockRow)
+	mockRow.On("Scan", mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		// Медленный запрос: остальные читатели успевают присоединиться
+		time.Sleep(300 * time.Millisecond)
+		*args.Get(0).(*float64) = 42
+	}).Return(nil)
+	mockDB := new(MockDB)
+	mockDB.On("QueryRowContext", mock.Anything, selectWalletBalanceQuery, walletID).Return(mockRow)
+
+	handler := NewWalletHandler(mockDB, mockCache, false)
+
+	var wg sync.WaitGroup
+	codes := make([]int, readers)
+	bodies := make([]string, readers)
+	for i := 0; i < readers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			handler.GetWalletBalance(w, httptest.NewRequest("GET", "/api/v1/wallets/"+walletID.String(), nil))
+			codes[i] = w.Code
+			bodies[i] = w.Body.String()
+		}(i)
+	}
+	wg.Wait()
+
+	mockDB.AssertNumberOfCalls(t, "QueryRowContext", 1)
+	for i := 0; i < readers; i++ {
+		assert.Equal(t, http.StatusOK, codes[i])
+		assert.JSONEq(t, `{"balance": "42.00", "balance_minor": 4200}`, bodies[i])
+	}
+	assert.Len(t, handler.cacheWrites, 1, "баланс должен попасть в кэш один раз")
+}
+
+// Отмена запроса, начавшего общее чтение, не прерывает его для остальных
+func TestBalanceSingleFlightLeaderCancelled(t *testing.T) {
+	walletID := uuid.New()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var queryCtx context.Context
+	mockRow := new(MockRow)
+	mockRow.On("Scan", mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		close(started)
+		<-release
+		assert.NoError(t, queryCtx.Err(), "общий запрос не должен отменяться вместе с первым клиентом")
+		*args.Get(0).(*float64) = 42
+	}).Return(nil)
+	mockDB := new(MockDB)
+	mockDB.On("QueryRowContext", mock.Anything, selectWalletBalanceQuery, walletID).Run(func(args mock.Arguments) {
+		queryCtx = args.Get(0).(context.Context)
+	}).Return(mockRow)
+
+	handler := NewWalletHandler(mockDB, nil, false)
+
+	leaderCtx, cancelLeader := context.WithCancel(context.Background())
+	leaderErr := make(chan error, 1)
+	go func() {
+		_, err := handler.loadBalance(leaderCtx, walletID)
+		leaderErr <- err
+	}()
+	<-started
+
+	type loaded struct {
+		balance walletBalance
+		err     error
+	}
+	follower := make(chan loaded, 1)
+	go func() {
+		balance, err := handler.loadBalance(context.Background(), walletID)
+		follower <- loaded{balance, err}
+	}()
+	// Даём второму читателю присоединиться к уже идущему запросу
+	time.Sleep(50 * time.Millisecond)
+
+	cancelLeader()
+	assert.ErrorIs(t, <-leaderErr, context.Canceled)
+
+	close(release)
+	result := <-follower
+	require.NoError(t, result.err)
+	assert.Equal(t, 42.0, result.balance.amount)
+	mockDB.AssertNumberOfCalls(t, "QueryRowContext", 1)
+}
+
+// Тесты для вспомоательных методов
+func TestHelperMethods(t *testing.T) {
+	mockDB := new(MockDB)
+	handler := NewWalletHandler(mockDB, nil, false)
+
+	t.Run("getCurrentBalance", func(t *testing.T) {
+		mockTx := new(MockTx)
+		mockRow := new(MockRow)
+		walletID := uuid.New()
+		expectedBalance := 100.0
+
+		mockTx.On("QueryRowContext",
+			mock.Anything,
+			selectBalanceForUpdateQuery,
+			mock.Anything,
+		).Return(mockRow).Once()
+
+		mockRow.On("Scan", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+			balance := args.Get(0).(*float64)
+			*balance = expectedBalance
+		}).Return(nil).Once()
+
+		balance, err := handler.getCurrentBalance(context.Background(), handler.postgresTx(mockTx), walletID)
+		assert.NoError(t, err)
+		assert.Equal(t, expectedBalance, balance)
+
+		mockTx.AssertExpectations(t)
+		mockRow.AssertExpectations(t)
+	})
+
+	t.Run("getCurrentBalance с advisory-блокировкой", func(t *testing.T) {
+		config := DefaultConfig()
+		config.LockStrategy = LockStrategyAdvisory
+		advisoryHandler := NewWalletHandlerWithConfig(mockDB, nil, false, config)
+
+		mockTx := new(MockTx)
+		mockRow := new(MockRow)
+		walletID := uuid.New()
+
+		mockTx.On("ExecContext",
+			mock.Anything,
+			"SELECT pg_advisory_xact_lock(hashtext($1))",
+			[]interface{}{walletID.String()},
+		).Return(&MockResult{}, nil).Once()
+		// Без FOR UPDATE: сериализацию обеспечивает advisory-блокировка
+		mockTx.On("QueryRowContext",
+			mock.Anything,
+			selectBalanceQuery,
+			mock.Anything,
+		).Return(mockRow).Once()
+		mockRow.On("Scan", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+			*args.Get(0).(*float64) = 42
+		}).Return(nil).Once()
+
+		balance, err := advisoryHandler.getCurrentBalance(context.Background(), advisoryHandler.postgresTx(mockTx), walletID)
+		assert.NoError(t, err)
+		assert.Equal(t, 42.0, balance)
+
+		mockTx.AssertExpectations(t)
+		mockRow.AssertExpectations(t)
+	})
+
+	t.Run("beginTx", func(t *testing.T) {
+		mockTx := new(MockTx)
+		mockDB.On("BeginTx", mock.Anything).Return(mockTx, nil).Once()
+
+		tx, err := handler.beginTx(context.Background())
+		assert.NoError(t, err)
+		assert.NotNil(t, tx)
+
+		mockDB.AssertExpectations(t)
+	})
+}
+
+func testMockRow(t *testing.T) {
+	mockRow := new(MockRow)
+	var balance float64
+	mockRow.On("Scan", mock.Anything).Return(nil).Once()
+	err := mockRow.Scan(&balance)
+	assert.NoError(t, err)
+	mockRow.AssertExpectations(t)
+}
+
+func testMockDB(t *testing.T) {
+	mockDB := new(MockDB)
+	ctx := context.Background()
+	mockRow := new(MockRow)
+
+	mockDB.On("QueryRowContext", mock.Anything, mock.Anything, mock.Anything).Return(mockRow).Once()
+	row := mockDB.QueryRowContext(ctx, "query", "arg")
+	assert.NotNil(t, row)
+
+	mockDB.AssertExpectations(t)
+}
+
+func testMockTx(t *testing.T) {
+	mockTx := new(MockTx)
+	mockRow := new(MockRow)
+
+	mockTx.On("QueryRowContext", mock.Anything, mock.Anything, mock.Anything).Return(mockRow).Once()
+}
+
+func testMockCache(t *testing.T) {
+	mockCache := new(MockCache)
+	ctx := context.Background()
+
+	mockCache.On("Get", mock.Anything, mock.Anything).Return("", nil).Once()
+	_, err := mockCache.Get(ctx, "test-key")
+	assert.NoError(t, err)
+
+	mockCache.AssertExpectations(t)
+}
+
+func testMockResult(t *testing.T) {
+	mockResult := new(MockResult)
+
+	mockResult.On("RowsAffected").Return(int64(1), nil).Once()
+	rows, err := mockResult.RowsAffected()
+
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), rows)
+	mockResult.AssertExpectations(t)
+}
+
+// Тесты результата операции из очереди
+func TestOperationResult(t *testing.T) {
+	walletID := uuid.New()
+	blockedKey := blockedWalletKey(walletID.String())
+
+	t.Run("Результат содержит новый баланс", func(t *testing.T) {
+		mockDB := new(MockDB)
+		mockTx := new(MockTx)
+		mockRow := new(MockRow)
+		mockCache := newWriteCache()
+		mockCache.On("Get", mock.Anything, blockedKey).Return("", redis.Nil).Once()
+
+		mockDB.On("BeginTx", mock.Anything).Return(mockTx, nil).Once()
+		mockTx.On("QueryRowContext", mock.Anything, selectBalanceForUpdateQuery, mock.Anything).Return(mockRow).Once()
+		mockRow.On("Scan", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+			*args.Get(0).(*float64) = 250
+		}).Return(nil).Once()
+		mockTx.On("ExecContext", mock.Anything, updateBalanceQuery,
+			[]interface{}{350.0, walletID}).Return(&MockResult{}, nil).Once()
+		mockTx.On("ExecContext", mock.Anything, mock.Anything,
+			recordedTransaction(walletID, 100.0, wallet.DEPOSIT, "")).Return(&MockResult{}, nil).Once()
+		mockTx.On("Commit").Return(nil).Once()
+		mockTx.On("Rollback").Return(nil).Maybe()
+
+		handler := NewWalletHandler(mockDB, mockCache, false)
+		result, err := handler.ProcessQueueOperation(wallet.WalletRequest{
+			ID:            "op-1",
+			WalletID:      walletID.String(),
+			OperationType: wallet.DEPOSIT,
+			Amount:        100,
+		})
+
+		assert.NoError(t, err)
+		assert.Equal(t, wallet.OperationResult{ID: "op-1", BalanceBefore: 250, BalanceAfter: 350, Status: wallet.OperationCompleted}, result)
+		mockTx.AssertExpectations(t)
+	})
+
+	t.Run("Неудачная операция помечается failed", func(t *testing.T) {
+		mockCache := new(MockCache)
+		mockCache.On("Get", mock.Anything, blockedKey).Return("1", nil).Once()
+
+		handler := NewWalletHandler(new(MockDB), mockCache, false)
+		result, err := handler.ProcessQueueOperation(wallet.WalletRequest{
+			ID:            "op-2",
+			WalletID:      walletID.String(),
+			OperationType: wallet.DEPOSIT,
+			Amount:        100,
+		})
+
+		assert.Error(t, err)
+		assert.Equal(t, "op-2", result.ID)
+		assert.Equal(t, wallet.OperationFailed, result.Status)
+		assert.Zero(t, result.BalanceAfter)
+	})
+}
+
+// Тесты закрытых кошельков
+func TestClosedWallet(t *testing.T) {
+	walletID := uuid.New()
+
+	closedRow := func(balance float64) *MockRow {
+		mockRow := new(MockRow)
+		mockRow.On("Scan", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+			*args.Get(0).(*float64) = balance
+			*args.Get(1).(*bool) = true
+		}).Return(nil).Once()
+		return mockRow
+	}
+
+	t.Run("Депозит на закрытый кошелек - 409", func(t *testing.T) {
+		mockDB := new(MockDB)
+		mockTx := new(MockTx)
+		mockDB.On("BeginTx", mock.Anything).Return(mockTx, nil).Once()
+		mockTx.On("QueryRowContext", mock.Anything, selectBalanceForUpdateQuery, mock.Anything).Return(closedRow(100)).Once()
+		mockTx.On("Rollback").Return(nil).Once()
+
+		handler := NewWalletHandler(mockDB, new(MockCache), false)
+		err := handler.handleOperation(context.Background(), &wallet.WalletRequest{
+			WalletID:      walletID.String(),
+			OperationType: wallet.DEPOSIT,
+			Amount:        50,
+		})
+
+		assert.NotNil(t, err)
+		assert.Equal(t, http.StatusConflict, err.Code)
+		assert.Equal(t, ErrWalletClosed, err.Message)
+		mockTx.AssertNotCalled(t, "ExecContext", mock.Anything, mock.Anything, mock.Anything)
+		mockTx.AssertNotCalled(t, "Commit")
+	})
+
+	t.Run("Баланс закрытого кошелька с флагом closed", func(t *testing.T) {
+		mockDB := new(MockDB)
+		mockCache := new(MockCache)
+		mockCache.On("Get", mock.Anything, fmt.Sprintf("balance:%s", walletID)).Return("", redis.Nil)
+		mockDB.On("QueryRowContext", mock.Anything, selectWalletBalanceQuery, walletID).
+			Return(closedRow(75)).Once()
+
+		handler := NewWalletHandler(mockDB, mockCache, false)
+		w := httptest.NewRecorder()
+		handler.GetWalletBalance(w, httptest.NewRequest("GET", "/api/v1/wallets/"+walletID.String(), nil))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var body Balance
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&body))
+		assert.Equal(t, Balance{Balance: "75.00", BalanceMinor: 7500, Closed: true}, body)
+
+		// Баланс закрытого кошелька не кэшируется
+		assert.Empty(t, handler.cacheWrites)
+		mockDB.AssertExpectations(t)
+	})
+}
+
+// Тесты stale-while-revalidate для баланса
+func TestStaleWhileRevalidate(t *testing.T) {
+	walletID := uuid.New()
+	cacheKey := fmt.Sprintf("balance:%s", walletID)
+
+	newSWRHandler := func(mockDB *MockDB, mockCache *MockCache) *WalletHandler {
+		config := DefaultConfig()
+		config.BalanceSoftTTL = 10 * time.Second
+		return NewWalletHandlerWithConfig(mockDB, mockCache, false, config)
+	}
+
+	t.Run("Устаревшее значение отдаётся, обновление запускается в фоне", func(t *testing.T) {
+		staleAt := time.Now().Add(-time.Second).UnixMilli()
+		mockCache := new(MockCache)
+		mockCache.On("Get", mock.Anything, cacheKey).Return(fmt.Sprintf(`{"balance":120.5,"version":4,"stale_at":%d}`, staleAt), nil).Once()
+
+		mockRow := new(MockRow)
+		mockRow.On("Scan", mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+			*args.Get(0).(*float64) = 200
+			*args.Get(2).(*int64) = 5
+		}).Return(nil).Once()
+		mockDB := new(MockDB)
+		mockDB.On("QueryRowContext", mock.Anything, selectWalletBalanceQuery, walletID).
+			Return(mockRow).Once()
+
+		handler := newSWRHandler(mockDB, mockCache)
+		w := httptest.NewRecorder()
+		handler.GetWalletBalance(w, httptest.NewRequest("GET", "/api/v1/wallets/"+walletID.String(), nil))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var body Balance
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&body))
+		assert.Equal(t, "120.50", body.Balance)
+
+		// Обновлённый баланс ставится в очередь записи в кэш с новым сроком свежести
+		assert.Eventually(t, func() bool { return len(handler.cacheWrites) == 1 }, time.Second, 10*time.Millisecond)
+		write := <-handler.cacheWrites
+		assert.Equal(t, cacheKey, write.key)
+		assert.Equal(t, balanceCacheTTL, write.ttl)
+		balance, stale, err := parseCachedBalance(write.value.(string), time.Now())
+		assert.NoError(t, err)
+		assert.Equal(t, 200.0, balance.Balance)
+		assert.Equal(t, int64(5), balance.Version)
+		assert.False(t, stale)
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("Свежее значение не вызывает обновления", func(t *testing.T) {
+		freshUntil := time.Now().Add(time.Minute).UnixMilli()
+		mockCache := new(MockCache)
+		mockCache.On("Get", mock.Anything, cacheKey).Return(fmt.Sprintf(`{"balance":120.5,"version":4,"stale_at":%d}`, freshUntil), nil).Once()
+		mockDB := new(MockDB)
+
+		handler := newSWRHandler(mockDB, mockCache)
+		w := httptest.NewRecorder()
+		handler.GetWalletBalance(w, httptest.NewRequest("GET", "/api/v1/wallets/"+walletID.String(), nil))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		time.Sleep(50 * time.Millisecond)
+		mockDB.AssertNotCalled(t, "QueryRowContext", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("Разбор значения кэша", func(t *testing.T) {
+		now := time.UnixMilli(1_000_000)
+
+		balance, stale, err := parseCachedBalance("42", now)
+		assert.NoError(t, err)
+		assert.Equal(t, cachedBalance{Balance: 42}, balance)
+		assert.False(t, stale)
+
+		balance, stale, err = parseCachedBalance(`{"balance":42,"version":3,"stale_at":1000000}`, now)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(3), balance.Version)
+		assert.True(t, stale)
+
+		_, stale, err = parseCachedBalance(`{"balance":42,"version":3}`, now)
+		assert.NoError(t, err)
+		assert.False(t, stale)
+
+		_, _, err = parseCachedBalance(`{"balance":"много"}`, now)
+		assert.Error(t, err)
+	})
+}
+
+// Тесты импорта операций из CSV
+func TestImport(t *testing.T) {
+	config := DefaultConfig()
+	config.AdminToken = "secret"
+	walletID := uuid.New()
+
+	newImportRequest := func(body string) *http.Request {
+		req := httptest.NewRequest("POST", "/api/v1/admin/import", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		req.Header.Set("Content-Type", "text/csv")
+		return req
+	}
+
+	t.Run("Некорректные строки пропускаются с номером строки", func(t *testing.T) {
+		csvBody := "wallet_id,operation_type,amount,reference\n" +
+			walletID.String() + ",DEPOSIT,100,перенос\n" +
+			walletID.String() + ",DEPOSIT\n" +
+			walletID.String() + ",withdraw,25.5,\n" +
+			"not-a-uuid,DEPOSIT,10,\n" +
+			walletID.String() + ",DEPOSIT,много,\n" +
+			walletID.String() + ",DEPOSIT,NaN,\n" +
+			walletID.String() + ",DEPOSIT,Inf,\n"
+
+		var queued []wallet.WalletRequest
+		mockCache := new(MockCache)
+		mockCache.On("LPush", mock.Anything, lowOperationsQueueKey, mock.Anything).Run(func(args mock.Arguments) {
+			var req wallet.WalletRequest
+			assert.NoError(t, json.Unmarshal(args.Get(2).([]interface{})[0].([]byte), &req))
+			queued = append(queued, req)
+		}).Return(redis.NewIntCmd(context.Background())).Times(2)
+
+		handler := NewWalletHandlerWithConfig(new(MockDB), mockCache, false, config)
+		w := httptest.NewRecorder()
+		handler.HandleImport(w, newImportRequest(csvBody))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var report ImportReport
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&report))
+		assert.Equal(t, 2, report.Queued)
+		assert.Equal(t, 5, report.Skipped)
+		assert.Zero(t, report.Failed)
+
+		lines := make(map[int]ImportRowResult)
+		for _, row := range report.Rows {
+			lines[row.Line] = row
+		}
+		assert.Equal(t, ImportRowQueued, lines[2].Status)
+		assert.NotEmpty(t, lines[2].OperationID)
+		assert.Equal(t, ImportRowSkipped, lines[3].Status)
+		assert.Equal(t, ErrImportMalformedRow, lines[3].Error)
+		assert.Equal(t, ImportRowQueued, lines[4].Status)
+		assert.Equal(t, ErrInvalidUUID, lines[5].Error)
+		assert.Equal(t, ImportRowSkipped, lines[6].Status)
+		// NaN и бесконечность - ошибка суммы, а не ошибка очереди
+		for _, line := range []int{7, 8} {
+			assert.Equal(t, ImportRowSkipped, lines[line].Status)
+			assert.Equal(t, lines[6].Error, lines[line].Error)
+		}
+
+		assert.Len(t, queued, 2)
+		assert.Equal(t, wallet.WalletRequest{
+			ID:            lines[2].OperationID,
+			WalletID:      walletID.String(),
+			OperationType: wallet.DEPOSIT,
+			Amount:        100,
+			Reference:     "перенос",
+			Subject:       adminSubject,
+			SourceIP:      "192.0.2.1",
+			Priority:      wallet.PriorityLow,
+		}, queued[0])
+		assert.Equal(t, wallet.WITHDRAW, queued[1].OperationType)
+		assert.Equal(t, 25.5, queued[1].Amount)
+	})
+
+	t.Run("Импорт без токена запрещён", func(t *testing.T) {
+		mockCache := new(MockCache)
+		handler := NewWalletHandlerWithConfig(new(MockDB), mockCache, false, config)
+		req := newImportRequest(walletID.String() + ",DEPOSIT,100,\n")
+		req.Header.Del("Authorization")
+		w := httptest.NewRecorder()
+
+		handler.HandleImport(w, req)
+
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		mockCache.AssertNotCalled(t, "LPush", mock.Anything, mock.Anything, mock.Anything)
+	})
+}
+
+// Тесты запрета отдельных операций для кошелька
+func TestOperationToggles(t *testing.T) {
+	walletID := uuid.New()
+
+	// Кошелек для пожертвований: зачисления разрешены, списания запрещены
+	newDonationHandler := func(mockTx *MockTx) *WalletHandler {
+		mockRow := new(MockRow)
+		mockRow.On("Scan", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+			*args.Get(0).(*float64) = 500
+			*args.Get(3).(*bool) = true
+		}).Return(nil).Once()
+
+		mockDB := new(MockDB)
+		mockDB.On("BeginTx", mock.Anything).Return(mockTx, nil).Once()
+		mockTx.On("QueryRowContext", mock.Anything, selectBalanceForUpdateQuery, mock.Anything).Return(mockRow).Once()
+		mockTx.On("Rollback").Return(nil).Once()
+		return NewWalletHandler(mockDB, newWriteCache(), false)
+	}
+
+	t.Run("Списание с кошелька только для зачислений - 403", func(t *testing.T) {
+		mockTx := new(MockTx)
+		handler := newDonationHandler(mockTx)
+
+		err := handler.handleOperation(context.Background(), &wallet.WalletRequest{
+			WalletID:      walletID.String(),
+			OperationType: wallet.WITHDRAW,
+			Amount:        100,
+		})
+
+		assert.NotNil(t, err)
+		assert.Equal(t, http.StatusForbidden, err.Code)
+		assert.Equal(t, ErrOperationNotAllowed, err.Message)
+		mockTx.AssertNotCalled(t, "ExecContext", mock.Anything, mock.Anything, mock.Anything)
+		mockTx.AssertNotCalled(t, "Commit")
+	})
+
+	t.Run("Зачисление на кошелек только для зачислений проходит", func(t *testing.T) {
+		mockTx := new(MockTx)
+		mockTx.On("ExecContext", mock.Anything, updateBalanceQuery,
+			[]interface{}{600.0, walletID}).Return(&MockResult{}, nil).Once()
+		mockTx.On("ExecContext", mock.Anything, mock.Anything,
+			recordedTransaction(walletID, 100.0, wallet.DEPOSIT, "")).Return(&MockResult{}, nil).Once()
+		mockTx.On("Commit").Return(nil).Once()
+		handler := newDonationHandler(mockTx)
+
+		err := handler.handleOperation(context.Background(), &wallet.WalletRequest{
+			WalletID:      walletID.String(),
+			OperationType: wallet.DEPOSIT,
+			Amount:        100,
+		})
+
+		assert.Nil(t, err)
+		mockTx.AssertExpectations(t)
+	})
+
+	t.Run("Нулевое состояние разрешает обе операции", func(t *testing.T) {
+		var locked lockedWallet
+		assert.True(t, locked.allows(wallet.Credit))
+		assert.True(t, locked.allows(wallet.Debit))
+	})
+}
+
+// Тесты защиты БД от перегрузки
+func TestLoadShedding(t *testing.T) {
+	walletID := uuid.New()
+	balanceQuery := selectWalletBalanceQuery
+
+	newSaturatedHandler := func(mockDB *MockDB) *WalletHandler {
+		mockCache := new(MockCache)
+		mockCache.On("Get", mock.Anything, mock.Anything).Return("", redis.Nil)
+
+		config := DefaultConfig()
+		config.DBLatencyThreshold = 100 * time.Millisecond
+		config.SaturatedDBReads = 1
+		handler := NewWalletHandlerWithConfig(mockDB, mockCache, false, config)
+		// Имитируем медленную БД
+		handler.dbLatency.observe(time.Second)
+		return handler
+	}
+
+	t.Run("При высокой задержке повторов нет", func(t *testing.T) {
+		mockRow := new(MockRow)
+		mockRow.On("Scan", mock.Anything, mock.Anything, mock.Anything).Return(errors.New("timeout")).Once()
+		mockDB := new(MockDB)
+		mockDB.On("QueryRowContext", mock.Anything, balanceQuery, walletID).Return(mockRow).Once()
+		handler := newSaturatedHandler(mockDB)
+
+		w := httptest.NewRecorder()
+		handler.GetWalletBalance(w, httptest.NewRequest("GET", "/api/v1/wallets/"+walletID.String(), nil))
+
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		mockDB.AssertNumberOfCalls(t, "QueryRowContext", 1)
+	})
+
+	t.Run("Чтения сверх лимита отклоняются", func(t *testing.T) {
+		mockDB := new(MockDB)
+		handler := newSaturatedHandler(mockDB)
+		// Единственный слот занят другим чтением
+		handler.saturatedReads <- struct{}{}
+
+		w := httptest.NewRecorder()
+		handler.GetWalletBalance(w, httptest.NewRequest("GET", "/api/v1/wallets/"+walletID.String(), nil))
+
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		assert.Contains(t, w.Body.String(), ErrServerBusy)
+		mockDB.AssertNotCalled(t, "QueryRowContext", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("При нормальной задержке три попытки", func(t *testing.T) {
+		mockRow := new(MockRow)
+		mockRow.On("Scan", mock.Anything, mock.Anything, mock.Anything).Return(errors.New("timeout")).Times(3)
+		mockDB := new(MockDB)
+		mockDB.On("QueryRowContext", mock.Anything, balanceQuery, walletID).Return(mockRow).Times(3)
+		mockCache := new(MockCache)
+		mockCache.On("Get", mock.Anything, mock.Anything).Return("", redis.Nil)
+		handler := NewWalletHandler(mockDB, mockCache, false)
+
+		w := httptest.NewRecorder()
+		handler.GetWalletBalance(w, httptest.NewRequest("GET", "/api/v1/wallets/"+walletID.String(), nil))
+
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		mockDB.AssertNumberOfCalls(t, "QueryRowContext", 3)
+	})
+
+	t.Run("Скользящая средняя", func(t *testing.T) {
+		var tracker latencyTracker
+		tracker.observe(100 * time.Millisecond)
+		assert.Equal(t, 100*time.Millisecond, tracker.value())
+
+		tracker.observe(600 * time.Millisecond)
+		assert.Equal(t, 200*time.Millisecond, tracker.value())
+	})
+}
+
+// Тесты проверки входных данных эндпоинтов: ошибки полей дают 422 до обращения к БД и кэшу
+func TestRequestValidation(t *testing.T) {
+	config := DefaultConfig()
+	config.AdminToken = "secret"
+	walletID := uuid.New().String()
+
+	adminRequest := func(method, path string) *http.Request {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		return req
+	}
+	operation := func(body string) *http.Request {
+		return newJSONRequest([]byte(body))
+	}
+
+	tests := []struct {
+		name     string
+		handle   func(h *WalletHandler) http.HandlerFunc
+		request  *http.Request
+		expected string
+	}{
+		{
+			name:     "Баланс: неверный UUID",
+			handle:   func(h *WalletHandler) http.HandlerFunc { return h.GetWalletBalance },
+			request:  httptest.NewRequest("GET", "/api/v1/wallets/123", nil),
+			expected: ErrInvalidUUID,
+		},
+		{
+			name:     "Баланс на момент: неверное время",
+			handle:   func(h *WalletHandler) http.HandlerFunc { return h.GetWalletBalance },
+			request:  httptest.NewRequest("GET", "/api/v1/wallets/"+walletID+"?at=вчера", nil),
+			expected: ErrInvalidTimestamp,
+		},
+		{
+			name:     "Операция: нет wallet_id",
+			handle:   func(h *WalletHandler) http.HandlerFunc { return h.HandleWalletOperation },
+			request:  operation(`{"operation_type": "DEPOSIT", "amount": 10}`),
+			expected: service.ErrEmptyWalletID.Error(),
+		},
+		{
+			name:     "Операция: нет operation_type",
+			handle:   func(h *WalletHandler) http.HandlerFunc { return h.HandleWalletOperation },
+			request:  operation(`{"wallet_id": "` + walletID + `", "amount": 10}`),
+			expected: service.ErrUnknownOperationType.Error(),
+		},
+		{
+			name:     "Операция: отрицательная сумма",
+			handle:   func(h *WalletHandler) http.HandlerFunc { return h.HandleWalletOperation },
+			request:  operation(`{"wallet_id": "` + walletID + `", "operation_type": "DEPOSIT", "amount": -1}`),
+			expected: service.ErrNegativeAmount.Error(),
+		},
+		{
+			name:     "История: неверный UUID",
+			handle:   func(h *WalletHandler) http.HandlerFunc { return h.GetTransactionHistory },
+			request:  httptest.NewRequest("GET", "/api/v1/wallets/123/transactions", nil),
+			expected: ErrInvalidUUID,
+		},
+		{
+			name:     "Проверка списания: нет amount",
+			handle:   func(h *WalletHandler) http.HandlerFunc { return h.CanWithdraw },
+			request:  httptest.NewRequest("GET", "/api/v1/wallets/"+walletID+"/can-withdraw", nil),
+			expected: ErrInvalidAmountParam,
+		},
+		{
+			name:     "Отмена: неверный UUID операции",
+			handle:   func(h *WalletHandler) http.HandlerFunc { return h.VoidTransaction },
+			request:  adminRequest("POST", "/api/v1/transactions/123/void"),
+			expected: ErrInvalidTransactionID,
+		},
+		{
+			name:     "Блокировка: неверный UUID",
+			handle:   func(h *WalletHandler) http.HandlerFunc { return h.HandleWalletBlock },
+			request:  adminRequest("POST", "/api/v1/admin/wallets/123/block"),
+			expected: ErrInvalidUUID,
+		},
+		{
+			name:     "Очередь: count вне диапазона",
+			handle:   func(h *WalletHandler) http.HandlerFunc { return h.HandleQueuePeek },
+			request:  adminRequest("GET", "/api/v1/admin/queue?count=0"),
+			expected: ErrInvalidRange,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB := new(MockDB)
+			mockCache := new(MockCache)
+			handler := NewWalletHandlerWithConfig(mockDB, mockCache, false, config)
+			w := httptest.NewRecorder()
+
+			tt.handle(handler)(w, tt.request)
+
+			assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+			assert.Contains(t, w.Body.String(), tt.expected)
+			assert.Empty(t, mockDB.Calls)
+			assert.Empty(t, mockCache.Calls)
+		})
+	}
+}
+
+// Тесты дедупликации одинаковых операций при постановке в очередь
+func TestOperationDedup(t *testing.T) {
+	walletID := uuid.New().String()
+	body := []byte(`{"wallet_id": "` + walletID + `", "operation_type": "DEPOSIT", "amount": 100, "reference": "счёт 7"}`)
+	dedupKey := operationDedupKey(&wallet.WalletRequest{
+		Subject: anonymousSubject, WalletID: walletID, OperationType: wallet.DEPOSIT, Amount: 100, Reference: "счёт 7",
+	})
+
+	newDedupHandler := func(mockCache *MockCache) *WalletHandler {
+		config := DefaultConfig()
+		config.OperationDedupWindow = time.Minute
+		return NewWalletHandlerWithConfig(new(MockDB), mockCache, false, config)
+	}
+
+	t.Run("Первая операция ставится в очередь", func(t *testing.T) {
+		mockCache := new(MockCache)
+		expectNotBlocked(mockCache)
+		mockCache.On("EnqueueUnique", mock.Anything, operationsQueueKey, mock.Anything, dedupKey, mock.Anything, time.Minute).
+			Return(true, int64(3), "", nil).Once()
+
+		w := httptest.NewRecorder()
+		newDedupHandler(mockCache).HandleWalletOperation(w, newJSONRequest(body))
+
+		assert.Equal(t, http.StatusAccepted, w.Code)
+		var response map[string]interface{}
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+		assert.NotContains(t, response, "deduplicated")
+		assert.Equal(t, 3.0, response["queue_position"])
+		mockCache.AssertExpectations(t)
+		mockCache.AssertNotCalled(t, "LPush", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("Повтор в окне возвращает id первой операции", func(t *testing.T) {
+		mockCache := new(MockCache)
+		expectNotBlocked(mockCache)
+		mockCache.On("EnqueueUnique", mock.Anything, operationsQueueKey, mock.Anything, dedupKey, mock.Anything, time.Minute).
+			Return(false, int64(0), "first-operation", nil).Once()
+
+		w := httptest.NewRecorder()
+		newDedupHandler(mockCache).HandleWalletOperation(w, newJSONRequest(body))
+
+		assert.Equal(t, http.StatusAccepted, w.Code)
+		var response map[string]interface{}
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+		assert.Equal(t, "first-operation", response["operation_id"])
+		assert.Equal(t, true, response["deduplicated"])
+		mockCache.AssertNotCalled(t, "LPush", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("Другая сумма - другая операция", func(t *testing.T) {
+		other := operationDedupKey(&wallet.WalletRequest{
+			Subject: anonymousSubject, WalletID: walletID, OperationType: wallet.DEPOSIT, Amount: 100.5, Reference: "счёт 7",
+		})
+		assert.NotEqual(t, dedupKey, other)
+	})
+
+	t.Run("Другой вызывающий - другая операция", func(t *testing.T) {
+		other := operationDedupKey(&wallet.WalletRequest{
+			Subject: adminSubject, WalletID: walletID, OperationType: wallet.DEPOSIT, Amount: 100, Reference: "счёт 7",
+		})
+		assert.NotEqual(t, dedupKey, other)
+	})
+
+	t.Run("Анонимная операция без комментария не дедуплицируется", func(t *testing.T) {
+		mockCache := new(MockCache)
+		expectNotBlocked(mockCache)
+		mockCache.On("LPush", mock.Anything, operationsQueueKey, mock.Anything).
+			Return(redis.NewIntResult(1, nil)).Twice()
+
+		handler := newDedupHandler(mockCache)
+		anonymous := []byte(`{"wallet_id": "` + walletID + `", "operation_type": "DEPOSIT", "amount": 100}`)
+		for range 2 {
+			w := httptest.NewRecorder()
+			handler.HandleWalletOperation(w, newJSONRequest(anonymous))
+			assert.Equal(t, http.StatusAccepted, w.Code)
+			assert.NotContains(t, w.Body.String(), "deduplicated")
+		}
+		mockCache.AssertExpectations(t)
+		mockCache.AssertNotCalled(t, "EnqueueUnique", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("Без окна дедупликация выключена", func(t *testing.T) {
+		mockCache := new(MockCache)
+		expectNotBlocked(mockCache)
+		mockCache.On("LPush", mock.Anything, operationsQueueKey, mock.Anything).
+			Return(redis.NewIntResult(1, nil)).Once()
+
+		w := httptest.NewRecorder()
+		NewWalletHandler(new(MockDB), mockCache, false).HandleWalletOperation(w, newJSONRequest(body))
+
+		assert.Equal(t, http.StatusAccepted, w.Code)
+		mockCache.AssertNotCalled(t, "EnqueueUnique", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("Ошибка скрипта - операция не ставится в очередь", func(t *testing.T) {
+		mockCache := new(MockCache)
+		expectNotBlocked(mockCache)
+		mockCache.On("EnqueueUnique", mock.Anything, operationsQueueKey, mock.Anything, dedupKey, mock.Anything, time.Minute).
+			Return(false, int64(0), "", errors.New("connection refused")).Once()
+
+		w := httptest.NewRecorder()
+		newDedupHandler(mockCache).HandleWalletOperation(w, newJSONRequest(body))
+
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		mockCache.AssertNotCalled(t, "LPush", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("TTL ключа дедупликации следует окну, изменённому без перезапуска", func(t *testing.T) {
+		mockCache := new(MockCache)
+		expectNotBlocked(mockCache)
+		mockCache.On("EnqueueUnique", mock.Anything, operationsQueueKey, mock.Anything, dedupKey, mock.Anything, 90*time.Second).
+			Return(true, int64(1), "", nil).Once()
+		handler := newDedupHandler(mockCache)
+		require.NoError(t, handler.ApplySettings(func(settings *Settings) error {
+			settings.OperationDedupWindow = 90 * time.Second
+			return nil
+		}))
+
+		w := httptest.NewRecorder()
+		handler.HandleWalletOperation(w, newJSONRequest(body))
+
+		assert.Equal(t, http.StatusAccepted, w.Code)
+		mockCache.AssertExpectations(t)
+	})
+
+	t.Run("Фоновая запись без TTL не выполняется", func(t *testing.T) {
+		handler := newDedupHandler(new(MockCache))
+
+		handler.enqueueCacheWrite(operationStatusKey("op-1"), "{}", 0)
+
+		assert.Empty(t, handler.cacheWrites)
+	})
+}
+
+// Тесты версии баланса и заголовка If-Version-Gt
+func TestBalanceVersion(t *testing.T) {
+	walletID := uuid.New()
+	cacheKey := fmt.Sprintf("balance:%s", walletID)
+
+	versionRow := func(balance float64, version int64) *MockRow {
+		mockRow := new(MockRow)
+		mockRow.On("Scan", mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+			*args.Get(0).(*float64) = balance
+			*args.Get(2).(*int64) = version
+		}).Return(nil).Once()
+		return mockRow
+	}
+
+	getBalance := func(handler *WalletHandler, minVersion string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/v1/wallets/"+walletID.String(), nil)
+		if minVersion != "" {
+			req.Header.Set(ifVersionGtHeader, minVersion)
+		}
+		w := httptest.NewRecorder()
+		handler.GetWalletBalance(w, req)
+		return w
+	}
+
+	t.Run("Обновление баланса увеличивает версию", func(t *testing.T) {
+		mockTx := new(MockTx)
+		mockTx.On("ExecContext", mock.Anything, updateBalanceQuery, mock.Anything).
+			Return(new(MockResult), nil).Once()
+
+		handler := NewWalletHandler(new(MockDB), new(MockCache), false)
+		assert.NoError(t, handler.updateBalance(context.Background(), handler.postgresTx(mockTx), walletID, 10))
+		assert.Contains(t, updateBalanceQuery, "version = version + 1")
+		mockTx.AssertExpectations(t)
+	})
+
+	t.Run("Версия из БД в заголовке и в кэше", func(t *testing.T) {
+		mockCache := new(MockCache)
+		mockCache.On("Get", mock.Anything, cacheKey).Return("", redis.Nil)
+		mockDB := new(MockDB)
+		mockDB.On("QueryRowContext", mock.Anything, selectWalletBalanceQuery, walletID).
+			Return(versionRow(75, 7)).Once()
+
+		handler := NewWalletHandler(mockDB, mockCache, false)
+		w := getBalance(handler, "")
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "7", w.Header().Get(balanceVersionHeader))
+		write := <-handler.cacheWrites
+		assert.Equal(t, `{"balance":75,"version":7}`, write.value)
+	})
+
+	t.Run("Версия из кэша в заголовке", func(t *testing.T) {
+		mockCache := new(MockCache)
+		mockCache.On("Get", mock.Anything, cacheKey).Return(`{"balance":75,"version":7}`, nil).Once()
+		mockDB := new(MockDB)
+
+		handler := NewWalletHandler(mockDB, mockCache, false)
+		w := getBalance(handler, "6")
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "7", w.Header().Get(balanceVersionHeader))
+		mockDB.AssertNotCalled(t, "QueryRowContext", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("Устаревший кэш - баланс читается из БД", func(t *testing.T) {
+		mockCache := new(MockCache)
+		mockCache.On("Get", mock.Anything, cacheKey).Return(`{"balance":75,"version":7}`, nil).Once()
+		mockDB := new(MockDB)
+		mockDB.On("QueryRowContext", mock.Anything, selectWalletBalanceQuery, walletID).
+			Return(versionRow(90, 8)).Once()
+
+		handler := NewWalletHandler(mockDB, mockCache, false)
+		w := getBalance(handler, "7")
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "8", w.Header().Get(balanceVersionHeader))
+		var body Balance
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&body))
+		assert.Equal(t, "90.00", body.Balance)
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("Версия в БД не новее запрошенной - 409", func(t *testing.T) {
+		mockCache := new(MockCache)
+		mockCache.On("Get", mock.Anything, cacheKey).Return("", redis.Nil)
+		mockDB := new(MockDB)
+		mockDB.On("QueryRowContext", mock.Anything, selectWalletBalanceQuery, walletID).
+			Return(versionRow(75, 7)).Once()
+
+		handler := NewWalletHandler(mockDB, mockCache, false)
+		w := getBalance(handler, "7")
+
+		assert.Equal(t, http.StatusConflict, w.Code)
+		assert.Equal(t, "7", w.Header().Get(balanceVersionHeader))
+		assert.Contains(t, w.Body.String(), ErrBalanceVersionStale)
+	})
+
+	t.Run("Неверный заголовок - 422", func(t *testing.T) {
+		for _, value := range []string{"abc", "-1", "1.5"} {
+			mockDB := new(MockDB)
+			handler := NewWalletHandler(mockDB, new(MockCache), false)
+			w := getBalance(handler, value)
+
+			assert.Equal(t, http.StatusUnprocessableEntity, w.Code, value)
+			assert.Contains(t, w.Body.String(), ErrInvalidVersionHeader)
+			mockDB.AssertNotCalled(t, "QueryRowContext", mock.Anything, mock.Anything, mock.Anything)
+		}
+	})
+}
+
+// Тесты хранилищ балансов: один и тот же сценарий на PostgreSQL (через моки) и в памяти
+func TestStores(t *testing.T) {
+	walletID := uuid.New()
+	cacheKey := fmt.Sprintf("balance:%s", walletID)
+
+	stores := map[string]func(t *testing.T) (*MockDB, Store){
+		"PostgreSQL": func(t *testing.T) (*MockDB, Store) {
+			mockDB := new(MockDB)
+			mockTx := new(MockTx)
+			lockRow := new(MockRow)
+			lockRow.On("Scan", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+				*args.Get(0).(*float64) = 50
+			}).Return(nil).Once()
+			readRow := new(MockRow)
+			readRow.On("Scan", mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+				*args.Get(0).(*float64) = 150
+				*args.Get(2).(*int64) = 1
+			}).Return(nil).Once()
+
+			mockDB.On("BeginTx", mock.Anything).Return(mockTx, nil).Once()
+			mockTx.On("QueryRowContext", mock.Anything, selectBalanceForUpdateQuery, mock.Anything).Return(lockRow).Once()
+			mockTx.On("ExecContext", mock.Anything, updateBalanceQuery, []interface{}{150.0, walletID}).Return(new(MockResult), nil).Once()
+			mockTx.On("ExecContext", mock.Anything, insertTransactionQuery, mock.Anything).Return(new(MockResult), nil).Once()
+			mockTx.On("Commit").Return(nil).Once()
+			mockTx.On("Rollback").Return(nil).Once()
+			mockDB.On("QueryRowContext", mock.Anything, selectWalletBalanceQuery, walletID).Return(readRow).Once()
+			return mockDB, NewPostgresStore(mockDB, LockStrategyRow)
+		},
+		"Память": func(t *testing.T) (*MockDB, Store) {
+			store := NewMemoryStore()
+			store.Put(walletID, StoredWallet{Balance: 50})
+			return new(MockDB), store
+		},
+	}
+
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			mockDB, store := newStore(t)
+			mockCache := newWriteCache()
+			mockCache.On("Get", mock.Anything, cacheKey).Return("", redis.Nil)
+
+			config := DefaultConfig()
+			config.Store = store
+			handler := NewWalletHandlerWithConfig(mockDB, mockCache, false, config)
+
+			_, balance, walletErr := handler.executeOperation(context.Background(), &wallet.WalletRequest{
+				WalletID:      walletID.String(),
+				OperationType: wallet.DEPOSIT,
+				Amount:        100,
+			})
+			assert.Nil(t, walletErr)
+			assert.Equal(t, 150.0, balance)
+
+			w := httptest.NewRecorder()
+			handler.GetWalletBalance(w, httptest.NewRequest("GET", "/api/v1/wallets/"+walletID.String(), nil))
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, "1", w.Header().Get(balanceVersionHeader))
+			var body Balance
+			assert.NoError(t, json.NewDecoder(w.Body).Decode(&body))
+			assert.Equal(t, "150.00", body.Balance)
+
+			mockDB.AssertExpectations(t)
+		})
+	}
+
+	start := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	clock := newFakeClock(start)
+	newMemoryHandler := func(store *MemoryStore, policy WalletPolicy) *WalletHandler {
+		config := DefaultConfig()
+		config.Store = store
+		config.WalletPolicy = policy
+		config.Clock = clock
+		return NewWalletHandlerWithConfig(nil, newWriteCache(), false, config)
+	}
+
+	t.Run("Память: создание кошелька и запись операций", func(t *testing.T) {
+		store := NewMemoryStore()
+		handler := newMemoryHandler(store, WalletPolicyAutoCreate)
+		id := uuid.New()
+
+		for _, amount := range []float64{80, 20} {
+			_, _, walletErr := handler.executeOperation(context.Background(), &wallet.WalletRequest{
+				WalletID: id.String(), OperationType: wallet.DEPOSIT, Amount: amount, Reference: "store",
+			})
+			assert.Nil(t, walletErr)
+			clock.Advance(time.Minute)
+		}
+
+		stored, err := store.GetBalance(context.Background(), id)
+		assert.NoError(t, err)
+		assert.Equal(t, StoredWallet{Balance: 100, Version: 2}, stored)
+		assert.Equal(t, []StoredTransaction{
+			{WalletID: id, Amount: 80, OperationType: wallet.DEPOSIT, Reference: "store", CreatedAt: start},
+			{WalletID: id, Amount: 20, OperationType: wallet.DEPOSIT, Reference: "store", CreatedAt: start.Add(time.Minute)},
+		}, store.Transactions(id))
+	})
+
+	t.Run("Память: кошелек не найден и недостаточно средств", func(t *testing.T) {
+		store := NewMemoryStore()
+		handler := newMemoryHandler(store, WalletPolicyStrict)
+		id := uuid.New()
+
+		_, _, walletErr := handler.executeOperation(context.Background(), &wallet.WalletRequest{
+			WalletID: id.String(), OperationType: wallet.DEPOSIT, Amount: 10,
+		})
+		assert.Equal(t, http.StatusNotFound, walletErr.Code)
+		// Ошибка-образец распознаётся сквозь WalletError и обёртки с контекстом
+		assert.ErrorIs(t, walletErr, service.ErrWalletNotFound)
+		assert.ErrorIs(t, fmt.Errorf("операция: %w", walletErr), service.ErrWalletNotFound)
+
+		store.Put(id, StoredWallet{Balance: 5})
+		_, _, walletErr = handler.executeOperation(context.Background(), &wallet.WalletRequest{
+			WalletID: id.String(), OperationType: wallet.WITHDRAW, Amount: 10,
+		})
+		assert.Equal(t, http.StatusBadRequest, walletErr.Code)
+
+		// Откат не оставляет следов в хранилище
+		stored, _ := store.GetBalance(context.Background(), id)
+		assert.Equal(t, StoredWallet{Balance: 5}, stored)
+		assert.Empty(t, store.Transactions(id))
+	})
+
+	t.Run("Память: конфликт параллельных транзакций", func(t *testing.T) {
+		store := NewMemoryStore()
+		id := uuid.New()
+		store.Put(id, StoredWallet{Balance: 10})
+		ctx := context.Background()
+
+		first, _ := store.BeginTx(ctx)
+		second, _ := store.BeginTx(ctx)
+		_, err := first.LockWallet(ctx, id)
+		assert.NoError(t, err)
+		_, err = second.LockWallet(ctx, id)
+		assert.NoError(t, err)
+
+		assert.NoError(t, first.UpdateBalance(ctx, id, 20))
+		assert.NoError(t, first.Commit())
+		assert.NoError(t, second.UpdateBalance(ctx, id, 30))
+		assert.ErrorIs(t, second.Commit(), ErrStoreConflict)
+
+		stored, _ := store.GetBalance(ctx, id)
+		assert.Equal(t, StoredWallet{Balance: 20, Version: 1}, stored)
+	})
+}
+
+// Тесты ответа на запрещённый доступ к существующему кошельку
+func TestConcealForbiddenWallets(t *testing.T) {
+	walletID := uuid.New()
+	blockedKey := blockedWalletKey(walletID.String())
+
+	getBlockedBalance := func(conceal bool) *httptest.ResponseRecorder {
+		mockCache := new(MockCache)
+		mockCache.On("Get", mock.Anything, blockedKey).Return("1", nil).Once()
+
+		config := DefaultConfig()
+		config.BlockReads = true
+		config.ConcealForbiddenWallets = conceal
+		handler := NewWalletHandlerWithConfig(new(MockDB), mockCache, false, config)
+		w := httptest.NewRecorder()
+		handler.GetWalletBalance(w, httptest.NewRequest("GET", "/api/v1/wallets/"+walletID.String(), nil))
+		return w
+	}
+
+	t.Run("По умолчанию - 403 с причиной", func(t *testing.T) {
+		w := getBlockedBalance(false)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Contains(t, w.Body.String(), ErrWalletBlocked)
+	})
+
+	t.Run("Ответ неотличим от несуществующего кошелька", func(t *testing.T) {
+		w := getBlockedBalance(true)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.NotContains(t, w.Body.String(), ErrWalletBlocked)
+
+		// Тот же ответ, что и для кошелька, которого нет в БД
+		missingID := uuid.New()
+		mockCache := new(MockCache)
+		mockCache.On("Get", mock.Anything, fmt.Sprintf("balance:%s", missingID)).Return("", redis.Nil)
+		missingRow := new(MockRow)
+		missingRow.On("Scan", mock.Anything, mock.Anything, mock.Anything).Return(sql.ErrNoRows).Once()
+		mockDB := new(MockDB)
+		mockDB.On("QueryRowContext", mock.Anything, selectWalletBalanceQuery, missingID).Return(missingRow).Once()
+
+		handler := NewWalletHandler(mockDB, mockCache, false)
+		missing := httptest.NewRecorder()
+		handler.GetWalletBalance(missing, httptest.NewRequest("GET", "/api/v1/wallets/"+missingID.String(), nil))
+
+		assert.Equal(t, missing.Code, w.Code)
+		assert.Equal(t, missing.Body.String(), w.Body.String())
+	})
+
+	writeWalletError := func(err *WalletError) *httptest.ResponseRecorder {
+		config := DefaultConfig()
+		config.ConcealForbiddenWallets = true
+		handler := NewWalletHandlerWithConfig(new(MockDB), new(MockCache), false, config)
+		w := httptest.NewRecorder()
+		handler.writeWalletError(w, httptest.NewRequest("POST", "/api/v1/wallet", nil), err)
+		return w
+	}
+
+	t.Run("Блокировка при выполнении операции скрывается", func(t *testing.T) {
+		w := writeWalletError(&WalletError{
+			Code:    http.StatusForbidden,
+			Message: ErrWalletBlocked,
+			Err:     service.ErrWalletBlocked,
+		})
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.Contains(t, w.Body.String(), ErrWalletNotFound)
+	})
+
+	t.Run("Запрещённый тип операции не выдаётся за отсутствие кошелька", func(t *testing.T) {
+		w := writeWalletError(&WalletError{
+			Code:    http.StatusForbidden,
+			Message: ErrOperationNotAllowed,
+		})
+
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Contains(t, w.Body.String(), ErrOperationNotAllowed)
+	})
+}
+
+// benchmarkTx - транзакция-мок для бенчмарков: кошелек с балансом balance,
+// все запросы успешны и повторяемы
+func benchmarkTx(balance float64) *MockTx {
+	lockRow := new(MockRow)
+	lockRow.On("Scan", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		*args.Get(0).(*float64) = balance
+	}).Return(nil)
+
+	mockTx := new(MockTx)
+	mockTx.On("QueryRowContext", mock.Anything, selectBalanceForUpdateQuery, mock.Anything).Return(lockRow)
+	mockTx.On("ExecContext", mock.Anything, mock.Anything, mock.Anything).Return(new(MockResult), nil)
+	mockTx.On("Commit").Return(nil)
+	mockTx.On("Rollback").Return(nil)
+	return mockTx
+}
+
+// discardLogs отключает журнал обработчика на время бенчмарка
+func discardLogs(b *testing.B) {
+	log.SetOutput(io.Discard)
+	b.Cleanup(func() { log.SetOutput(os.Stderr) })
+}
+
+// Бенчмарки выполняются и в режиме проверки: go test -run '^$' -bench . -benchtime=1x ./internal/handler
+func BenchmarkHandleOperationDeposit(b *testing.B) {
+	discardLogs(b)
+	mockDB := new(MockDB)
+	mockDB.On("BeginTx", mock.Anything).Return(benchmarkTx(100), nil)
+	handler := NewWalletHandler(mockDB, new(MockCache), false)
+	req := &wallet.WalletRequest{
+		WalletID:      uuid.New().String(),
+		OperationType: wallet.DEPOSIT,
+		Amount:        10,
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := handler.handleOperation(context.Background(), req); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkHandleOperationWithdraw(b *testing.B) {
+	discardLogs(b)
+	mockDB := new(MockDB)
+	mockDB.On("BeginTx", mock.Anything).Return(benchmarkTx(math.MaxInt32), nil)
+	handler := NewWalletHandler(mockDB, new(MockCache), false)
+	req := &wallet.WalletRequest{
+		WalletID:      uuid.New().String(),
+		OperationType: wallet.WITHDRAW,
+		Amount:        10,
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := handler.handleOperation(context.Background(), req); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGetBalanceFromDB(b *testing.B) {
+	discardLogs(b)
+	walletID := uuid.New()
+	row := new(MockRow)
+	row.On("Scan", mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		*args.Get(0).(*float64) = 150
+	}).Return(nil)
+	mockDB := new(MockDB)
+	mockDB.On("QueryRowContext", mock.Anything, selectWalletBalanceQuery, walletID).Return(row)
+	handler := NewWalletHandler(mockDB, new(MockCache), false)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := handler.getBalanceFromDB(context.Background(), walletID); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// Тесты перехвата паник в обработчиках
+func TestRecoverPanics(t *testing.T) {
+	handler := NewWalletHandler(new(MockDB), new(MockCache), false)
+
+	t.Run("Паника - 500 в JSON", func(t *testing.T) {
+		panicking := handler.RecoverPanics(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var writer http.ResponseWriter
+			writer.WriteHeader(http.StatusOK)
+		}))
+
+		req := httptest.NewRequest("POST", "/api/v1/wallet", nil)
+		req.Header.Set("X-Request-ID", "req-42")
+		w := httptest.NewRecorder()
+		before := handler.RecoveredPanics()
+
+		assert.NotPanics(t, func() { panicking.ServeHTTP(w, req) })
+
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+		assert.Equal(t, "req-42", w.Header().Get("X-Request-ID"))
+		var body ErrorResponse
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&body))
+		assert.Equal(t, ErrorResponse{Error: ErrInternal, Code: "server.internal_error", RequestID: "req-42"}, body)
+		assert.Equal(t, before+1, handler.RecoveredPanics())
+	})
+
+	t.Run("Сообщение на языке клиента", func(t *testing.T) {
+		panicking := handler.RecoverPanics(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			panic("сбой")
+		}))
+		req := httptest.NewRequest("GET", "/api/v1/wallets/x", nil)
+		req.Header.Set("Accept-Language", "en")
+		w := httptest.NewRecorder()
+
+		panicking.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		assert.NotEmpty(t, w.Header().Get("X-Request-ID"))
+		assert.Contains(t, w.Body.String(), `"code":"server.internal_error"`)
+	})
+
+	t.Run("Без паники ответ не меняется", func(t *testing.T) {
+		ok := handler.RecoverPanics(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusAccepted)
+		}))
+		w := httptest.NewRecorder()
+		before := handler.RecoveredPanics()
+
+		ok.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+		assert.Equal(t, http.StatusAccepted, w.Code)
+		assert.Equal(t, before, handler.RecoveredPanics())
+	})
+
+	t.Run("http.ErrAbortHandler не перехватывается", func(t *testing.T) {
+		aborting := handler.RecoverPanics(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			panic(http.ErrAbortHandler)
+		}))
+		assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+			aborting.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+		})
+	})
+}
+
+// Тесты счётчиков выполняющихся операций и запросов
+func TestInFlight(t *testing.T) {
+	config := DefaultConfig()
+	config.AdminToken = "secret"
+
+	getInFlight := func(handler *WalletHandler) InFlight {
+		req := httptest.NewRequest("GET", "/api/v1/admin/inflight", nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		handler.HandleInFlight(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var inFlight InFlight
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&inFlight))
+		return inFlight
+	}
+
+	t.Run("Операция из очереди учитывается, пока выполняется", func(t *testing.T) {
+		opJSON, _ := json.Marshal(wallet.WalletRequest{
+			ID:            "op-1",
+			WalletID:      uuid.New().String(),
+			OperationType: wallet.DEPOSIT,
+			Amount:        10,
+		})
+		mockCache := new(MockCache)
+		expectNotBlocked(mockCache)
+		popCmd := redis.NewStringSliceCmd(context.Background())
+		popCmd.SetVal([]string{operationsQueueKey, string(opJSON)})
+		mockCache.On("BRPop", mock.Anything, config.HealthCheckInterval, priorityQueueKeys).Return(popCmd).Once()
+
+		// Транзакция не начнётся, пока тест не отпустит операцию
+		started := make(chan struct{})
+		release := make(chan struct{})
+		mockDB := new(MockDB)
+		mockDB.On("BeginTx", mock.Anything).Run(func(mock.Arguments) {
+			close(started)
+			<-release
+		}).Return((*MockTx)(nil), errors.New("отменено тестом")).Once()
+		mockCache.On("ZAdd", mock.Anything, retryQueueKey, mock.Anything, mock.Anything).Return(nil).Maybe()
+
+		handler := NewWalletHandlerWithConfig(mockDB, mockCache, false, config)
+		assert.Equal(t, InFlight{}, getInFlight(handler))
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			handler.processQueueItem(context.Background())
+		}()
+
+		<-started
+		assert.Equal(t, int64(1), getInFlight(handler).Operations)
+
+		close(release)
+		<-done
+		assert.Equal(t, int64(0), getInFlight(handler).Operations)
+	})
+
+	t.Run("Запросы и пишущие транзакции", func(t *testing.T) {
+		handler := NewWalletHandlerWithConfig(new(MockDB), new(MockCache), false, config)
+		handler.semaphore <- struct{}{}
+		handler.semaphore <- struct{}{}
+		release := handler.acquireWriteSlot()
+
+		assert.Equal(t, InFlight{Requests: 2, WriteTransactions: 1}, getInFlight(handler))
+
+		<-handler.semaphore
+		release()
+		assert.Equal(t, InFlight{Requests: 1}, getInFlight(handler))
+	})
+
+	t.Run("Без токена администратора - 403", func(t *testing.T) {
+		handler := NewWalletHandlerWithConfig(new(MockDB), new(MockCache), false, config)
+		w := httptest.NewRecorder()
+		handler.HandleInFlight(w, httptest.NewRequest("GET", "/api/v1/admin/inflight", nil))
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+}
+
+// Тесты точной суммы балансов
+func TestTotals(t *testing.T) {
+	config := DefaultConfig()
+	config.AdminToken = "secret"
+	totalsRequest := func() *http.Request {
+		req := httptest.NewRequest("GET", "/api/v1/admin/totals", nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		return req
+	}
+
+	t.Run("Сумма множества мелких балансов точная", func(t *testing.T) {
+		const wallets = 100000
+		// Те же балансы по 0.01, сложенные во float, дают погрешность
+		var floatSum float64
+		for i := 0; i < wallets; i++ {
+			floatSum += 0.01
+		}
+		assert.NotEqual(t, 1000.0, floatSum)
+
+		row := new(MockRow)
+		row.On("Scan", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+			*args.Get(0).(*int64) = wallets
+			*args.Get(1).(*int64) = wallets // по одной копейке на кошелек
+		}).Return(nil).Once()
+		mockDB := new(MockDB)
+		mockDB.On("QueryRowContext", mock.Anything, totalsQuery, nil).Return(row).Once()
+
+		handler := NewWalletHandlerWithConfig(mockDB, new(MockCache), false, config)
+		w := httptest.NewRecorder()
+		handler.HandleTotals(w, totalsRequest())
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"wallets":100000,"total":{"balance":"1000.00","balance_minor":100000}}`, w.Body.String())
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("Суммы считаются в NUMERIC и приводятся к копейкам", func(t *testing.T) {
+		for _, query := range []string{totalsQuery, balanceAtFromSnapshotQuery, balanceAtFromCurrentQuery} {
+			assert.Contains(t, query, "* 100)::BIGINT")
+		}
+	})
+
+	t.Run("Ошибка БД - 503", func(t *testing.T) {
+		row := new(MockRow)
+		row.On("Scan", mock.Anything, mock.Anything).Return(errors.New("connection reset")).Once()
+		mockDB := new(MockDB)
+		mockDB.On("QueryRowContext", mock.Anything, totalsQuery, nil).Return(row).Once()
+
+		handler := NewWalletHandlerWithConfig(mockDB, new(MockCache), false, config)
+		w := httptest.NewRecorder()
+		handler.HandleTotals(w, totalsRequest())
+
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		assert.Contains(t, w.Body.String(), ErrTotalsGet)
+	})
+
+	t.Run("Без токена администратора - 403", func(t *testing.T) {
+		handler := NewWalletHandlerWithConfig(new(MockDB), new(MockCache), false, config)
+		w := httptest.NewRecorder()
+		handler.HandleTotals(w, httptest.NewRequest("GET", "/api/v1/admin/totals", nil))
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+}
+
+// Тесты обнуления баланса администратором
+func TestWalletReset(t *testing.T) {
+	walletID := uuid.New()
+	resetAt := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+
+	newResetHandler := func(store *MemoryStore, configure func(*Config)) (*WalletHandler, *MockCache) {
+		mockCache := new(MockCache)
+		mockCache.On("Delete", mock.Anything, fmt.Sprintf("balance:%s", walletID)).Return(nil).Maybe()
+		config := DefaultConfig()
+		config.Store = store
+		config.AdminToken = "secret"
+		config.AuditSink = &memoryAuditSink{entries: make(chan audit.Entry, 1)}
+		config.Clock = newFakeClock(resetAt)
+		if configure != nil {
+			configure(&config)
+		}
+		return NewWalletHandlerWithConfig(new(MockDB), mockCache, false, config), mockCache
+	}
+
+	reset := func(handler *WalletHandler, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/v1/admin/wallets/"+walletID.String()+"/reset", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		handler.HandleWalletReset(w, req)
+		return w
+	}
+
+	t.Run("Баланс обнуляется с записью ADJUSTMENT", func(t *testing.T) {
+		store := NewMemoryStore()
+		store.Put(walletID, StoredWallet{Balance: 125.5})
+		handler, mockCache := newResetHandler(store, nil)
+
+		w := reset(handler, `{"reason": "тестовый стенд"}`)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, fmt.Sprintf(`{
+			"wallet_id": %q,
+			"previous_balance": {"balance": "125.50", "balance_minor": 12550},
+			"balance": {"balance": "0.00", "balance_minor": 0}
+		}`, walletID), w.Body.String())
+
+		stored, _ := store.GetBalance(context.Background(), walletID)
+		assert.Equal(t, 0.0, stored.Balance)
+		assert.Equal(t, []StoredTransaction{
+			{WalletID: walletID, Amount: -125.5, OperationType: wallet.ADJUSTMENT, Reference: "тестовый стенд", CreatedAt: resetAt},
+		}, store.Transactions(walletID))
+		mockCache.AssertCalled(t, "Delete", mock.Anything, fmt.Sprintf("balance:%s", walletID))
+
+		entry := <-handler.auditEntries
+		assert.Equal(t, AuditActionReset, entry.Action)
+		assert.Equal(t, adminSubject, entry.Subject)
+		assert.Equal(t, 125.5, entry.Amount)
+		assert.Equal(t, audit.ResultSuccess, entry.Result)
+	})
+
+	t.Run("Нулевой баланс - без записи ADJUSTMENT", func(t *testing.T) {
+		store := NewMemoryStore()
+		store.Put(walletID, StoredWallet{Balance: 0})
+		handler, mockCache := newResetHandler(store, nil)
+
+		w := reset(handler, `{"reason": "тестовый стенд"}`)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, store.Transactions(walletID))
+		mockCache.AssertNotCalled(t, "Delete", mock.Anything, mock.Anything)
+
+		entry := <-handler.auditEntries
+		assert.Equal(t, audit.ResultSuccess, entry.Result)
+		assert.Equal(t, 0.0, entry.Amount)
+	})
+
+	t.Run("В production обнуление запрещено", func(t *testing.T) {
+		store := NewMemoryStore()
+		store.Put(walletID, StoredWallet{Balance: 10})
+		handler, _ := newResetHandler(store, func(config *Config) { config.Production = true })
+
+		w := reset(handler, `{"reason": "исправление"}`)
+
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Contains(t, w.Body.String(), ErrResetDisabled)
+		stored, _ := store.GetBalance(context.Background(), walletID)
+		assert.Equal(t, 10.0, stored.Balance)
+		assert.Empty(t, store.Transactions(walletID))
+	})
+
+	t.Run("В production с AllowBalanceReset", func(t *testing.T) {
+		store := NewMemoryStore()
+		store.Put(walletID, StoredWallet{Balance: 10})
+		handler, _ := newResetHandler(store, func(config *Config) {
+			config.Production = true
+			config.AllowBalanceReset = true
+		})
+
+		w := reset(handler, `{"reason": "исправление"}`)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		stored, _ := store.GetBalance(context.Background(), walletID)
+		assert.Equal(t, 0.0, stored.Balance)
+	})
+
+	t.Run("Без причины - 422", func(t *testing.T) {
+		store := NewMemoryStore()
+		store.Put(walletID, StoredWallet{Balance: 10})
+		handler, _ := newResetHandler(store, nil)
+
+		w := reset(handler, `{"reason": "  "}`)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		assert.Contains(t, w.Body.String(), ErrResetReasonRequired)
+		assert.Empty(t, store.Transactions(walletID))
+	})
+
+	t.Run("Несуществующий кошелек - 404", func(t *testing.T) {
+		handler, _ := newResetHandler(NewMemoryStore(), nil)
+		w := reset(handler, `{"reason": "исправление"}`)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+
+		entry := <-handler.auditEntries
+		assert.Equal(t, audit.ResultFailure, entry.Result)
+	})
+
+	t.Run("Без токена администратора - 403", func(t *testing.T) {
+		handler, _ := newResetHandler(NewMemoryStore(), nil)
+		w := httptest.NewRecorder()
+		handler.HandleWalletReset(w, httptest.NewRequest("POST", "/api/v1/admin/wallets/"+walletID.String()+"/reset", nil))
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Contains(t, w.Body.String(), ErrForbidden)
+	})
+}
+
+// Тесты архивации истории операций
+func TestArchive(t *testing.T) {
+	cutoff := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// expectBatch ожидает пакет переноса, возвращающий moved операций
+	expectBatch := func(mockTx *MockTx, batchSize int, moved int64, err error) *mock.Call {
+		row := new(MockRow)
+		row.On("Scan", mock.Anything).Run(func(args mock.Arguments) {
+			*args.Get(0).(*int64) = moved
+		}).Return(err).Once()
+		return mockTx.On("QueryRowContext", mock.Anything, archiveTransactionsQuery, []interface{}{cutoff, batchSize}).
+			Return(row).Once()
+	}
+
+	newArchiveHandler := func(mockDB *MockDB, batchSize int) *WalletHandler {
+		config := DefaultConfig()
+		config.ArchiveBatchSize = batchSize
+		return NewWalletHandlerWithConfig(mockDB, new(MockCache), false, config)
+	}
+
+	t.Run("Операции переносятся пакетами после снимка баланса", func(t *testing.T) {
+		mockDB := new(MockDB)
+		mockTx := new(MockTx)
+		// Снимок и три пакета - каждый в своей транзакции
+		mockDB.On("BeginTx", mock.Anything).Return(mockTx, nil).Times(4)
+		snapshot := mockTx.On("ExecContext", mock.Anything, archiveSnapshotQuery, []interface{}{cutoff}).
+			Return(&MockResult{}, nil).Once()
+		expectBatch(mockTx, 2, 2, nil).NotBefore(snapshot)
+		expectBatch(mockTx, 2, 1, nil)
+		// Компенсация ушла в прошлом пакете - отменённая операция переносится следом
+		expectBatch(mockTx, 2, 0, nil)
+		mockTx.On("Commit").Return(nil).Times(4)
+		mockTx.On("Rollback").Return(nil).Maybe()
+
+		archived, err := newArchiveHandler(mockDB, 2).archiveTransactions(context.Background(), cutoff)
+
+		assert.NoError(t, err)
+		assert.Equal(t, int64(3), archived)
+		mockDB.AssertExpectations(t)
+		mockTx.AssertExpectations(t)
+	})
+
+	t.Run("Ошибка пакета не отменяет перенесённые раньше", func(t *testing.T) {
+		mockDB := new(MockDB)
+		mockTx := new(MockTx)
+		mockDB.On("BeginTx", mock.Anything).Return(mockTx, nil).Times(3)
+		mockTx.On("ExecContext", mock.Anything, archiveSnapshotQuery, mock.Anything).Return(&MockResult{}, nil).Once()
+		expectBatch(mockTx, 2, 2, nil)
+		expectBatch(mockTx, 2, 0, errors.New("canceling statement due to statement timeout"))
+		mockTx.On("Commit").Return(nil).Twice()
+		mockTx.On("Rollback").Return(nil)
+
+		archived, err := newArchiveHandler(mockDB, 2).archiveTransactions(context.Background(), cutoff)
+
+		assert.ErrorContains(t, err, ErrHistoryArchive)
+		assert.Equal(t, int64(2), archived)
+		mockTx.AssertExpectations(t)
+	})
+
+	t.Run("Пакеты ограничены по размеру", func(t *testing.T) {
+		assert.Contains(t, archiveTransactionsQuery, "LIMIT $2")
+	})
+
+	t.Run("В режиме обслуживания архивация и снимки пропускаются", func(t *testing.T) {
+		mockDB := new(MockDB)
+		config := DefaultConfig()
+		config.MaintenanceMode = true
+		config.HistoryRetention = time.Hour
+		config.ArchiveInterval = 5 * time.Millisecond
+		config.SnapshotInterval = 5 * time.Millisecond
+		handler := NewWalletHandlerWithConfig(mockDB, new(MockCache), false, config)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() { defer wg.Done(); handler.RunArchival(ctx) }()
+		go func() { defer wg.Done(); handler.RunSnapshots(ctx) }()
+		wg.Wait()
+
+		mockDB.AssertNotCalled(t, "BeginTx", mock.Anything)
+	})
+
+	t.Run("Баланс на момент времени учитывает архив", func(t *testing.T) {
+		assert.Contains(t, balanceAtFromSnapshotQuery, "FROM transactions_all")
+		assert.Contains(t, balanceAtFromCurrentQuery, "FROM transactions_all")
+		assert.Contains(t, archiveSnapshotQuery, "FROM transactions_all")
+		assert.Contains(t, archiveTransactionsQuery, "INSERT INTO ledger_rollups")
+	})
+
+	t.Run("История с архивными операциями", func(t *testing.T) {
+		for _, tc := range []struct {
+			query string
+			sql   string
+		}{
+			{"?archived=true", archivedHistoryQuery},
+			{"?archived=true&audit=true", archivedAuditHistoryQuery},
+		} {
+			mockDB := new(MockDB)
+			walletID := uuid.New()
+			createdAt := cutoff.Add(-time.Hour)
+			rows := &MockRows{rows: [][]interface{}{
+				{uuid.New().String(), walletID.String(), 50.0, wallet.DEPOSIT, "", createdAt, (*time.Time)(nil), (*string)(nil)},
+			}}
+			mockDB.On("QueryContext", mock.Anything, tc.sql, []interface{}{walletID, historyLimit + 1, (*time.Time)(nil), (*string)(nil)}).
+				Return(rows, nil).Once()
+
+			handler := NewWalletHandler(mockDB, new(MockCache), false)
+			req := httptest.NewRequest("GET", "/api/v1/wallets/"+walletID.String()+"/transactions"+tc.query, nil)
+			w := httptest.NewRecorder()
+
+			handler.GetTransactionHistory(w, req)
+
+			assert.Equal(t, http.StatusOK, w.Code, tc.query)
+			var page Page[wallet.Transaction]
+			assert.NoError(t, json.NewDecoder(w.Body).Decode(&page))
+			assert.Len(t, page.Items, 1)
+			mockDB.AssertExpectations(t)
+		}
+	})
+}
+
+// wsTestConn - клиент WebSocket для тестов
+type wsTestConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func dialWalletEvents(t *testing.T, serverURL string, walletID uuid.UUID) *wsTestConn {
+	t.Helper()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(serverURL, "http://"))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	key := base64.StdEncoding.EncodeToString([]byte("0123456789abcdef"))
+	fmt.Fprintf(conn, "GET /api/v1/wallets/%s/ws HTTP/1.1\r\nHost: localhost\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Key: %s\r\nSec-WebSocket-Version: 13\r\n\r\n", walletID, key)
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+	require.Equal(t, websocketAccept(key), resp.Header.Get("Sec-WebSocket-Accept"))
+	return &wsTestConn{Conn: conn, reader: reader}
+}
+
+// writeFrame отправляет маскированный кадр, как того требует RFC 6455 от клиента
+func (c *wsTestConn) writeFrame(t *testing.T, opcode byte, payload []byte) {
+	mask := []byte{1, 2, 3, 4}
+	frame := []byte{0x80 | opcode, 0x80 | byte(len(payload))}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	_, err := c.Write(frame)
+	require.NoError(t, err)
+}
+
+func (c *wsTestConn) readFrame(t *testing.T) (byte, []byte) {
+	c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var header [2]byte
+	_, err := io.ReadFull(c.reader, header[:])
+	require.NoError(t, err)
+	length := int(header[1] & 0x7F)
+	if length == 126 {
+		var ext [2]byte
+		_, err := io.ReadFull(c.reader, ext[:])
+		require.NoError(t, err)
+		length = int(ext[0])<<8 | int(ext[1])
+	}
+	payload := make([]byte, length)
+	_, err = io.ReadFull(c.reader, payload)
+	require.NoError(t, err)
+	return header[0] & 0x0F, payload
+}
+
+// Тесты подписки на события кошелька по WebSocket
+func TestWalletEvents(t *testing.T) {
+	newEventsServer := func(t *testing.T) (*WalletHandler, *MemoryStore, string) {
+		store := NewMemoryStore()
+		config := DefaultConfig()
+		config.Store = store
+		handler := NewWalletHandlerWithConfig(new(MockDB), newWriteCache(), false, config)
+		mux := http.NewServeMux()
+		mux.HandleFunc("/api/v1/wallets/{uuid}/ws", handler.HandleWalletEvents)
+		server := httptest.NewServer(mux)
+		t.Cleanup(server.Close)
+		return handler, store, server.URL
+	}
+
+	t.Run("Событие после пополнения", func(t *testing.T) {
+		handler, store, url := newEventsServer(t)
+		walletID := uuid.New()
+		store.Put(walletID, StoredWallet{Balance: 10})
+		conn := dialWalletEvents(t, url, walletID)
+
+		_, _, walletErr := handler.executeOperation(context.Background(), &wallet.WalletRequest{
+			WalletID:      walletID.String(),
+			OperationType: wallet.DEPOSIT,
+			Amount:        5.25,
+		})
+		require.Nil(t, walletErr)
+
+		opcode, payload := conn.readFrame(t)
+		assert.Equal(t, byte(wsOpText), opcode)
+		var event WalletEvent
+		require.NoError(t, json.Unmarshal(payload, &event))
+		assert.Equal(t, EventBalance, event.Type)
+		assert.Equal(t, walletID.String(), event.WalletID)
+		assert.Equal(t, newBalance(15.25), *event.Balance)
+	})
+
+	t.Run("Итог операции из очереди и события других кошельков", func(t *testing.T) {
+		handler, _, url := newEventsServer(t)
+		walletID := uuid.New()
+		conn := dialWalletEvents(t, url, walletID)
+
+		handler.publishEvent(operationEvent(wallet.WalletRequest{ID: "other", WalletID: uuid.New().String()}, wallet.OperationCompleted))
+		handler.publishEvent(operationEvent(wallet.WalletRequest{ID: "op-1", WalletID: walletID.String()}, wallet.OperationFailed))
+
+		_, payload := conn.readFrame(t)
+		var event WalletEvent
+		require.NoError(t, json.Unmarshal(payload, &event))
+		assert.Equal(t, EventOperation, event.Type)
+		assert.Equal(t, "op-1", event.ID)
+		assert.Equal(t, wallet.OperationFailed, event.Status)
+	})
+
+	t.Run("Ping и закрытие клиентом", func(t *testing.T) {
+		_, _, url := newEventsServer(t)
+		conn := dialWalletEvents(t, url, uuid.New())
+
+		conn.writeFrame(t, wsOpPing, []byte("hello"))
+		opcode, payload := conn.readFrame(t)
+		assert.Equal(t, byte(wsOpPong), opcode)
+		assert.Equal(t, "hello", string(payload))
+
+		conn.writeFrame(t, wsOpClose, []byte{0x03, 0xE8})
+		opcode, payload = conn.readFrame(t)
+		assert.Equal(t, byte(wsOpClose), opcode)
+		assert.Equal(t, []byte{0x03, 0xE8}, payload)
+
+		// После обмена close сервер закрывает соединение
+		_, err := conn.reader.ReadByte()
+		assert.Error(t, err)
+	})
+
+	t.Run("Publish не ждёт медленного подписчика", func(t *testing.T) {
+		events := NewLocalEvents()
+		walletID := uuid.New().String()
+		ch, unsubscribe, err := events.Subscribe(context.Background(), walletID)
+		require.NoError(t, err)
+		defer unsubscribe()
+
+		for i := 0; i < subscriberBuffer+10; i++ {
+			events.Publish(context.Background(), WalletEvent{WalletID: walletID})
+		}
+		// Publish не блокируется: лишние события отброшены
+		assert.Equal(t, cap(ch), len(ch))
+	})
+
+	t.Run("Запрос без Upgrade - 426", func(t *testing.T) {
+		handler := NewWalletHandler(new(MockDB), new(MockCache), false)
+		w := httptest.NewRecorder()
+		handler.HandleWalletEvents(w, httptest.NewRequest("GET", "/api/v1/wallets/"+uuid.New().String()+"/ws", nil))
+
+		assert.Equal(t, http.StatusUpgradeRequired, w.Code)
+		assert.Equal(t, "websocket", w.Header().Get("Upgrade"))
+		assert.Contains(t, w.Body.String(), ErrWebSocketUpgrade)
+	})
+
+	t.Run("Соединение без перехвата - 426", func(t *testing.T) {
+		handler := NewWalletHandler(new(MockDB), new(MockCache), false)
+		req := httptest.NewRequest("GET", "/api/v1/wallets/"+uuid.New().String()+"/ws", nil)
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Upgrade", "websocket")
+		req.Header.Set("Sec-WebSocket-Version", "13")
+		req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+		// ResponseRecorder, как и HTTP/2, не поддерживает Hijack
+		w := httptest.NewRecorder()
+		handler.HandleWalletEvents(w, req)
+
+		assert.Equal(t, http.StatusUpgradeRequired, w.Code)
+		assert.Contains(t, w.Body.String(), ErrWebSocketHijack)
+	})
+}
+
+// Тесты доверенных внутренних вызовов
+func TestTrustedCaller(t *testing.T) {
+	walletID := uuid.New()
+
+	newTrustedHandler := func(store *MemoryStore, validator *MockValidator) *WalletHandler {
+		mockCache := newWriteCache()
+		mockCache.On("Get", mock.Anything, blockedWalletKey(walletID.String())).Return("", redis.Nil)
+		config := DefaultConfig()
+		config.Store = store
+		config.Validator = validator
+		config.TrustedCallerKey = "batch-key"
+		config.AuditSink = &memoryAuditSink{entries: make(chan audit.Entry, 1)}
+		return NewWalletHandlerWithConfig(new(MockDB), mockCache, true, config)
+	}
+
+	operation := func(handler *WalletHandler, key string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/v1/wallet", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if key != "" {
+			req.Header.Set(trustedCallerHeader, key)
+		}
+		w := httptest.NewRecorder()
+		handler.HandleWalletOperation(w, req)
+		return w
+	}
+
+	t.Run("Доверенный вызов не проходит без средств", func(t *testing.T) {
+		store := NewMemoryStore()
+		store.Put(walletID, StoredWallet{Balance: 10})
+		validator := new(MockValidator)
+		validator.On("ValidateBalance", 10.0, 50.0).Return(service.ErrInsufficientFunds).Once()
+		handler := newTrustedHandler(store, validator)
+
+		w := operation(handler, "batch-key", fmt.Sprintf(`{"wallet_id": %q, "operation_type": "WITHDRAW", "amount": 50}`, walletID))
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), service.ErrInsufficientFunds.Error())
+		stored, _ := store.GetBalance(context.Background(), walletID)
+		assert.Equal(t, 10.0, stored.Balance)
+		assert.Empty(t, store.Transactions(walletID))
+		validator.AssertNotCalled(t, "ValidateWalletRequest", mock.Anything)
+		validator.AssertNotCalled(t, "ValidateAmount", mock.Anything)
+		validator.AssertExpectations(t)
+
+		entry := <-handler.auditEntries
+		assert.Equal(t, AuditActionTrustedOperation, entry.Action)
+		assert.Equal(t, trustedSubject, entry.Subject)
+		assert.Equal(t, audit.ResultFailure, entry.Result)
+	})
+
+	t.Run("Доверенный вызов пропускает валидацию запроса", func(t *testing.T) {
+		store := NewMemoryStore()
+		store.Put(walletID, StoredWallet{Balance: 10})
+		validator := new(MockValidator)
+		handler := newTrustedHandler(store, validator)
+
+		w := operation(handler, "batch-key", fmt.Sprintf(`{"wallet_id": %q, "operation_type": "DEPOSIT", "amount": 5}`, walletID))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		stored, _ := store.GetBalance(context.Background(), walletID)
+		assert.Equal(t, 15.0, stored.Balance)
+		validator.AssertNotCalled(t, "ValidateWalletRequest", mock.Anything)
+
+		entry := <-handler.auditEntries
+		assert.Equal(t, AuditActionTrustedOperation, entry.Action)
+		assert.Equal(t, audit.ResultSuccess, entry.Result)
+	})
+
+	t.Run("Доверенный вызов: неположительная сумма и неизвестный тип", func(t *testing.T) {
+		for _, body := range []string{
+			// Списание -50 с баланса 10 иначе увеличило бы баланс до 60
+			fmt.Sprintf(`{"wallet_id": %q, "operation_type": "WITHDRAW", "amount": -50}`, walletID),
+			// Зачисление -5 иначе уменьшило бы баланс без проверки средств
+			fmt.Sprintf(`{"wallet_id": %q, "operation_type": "DEPOSIT", "amount": -5}`, walletID),
+			fmt.Sprintf(`{"wallet_id": %q, "operation_type": "DEPOSIT", "amount": 0}`, walletID),
+			fmt.Sprintf(`{"wallet_id": %q, "operation_type": "TRANSFER", "amount": 5}`, walletID),
+		} {
+			store := NewMemoryStore()
+			store.Put(walletID, StoredWallet{Balance: 10})
+			validator := new(MockValidator)
+			handler := newTrustedHandler(store, validator)
+
+			w := operation(handler, "batch-key", body)
+
+			assert.Equal(t, http.StatusUnprocessableEntity, w.Code, body)
+			stored, _ := store.GetBalance(context.Background(), walletID)
+			assert.Equal(t, 10.0, stored.Balance, body)
+			assert.Empty(t, store.Transactions(walletID), body)
+			validator.AssertNotCalled(t, "ValidateBalance", mock.Anything, mock.Anything)
+		}
+	})
+
+	t.Run("Доверенная операция из очереди с отрицательной суммой", func(t *testing.T) {
+		for _, opType := range []wallet.OperationType{wallet.WITHDRAW, wallet.DEPOSIT} {
+			store := NewMemoryStore()
+			store.Put(walletID, StoredWallet{Balance: 10})
+			validator := new(MockValidator)
+			handler := newTrustedHandler(store, validator)
+
+			_, _, walletErr := handler.executeOperation(context.Background(), &wallet.WalletRequest{
+				WalletID: walletID.String(), OperationType: opType, Amount: -50, Trusted: true,
+			})
+
+			require.NotNil(t, walletErr)
+			assert.Equal(t, http.StatusBadRequest, walletErr.Code)
+			assert.ErrorIs(t, walletErr, service.ErrNegativeAmount)
+			stored, _ := store.GetBalance(context.Background(), walletID)
+			assert.Equal(t, 10.0, stored.Balance)
+			assert.Empty(t, store.Transactions(walletID))
+		}
+	})
+
+	t.Run("Неверный ключ - обычная валидация", func(t *testing.T) {
+		store := NewMemoryStore()
+		validator := new(MockValidator)
+		handler := newTrustedHandler(store, validator)
+
+		for _, body := range []string{
+			fmt.Sprintf(`{"wallet_id": %q, "operation_type": "DEPOSIT", "amount": -5}`, walletID),
+			// Признак доверенного вызова из тела запроса не принимается
+			fmt.Sprintf(`{"wallet_id": %q, "operation_type": "DEPOSIT", "amount": -5, "trusted": true}`, walletID),
+		} {
+			validator.On("ValidateWalletRequest", mock.Anything).Return(service.ErrInvalidAmount).Once()
+			w := operation(handler, "wrong-key", body)
+			assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		}
+		assert.Empty(t, store.Transactions(walletID))
+	})
+
+	t.Run("Без TrustedCallerKey доверенных вызовов нет", func(t *testing.T) {
+		handler := NewWalletHandler(new(MockDB), new(MockCache), false)
+		req := httptest.NewRequest("POST", "/api/v1/wallet", nil)
+		req.Header.Set(trustedCallerHeader, "")
+		assert.False(t, handler.isTrustedCaller(req))
+	})
+}
+
+// Тесты источника времени: время берётся из Clock, а не из системных часов или NOW()
+func TestClock(t *testing.T) {
+	now := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+
+	newClockHandler := func(mockDB *MockDB, mockCache *MockCache, clock Clock, configure func(*Config)) *WalletHandler {
+		config := DefaultConfig()
+		config.Clock = clock
+		if configure != nil {
+			configure(&config)
+		}
+		return NewWalletHandlerWithConfig(mockDB, mockCache, false, config)
+	}
+
+	t.Run("Время повтора операции", func(t *testing.T) {
+		mockCache := new(MockCache)
+		mockCache.On("ZAdd", mock.Anything, retryQueueKey, float64(now.Add(2*time.Second).UnixMilli()), mock.Anything).
+			Return(nil).Once()
+		handler := newClockHandler(new(MockDB), mockCache, newFakeClock(now), func(config *Config) {
+			config.RetryBaseDelay = time.Second
+		})
+
+		scheduled, err := handler.scheduleRetry(context.Background(), wallet.WalletRequest{Attempts: 1})
+
+		assert.NoError(t, err)
+		assert.True(t, scheduled)
+		mockCache.AssertExpectations(t)
+	})
+
+	t.Run("Время снимка балансов", func(t *testing.T) {
+		mockDB := new(MockDB)
+		mockTx := new(MockTx)
+		mockDB.On("BeginTx", mock.Anything).Return(mockTx, nil).Once()
+		mockTx.On("ExecContext", mock.Anything, createSnapshotsQuery, []interface{}{now}).Return(&MockResult{}, nil).Once()
+		mockTx.On("Commit").Return(nil).Once()
+		mockTx.On("Rollback").Return(nil).Maybe()
+
+		assert.NoError(t, newClockHandler(mockDB, new(MockCache), newFakeClock(now), nil).createSnapshots(context.Background()))
+		mockTx.AssertExpectations(t)
+	})
+
+	t.Run("Устаревание баланса в кэше", func(t *testing.T) {
+		clock := newFakeClock(now)
+		handler := newClockHandler(new(MockDB), new(MockCache), clock, func(config *Config) {
+			config.BalanceSoftTTL = time.Minute
+		})
+		value := handler.cachedBalanceValue(walletBalance{amount: 10, version: 1})
+
+		_, stale, err := parseCachedBalance(value, clock.Now())
+		assert.NoError(t, err)
+		assert.False(t, stale)
+
+		clock.Advance(time.Minute + time.Millisecond)
+		_, stale, err = parseCachedBalance(value, clock.Now())
+		assert.NoError(t, err)
+		assert.True(t, stale)
+	})
+
+	t.Run("Время записи аудита и события", func(t *testing.T) {
+		handler := newClockHandler(new(MockDB), new(MockCache), newFakeClock(now), func(config *Config) {
+			config.AuditSink = &memoryAuditSink{entries: make(chan audit.Entry, 1)}
+		})
+		walletID := uuid.New().String()
+		events, unsubscribe, err := handler.events.Subscribe(context.Background(), walletID)
+		assert.NoError(t, err)
+		defer unsubscribe()
+
+		handler.recordAudit(audit.Entry{Action: AuditActionOperation})
+		handler.publishEvent(balanceEvent(walletID, 1))
+
+		assert.Equal(t, now, (<-handler.auditEntries).Time)
+		assert.Equal(t, now, (<-events).Time)
+	})
+}
+
+func TestBalanceBeforeAfter(t *testing.T) {
+	walletID := uuid.New()
+
+	newStoreHandler := func(store *MemoryStore) *WalletHandler {
+		mockCache := newWriteCache()
+		mockCache.On("Get", mock.Anything, blockedWalletKey(walletID.String())).Return("", redis.Nil)
+		config := DefaultConfig()
+		config.Store = store
+		return NewWalletHandlerWithConfig(new(MockDB), mockCache, true, config)
+	}
+
+	t.Run("Ответ debug-режима содержит баланс до и после пополнения", func(t *testing.T) {
+		store := NewMemoryStore()
+		store.Put(walletID, StoredWallet{Balance: 10})
+		handler := newStoreHandler(store)
+
+		body := fmt.Sprintf(`{"wallet_id": %q, "operation_type": "DEPOSIT", "amount": 5}`, walletID)
+		req := httptest.NewRequest("POST", "/api/v1/wallet", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		handler.HandleWalletOperation(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var response struct {
+			BalanceBefore Balance `json:"balance_before"`
+			BalanceAfter  Balance `json:"balance_after"`
+		}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, newBalance(10), response.BalanceBefore)
+		assert.Equal(t, newBalance(15), response.BalanceAfter)
+	})
+
+	t.Run("Итог операции из очереди содержит баланс до и после", func(t *testing.T) {
+		store := NewMemoryStore()
+		store.Put(walletID, StoredWallet{Balance: 10})
+		handler := newStoreHandler(store)
+
+		result, err := handler.ProcessQueueOperation(wallet.WalletRequest{
+			ID:            "op-1",
+			WalletID:      walletID.String(),
+			OperationType: wallet.DEPOSIT,
+			Amount:        5,
+		})
+
+		assert.NoError(t, err)
+		assert.Equal(t, 10.0, result.BalanceBefore)
+		assert.Equal(t, 15.0, result.BalanceAfter)
+		assert.Equal(t, wallet.OperationCompleted, result.Status)
+	})
+}
+
+func TestQueueFallback(t *testing.T) {
+	walletID := uuid.New()
+
+	dialErr := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+
+	newQueueHandler := func(store *MemoryStore, fallback bool, pushErr error) (*WalletHandler, *MockCache) {
+		mockCache := newWriteCache()
+		mockCache.On("Get", mock.Anything, blockedWalletKey(walletID.String())).Return("", redis.Nil)
+		mockCache.On("LPush", mock.Anything, operationsQueueKey, mock.Anything).Run(func(args mock.Arguments) {
+			_, hasDeadline := args.Get(0).(context.Context).Deadline()
+			assert.True(t, hasDeadline, "постановка в очередь должна быть ограничена по времени")
+		}).Return(redis.NewIntResult(0, pushErr)).Once()
+		config := DefaultConfig()
+		config.Store = store
+		config.QueueFallback = fallback
+		return NewWalletHandlerWithConfig(new(MockDB), mockCache, false, config), mockCache
+	}
+
+	deposit := func(handler *WalletHandler) *httptest.ResponseRecorder {
+		body := fmt.Sprintf(`{"wallet_id": %q, "operation_type": "DEPOSIT", "amount": 5}`, walletID)
+		req := httptest.NewRequest("POST", "/api/v1/wallet", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		handler.HandleWalletOperation(w, req)
+		return w
+	}
+
+	t.Run("Без QueueFallback ошибка очереди возвращает 500", func(t *testing.T) {
+		store := NewMemoryStore()
+		store.Put(walletID, StoredWallet{Balance: 10})
+		handler, mockCache := newQueueHandler(store, false, dialErr)
+
+		w := deposit(handler)
+
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		stored, _ := store.GetBalance(context.Background(), walletID)
+		assert.Equal(t, 10.0, stored.Balance)
+		mockCache.AssertExpectations(t)
+	})
+
+	t.Run("С QueueFallback операция выполняется синхронно", func(t *testing.T) {
+		store := NewMemoryStore()
+		store.Put(walletID, StoredWallet{Balance: 10})
+		handler, mockCache := newQueueHandler(store, true, dialErr)
+
+		w := deposit(handler)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var response struct {
+			BalanceAfter Balance `json:"balance_after"`
+		}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, newBalance(15), response.BalanceAfter)
+		stored, _ := store.GetBalance(context.Background(), walletID)
+		assert.Equal(t, 15.0, stored.Balance)
+		assert.Len(t, store.Transactions(walletID), 1)
+		mockCache.AssertExpectations(t)
+	})
+
+	t.Run("Обрыв после отправки команды не выполняет операцию синхронно", func(t *testing.T) {
+		for _, pushErr := range []error{
+			&net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset by peer")},
+			context.DeadlineExceeded,
+		} {
+			store := NewMemoryStore()
+			store.Put(walletID, StoredWallet{Balance: 10})
+			handler, mockCache := newQueueHandler(store, true, pushErr)
+
+			w := deposit(handler)
+
+			// Redis мог сохранить операцию: повтор синхронно применил бы её дважды
+			assert.Equal(t, http.StatusInternalServerError, w.Code)
+			stored, _ := store.GetBalance(context.Background(), walletID)
+			assert.Equal(t, 10.0, stored.Balance)
+			assert.Empty(t, store.Transactions(walletID))
+			mockCache.AssertExpectations(t)
+		}
+	})
+}
+
+func TestOperationTracing(t *testing.T) {
+	walletID := uuid.New()
+	store := NewMemoryStore()
+	store.Put(walletID, StoredWallet{Balance: 10})
+
+	var queued string
+	mockCache := newWriteCache()
+	mockCache.On("Get", mock.Anything, blockedWalletKey(walletID.String())).Return("", redis.Nil)
+	mockCache.On("LPush", mock.Anything, operationsQueueKey, mock.Anything).Run(func(args mock.Arguments) {
+		queued = string(args.Get(2).([]interface{})[0].([]byte))
+	}).Return(redis.NewIntResult(1, nil)).Once()
+
+	config := DefaultConfig()
+	config.Store = store
+	handler := NewWalletHandlerWithConfig(new(MockDB), mockCache, false, config)
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	body := fmt.Sprintf(`{"wallet_id": %q, "operation_type": "DEPOSIT", "amount": 5}`, walletID)
+	req := httptest.NewRequest("POST", "/api/v1/wallet", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Request-ID", "trace-123")
+	w := httptest.NewRecorder()
+	handler.HandleWalletOperation(w, req)
+
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, "trace-123", w.Header().Get("X-Request-ID"))
+	var message wallet.WalletRequest
+	assert.NoError(t, json.Unmarshal([]byte(queued), &message))
+	assert.Equal(t, "trace-123", message.TraceID)
+	assert.Contains(t, logs.String(), "[trace trace-123] Операция "+message.ID+" поставлена в очередь")
+
+	// Обработчик очереди получает то же сообщение и пишет в лог тот же идентификатор
+	logs.Reset()
+	mockCache.On("BRPop", mock.Anything, config.HealthCheckInterval, priorityQueueKeys).
+		Return(redis.NewStringSliceResult([]string{operationsQueueKey, queued}, nil)).Once()
+	handler.processQueueItem(context.Background())
+
+	assert.Contains(t, logs.String(), "[trace trace-123] Обработка операции "+message.ID)
+	assert.Contains(t, logs.String(), "[trace trace-123] Операция "+message.ID+" над кошельком")
+	stored, _ := store.GetBalance(context.Background(), walletID)
+	assert.Equal(t, 15.0, stored.Balance)
+	mockCache.AssertExpectations(t)
+}
+
+func TestSettingsReload(t *testing.T) {
+	config := DefaultConfig()
+	config.AdminToken = "secret"
+	config.RateLimit = 1
+	config.RateBurst = 1
+
+	settingsRequest := func(handler *WalletHandler, method, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/v1/admin/config", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		handler.HandleSettings(w, req)
+		return w
+	}
+
+	t.Run("Лимит запросов меняется без перезапуска", func(t *testing.T) {
+		handler := NewWalletHandlerWithConfig(new(MockDB), new(MockCache), false, config)
+
+		_, ok := handler.reserveRateLimit(newJSONRequest(nil))
+		assert.True(t, ok)
+		_, ok = handler.reserveRateLimit(newJSONRequest(nil))
+		assert.False(t, ok)
+
+		w := settingsRequest(handler, http.MethodPost, `{"rate_limit": 1000, "rate_burst": 5}`)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var response settingsResponse
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, 1000.0, response.RateLimit)
+		assert.Equal(t, 5, response.RateBurst)
+		// Не переданные поля сохраняют прежние значения
+		assert.Equal(t, config.MaxWriteTransactions, response.MaxWriteTransactions)
+
+		// За 10 мс при новом лимите накапливается весь всплеск
+		time.Sleep(10 * time.Millisecond)
+		for range 5 {
+			_, ok = handler.reserveRateLimit(newJSONRequest(nil))
+			assert.True(t, ok)
+		}
+	})
+
+	t.Run("Размер слотов записи и TTL", func(t *testing.T) {
+		handler := NewWalletHandlerWithConfig(new(MockDB), new(MockCache), false, config)
+		release, ok := handler.tryAcquireWriteSlot()
+		assert.True(t, ok)
+
+		w := settingsRequest(handler, http.MethodPost,
+			`{"max_write_transactions": 1, "balance_soft_ttl": "10s", "operation_dedup_window": "1m"}`)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, 10*time.Second, handler.settings().BalanceSoftTTL)
+		assert.Equal(t, time.Minute, handler.settings().OperationDedupWindow)
+
+		// Новый набор слотов: занятый ранее слот освобождается в прежний
+		newRelease, ok := handler.tryAcquireWriteSlot()
+		assert.True(t, ok)
+		_, ok = handler.tryAcquireWriteSlot()
+		assert.False(t, ok)
+		release()
+		newRelease()
+		assert.Equal(t, 0, handler.writeTransactions())
+	})
+
+	t.Run("Неверное значение - 422, настройки не меняются", func(t *testing.T) {
+		handler := NewWalletHandlerWithConfig(new(MockDB), new(MockCache), false, config)
+
+		w := settingsRequest(handler, http.MethodPost, `{"rate_limit": 50, "balance_soft_ttl": "-1s"}`)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		assert.Contains(t, w.Body.String(), ErrInvalidSetting)
+		assert.Equal(t, settingsFromConfig(config), handler.settings())
+	})
+
+	t.Run("Одновременные частичные изменения не теряются", func(t *testing.T) {
+		for range 50 {
+			handler := NewWalletHandlerWithConfig(new(MockDB), new(MockCache), false, config)
+
+			var wg sync.WaitGroup
+			for _, body := range []string{`{"rate_burst": 7}`, `{"balance_soft_ttl": "10s"}`} {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					settingsRequest(handler, http.MethodPost, body)
+				}()
+			}
+			wg.Wait()
+
+			assert.Equal(t, 7, handler.settings().RateBurst)
+			assert.Equal(t, 10*time.Second, handler.settings().BalanceSoftTTL)
+		}
+	})
+
+	t.Run("Без токена администратора - 403", func(t *testing.T) {
+		handler := NewWalletHandlerWithConfig(new(MockDB), new(MockCache), false, config)
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/admin/config", strings.NewReader(`{"rate_limit": 1000}`))
+		w := httptest.NewRecorder()
+		handler.HandleSettings(w, req)
+
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Equal(t, 1.0, handler.settings().RateLimit)
+	})
+}
+
+func TestLogRedaction(t *testing.T) {
+	walletID := uuid.New()
+
+	processDeposit := func(level LogLevel) string {
+		store := NewMemoryStore()
+		store.Put(walletID, StoredWallet{Balance: 10})
+		mockCache := newWriteCache()
+		mockCache.On("Get", mock.Anything, mock.Anything).Return("", redis.Nil)
+		operation, _ := json.Marshal(wallet.WalletRequest{
+			ID: "op-1", WalletID: walletID.String(), OperationType: wallet.DEPOSIT, Amount: 5,
+		})
+		mockCache.On("BRPop", mock.Anything, mock.Anything, priorityQueueKeys).
+			Return(redis.NewStringSliceResult([]string{operationsQueueKey, string(operation)}, nil)).Once()
+		config := DefaultConfig()
+		config.Store = store
+		config.LogLevel = level
+		handler := NewWalletHandlerWithConfig(new(MockDB), mockCache, false, config)
+
+		var logs bytes.Buffer
+		log.SetOutput(&logs)
+		defer log.SetOutput(os.Stderr)
+
+		handler.processQueueItem(context.Background())
+		_, err := handler.getBalanceFromDB(context.Background(), walletID)
+		assert.NoError(t, err)
+		return logs.String()
+	}
+
+	t.Run("На уровне info идентификатор и суммы скрыты", func(t *testing.T) {
+		logs := processDeposit(LogLevelInfo)
+
+		assert.NotContains(t, logs, walletID.String())
+		assert.NotContains(t, logs, "10.00")
+		assert.NotContains(t, logs, "15.00")
+		assert.Contains(t, logs, "баланс: *** -> ***")
+		// Хеш одинаков для всех записей об одном кошельке
+		handler := NewWalletHandler(new(MockDB), new(MockCache), false)
+		assert.Equal(t, 2, strings.Count(logs, handler.logWalletID(walletID.String())))
+	})
+
+	t.Run("На уровне debug всё пишется полностью", func(t *testing.T) {
+		logs := processDeposit(LogLevelDebug)
+
+		assert.Contains(t, logs, walletID.String())
+		assert.Contains(t, logs, "баланс: 10.00 -> 15.00")
+		assert.Contains(t, logs, "Получен баланс: 15.00")
+	})
+}
+
+func TestDryRun(t *testing.T) {
+	walletID := uuid.New()
+
+	newDryRunHandler := func(store *MemoryStore) (*WalletHandler, *MockCache) {
+		mockCache := new(MockCache)
+		mockCache.On("Get", mock.Anything, blockedWalletKey(walletID.String())).Return("", redis.Nil)
+		config := DefaultConfig()
+		config.Store = store
+		config.AuditSink = &memoryAuditSink{entries: make(chan audit.Entry, 1)}
+		return NewWalletHandlerWithConfig(new(MockDB), mockCache, false, config), mockCache
+	}
+
+	dryRun := func(handler *WalletHandler, opType string, amount float64) *httptest.ResponseRecorder {
+		body := fmt.Sprintf(`{"wallet_id": %q, "operation_type": %q, "amount": %v, "dry_run": true}`, walletID, opType, amount)
+		req := httptest.NewRequest("POST", "/api/v1/wallet", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		handler.HandleWalletOperation(w, req)
+		return w
+	}
+
+	t.Run("Баланс не меняется, ответ содержит будущий баланс", func(t *testing.T) {
+		store := NewMemoryStore()
+		store.Put(walletID, StoredWallet{Balance: 10})
+		handler, mockCache := newDryRunHandler(store)
+
+		w := dryRun(handler, "WITHDRAW", 4)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var response struct {
+			Code          string  `json:"code"`
+			DryRun        bool    `json:"dry_run"`
+			BalanceBefore Balance `json:"balance_before"`
+			BalanceAfter  Balance `json:"balance_after"`
+		}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, CodeDryRun, response.Code)
+		assert.True(t, response.DryRun)
+		assert.Equal(t, newBalance(10), response.BalanceBefore)
+		assert.Equal(t, newBalance(6), response.BalanceAfter)
+
+		stored, _ := store.GetBalance(context.Background(), walletID)
+		assert.Equal(t, 10.0, stored.Balance)
+		assert.Empty(t, store.Transactions(walletID))
+		// Пробный запуск не ставится в очередь и не попадает в аудит
+		mockCache.AssertNotCalled(t, "LPush", mock.Anything, mock.Anything, mock.Anything)
+		assert.Empty(t, handler.auditEntries)
+	})
+
+	t.Run("Недостаточно средств - ошибка, как у обычной операции", func(t *testing.T) {
+		store := NewMemoryStore()
+		store.Put(walletID, StoredWallet{Balance: 10})
+		handler, _ := newDryRunHandler(store)
+
+		w := dryRun(handler, "WITHDRAW", 50)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), service.ErrInsufficientFunds.Error())
+		stored, _ := store.GetBalance(context.Background(), walletID)
+		assert.Equal(t, 10.0, stored.Balance)
+	})
+
+	t.Run("Несуществующий кошелек не создаётся", func(t *testing.T) {
+		store := NewMemoryStore()
+		handler, _ := newDryRunHandler(store)
+		handler.config.WalletPolicy = WalletPolicyAutoCreate
+
+		w := dryRun(handler, "DEPOSIT", 5)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		_, err := store.GetBalance(context.Background(), walletID)
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+	})
+}
+
+// listCache - списки Redis в памяти для проверки порядка обработки очередей;
+// остальные методы CacheInterface - из MockCache
+type listCache struct {
+	*MockCache
+	mu    sync.Mutex
+	lists map[string][]string
+}
+
+func newListCache() *listCache {
+	mockCache := newWriteCache()
+	expectNotBlocked(mockCache)
+	return &listCache{MockCache: mockCache, lists: make(map[string][]string)}
+}
+
+func (c *listCache) LPush(ctx context.Context, key string, values ...interface{}) *redis.IntCmd {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, value := range values {
+		var item string
+		switch v := value.(type) {
+		case []byte:
+			item = string(v)
+		default:
+			item = fmt.Sprint(v)
+		}
+		c.lists[key] = append([]string{item}, c.lists[key]...)
+	}
+	return redis.NewIntResult(int64(len(c.lists[key])), nil)
+}
+
+// MoveToQueue считает элемент ещё не забранным и добавляет его в список queueKey
+func (c *listCache) MoveToQueue(ctx context.Context, setKey, member, queueKey string) (bool, error) {
+	c.LPush(ctx, queueKey, member)
+	return true, nil
+}
+
+// BRPop без ожидания: забирает старший элемент первого непустого списка
+func (c *listCache) BRPop(ctx context.Context, timeout time.Duration, keys ...string) *redis.StringSliceCmd {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range keys {
+		if list := c.lists[key]; len(list) > 0 {
+			c.lists[key] = list[:len(list)-1]
+			return redis.NewStringSliceResult([]string{key, list[len(list)-1]}, nil)
+		}
+	}
+	return redis.NewStringSliceResult(nil, redis.Nil)
+}
+
+func TestOperationPriority(t *testing.T) {
+	walletID := uuid.New()
+
+	newPriorityHandler := func(cache *listCache, store *MemoryStore, lowEvery int) *WalletHandler {
+		config := DefaultConfig()
+		config.Store = store
+		config.AdminToken = "secret"
+		config.LowPriorityEvery = lowEvery
+		return NewWalletHandlerWithConfig(new(MockDB), cache, false, config)
+	}
+
+	enqueue := func(handler *WalletHandler, reference string, priority wallet.Priority, admin bool) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(map[string]interface{}{
+			"wallet_id":      walletID.String(),
+			"operation_type": "DEPOSIT",
+			"amount":         1,
+			"reference":      reference,
+			"priority":       priority,
+		})
+		req := newJSONRequest(body)
+		if admin {
+			req.Header.Set("Authorization", "Bearer secret")
+		}
+		w := httptest.NewRecorder()
+		handler.HandleWalletOperation(w, req)
+		return w
+	}
+
+	processed := func(store *MemoryStore) []string {
+		var references []string
+		for _, tx := range store.Transactions(walletID) {
+			references = append(references, tx.Reference)
+		}
+		return references
+	}
+
+	t.Run("Срочная операция обрабатывается раньше поставленных до неё обычных", func(t *testing.T) {
+		cache := newListCache()
+		store := NewMemoryStore()
+		store.Put(walletID, StoredWallet{})
+		handler := newPriorityHandler(cache, store, 0)
+
+		assert.Equal(t, http.StatusAccepted, enqueue(handler, "normal-1", "", false).Code)
+		assert.Equal(t, http.StatusAccepted, enqueue(handler, "normal-2", wallet.PriorityNormal, false).Code)
+		// Операция администратора без явного приоритета - срочная
+		assert.Equal(t, http.StatusAccepted, enqueue(handler, "admin", "", true).Code)
+		assert.Len(t, cache.lists[highOperationsQueueKey], 1)
+
+		for range 3 {
+			handler.processQueueItem(context.Background())
+		}
+
+		assert.Equal(t, []string{"admin", "normal-1", "normal-2"}, processed(store))
+	})
+
+	t.Run("Низкий приоритет не голодает", func(t *testing.T) {
+		cache := newListCache()
+		store := NewMemoryStore()
+		store.Put(walletID, StoredWallet{})
+		handler := newPriorityHandler(cache, store, 3)
+
+		assert.Equal(t, http.StatusAccepted, enqueue(handler, "low", wallet.PriorityLow, false).Code)
+		for i := range 4 {
+			assert.Equal(t, http.StatusAccepted, enqueue(handler, fmt.Sprintf("high-%d", i), wallet.PriorityHigh, true).Code)
+		}
+
+		for range 5 {
+			handler.processQueueItem(context.Background())
+		}
+
+		// Каждая третья выборка начинается с низкого приоритета
+		assert.Equal(t, []string{"high-0", "high-1", "low", "high-2", "high-3"}, processed(store))
+	})
+
+	t.Run("Операции из общей очереди до приоритетов обрабатываются", func(t *testing.T) {
+		cache := newListCache()
+		store := NewMemoryStore()
+		store.Put(walletID, StoredWallet{})
+		handler := newPriorityHandler(cache, store, 0)
+
+		legacy, _ := json.Marshal(wallet.WalletRequest{ID: "legacy", WalletID: walletID.String(), OperationType: wallet.DEPOSIT, Amount: 1, Reference: "legacy"})
+		cache.LPush(context.Background(), legacyOperationsQueueKey, legacy)
+		handler.processQueueItem(context.Background())
+
+		assert.Equal(t, []string{"legacy"}, processed(store))
+	})
+
+	t.Run("Высокий приоритет без прав и неизвестный приоритет - 422", func(t *testing.T) {
+		cache := newListCache()
+		handler := newPriorityHandler(cache, NewMemoryStore(), 0)
+
+		w := enqueue(handler, "", wallet.PriorityHigh, false)
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		assert.Contains(t, w.Body.String(), ErrPriorityNotAllowed)
+
+		w = enqueue(handler, "", "urgent", true)
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		assert.Contains(t, w.Body.String(), ErrInvalidPriority)
+		assert.Empty(t, cache.lists)
+	})
+
+	t.Run("Повтор возвращается в очередь своего приоритета", func(t *testing.T) {
+		cache := newListCache()
+		cache.On("ZRangeByScore", mock.Anything, retryQueueKey, mock.Anything, mock.Anything).
+			Return([]string{`{"id":"op-1","priority":"high"}`, `{"id":"op-2"}`}, nil).Once()
+		handler := newPriorityHandler(cache, NewMemoryStore(), 0)
+
+		moved, err := handler.drainRetryQueue(context.Background(), time.Now())
+
+		assert.NoError(t, err)
+		assert.Equal(t, 2, moved)
+		assert.Equal(t, []string{`{"id":"op-1","priority":"high"}`}, cache.lists[highOperationsQueueKey])
+		assert.Equal(t, []string{`{"id":"op-2"}`}, cache.lists[operationsQueueKey])
+	})
+}
+
+// Тесты режима баланса по журналу операций
+func TestLedgerBalance(t *testing.T) {
+	walletID := uuid.New()
+
+	newLedgerHandler := func(store *MemoryStore) *WalletHandler {
+		mockCache := new(MockCache)
+		mockCache.On("Get", mock.Anything, blockedWalletKey(walletID.String())).Return("", redis.Nil).Maybe()
+		mockCache.On("Delete", mock.Anything, mock.Anything).Return(nil).Maybe()
+		config := DefaultConfig()
+		config.Store = store
+		config.AuditSink = &memoryAuditSink{entries: make(chan audit.Entry, 100)}
+		return NewWalletHandlerWithConfig(new(MockDB), mockCache, false, config)
+	}
+
+	deposit := func(t *testing.T, handler *WalletHandler, amount float64) {
+		_, _, walletErr := handler.executeOperation(context.Background(), &wallet.WalletRequest{
+			WalletID:      walletID.String(),
+			OperationType: wallet.DEPOSIT,
+			Amount:        amount,
+		})
+		require.Nil(t, walletErr)
+	}
+
+	t.Run("Баланс по журналу совпадает с балансом в колонке", func(t *testing.T) {
+		column, ledger := NewMemoryStore(), NewMemoryLedgerStore()
+		for _, store := range []*MemoryStore{column, ledger} {
+			store.Put(walletID, StoredWallet{Balance: 10})
+			handler := newLedgerHandler(store)
+			deposit(t, handler, 100)
+			deposit(t, handler, 25.5)
+			_, walletErr := handler.resetBalance(context.Background(), walletID, "проверка")
+			require.Nil(t, walletErr)
+			deposit(t, handler, 7)
+		}
+
+		columnWallet, err := column.GetBalance(context.Background(), walletID)
+		require.NoError(t, err)
+		ledgerWallet, err := ledger.GetBalance(context.Background(), walletID)
+		require.NoError(t, err)
+		assert.Equal(t, 7.0, columnWallet.Balance)
+		assert.Equal(t, columnWallet.Balance, ledgerWallet.Balance)
+		assert.Equal(t, columnWallet.Version, ledgerWallet.Version)
+
+		// В журнале нет ничего, кроме операций: начальный баланс, два зачисления, обнуление, зачисление
+		var sum float64
+		for _, recorded := range ledger.Transactions(walletID) {
+			sum += recorded.Amount
+		}
+		assert.Len(t, ledger.Transactions(walletID), 5)
+		assert.Equal(t, ledgerWallet.Balance, sum)
+	})
+
+	t.Run("Параллельные зачисления", func(t *testing.T) {
+		column, ledger := NewMemoryStore(), NewMemoryLedgerStore()
+		for _, store := range []*MemoryStore{column, ledger} {
+			store.Put(walletID, StoredWallet{})
+			handler := newLedgerHandler(store)
+			var wg sync.WaitGroup
+			for i := 0; i < 20; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					// Конфликт версий откатывает операцию целиком; повторяем до успеха
+					for {
+						_, _, walletErr := handler.executeOperation(context.Background(), &wallet.WalletRequest{
+							WalletID:      walletID.String(),
+							OperationType: wallet.DEPOSIT,
+							Amount:        1,
+						})
+						if walletErr == nil {
+							return
+						}
+					}
+				}()
+			}
+			wg.Wait()
+		}
+
+		columnWallet, _ := column.GetBalance(context.Background(), walletID)
+		ledgerWallet, _ := ledger.GetBalance(context.Background(), walletID)
+		assert.Equal(t, 20.0, columnWallet.Balance)
+		assert.Equal(t, columnWallet.Balance, ledgerWallet.Balance)
+	})
+
+	t.Run("PostgreSQL: баланс считается после блокировки, запись меняет только версию", func(t *testing.T) {
+		mockDB := new(MockDB)
+		mockTx := new(MockTx)
+		mockDB.On("BeginTx", mock.Anything).Return(mockTx, nil).Once()
+		lockRow := new(MockRow)
+		lockRow.On("Scan", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil).Once()
+		lock := mockTx.On("QueryRowContext", mock.Anything, selectBalanceForUpdateQuery, []interface{}{walletID}).Return(lockRow).Once()
+		sumRow := new(MockRow)
+		sumRow.On("Scan", mock.Anything).Run(func(args mock.Arguments) {
+			*args.Get(0).(*float64) = 42
+		}).Return(nil).Once()
+		mockTx.On("QueryRowContext", mock.Anything, ledgerBalanceQuery, []interface{}{walletID}).Return(sumRow).Once().NotBefore(lock)
+		mockTx.On("ExecContext", mock.Anything, bumpWalletVersionQuery, []interface{}{walletID}).Return(&MockResult{}, nil).Once()
+		mockTx.On("Commit").Return(nil).Once()
+		mockTx.On("Rollback").Return(nil).Maybe()
+
+		store := NewLedgerStore(mockDB, LockStrategyRow)
+		tx, err := store.BeginTx(context.Background())
+		require.NoError(t, err)
+		stored, err := tx.LockWallet(context.Background(), walletID)
+		require.NoError(t, err)
+		assert.Equal(t, 42.0, stored.Balance)
+		require.NoError(t, tx.UpdateBalance(context.Background(), walletID, 50))
+		require.NoError(t, tx.Commit())
+
+		mockTx.AssertNotCalled(t, "ExecContext", mock.Anything, updateBalanceQuery, mock.Anything)
+		mockTx.AssertExpectations(t)
+	})
+
+	t.Run("Чтение баланса и суммы по представлению журнала", func(t *testing.T) {
+		config := DefaultConfig()
+		config.BalanceMode = BalanceModeLedger
+		handler := NewWalletHandlerWithConfig(new(MockDB), new(MockCache), false, config)
+
+		assert.IsType(t, &ledgerStore{}, handler.store)
+		assert.IsType(t, &ledgerTx{}, handler.postgresTx(new(MockTx)))
+		assert.Equal(t, ledgerTotalsQuery, handler.byBalanceMode(totalsQuery, ledgerTotalsQuery))
+		assert.Contains(t, selectLedgerWalletBalanceQuery, "wallet_ledger_balances")
+	})
+}
+
+// Тесты пересчёта баланса по журналу операций
+func TestWalletRebuild(t *testing.T) {
+	walletID := uuid.New()
+
+	newRebuildHandler := func(stored, ledger float64) (*WalletHandler, *MockDB, *MockTx, *MockCache) {
+		mockDB := new(MockDB)
+		mockTx := new(MockTx)
+		mockCache := new(MockCache)
+		mockDB.On("BeginTx", mock.Anything).Return(mockTx, nil).Once()
+		lockRow := new(MockRow)
+		lockRow.On("Scan", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+			*args.Get(0).(*float64) = stored
+		}).Return(nil).Once()
+		lock := mockTx.On("QueryRowContext", mock.Anything, selectBalanceForUpdateQuery, []interface{}{walletID}).Return(lockRow).Once()
+		sumRow := new(MockRow)
+		sumRow.On("Scan", mock.Anything).Run(func(args mock.Arguments) {
+			*args.Get(0).(*float64) = ledger
+		}).Return(nil).Once()
+		mockTx.On("QueryRowContext", mock.Anything, ledgerBalanceQuery, []interface{}{walletID}).Return(sumRow).Once().NotBefore(lock)
+		mockTx.On("Rollback").Return(nil).Maybe()
+
+		config := DefaultConfig()
+		config.AdminToken = "secret"
+		config.AuditSink = &memoryAuditSink{entries: make(chan audit.Entry, 1)}
+		return NewWalletHandlerWithConfig(mockDB, mockCache, false, config), mockDB, mockTx, mockCache
+	}
+
+	rebuild := func(handler *WalletHandler) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/v1/admin/wallets/"+walletID.String()+"/rebuild", nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		handler.HandleWalletRebuild(w, req)
+		return w
+	}
+
+	t.Run("Неверный баланс исправляется, ответ содержит расхождение", func(t *testing.T) {
+		handler, _, mockTx, mockCache := newRebuildHandler(100, 120.5)
+		mockTx.On("ExecContext", mock.Anything, updateBalanceQuery, []interface{}{120.5, walletID}).Return(&MockResult{}, nil).Once()
+		mockTx.On("Commit").Return(nil).Once()
+		mockCache.On("Delete", mock.Anything, fmt.Sprintf("balance:%s", walletID)).Return(nil).Once()
+
+		w := rebuild(handler)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var result RebuildResult
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+		assert.Equal(t, newBalance(100), result.PreviousBalance)
+		assert.Equal(t, newBalance(120.5), result.Balance)
+		assert.Equal(t, newBalanceMinor(2050), result.Delta)
+		assert.True(t, result.Corrected)
+		mockTx.AssertExpectations(t)
+		mockCache.AssertExpectations(t)
+
+		entry := <-handler.auditEntries
+		assert.Equal(t, AuditActionRebuild, entry.Action)
+		assert.Equal(t, 20.5, entry.Amount)
+		assert.Equal(t, audit.ResultSuccess, entry.Result)
+	})
+
+	t.Run("Баланс совпадает с журналом - без изменений", func(t *testing.T) {
+		handler, _, mockTx, mockCache := newRebuildHandler(100, 100)
+
+		w := rebuild(handler)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var result RebuildResult
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+		assert.Equal(t, newBalanceMinor(0), result.Delta)
+		assert.False(t, result.Corrected)
+		mockTx.AssertNotCalled(t, "ExecContext", mock.Anything, updateBalanceQuery, mock.Anything)
+		mockTx.AssertNotCalled(t, "Commit")
+		mockCache.AssertNotCalled(t, "Delete", mock.Anything, mock.Anything)
+	})
+
+	t.Run("Без токена администратора - 403", func(t *testing.T) {
+		handler := NewWalletHandler(new(MockDB), new(MockCache), false)
+		w := httptest.NewRecorder()
+		handler.HandleWalletRebuild(w, httptest.NewRequest("POST", "/api/v1/admin/wallets/"+walletID.String()+"/rebuild", nil))
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+}
+
+// Тесты ответов в MessagePack
+func TestMsgPackResponse(t *testing.T) {
+	walletID := uuid.New()
+	cacheKey := fmt.Sprintf("balance:%s", walletID)
+
+	t.Run("Баланс в MessagePack", func(t *testing.T) {
+		mockCache := new(MockCache)
+		mockCache.On("Get", mock.Anything, cacheKey).Return("", redis.Nil).Maybe()
+		mockCache.On("Set", mock.Anything, cacheKey, mock.Anything, mock.Anything).Return(nil).Maybe()
+		mockDB := new(MockDB)
+		mockRow := new(MockRow)
+		mockRow.On("Scan", mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+			*args.Get(0).(*float64) = 250.5
+		}).Return(nil).Once()
+		mockDB.On("QueryRowContext", mock.Anything, selectWalletBalanceQuery, walletID).Return(mockRow).Once()
+
+		handler := NewWalletHandler(mockDB, mockCache, false)
+		req := httptest.NewRequest("GET", "/api/v1/wallets/"+walletID.String(), nil)
+		req.Header.Set("Accept", "application/msgpack;q=1.0, application/json;q=0.5")
+		w := httptest.NewRecorder()
+		handler.GetWalletBalance(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, msgpack.ContentType, w.Header().Get("Content-Type"))
+		assert.Contains(t, w.Header().Values("Vary"), "Accept")
+		var balance Balance
+		require.NoError(t, msgpack.Unmarshal(w.Body.Bytes(), &balance))
+		assert.Equal(t, Balance{Balance: "250.50", BalanceMinor: 25050}, balance)
+	})
+
+	t.Run("Список в MessagePack", func(t *testing.T) {
+		mockDB := new(MockDB)
+		createdAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+		id := uuid.New().String()
+		mockDB.On("QueryContext", mock.Anything, historyQuery, mock.Anything).Return(&MockRows{rows: [][]interface{}{
+			{id, walletID.String(), 10.0, wallet.DEPOSIT, "", createdAt, (*time.Time)(nil), (*string)(nil)},
+		}}, nil).Once()
+
+		handler := NewWalletHandler(mockDB, new(MockCache), false)
+		req := httptest.NewRequest("GET", "/api/v1/wallets/"+walletID.String()+"/transactions", nil)
+		req.Header.Set("Accept", "application/msgpack")
+		w := httptest.NewRecorder()
+		handler.GetTransactionHistory(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var page Page[wallet.Transaction]
+		require.NoError(t, msgpack.Unmarshal(w.Body.Bytes(), &page))
+		require.Len(t, page.Items, 1)
+		assert.Equal(t, id, page.Items[0].ID)
+		assert.True(t, createdAt.Equal(page.Items[0].CreatedAt))
+		assert.Equal(t, historyLimit, page.Page.Limit)
+	})
+
+	t.Run("Без Accept - JSON", func(t *testing.T) {
+		mockDB := new(MockDB)
+		mockDB.On("QueryContext", mock.Anything, historyQuery, mock.Anything).Return(&MockRows{}, nil).Once()
+
+		handler := NewWalletHandler(mockDB, new(MockCache), false)
+		w := httptest.NewRecorder()
+		handler.GetTransactionHistory(w, httptest.NewRequest("GET", "/api/v1/wallets/"+walletID.String()+"/transactions", nil))
+
+		assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+		assert.JSONEq(t, `{"items":[],"page":{"has_more":false,"limit":100}}`, w.Body.String())
+	})
+}
+
+func TestWalletOperationLimit(t *testing.T) {
+	hotWallet, coldWallet := uuid.New(), uuid.New()
+
+	newLimitedHandler := func(cache *listCache, store *MemoryStore, limit int) *WalletHandler {
+		config := DefaultConfig()
+		config.Store = store
+		config.MaxWalletOperations = limit
+		return NewWalletHandlerWithConfig(new(MockDB), cache, false, config)
+	}
+
+	enqueue := func(handler *WalletHandler, walletID uuid.UUID, reference string) {
+		body, _ := json.Marshal(map[string]interface{}{
+			"wallet_id":      walletID.String(),
+			"operation_type": "DEPOSIT",
+			"amount":         1,
+			"reference":      reference,
+		})
+		w := httptest.NewRecorder()
+		handler.HandleWalletOperation(w, newJSONRequest(body))
+		require.Equal(t, http.StatusAccepted, w.Code)
+	}
+
+	t.Run("Горячий кошелек не задерживает операцию другого кошелька", func(t *testing.T) {
+		cache := newListCache()
+		store := NewMemoryStore()
+		store.Put(hotWallet, StoredWallet{})
+		store.Put(coldWallet, StoredWallet{})
+		handler := newLimitedHandler(cache, store, 1)
+
+		enqueue(handler, hotWallet, "hot-2")
+		enqueue(handler, coldWallet, "cold")
+		// Над горячим кошельком уже выполняется операция другого обработчика
+		release, ok := handler.walletSlots.tryAcquire(hotWallet.String(), 1)
+		require.True(t, ok)
+
+		handler.processQueueItem(context.Background())
+		handler.processQueueItem(context.Background())
+
+		assert.Empty(t, store.Transactions(hotWallet))
+		require.Len(t, store.Transactions(coldWallet), 1)
+		// Отложенная операция вернулась в очередь, а не потерялась
+		assert.Len(t, cache.lists[operationsQueueKey], 1)
+
+		release()
+		handler.processQueueItem(context.Background())
+
+		require.Len(t, store.Transactions(hotWallet), 1)
+		assert.Equal(t, "hot-2", store.Transactions(hotWallet)[0].Reference)
+		assert.Empty(t, cache.lists[operationsQueueKey])
+	})
+
+	t.Run("Слоты кошелька", func(t *testing.T) {
+		var slots walletSlots
+		first, ok := slots.tryAcquire("a", 2)
+		require.True(t, ok)
+		second, ok := slots.tryAcquire("a", 2)
+		require.True(t, ok)
+		_, ok = slots.tryAcquire("a", 2)
+		assert.False(t, ok)
+		_, ok = slots.tryAcquire("b", 2)
+		assert.True(t, ok)
+
+		first()
+		_, ok = slots.tryAcquire("a", 2)
+		assert.True(t, ok)
+		second()
+
+		// 0 снимает ограничение
+		for range 10 {
+			_, ok := slots.tryAcquire("c", 0)
+			assert.True(t, ok)
+		}
+	})
+}
+
+// Тесты ответа на таймаут и отключение клиента при чтении баланса
+func TestBalanceContextErrors(t *testing.T) {
+	walletID := uuid.New()
+	cacheKey := fmt.Sprintf("balance:%s", walletID)
+
+	newFailingHandler := func(scanErr error) (*WalletHandler, *MockDB) {
+		mockCache := new(MockCache)
+		mockCache.On("Get", mock.Anything, cacheKey).Return("", redis.Nil)
+		mockDB := new(MockDB)
+		mockRow := new(MockRow)
+		mockRow.On("Scan", mock.Anything, mock.Anything, mock.Anything).Return(scanErr)
+		mockDB.On("QueryRowContext", mock.Anything, selectWalletBalanceQuery, walletID).Return(mockRow)
+		return NewWalletHandler(mockDB, mockCache, false), mockDB
+	}
+
+	t.Run("Истёк таймаут - 504", func(t *testing.T) {
+		handler, mockDB := newFailingHandler(fmt.Errorf("query: %w", context.DeadlineExceeded))
+		w := httptest.NewRecorder()
+		handler.GetWalletBalance(w, httptest.NewRequest("GET", "/api/v1/wallets/"+walletID.String(), nil))
+
+		assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+		assert.Contains(t, w.Body.String(), ErrTimeout)
+		// Повторы после истёкшего таймаута не нужны
+		mockDB.AssertNumberOfCalls(t, "QueryRowContext", 1)
+	})
+
+	t.Run("Клиент отключился - ответ не пишется", func(t *testing.T) {
+		handler, _ := newFailingHandler(errors.New("pq: canceling statement due to user request"))
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		w := httptest.NewRecorder()
+		handler.GetWalletBalance(w, httptest.NewRequest("GET", "/api/v1/wallets/"+walletID.String(), nil).WithContext(ctx))
+
+		assert.False(t, w.Flushed)
+		assert.Empty(t, w.Body.String())
+	})
+
+	t.Run("Ошибка БД - 503", func(t *testing.T) {
+		handler, _ := newFailingHandler(errors.New("connection refused"))
+		w := httptest.NewRecorder()
+		handler.GetWalletBalance(w, httptest.NewRequest("GET", "/api/v1/wallets/"+walletID.String(), nil))
+
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		assert.Contains(t, w.Body.String(), ErrBalanceRetrievalFail)
+	})
+}
+
+// Тесты заголовков кэширования баланса для прокси
+func TestBalanceCacheHeaders(t *testing.T) {
+	walletID := uuid.New()
+	cacheKey := fmt.Sprintf("balance:%s", walletID)
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	newCachingHandler := func(mockDB *MockDB, mockCache *MockCache, enabled bool) *WalletHandler {
+		config := DefaultConfig()
+		config.BalanceCacheHeaders = enabled
+		config.Clock = newFakeClock(now)
+		return NewWalletHandlerWithConfig(mockDB, mockCache, false, config)
+	}
+
+	t.Run("Баланс из кэша: max-age по TTL кэша и возраст значения", func(t *testing.T) {
+		mockCache := new(MockCache)
+		cached := fmt.Sprintf(`{"balance":5,"version":3,"cached_at":%d}`, now.Add(-12*time.Second).UnixMilli())
+		mockCache.On("Get", mock.Anything, cacheKey).Return(cached, nil).Once()
+		handler := newCachingHandler(new(MockDB), mockCache, true)
+
+		w := httptest.NewRecorder()
+		handler.GetWalletBalance(w, httptest.NewRequest("GET", "/api/v1/wallets/"+walletID.String(), nil))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, fmt.Sprintf("max-age=%d", int(balanceCacheTTL.Seconds())), w.Header().Get("Cache-Control"))
+		assert.Equal(t, "12", w.Header().Get("Age"))
+	})
+
+	t.Run("Баланс из БД: возраст 0, момент записи сохраняется в кэше", func(t *testing.T) {
+		mockCache := new(MockCache)
+		mockCache.On("Get", mock.Anything, cacheKey).Return("", redis.Nil)
+		written := make(chan string, 1)
+		mockCache.On("Set", mock.Anything, cacheKey, mock.Anything, balanceCacheTTL).Run(func(args mock.Arguments) {
+			written <- args.Get(2).(string)
+		}).Return(nil).Once()
+		mockDB := new(MockDB)
+		mockRow := new(MockRow)
+		mockRow.On("Scan", mock.Anything, mock.Anything, mock.Anything).Return(nil).Once()
+		mockDB.On("QueryRowContext", mock.Anything, selectWalletBalanceQuery, walletID).Return(mockRow).Once()
+		handler := newCachingHandler(mockDB, mockCache, true)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go handler.RunCacheWriter(ctx)
+
+		w := httptest.NewRecorder()
+		handler.GetWalletBalance(w, httptest.NewRequest("GET", "/api/v1/wallets/"+walletID.String(), nil))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, fmt.Sprintf("max-age=%d", int(balanceCacheTTL.Seconds())), w.Header().Get("Cache-Control"))
+		assert.Equal(t, "0", w.Header().Get("Age"))
+		select {
+		case value := <-written:
+			assert.Contains(t, value, fmt.Sprintf(`"cached_at":%d`, now.UnixMilli()))
+		case <-time.After(time.Second):
+			t.Fatal("баланс не записан в кэш")
+		}
+	})
+
+	t.Run("Заголовки выключены по умолчанию", func(t *testing.T) {
+		mockCache := new(MockCache)
+		mockCache.On("Get", mock.Anything, cacheKey).Return(`{"balance":5,"version":3}`, nil).Once()
+		handler := newCachingHandler(new(MockDB), mockCache, false)
+
+		w := httptest.NewRecorder()
+		handler.GetWalletBalance(w, httptest.NewRequest("GET", "/api/v1/wallets/"+walletID.String(), nil))
+
+		assert.Empty(t, w.Header().Get("Cache-Control"))
+		assert.Empty(t, w.Header().Get("Age"))
+	})
+
+	t.Run("Ответ на операцию не кэшируется", func(t *testing.T) {
+		handler := newCachingHandler(new(MockDB), new(MockCache), true)
+		w := httptest.NewRecorder()
+		handler.HandleWalletOperation(w, httptest.NewRequest("GET", "/api/v1/wallet", nil))
+
+		assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+	})
+}
+
+func TestOperationTimeout(t *testing.T) {
+	walletID := uuid.New()
+
+	t.Run("Долгое ожидание блокировки - 504 и откат", func(t *testing.T) {
+		mockDB := new(MockDB)
+		mockTx := new(MockTx)
+		mockDB.On("BeginTx", mock.Anything).Return(mockTx, nil).Once()
+		slowRow := new(MockRow)
+		slowRow.On("Scan", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(context.DeadlineExceeded).Once()
+		// Запрос блокировки ждёт, пока не истечёт таймаут операции
+		mockTx.On("QueryRowContext", mock.Anything, selectBalanceForUpdateQuery, []interface{}{walletID}).Run(func(args mock.Arguments) {
+			<-args.Get(0).(context.Context).Done()
+		}).Return(slowRow).Once()
+		mockTx.On("Rollback").Return(nil).Once()
+
+		config := DefaultConfig()
+		config.OperationTimeout = 50 * time.Millisecond
+		handler := NewWalletHandlerWithConfig(mockDB, new(MockCache), false, config)
+
+		start := time.Now()
+		_, _, walletErr := handler.executeOperation(context.Background(), &wallet.WalletRequest{
+			WalletID:      walletID.String(),
+			OperationType: wallet.DEPOSIT,
+			Amount:        10,
+		})
+
+		require.NotNil(t, walletErr)
+		assert.Equal(t, http.StatusGatewayTimeout, walletErr.Code)
+		assert.Equal(t, ErrOperationTimeout, walletErr.Message)
+		assert.Less(t, time.Since(start), time.Second)
+		// Откат снимает блокировку; операция повторяется как после временной ошибки
+		mockTx.AssertExpectations(t)
+		mockTx.AssertNotCalled(t, "Commit")
+		assert.True(t, isTransient(walletErr))
+	})
+
+	t.Run("Ошибка без истечения таймаута не считается таймаутом", func(t *testing.T) {
+		mockDB := new(MockDB)
+		mockTx := new(MockTx)
+		mockDB.On("BeginTx", mock.Anything).Return(mockTx, nil).Once()
+		row := new(MockRow)
+		row.On("Scan", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(errors.New("connection reset")).Once()
+		mockTx.On("QueryRowContext", mock.Anything, selectBalanceForUpdateQuery, []interface{}{walletID}).Return(row).Once()
+		mockTx.On("Rollback").Return(nil).Once()
+
+		handler := NewWalletHandler(mockDB, new(MockCache), false)
+		_, _, walletErr := handler.executeOperation(context.Background(), &wallet.WalletRequest{
+			WalletID:      walletID.String(),
+			OperationType: wallet.DEPOSIT,
+			Amount:        10,
+		})
+
+		require.NotNil(t, walletErr)
+		assert.Equal(t, http.StatusInternalServerError, walletErr.Code)
+		assert.Equal(t, ErrBalanceGet, walletErr.Message)
+	})
+}
+
+func TestOperationExpiry(t *testing.T) {
+	walletID := uuid.New()
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	newExpiryHandler := func(cache *listCache, store *MemoryStore, clock Clock) *WalletHandler {
+		config := DefaultConfig()
+		config.Store = store
+		config.Clock = clock
+		return NewWalletHandlerWithConfig(new(MockDB), cache, false, config)
+	}
+
+	enqueue := func(handler *WalletHandler, expiresAt time.Time) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(map[string]interface{}{
+			"wallet_id":      walletID.String(),
+			"operation_type": "DEPOSIT",
+			"amount":         1,
+			"expires_at":     expiresAt,
+		})
+		w := httptest.NewRecorder()
+		handler.HandleWalletOperation(w, newJSONRequest(body))
+		return w
+	}
+
+	t.Run("Истёкшая операция отбрасывается", func(t *testing.T) {
+		cache := newListCache()
+		store := NewMemoryStore()
+		store.Put(walletID, StoredWallet{})
+		clock := newFakeClock(now)
+		handler := newExpiryHandler(cache, store, clock)
+
+		require.Equal(t, http.StatusAccepted, enqueue(handler, now.Add(time.Minute)).Code)
+		// Обработчики очереди не работали дольше срока действия
+		clock.Advance(2 * time.Minute)
+		handler.processQueueItem(context.Background())
+
+		assert.Empty(t, store.Transactions(walletID))
+		assert.Empty(t, cache.lists[operationsQueueKey])
+		assert.Equal(t, int64(1), handler.ExpiredOperations())
+	})
+
+	t.Run("Операция до истечения срока выполняется", func(t *testing.T) {
+		cache := newListCache()
+		store := NewMemoryStore()
+		store.Put(walletID, StoredWallet{})
+		clock := newFakeClock(now)
+		handler := newExpiryHandler(cache, store, clock)
+
+		require.Equal(t, http.StatusAccepted, enqueue(handler, now.Add(time.Minute)).Code)
+		clock.Advance(30 * time.Second)
+		handler.processQueueItem(context.Background())
+
+		assert.Len(t, store.Transactions(walletID), 1)
+		assert.Zero(t, handler.ExpiredOperations())
+	})
+
+	t.Run("Срок действия в прошлом - 422", func(t *testing.T) {
+		cache := newListCache()
+		handler := newExpiryHandler(cache, NewMemoryStore(), newFakeClock(now))
+
+		// Прошедший больше, чем на допустимое отставание часов
+		w := enqueue(handler, now.Add(-time.Minute))
+
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		assert.Contains(t, w.Body.String(), ErrInvalidExpiresAt)
+		assert.Empty(t, cache.lists[operationsQueueKey])
+	})
+}
+
+// Тест центрального сопоставления ошибок статусам и JSON-телу ответа
+func TestErrorMapping(t *testing.T) {
+	handler := NewWalletHandler(new(MockDB), new(MockCache), false)
+
+	tests := []struct {
+		name           string
+		err            error
+		expectedStatus int
+		expectedCode   string
+	}{
+		{"Кошелек не найден", service.ErrWalletNotFound, http.StatusNotFound, "wallet.not_found"},
+		{"Обёрнутый кошелек не найден", fmt.Errorf("загрузка: %w", service.ErrWalletNotFound), http.StatusNotFound, "wallet.not_found"},
+		{"Нет строки в БД", sql.ErrNoRows, http.StatusNotFound, "wallet.not_found"},
+		{"Кошелек закрыт", service.ErrWalletClosed, http.StatusConflict, errorCodes[ErrWalletClosed]},
+		{"Кошелек заблокирован", service.ErrWalletBlocked, http.StatusForbidden, errorCodes[ErrWalletBlocked]},
+		{"Таймаут", fmt.Errorf("query: %w", context.DeadlineExceeded), http.StatusGatewayTimeout, errorCodes[ErrTimeout]},
+		{"Недостаточно средств", service.ErrInsufficientFunds, http.StatusBadRequest, "validation.insufficient_funds"},
+		{"Неверный тип операции", service.ErrUnknownOperationType, http.StatusUnprocessableEntity, "validation.unknown_operation_type"},
+		{"Неверный UUID", fmt.Errorf("%w: bad", service.ErrInvalidWalletID), http.StatusUnprocessableEntity, "validation.invalid_wallet_id"},
+		{"Отрицательная сумма", service.ErrNegativeAmount, http.StatusUnprocessableEntity, "validation.negative_amount"},
+		{"WalletError", &WalletError{Code: http.StatusConflict, Message: ErrWalletClosed}, http.StatusConflict, errorCodes[ErrWalletClosed]},
+		{"Неизвестная ошибка", errors.New("boom"), http.StatusInternalServerError, errorCodes[ErrInternal]},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/wallets/x", nil)
+			req.Header.Set("X-Request-ID", "req-7")
+			w := httptest.NewRecorder()
+
+			handler.writeErr(w, req, tt.err)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+			var body ErrorResponse
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+			assert.Equal(t, tt.expectedCode, body.Code)
+			assert.NotEmpty(t, body.Error)
+			assert.Equal(t, "req-7", body.RequestID)
+		})
+	}
+}
+
+// Тест статуса операции из очереди: истёкший статус - 410, неизвестная операция - 404
+func TestOperationStatus(t *testing.T) {
+	walletID := uuid.New()
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	cache := newListCache()
+	store := NewMemoryStore()
+	store.Put(walletID, StoredWallet{Balance: 5})
+	clock := newFakeClock(now)
+	config := DefaultConfig()
+	config.Store = store
+	config.Clock = clock
+	config.OperationStatusTTL = time.Minute
+	handler := NewWalletHandlerWithConfig(new(MockDB), cache, false, config)
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"wallet_id": walletID.String(), "operation_type": "DEPOSIT", "amount": 10,
+	})
+	enqueued := httptest.NewRecorder()
+	handler.HandleWalletOperation(enqueued, newJSONRequest(body))
+	require.Equal(t, http.StatusAccepted, enqueued.Code)
+	var queued struct {
+		OperationID string `json:"operation_id"`
+	}
+	require.NoError(t, json.Unmarshal(enqueued.Body.Bytes(), &queued))
+
+	handler.processQueueItem(context.Background())
+
+	// Статус пишется фоновым писателем кэша с запасом сверх OperationStatusTTL
+	write := <-handler.cacheWrites
+	assert.Equal(t, operationStatusKey(queued.OperationID), write.key)
+	assert.Equal(t, time.Minute+operationStatusGrace, write.ttl)
+	cache.On("Get", mock.Anything, write.key).Return(write.value, nil)
+	cache.On("Get", mock.Anything, operationStatusKey("unknown")).Return("", redis.Nil)
+
+	getStatus := func(id string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/operations/"+id, nil)
+		req.SetPathValue("id", id)
+		w := httptest.NewRecorder()
+		handler.HandleOperationStatus(w, req)
+		return w
+	}
+
+	t.Run("Статус выполненной операции", func(t *testing.T) {
+		w := getStatus(queued.OperationID)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var result wallet.OperationResult
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+		assert.Equal(t, wallet.OperationResult{
+			ID: queued.OperationID, BalanceBefore: 5, BalanceAfter: 15, Status: wallet.OperationCompleted,
+		}, result)
+	})
+
+	t.Run("Неизвестная операция - 404", func(t *testing.T) {
+		w := getStatus("unknown")
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.Equal(t, ErrOperationNotFound, errorMessage(t, w))
+	})
+
+	t.Run("Истёкший статус - 410", func(t *testing.T) {
+		clock.Advance(time.Minute + time.Second)
+		w := getStatus(queued.OperationID)
+
+		assert.Equal(t, http.StatusGone, w.Code)
+		assert.Equal(t, ErrOperationStatusGone, errorMessage(t, w))
+	})
+}
+
+// Тест многоуровневого лимита запросов: по адресу, по субъекту и общий потолок
+func TestLayeredRateLimit(t *testing.T) {
+	newLimitedHandler := func(configure func(*Config)) *WalletHandler {
+		config := DefaultConfig()
+		config.AdminToken = "secret"
+		config.IPRateLimit = 1
+		config.IPRateBurst = 2
+		configure(&config)
+		return NewWalletHandlerWithConfig(new(MockDB), new(MockCache), false, config)
+	}
+
+	// GET проходит лимитер и получает 405, поэтому код ответа показывает, сработал ли лимит
+	send := func(handler *WalletHandler, remoteAddr, forwardedFor, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/wallet", nil)
+		req.RemoteAddr = remoteAddr
+		if forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		handler.HandleWalletOperation(w, req)
+		return w
+	}
+
+	t.Run("Поток анонимных запросов с одного адреса", func(t *testing.T) {
+		handler := newLimitedHandler(func(*Config) {})
+
+		for range 2 {
+			assert.Equal(t, http.StatusMethodNotAllowed, send(handler, "10.0.0.1:5000", "", "").Code)
+		}
+		w := send(handler, "10.0.0.1:5001", "", "")
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.NotEmpty(t, w.Header().Get("Retry-After"))
+
+		// Другой адрес не затронут
+		assert.Equal(t, http.StatusMethodNotAllowed, send(handler, "10.0.0.2:5000", "", "").Code)
+	})
+
+	t.Run("Адрес берётся из X-Forwarded-For доверенного прокси", func(t *testing.T) {
+		handler := newLimitedHandler(func(config *Config) {
+			proxies, err := ParseTrustedProxies("192.168.0.0/24")
+			require.NoError(t, err)
+			config.TrustedProxies = proxies
+		})
+
+		for range 2 {
+			assert.Equal(t, http.StatusMethodNotAllowed, send(handler, "192.168.0.1:80", "203.0.113.7, 192.168.0.1", "").Code)
+		}
+		assert.Equal(t, http.StatusTooManyRequests, send(handler, "192.168.0.1:80", "203.0.113.7", "").Code)
+		// Тот же балансировщик для другого клиента
+		assert.Equal(t, http.StatusMethodNotAllowed, send(handler, "192.168.0.1:80", "203.0.113.8", "").Code)
+	})
+
+	t.Run("X-Forwarded-For от недоверенного адреса игнорируется", func(t *testing.T) {
+		handler := newLimitedHandler(func(*Config) {})
+
+		// Клиент не обходит лимит, подставляя новый адрес в заголовок
+		for i := range 2 {
+			forwarded := fmt.Sprintf("203.0.113.%d", i)
+			assert.Equal(t, http.StatusMethodNotAllowed, send(handler, "10.0.0.1:5000", forwarded, "").Code)
+		}
+		assert.Equal(t, http.StatusTooManyRequests, send(handler, "10.0.0.1:5000", "203.0.113.99", "").Code)
+	})
+
+	t.Run("Левые записи X-Forwarded-For не доверяются", func(t *testing.T) {
+		handler := newLimitedHandler(func(config *Config) {
+			proxies, err := ParseTrustedProxies("192.168.0.1")
+			require.NoError(t, err)
+			config.TrustedProxies = proxies
+		})
+
+		// Балансировщик дописывает реальный адрес справа; левые записи подставил клиент
+		for i := range 2 {
+			forwarded := fmt.Sprintf("198.51.100.%d, 203.0.113.7", i)
+			assert.Equal(t, http.StatusMethodNotAllowed, send(handler, "192.168.0.1:80", forwarded, "").Code)
+		}
+		assert.Equal(t, http.StatusTooManyRequests, send(handler, "192.168.0.1:80", "198.51.100.50, 203.0.113.7", "").Code)
+	})
+
+	t.Run("Лимит субъекта строже лимита адреса", func(t *testing.T) {
+		handler := newLimitedHandler(func(config *Config) {
+			config.SubjectRateLimit = 1
+			config.SubjectRateBurst = 1
+		})
+
+		assert.Equal(t, http.StatusMethodNotAllowed, send(handler, "10.0.0.1:5000", "", "secret").Code)
+		assert.Equal(t, http.StatusTooManyRequests, send(handler, "10.0.0.2:5000", "", "secret").Code)
+		// Отклонённый запрос не расходует токен адреса
+		assert.Equal(t, http.StatusMethodNotAllowed, send(handler, "10.0.0.2:5000", "", "").Code)
+		assert.Equal(t, http.StatusMethodNotAllowed, send(handler, "10.0.0.2:5000", "", "").Code)
+	})
+
+	t.Run("Общий лимит остаётся потолком", func(t *testing.T) {
+		handler := newLimitedHandler(func(config *Config) {
+			config.RateLimit = 1
+			config.RateBurst = 1
+		})
+
+		assert.Equal(t, http.StatusMethodNotAllowed, send(handler, "10.0.0.1:5000", "", "").Code)
+		assert.Equal(t, http.StatusTooManyRequests, send(handler, "10.0.0.2:5000", "", "").Code)
+	})
+}
+
+// Тест ограничения числа лимитеров: новые ключи сверх предела делят общий лимитер
+func TestKeyedLimitersBounded(t *testing.T) {
+	limiters := newKeyedLimiters(1, 1)
+	limiters.maxSize = 2
+
+	first := limiters.get("10.0.0.1")
+	assert.Same(t, first, limiters.get("10.0.0.1"))
+	assert.NotSame(t, first, limiters.get("10.0.0.2"))
+
+	overflow := limiters.get("10.0.0.3")
+	assert.Same(t, overflow, limiters.get("10.0.0.4"))
+	assert.Len(t, limiters.limiters, 2)
+}
+
+// Тест разбора списка доверенных прокси
+func TestParseTrustedProxies(t *testing.T) {
+	proxies, err := ParseTrustedProxies(" 10.0.0.0/8, 192.168.1.10 ,,2001:db8::/32")
+	require.NoError(t, err)
+	assert.Equal(t, []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("192.168.1.10/32"),
+		netip.MustParsePrefix("2001:db8::/32"),
+	}, proxies)
+
+	_, err = ParseTrustedProxies("10.0.0.0/8, not-an-ip")
+	assert.Error(t, err)
+}
+
+// Тест повтора транзакции после взаимной блокировки и сбоя сериализации
+func TestSerializationRetry(t *testing.T) {
+	walletID := uuid.New()
+
+	// expectDeposit готовит транзакцию зачисления 50 на баланс 500; updateErr - ошибка обновления баланса
+	expectDeposit := func(mockDB *MockDB, updateErr error) *MockTx {
+		mockTx := new(MockTx)
+		mockRow := new(MockRow)
+		mockDB.On("BeginTx", mock.Anything).Return(mockTx, nil).Once()
+		mockTx.On("QueryRowContext", mock.Anything, mock.Anything, mock.Anything).Return(mockRow).Once()
+		mockRow.On("Scan", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+			*args.Get(0).(*float64) = 500
+		}).Return(nil).Once()
+		mockTx.On("ExecContext", mock.Anything, updateBalanceQuery,
+			[]interface{}{550.0, walletID}).Return(&MockResult{}, updateErr).Once()
+		if updateErr == nil {
+			mockTx.On("ExecContext", mock.Anything, mock.Anything,
+				recordedTransaction(walletID, 50.0, wallet.DEPOSIT, "")).Return(&MockResult{}, nil).Once()
+			mockTx.On("Commit").Return(nil).Once()
+		}
+		mockTx.On("Rollback").Return(nil).Maybe()
+		return mockTx
+	}
+
+	deposit := func(handler *WalletHandler) (float64, *WalletError) {
+		_, balance, walletErr := handler.executeOperation(context.Background(), &wallet.WalletRequest{
+			WalletID: walletID.String(), OperationType: wallet.DEPOSIT, Amount: 50,
+		})
+		return balance, walletErr
+	}
+
+	t.Run("Взаимная блокировка - успех при повторе", func(t *testing.T) {
+		mockDB := new(MockDB)
+		deadlocked := expectDeposit(mockDB, &pq.Error{Code: sqlStateDeadlockDetected})
+		retried := expectDeposit(mockDB, nil)
+
+		balance, walletErr := deposit(NewWalletHandler(mockDB, newWriteCache(), false))
+
+		assert.Nil(t, walletErr)
+		assert.Equal(t, 550.0, balance)
+		deadlocked.AssertNotCalled(t, "Commit")
+		retried.AssertExpectations(t)
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("Попытки исчерпаны - 500", func(t *testing.T) {
+		config := DefaultConfig()
+		config.MaxRetries = 1
+		mockDB := new(MockDB)
+		expectDeposit(mockDB, &pq.Error{Code: sqlStateSerializationFailure})
+		expectDeposit(mockDB, fmt.Errorf("update: %w", &pq.Error{Code: sqlStateSerializationFailure}))
+
+		_, walletErr := deposit(NewWalletHandlerWithConfig(mockDB, new(MockCache), false, config))
+
+		require.NotNil(t, walletErr)
+		assert.Equal(t, http.StatusInternalServerError, walletErr.Code)
+		assert.Equal(t, ErrBalanceUpdate, walletErr.Message)
+		mockDB.AssertNumberOfCalls(t, "BeginTx", 2)
+	})
+
+	t.Run("Прочие ошибки БД не повторяются", func(t *testing.T) {
+		mockDB := new(MockDB)
+		expectDeposit(mockDB, &pq.Error{Code: "23505"})
+
+		_, walletErr := deposit(NewWalletHandler(mockDB, new(MockCache), false))
+
+		require.NotNil(t, walletErr)
+		mockDB.AssertNumberOfCalls(t, "BeginTx", 1)
+	})
+}
+
+// Тест потолка размера списков: превышение - 400 или, по настройке, урезание до потолка
+func TestListSizeCeiling(t *testing.T) {
+	walletID := uuid.New()
+	historyPath := "/api/v1/wallets/" + walletID.String() + "/transactions"
+
+	t.Run("История сверх потолка - 400", func(t *testing.T) {
+		mockDB := new(MockDB)
+		handler := NewWalletHandler(mockDB, new(MockCache), false)
+		w := httptest.NewRecorder()
+
+		handler.GetTransactionHistory(w, httptest.NewRequest("GET", historyPath+"?limit=101", nil))
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, fmt.Sprintf(ErrListSizeExceeded, 100), errorMessage(t, w))
+		mockDB.AssertNotCalled(t, "QueryContext", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("История урезается до потолка", func(t *testing.T) {
+		config := DefaultConfig()
+		config.MaxListSize = 10
+		config.ClampListLimit = true
+		mockDB := new(MockDB)
+		// В запрос уходит потолок и одна строка для признака следующей страницы
+		mockDB.On("QueryContext", mock.Anything, historyQuery, []interface{}{walletID, 11, (*time.Time)(nil), (*string)(nil)}).
+			Return(&MockRows{}, nil).Once()
+		handler := NewWalletHandlerWithConfig(mockDB, new(MockCache), false, config)
+		w := httptest.NewRecorder()
+
+		handler.GetTransactionHistory(w, httptest.NewRequest("GET", historyPath+"?limit=5000", nil))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var page Page[wallet.Transaction]
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+		assert.Equal(t, 10, page.Page.Limit)
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("Страница по умолчанию не больше потолка", func(t *testing.T) {
+		config := DefaultConfig()
+		config.MaxListSize = 10
+		mockDB := new(MockDB)
+		mockDB.On("QueryContext", mock.Anything, historyQuery, []interface{}{walletID, 11, (*time.Time)(nil), (*string)(nil)}).
+			Return(&MockRows{}, nil).Once()
+		handler := NewWalletHandlerWithConfig(mockDB, new(MockCache), false, config)
+		w := httptest.NewRecorder()
+
+		handler.GetTransactionHistory(w, httptest.NewRequest("GET", historyPath, nil))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("Просмотр очереди сверх потолка", func(t *testing.T) {
+		config := DefaultConfig()
+		config.AdminToken = "secret"
+		config.MaxListSize = 5
+		peek := func(handler *WalletHandler) *httptest.ResponseRecorder {
+			req := httptest.NewRequest("GET", "/api/v1/admin/dlq?count=50", nil)
+			req.Header.Set("Authorization", "Bearer secret")
+			w := httptest.NewRecorder()
+			handler.HandleDeadLetterPeek(w, req)
+			return w
+		}
+
+		mockCache := new(MockCache)
+		w := peek(NewWalletHandlerWithConfig(new(MockDB), mockCache, false, config))
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		mockCache.AssertNotCalled(t, "LLen", mock.Anything, mock.Anything)
+
+		config.ClampListLimit = true
+		mockCache = new(MockCache)
+		mockCache.On("LLen", mock.Anything, deadLetterQueueKey).Return(int64(50), nil).Once()
+		mockCache.On("LRange", mock.Anything, deadLetterQueueKey, int64(0), int64(4)).Return([]string{}, nil).Once()
+		w = peek(NewWalletHandlerWithConfig(new(MockDB), mockCache, false, config))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"items": [], "offset": 0, "count": 5, "total": 50}`, w.Body.String())
+		mockCache.AssertExpectations(t)
+	})
+}
+
+// Тест долгого опроса баланса: сразу - если версия уже новее, иначе по событию или таймауту
+func TestBalancePoll(t *testing.T) {
+	walletID := uuid.New()
+
+	newPollHandler := func() (*WalletHandler, *MemoryStore) {
+		store := NewMemoryStore()
+		store.Put(walletID, StoredWallet{Balance: 10, Version: 3})
+		config := DefaultConfig()
+		config.Store = store
+		return NewWalletHandlerWithConfig(nil, newWriteCache(), false, config), store
+	}
+
+	poll := func(handler *WalletHandler, query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/wallets/"+walletID.String()+"/balance/poll?"+query, nil)
+		w := httptest.NewRecorder()
+		handler.HandleBalancePoll(w, req)
+		return w
+	}
+
+	t.Run("Версия уже новее - ответ сразу", func(t *testing.T) {
+		handler, _ := newPollHandler()
+
+		start := time.Now()
+		w := poll(handler, "since_version=2&timeout=5s")
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Less(t, time.Since(start), time.Second)
+		assert.Equal(t, "3", w.Header().Get(balanceVersionHeader))
+		assert.JSONEq(t, `{"balance": "10.00", "balance_minor": 1000}`, w.Body.String())
+	})
+
+	t.Run("Без изменений - текущий баланс по таймауту", func(t *testing.T) {
+		handler, _ := newPollHandler()
+
+		start := time.Now()
+		w := poll(handler, "since_version=3&timeout=50ms")
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+		assert.Equal(t, "3", w.Header().Get(balanceVersionHeader))
+	})
+
+	t.Run("Операция над кошельком завершает ожидание", func(t *testing.T) {
+		handler, store := newPollHandler()
+
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			handler.executeOperation(context.Background(), &wallet.WalletRequest{
+				WalletID: walletID.String(), OperationType: wallet.DEPOSIT, Amount: 5,
+			})
+		}()
+		start := time.Now()
+		w := poll(handler, "since_version=3&timeout=5s")
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Less(t, time.Since(start), time.Second)
+		assert.Equal(t, "4", w.Header().Get(balanceVersionHeader))
+		assert.JSONEq(t, `{"balance": "15.00", "balance_minor": 1500}`, w.Body.String())
+		stored, _ := store.GetBalance(context.Background(), walletID)
+		assert.Equal(t, int64(4), stored.Version)
+	})
+
+	t.Run("Неверные параметры - 422", func(t *testing.T) {
+		handler, _ := newPollHandler()
+		for _, query := range []string{"", "since_version=-1", "since_version=1&timeout=abc", "since_version=1&timeout=5m"} {
+			assert.Equal(t, http.StatusUnprocessableEntity, poll(handler, query).Code, query)
+		}
+	})
+}
+
+// Тест сверки сохранённых балансов с журналом операций
+func TestInvariantCheck(t *testing.T) {
+	consistent, broken, last := uuid.New(), uuid.New(), uuid.New()
+
+	newInvariantHandler := func(mockDB *MockDB, mockCache *MockCache) *WalletHandler {
+		config := DefaultConfig()
+		config.AdminToken = "secret"
+		config.InvariantBatchSize = 2
+		config.AuditSink = &memoryAuditSink{entries: make(chan audit.Entry, 1)}
+		return NewWalletHandlerWithConfig(mockDB, mockCache, false, config)
+	}
+
+	check := func(handler *WalletHandler, method string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/v1/admin/invariant", nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		handler.HandleInvariantCheck(w, req)
+		return w
+	}
+
+	// Два пакета: полный из двух кошельков и неполный, после которого обход заканчивается
+	expectBatches := func(mockDB *MockDB) {
+		mockDB.On("QueryContext", mock.Anything, invariantBatchQuery, []interface{}{uuid.Nil, 2}).
+			Return(&MockRows{rows: [][]interface{}{{consistent, 100.0, 100.0}, {broken, 50.0, 80.25}}}, nil).Once()
+		mockDB.On("QueryContext", mock.Anything, invariantBatchQuery, []interface{}{broken, 2}).
+			Return(&MockRows{rows: [][]interface{}{{last, 0.1 + 0.2, 0.3}}}, nil).Once()
+	}
+
+	t.Run("Расхождение попадает в отчёт", func(t *testing.T) {
+		mockDB := new(MockDB)
+		expectBatches(mockDB)
+		handler := newInvariantHandler(mockDB, new(MockCache))
+
+		w := check(handler, http.MethodGet)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var report InvariantReport
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+		assert.Equal(t, InvariantReport{
+			Checked:    3,
+			Mismatched: 1,
+			Mismatches: []InvariantMismatch{{
+				WalletID: broken,
+				Balance:  newBalance(50),
+				Ledger:   newBalance(80.25),
+				Delta:    newBalanceMinor(3025),
+			}},
+		}, report)
+		mockDB.AssertExpectations(t)
+		mockDB.AssertNotCalled(t, "BeginTx", mock.Anything)
+	})
+
+	t.Run("POST исправляет расхождение", func(t *testing.T) {
+		mockDB := new(MockDB)
+		expectBatches(mockDB)
+		mockTx := new(MockTx)
+		mockDB.On("BeginTx", mock.Anything).Return(mockTx, nil).Once()
+		lockRow := new(MockRow)
+		lockRow.On("Scan", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+			*args.Get(0).(*float64) = 50
+		}).Return(nil).Once()
+		mockTx.On("QueryRowContext", mock.Anything, selectBalanceForUpdateQuery, []interface{}{broken}).Return(lockRow).Once()
+		sumRow := new(MockRow)
+		sumRow.On("Scan", mock.Anything).Run(func(args mock.Arguments) {
+			*args.Get(0).(*float64) = 80.25
+		}).Return(nil).Once()
+		mockTx.On("QueryRowContext", mock.Anything, ledgerBalanceQuery, []interface{}{broken}).Return(sumRow).Once()
+		mockTx.On("ExecContext", mock.Anything, updateBalanceQuery, []interface{}{80.25, broken}).Return(&MockResult{}, nil).Once()
+		mockTx.On("Commit").Return(nil).Once()
+		mockTx.On("Rollback").Return(nil).Maybe()
+		mockCache := new(MockCache)
+		mockCache.On("Delete", mock.Anything, fmt.Sprintf("balance:%s", broken)).Return(nil).Once()
+		handler := newInvariantHandler(mockDB, mockCache)
+
+		w := check(handler, http.MethodPost)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var report InvariantReport
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+		assert.Equal(t, 1, report.Fixed)
+		require.Len(t, report.Mismatches, 1)
+		assert.True(t, report.Mismatches[0].Fixed)
+		mockTx.AssertExpectations(t)
+		mockCache.AssertExpectations(t)
+
+		entry := <-handler.auditEntries
+		assert.Equal(t, AuditActionRebuild, entry.Action)
+		assert.Equal(t, broken.String(), entry.WalletID)
+		assert.Equal(t, 30.25, entry.Amount)
+	})
+
+	t.Run("В режиме ledger - 409", func(t *testing.T) {
+		mockDB := new(MockDB)
+		config := DefaultConfig()
+		config.AdminToken = "secret"
+		config.BalanceMode = BalanceModeLedger
+		handler := NewWalletHandlerWithConfig(mockDB, new(MockCache), false, config)
+
+		assert.Equal(t, http.StatusConflict, check(handler, http.MethodGet).Code)
+		mockDB.AssertNotCalled(t, "QueryContext", mock.Anything, mock.Anything, mock.Anything)
+	})
+}
+
+// Тесты именования полей ответа
+func TestJSONNaming(t *testing.T) {
+	walletID := uuid.New()
+	cacheKey := fmt.Sprintf("balance:%s", walletID)
+
+	getBalance := func(t *testing.T, config Config) map[string]interface{} {
+		mockCache := new(MockCache)
+		mockCache.On("Get", mock.Anything, cacheKey).Return("100.5", nil).Once()
+
+		handler := NewWalletHandlerWithConfig(new(MockDB), mockCache, false, config)
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/api/v1/wallets/"+walletID.String(), nil)
+		req.Header.Set("X-Request-ID", "req-7")
+
+		handler.GetWalletBalance(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var body map[string]interface{}
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&body))
+		mockCache.AssertExpectations(t)
+		return body
+	}
+
+	t.Run("snake_case по умолчанию", func(t *testing.T) {
+		body := getBalance(t, DefaultConfig())
+
+		assert.Equal(t, map[string]interface{}{
+			"balance":       "100.50",
+			"balance_minor": float64(10050),
+		}, body)
+	})
+
+	t.Run("camelCase", func(t *testing.T) {
+		config := DefaultConfig()
+		config.JSONNaming = JSONNamingCamel
+		body := getBalance(t, config)
+
+		assert.Equal(t, map[string]interface{}{
+			"balance":      "100.50",
+			"balanceMinor": float64(10050),
+		}, body)
+	})
+
+	t.Run("camelCase в обёртке", func(t *testing.T) {
+		config := DefaultConfig()
+		config.JSONNaming = JSONNamingCamel
+		config.ResponseEnvelope = true
+		body := getBalance(t, config)
+
+		assert.Equal(t, map[string]interface{}{
+			"balance":      "100.50",
+			"balanceMinor": float64(10050),
+		}, body["data"])
+		meta := body["meta"].(map[string]interface{})
+		assert.Equal(t, "req-7", meta["requestId"])
+		assert.NotContains(t, meta, "request_id")
+	})
+
+	t.Run("Имена полей", func(t *testing.T) {
+		assert.Equal(t, "walletId", camelCase("wallet_id"))
+		assert.Equal(t, "balanceBeforeMinor", camelCase("balance_before_minor"))
+		assert.Equal(t, "status", camelCase("status"))
+	})
+}
+
+// Тесты дозаписи буферов при остановке
+func TestShutdownFlush(t *testing.T) {
+	t.Run("Запись из очереди выполняется после отмены", func(t *testing.T) {
+		var setErr error
+		mockCache := new(MockCache)
+		mockCache.On("Set", mock.Anything, "balance:1", 1.0, balanceCacheTTL).
+			Run(func(args mock.Arguments) { setErr = args.Get(0).(context.Context).Err() }).
+			Return(nil).Once()
+		handler := NewWalletHandler(new(MockDB), mockCache, false)
+		handler.enqueueCacheWrite("balance:1", 1.0, balanceCacheTTL)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			handler.RunCacheWriter(ctx)
+		}()
+
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("RunCacheWriter не завершился после дозаписи")
+		}
+		mockCache.AssertExpectations(t)
+		assert.NoError(t, setErr, "дозапись не должна прерываться отменой ctx")
+		assert.Empty(t, handler.cacheWrites)
+	})
+
+	t.Run("Дозапись ограничена ShutdownFlushTimeout", func(t *testing.T) {
+		config := DefaultConfig()
+		config.ShutdownFlushTimeout = 20 * time.Millisecond
+		handler := NewWalletHandlerWithConfig(new(MockDB), new(MockCache), false, config)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		flushCtx, release := handler.flushContext(ctx)
+		defer release()
+
+		cancel()
+		assert.NoError(t, flushCtx.Err())
+		assert.Eventually(t, func() bool { return flushCtx.Err() != nil }, time.Second, 5*time.Millisecond)
+	})
+}
+
+// Тесты кодов ошибок полей в ответе 422
+func TestValidationFieldCodes(t *testing.T) {
+	walletID := uuid.New().String()
+
+	tests := []struct {
+		name  string
+		body  string
+		field string
+		code  string
+	}{
+		{"Пустой wallet_id", `{"operation_type":"DEPOSIT","amount":10}`, "wallet_id", "wallet_id.empty"},
+		{"Отрицательная сумма", `{"wallet_id":"` + walletID + `","operation_type":"DEPOSIT","amount":-5}`, "amount", "amount.negative"},
+		{"Неизвестный тип операции", `{"wallet_id":"` + walletID + `","operation_type":"TRANSFER","amount":10}`, "operation_type", "operation_type.invalid"},
+		{"Длинный комментарий", `{"wallet_id":"` + walletID + `","operation_type":"DEPOSIT","amount":10,"reference":"` + strings.Repeat("x", service.MaxReferenceLength+1) + `"}`, "reference", "reference.too_long"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := NewWalletHandler(new(MockDB), new(MockCache), false)
+			w := httptest.NewRecorder()
+
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/wallet", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+
+			handler.HandleWalletOperation(w, req)
+
+			assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+			var body ErrorResponse
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+			require.Len(t, body.Fields, 1)
+			assert.Equal(t, tt.field, body.Fields[0].Field)
+			assert.Equal(t, tt.code, body.Fields[0].Code)
+			assert.Equal(t, body.Error, body.Fields[0].Message)
+		})
+	}
+
+	t.Run("Ошибка без поля не содержит fields", func(t *testing.T) {
+		handler := NewWalletHandler(new(MockDB), new(MockCache), false)
+		w := httptest.NewRecorder()
+
+		handler.writeErr(w, httptest.NewRequest(http.MethodGet, "/", nil), service.ErrInsufficientFunds)
+
+		assert.NotContains(t, w.Body.String(), `"fields"`)
+	})
+}
+
+// Тесты валюты и числа знаков в ответах с балансом
+func TestBalanceDisplay(t *testing.T) {
+	walletID := uuid.New()
+	cacheKey := fmt.Sprintf("balance:%s", walletID)
+
+	getBalance := func(t *testing.T, displayCurrency string) map[string]interface{} {
+		mockCache := new(MockCache)
+		mockCache.On("Get", mock.Anything, cacheKey).Return("1500", nil).Once()
+
+		config := DefaultConfig()
+		config.DisplayCurrency = displayCurrency
+		handler := NewWalletHandlerWithConfig(new(MockDB), mockCache, false, config)
+		w := httptest.NewRecorder()
+
+		handler.GetWalletBalance(w, httptest.NewRequest("GET", "/api/v1/wallets/"+walletID.String(), nil))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var body map[string]interface{}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&body))
+		return body
+	}
+
+	for _, tt := range []struct {
+		currency string
+		unit     string
+		scale    float64
+		balance  string
+		minor    float64
+	}{
+		{"JPY", "¥", 0, "1500", 1500},
+		{"USD", "$", 2, "1500.00", 150000},
+		{"KWD", "KD", 3, "1500.000", 1500000},
+	} {
+		t.Run(tt.currency, func(t *testing.T) {
+			body := getBalance(t, tt.currency)
+			assert.Equal(t, tt.currency, body["currency"])
+			assert.Equal(t, tt.unit, body["unit"])
+			assert.Equal(t, tt.scale, body["scale"])
+			// Строка и минимальные единицы записаны с точностью валюты
+			assert.Equal(t, tt.balance, body["balance"])
+			assert.Equal(t, tt.minor, body["balance_minor"])
+		})
+	}
+
+	t.Run("Без валюты поля не добавляются", func(t *testing.T) {
+		body := getBalance(t, "")
+		assert.NotContains(t, body, "scale")
+		assert.NotContains(t, body, "currency")
+	})
+
+	t.Run("Сумма балансов", func(t *testing.T) {
+		mockDB := new(MockDB)
+		mockRow := new(MockRow)
+		mockRow.On("Scan", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+			*args.Get(0).(*int64) = 3
+			*args.Get(1).(*int64) = 150000
+		}).Return(nil).Once()
+		mockDB.On("QueryRowContext", mock.Anything, totalsQuery, nil).Return(mockRow).Once()
+
+		config := DefaultConfig()
+		config.AdminToken = "secret"
+		config.DisplayCurrency = "JPY"
+		handler := NewWalletHandlerWithConfig(mockDB, new(MockCache), false, config)
+		req := httptest.NewRequest("GET", "/api/v1/admin/totals", nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+
+		handler.HandleTotals(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var totals Totals
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&totals))
+		require.NotNil(t, totals.Total.BalanceDisplay)
+		assert.Equal(t, BalanceDisplay{Currency: "JPY", Unit: "¥", Scale: 0}, *totals.Total.BalanceDisplay)
+		assert.Equal(t, "1500", totals.Total.Balance)
+		assert.Equal(t, int64(1500), totals.Total.BalanceMinor)
+	})
+}
+
+// Тесты объединения фоновых записей в кэш в один конвейер
+func TestCacheWritePipelining(t *testing.T) {
+	t.Run("Записи за окно уходят одним SetMany", func(t *testing.T) {
+		written := make(chan []cache.Entry, 1)
+		mockCache := new(MockCache)
+		mockCache.On("SetMany", mock.Anything, mock.Anything).
+			Run(func(args mock.Arguments) { written <- args.Get(1).([]cache.Entry) }).
+			Return(nil).Once()
+
+		config := DefaultConfig()
+		config.CacheWriteWorkers = 1
+		config.CacheWriteBatchWindow = 50 * time.Millisecond
+		handler := NewWalletHandlerWithConfig(new(MockDB), mockCache, false, config)
+		for i := 1; i <= 3; i++ {
+			handler.enqueueCacheWrite(fmt.Sprintf("balance:%d", i), float64(i), balanceCacheTTL)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go handler.RunCacheWriter(ctx)
+
+		select {
+		case entries := <-written:
+			assert.Equal(t, []cache.Entry{
+				{Key: "balance:1", Value: 1.0, TTL: balanceCacheTTL},
+				{Key: "balance:2", Value: 2.0, TTL: balanceCacheTTL},
+				{Key: "balance:3", Value: 3.0, TTL: balanceCacheTTL},
+			}, entries)
+		case <-time.After(time.Second):
+			t.Fatal("пакет записей не отправлен")
+		}
+		mockCache.AssertNotCalled(t, "Set", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("Пакет ограничен CacheWriteBatchSize", func(t *testing.T) {
+		config := DefaultConfig()
+		config.CacheWriteBatchWindow = time.Second
+		config.CacheWriteBatchSize = 2
+		handler := NewWalletHandlerWithConfig(new(MockDB), new(MockCache), false, config)
+		handler.enqueueCacheWrite("balance:2", 2.0, balanceCacheTTL)
+		handler.enqueueCacheWrite("balance:3", 3.0, balanceCacheTTL)
+
+		batch := handler.collectCacheWrites(context.Background(), cacheWrite{key: "balance:1", value: 1.0, ttl: balanceCacheTTL})
+
+		assert.Len(t, batch, 2)
+		assert.Len(t, handler.cacheWrites, 1)
+	})
+}
+
+// Тесты срока запроса из заголовка X-Request-Timeout
+func TestRequestTimeoutHeader(t *testing.T) {
+	config := DefaultConfig()
+	config.MaxRequestTimeout = 10 * time.Second
+	handler := NewWalletHandlerWithConfig(new(MockDB), new(MockCache), false, config)
+
+	tests := []struct {
+		name     string
+		header   string
+		expected time.Duration
+	}{
+		{"Без заголовка", "", defaultReadTimeout},
+		{"Срок клиента", "2s", 2 * time.Second},
+		{"Миллисекунды", "250ms", 250 * time.Millisecond},
+		{"Больше максимума", "1m", 10 * time.Second},
+		{"Неверное значение", "soon", defaultReadTimeout},
+		{"Отрицательное значение", "-1s", defaultReadTimeout},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			if tt.header != "" {
+				req.Header.Set(requestTimeoutHeader, tt.header)
+			}
+			assert.Equal(t, tt.expected, handler.requestTimeout(req, defaultReadTimeout))
+		})
+	}
+
+	balanceDeadline := func(t *testing.T, header string) time.Duration {
+		walletID := uuid.New()
+		var deadline time.Time
+		mockCache := new(MockCache)
+		mockCache.On("Get", mock.Anything, "balance:"+walletID.String()).
+			Run(func(args mock.Arguments) { deadline, _ = args.Get(0).(context.Context).Deadline() }).
+			Return("100.0", nil).Once()
+		handler := NewWalletHandlerWithConfig(new(MockDB), mockCache, false, config)
+		req := httptest.NewRequest("GET", "/api/v1/wallets/"+walletID.String(), nil)
+		req.Header.Set(requestTimeoutHeader, header)
+
+		start := time.Now()
+		handler.GetWalletBalance(httptest.NewRecorder(), req)
+
+		require.False(t, deadline.IsZero())
+		return deadline.Sub(start)
+	}
+
+	t.Run("Срок клиента применяется к чтению баланса", func(t *testing.T) {
+		assert.InDelta(t, float64(200*time.Millisecond), float64(balanceDeadline(t, "200ms")), float64(50*time.Millisecond))
+	})
+
+	t.Run("Срок больше максимума сокращается", func(t *testing.T) {
+		assert.InDelta(t, float64(10*time.Second), float64(balanceDeadline(t, "1h")), float64(50*time.Millisecond))
+	})
+
+	t.Run("Истёкший срок операции даёт 504", func(t *testing.T) {
+		walletID := uuid.New()
+		mockDB := new(MockDB)
+		mockDB.On("BeginTx", mock.Anything).
+			Run(func(args mock.Arguments) { <-args.Get(0).(context.Context).Done() }).
+			Return(new(MockTx), context.DeadlineExceeded).Once()
+		mockCache := new(MockCache)
+		mockCache.On("Get", mock.Anything, mock.Anything).Return("", redis.Nil).Maybe()
+		handler := NewWalletHandlerWithConfig(mockDB, mockCache, true, config)
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/wallet",
+			strings.NewReader(`{"wallet_id":"`+walletID.String()+`","operation_type":"DEPOSIT","amount":10}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(requestTimeoutHeader, "50ms")
+		w := httptest.NewRecorder()
+
+		start := time.Now()
+		handler.HandleWalletOperation(w, req)
+
+		assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+		assert.Less(t, time.Since(start), time.Second)
+	})
+}
+
+// Тесты сброса кэша баланса администратором
+func TestCacheFlush(t *testing.T) {
+	config := DefaultConfig()
+	config.AdminToken = "secret"
+	walletID := uuid.New()
+	cacheKey := "balance:" + walletID.String()
+
+	flushRequest := func(token string) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/admin/wallets/"+walletID.String()+"/cache/flush", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		return req
+	}
+
+	for _, tt := range []struct {
+		name    string
+		getErr  error
+		existed bool
+	}{
+		{"Запись была", nil, true},
+		{"Записи не было", redis.Nil, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			mockCache := new(MockCache)
+			mockCache.On("Get", mock.Anything, cacheKey).Return("100.0", tt.getErr).Once()
+			mockCache.On("Delete", mock.Anything, cacheKey).Return(nil).Once()
+			handler := NewWalletHandlerWithConfig(new(MockDB), mockCache, false, config)
+			w := httptest.NewRecorder()
+
+			handler.HandleCacheFlush(w, flushRequest("secret"))
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			var result CacheFlushResult
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&result))
+			assert.Equal(t, CacheFlushResult{WalletID: walletID, Existed: tt.existed}, result)
+			mockCache.AssertExpectations(t)
+		})
+	}
+
+	t.Run("Без токена администратора", func(t *testing.T) {
+		mockCache := new(MockCache)
+		handler := NewWalletHandlerWithConfig(new(MockDB), mockCache, false, config)
+		w := httptest.NewRecorder()
+
+		handler.HandleCacheFlush(w, flushRequest(""))
+
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		mockCache.AssertNotCalled(t, "Delete", mock.Anything, mock.Anything)
+	})
+
+	t.Run("Кэш недоступен", func(t *testing.T) {
+		mockCache := new(MockCache)
+		mockCache.On("Get", mock.Anything, cacheKey).Return("", errors.New("connection refused")).Once()
+		handler := NewWalletHandlerWithConfig(new(MockDB), mockCache, false, config)
+		w := httptest.NewRecorder()
+
+		handler.HandleCacheFlush(w, flushRequest("secret"))
+
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		assert.Equal(t, ErrCacheFlush, errorMessage(t, w))
+	})
+}
+
+// Тесты записи операций в журнал: одна запись на операцию, сумма со знаком направления
+func TestOperationJournal(t *testing.T) {
+	for name, newStore := range map[string]func() *MemoryStore{
+		"Баланс в колонке":  NewMemoryStore,
+		"Баланс по журналу": NewMemoryLedgerStore,
+	} {
+		t.Run(name, func(t *testing.T) {
+			store := newStore()
+			id := uuid.New()
+			store.Put(id, StoredWallet{})
+			config := DefaultConfig()
+			config.Store = store
+			handler := NewWalletHandlerWithConfig(nil, newWriteCache(), false, config)
+
+			for _, req := range []wallet.WalletRequest{
+				{WalletID: id.String(), OperationType: wallet.DEPOSIT, Amount: 100},
+				{WalletID: id.String(), OperationType: wallet.WITHDRAW, Amount: 30},
+			} {
+				assert.Nil(t, handler.handleOperation(context.Background(), &req))
+			}
+
+			transactions := store.Transactions(id)
+			require.Len(t, transactions, 2)
+			assert.Equal(t, 100.0, transactions[0].Amount)
+			assert.Equal(t, wallet.DEPOSIT, transactions[0].OperationType)
+			assert.Equal(t, -30.0, transactions[1].Amount)
+			assert.Equal(t, wallet.WITHDRAW, transactions[1].OperationType)
+
+			stored, err := store.GetBalance(context.Background(), id)
+			require.NoError(t, err)
+			assert.Equal(t, 70.0, stored.Balance)
+		})
+	}
+
+	t.Run("Списание в PostgreSQL - одна транзакция и одна запись", func(t *testing.T) {
+		walletID := uuid.New()
+		lockRow := new(MockRow)
+		lockRow.On("Scan", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+			*args.Get(0).(*float64) = 100
+		}).Return(nil).Once()
+		mockTx := new(MockTx)
+		mockTx.On("QueryRowContext", mock.Anything, selectBalanceForUpdateQuery, mock.Anything).Return(lockRow).Once()
+		mockTx.On("ExecContext", mock.Anything, updateBalanceQuery, []interface{}{70.0, walletID}).Return(new(MockResult), nil).Once()
+		mockTx.On("ExecContext", mock.Anything, insertTransactionQuery, recordedTransaction(walletID, -30, wallet.WITHDRAW, "")).
+			Return(new(MockResult), nil).Once()
+		mockTx.On("Commit").Return(nil).Once()
+		mockTx.On("Rollback").Return(nil).Once()
+		mockDB := new(MockDB)
+		mockDB.On("BeginTx", mock.Anything).Return(mockTx, nil).Once()
+		handler := NewWalletHandler(mockDB, newWriteCache(), false)
+
+		walletErr := handler.handleOperation(context.Background(), &wallet.WalletRequest{
+			WalletID: walletID.String(), OperationType: wallet.WITHDRAW, Amount: 30,
+		})
+
+		assert.Nil(t, walletErr)
+		mockDB.AssertExpectations(t)
+		mockTx.AssertExpectations(t)
+	})
+}
+
+// metricCall - один вызов fakeMetrics
+type metricCall struct {
+	Method string
+	Name   string
+	Value  float64
+	Labels metrics.Labels
+}
+
+// fakeMetrics запоминает вызовы в порядке поступления
+type fakeMetrics struct {
+	mu    sync.Mutex
+	calls []metricCall
+}
+
+func (m *fakeMetrics) record(call metricCall) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.calls = append(m.calls, call)
+}
+
+func (m *fakeMetrics) IncCounter(name string, labels metrics.Labels) {
+	m.record(metricCall{Method: "IncCounter", Name: name, Value: 1, Labels: labels})
+}
+
+func (m *fakeMetrics) ObserveHistogram(name string, value float64, labels metrics.Labels) {
+	m.record(metricCall{Method: "ObserveHistogram", Name: name, Value: value, Labels: labels})
+}
+
+func (m *fakeMetrics) SetGauge(name string, value float64, labels metrics.Labels) {
+	m.record(metricCall{Method: "SetGauge", Name: name, Value: value, Labels: labels})
+}
+
+func (m *fakeMetrics) Calls() []metricCall {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]metricCall(nil), m.calls...)
+}
+
+// Операции учитываются в подключённых метриках, пробный запуск - нет
+func TestMetrics(t *testing.T) {
+	newHandler := func() (*WalletHandler, *fakeMetrics, uuid.UUID) {
+		store := NewMemoryStore()
+		id := uuid.New()
+		store.Put(id, StoredWallet{})
+		recorder := new(fakeMetrics)
+		// Время стоит на месте, поэтому длительность операции равна нулю
+		clock := newFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+		config := DefaultConfig()
+		config.Store = store
+		config.Metrics = recorder
+		config.Clock = clock
+		return NewWalletHandlerWithConfig(nil, newWriteCache(), false, config), recorder, id
+	}
+
+	t.Run("Пополнение и неудачное списание", func(t *testing.T) {
+		handler, recorder, id := newHandler()
+
+		assert.Nil(t, handler.handleOperation(context.Background(), &wallet.WalletRequest{
+			WalletID: id.String(), OperationType: wallet.DEPOSIT, Amount: 100,
+		}))
+		assert.NotNil(t, handler.handleOperation(context.Background(), &wallet.WalletRequest{
+			WalletID: id.String(), OperationType: wallet.WITHDRAW, Amount: 500,
+		}))
+
+		success := metrics.Labels{"operation_type": "DEPOSIT", "result": audit.ResultSuccess}
+		failure := metrics.Labels{"operation_type": "WITHDRAW", "result": audit.ResultFailure}
+		assert.Equal(t, []metricCall{
+			{Method: "IncCounter", Name: MetricOperationsTotal, Value: 1, Labels: success},
+			{Method: "ObserveHistogram", Name: MetricOperationDuration, Value: 0, Labels: success},
+			{Method: "IncCounter", Name: MetricOperationsTotal, Value: 1, Labels: failure},
+			{Method: "ObserveHistogram", Name: MetricOperationDuration, Value: 0, Labels: failure},
+		}, recorder.Calls())
+	})
+
+	t.Run("Пробный запуск не учитывается", func(t *testing.T) {
+		handler, recorder, id := newHandler()
+		assert.Nil(t, handler.handleOperation(context.Background(), &wallet.WalletRequest{
+			WalletID: id.String(), OperationType: wallet.DEPOSIT, Amount: 100, DryRun: true,
+		}))
+		assert.Empty(t, recorder.Calls())
+	})
+
+	t.Run("Длина очереди после постановки", func(t *testing.T) {
+		mockCache := new(MockCache)
+		expectNotBlocked(mockCache)
+		length := redis.NewIntCmd(context.Background())
+		length.SetVal(4)
+		mockCache.On("LPush", mock.Anything, operationsQueueKey, mock.Anything).Return(length).Once()
+		recorder := new(fakeMetrics)
+		config := DefaultConfig()
+		config.Metrics = recorder
+		handler := NewWalletHandlerWithConfig(new(MockDB), mockCache, false, config)
+
+		body, _ := json.Marshal(wallet.WalletRequest{
+			WalletID:      uuid.New().String(),
+			OperationType: wallet.DEPOSIT,
+			Amount:        100,
+		})
+		w := httptest.NewRecorder()
+		handler.HandleWalletOperation(w, newJSONRequest(body))
+
+		assert.Equal(t, http.StatusAccepted, w.Code)
+		assert.Equal(t, []metricCall{
+			{Method: "SetGauge", Name: MetricQueueLength, Value: 4, Labels: metrics.Labels{"queue": operationsQueueKey}},
+		}, recorder.Calls())
+		mockCache.AssertExpectations(t)
+	})
+}
+
+// В строгом режиме неизвестный тип операции отклоняется при декодировании,
+// до проверки остальных полей
+func TestStrictOperationTypes(t *testing.T) {
+	// Пустой wallet_id: без строгого режима валидация сообщит о нём первым
+	const body = `{"operation_type":"TRANSFER","amount":10}`
+
+	send := func(strict bool) ErrorResponse {
+		config := DefaultConfig()
+		config.StrictOperationTypes = strict
+		handler := NewWalletHandlerWithConfig(new(MockDB), new(MockCache), false, config)
+		w := httptest.NewRecorder()
+		handler.HandleWalletOperation(w, newJSONRequest([]byte(body)))
+
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		var response ErrorResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		return response
+	}
+
+	t.Run("Строгий режим", func(t *testing.T) {
+		response := send(true)
+		assert.Equal(t, service.CodeUnknownOperationType, response.Code)
+		assert.Contains(t, response.Error, "TRANSFER")
+		assert.Equal(t, []FieldError{{Field: service.FieldOperationType, Code: service.FieldCodeOperationType, Message: response.Error}}, response.Fields)
+	})
+
+	t.Run("Без строгого режима", func(t *testing.T) {
+		response := send(false)
+		require.Len(t, response.Fields, 1)
+		assert.Equal(t, service.FieldWalletID, response.Fields[0].Field)
+	})
+}
+
+// Квитанции выполненных операций: выдача, проверка и отказ для изменённых
+func TestReceipts(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	newHandler := func(key string) (*WalletHandler, uuid.UUID) {
+		store := NewMemoryStore()
+		id := uuid.New()
+		store.Put(id, StoredWallet{Balance: 50})
+		config := DefaultConfig()
+		config.Store = store
+		config.Clock = newFakeClock(now)
+		config.ReceiptKey = key
+		mockCache := newWriteCache()
+		expectNotBlocked(mockCache)
+		return NewWalletHandlerWithConfig(nil, mockCache, true, config), id
+	}
+	verify := func(handler *WalletHandler, receipt string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(ReceiptVerifyRequest{Receipt: receipt})
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/receipts/verify", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		handler.HandleReceiptVerify(w, req)
+		return w
+	}
+	deposit := func(handler *WalletHandler, id uuid.UUID) map[string]interface{} {
+		body, _ := json.Marshal(wallet.WalletRequest{WalletID: id.String(), OperationType: wallet.DEPOSIT, Amount: 25})
+		w := httptest.NewRecorder()
+		handler.HandleWalletOperation(w, newJSONRequest(body))
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var response map[string]interface{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		return response
+	}
+
+	handler, id := newHandler("secret")
+	receipt, ok := deposit(handler, id)["receipt"].(string)
+	require.True(t, ok)
+
+	t.Run("Подлинная квитанция", func(t *testing.T) {
+		w := verify(handler, receipt)
+		require.Equal(t, http.StatusOK, w.Code)
+		var decoded Receipt
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &decoded))
+		assert.Equal(t, Receipt{
+			WalletID:      id.String(),
+			OperationType: wallet.DEPOSIT,
+			Amount:        25,
+			Balance:       75,
+			IssuedAt:      now,
+		}, decoded)
+	})
+
+	t.Run("Изменённая сумма", func(t *testing.T) {
+		payload, signature, _ := strings.Cut(receipt, ".")
+		raw, err := base64.RawURLEncoding.DecodeString(payload)
+		require.NoError(t, err)
+		tampered := strings.Replace(string(raw), `"amount":25`, `"amount":2500`, 1)
+		require.NotEqual(t, string(raw), tampered)
+
+		w := verify(handler, base64.RawURLEncoding.EncodeToString([]byte(tampered))+"."+signature)
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		assert.Equal(t, ErrInvalidReceipt, errorMessage(t, w))
+	})
+
+	t.Run("Подпись другим ключом", func(t *testing.T) {
+		other, otherID := newHandler("other")
+		foreign := deposit(other, otherID)["receipt"].(string)
+		assert.Equal(t, http.StatusUnprocessableEntity, verify(handler, foreign).Code)
+	})
+
+	t.Run("Не квитанция", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnprocessableEntity, verify(handler, "garbage").Code)
+	})
+
+	t.Run("Квитанции отключены", func(t *testing.T) {
+		disabled, disabledID := newHandler("")
+		assert.NotContains(t, deposit(disabled, disabledID), "receipt")
+		assert.Equal(t, http.StatusNotImplemented, verify(disabled, receipt).Code)
+	})
+
+	t.Run("Итог операции из очереди", func(t *testing.T) {
+		result, err := handler.ProcessQueueOperation(wallet.WalletRequest{
+			ID: "op-1", WalletID: id.String(), OperationType: wallet.WITHDRAW, Amount: 5,
+		})
+		require.NoError(t, err)
+		decoded, err := handler.verifyReceipt(result.Receipt)
+		require.NoError(t, err)
+		assert.Equal(t, "op-1", decoded.OperationID)
+		assert.Equal(t, 70.0, decoded.Balance)
+	})
+}
+
+// Срок действия операции ограничен горизонтом, а срок чуть в прошлом
+// принимается в пределах отставания часов клиента
+func TestScheduleHorizon(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	newHandler := func(cache *listCache) *WalletHandler {
+		config := DefaultConfig()
+		config.Clock = newFakeClock(now)
+		config.MaxScheduleHorizon = 24 * time.Hour
+		config.ClockSkew = 2 * time.Second
+		return NewWalletHandlerWithConfig(new(MockDB), cache, false, config)
+	}
+	enqueue := func(handler *WalletHandler, expiresAt time.Time) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(map[string]interface{}{
+			"wallet_id":      uuid.New().String(),
+			"operation_type": "DEPOSIT",
+			"amount":         1,
+			"expires_at":     expiresAt,
+		})
+		w := httptest.NewRecorder()
+		handler.HandleWalletOperation(w, newJSONRequest(body))
+		return w
+	}
+
+	tests := []struct {
+		name      string
+		expiresAt time.Time
+		status    int
+		message   string
+	}{
+		{"Ровно на горизонте", now.Add(24 * time.Hour), http.StatusAccepted, ""},
+		{"Сразу за горизонтом", now.Add(24*time.Hour + time.Millisecond), http.StatusUnprocessableEntity, ErrExpiresAtTooFar},
+		{"Год 3000", time.Date(3000, 1, 1, 0, 0, 0, 0, time.UTC), http.StatusUnprocessableEntity, ErrExpiresAtTooFar},
+		{"Чуть в прошлом - в пределах отставания часов", now.Add(-time.Second), http.StatusAccepted, ""},
+		{"На границе отставания часов", now.Add(-2 * time.Second), http.StatusUnprocessableEntity, ErrInvalidExpiresAt},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := newListCache()
+			w := enqueue(newHandler(cache), tt.expiresAt)
+
+			assert.Equal(t, tt.status, w.Code, w.Body.String())
+			if tt.message != "" {
+				assert.Equal(t, tt.message, errorMessage(t, w))
+				assert.Empty(t, cache.lists[operationsQueueKey])
+			} else {
+				assert.Len(t, cache.lists[operationsQueueKey], 1)
+			}
+		})
+	}
+
+	t.Run("Нулевой горизонт снимает ограничение", func(t *testing.T) {
+		handler := newHandler(newListCache())
+		handler.config.MaxScheduleHorizon = 0
+		assert.Equal(t, http.StatusAccepted, enqueue(handler, time.Date(3000, 1, 1, 0, 0, 0, 0, time.UTC)).Code)
+	})
+}
+
+// sqlDB - DBInterface поверх *sql.DB для тестов запросов со sqlmock
+type sqlDB struct {
+	*sql.DB
+}
+
+func (d sqlDB) QueryRowContext(ctx context.Context, query string, args ...interface{}) RowScanner {
+	return d.DB.QueryRowContext(ctx, query, args...)
+}
+
+func (d sqlDB) QueryContext(ctx context.Context, query string, args ...interface{}) (RowsInterface, error) {
+	rows, err := d.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+func (d sqlDB) BeginTx(ctx context.Context) (TxInterface, error) {
+	tx, err := d.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return sqlTx{tx}, nil
+}
+
+type sqlTx struct {
+	*sql.Tx
+}
+
+func (t sqlTx) QueryRowContext(ctx context.Context, query string, args ...any) RowInterface {
+	return t.Tx.QueryRowContext(ctx, query, args...)
+}
+
+func (t sqlTx) ExecContext(ctx context.Context, query string, args ...interface{}) (ResultInterface, error) {
+	return t.Tx.ExecContext(ctx, query, args...)
+}
+
+// Запросы WalletRepository к PostgreSQL
+func TestWalletRepository(t *testing.T) {
+	walletID := uuid.New()
+	ctx := context.Background()
+
+	newRepository := func(t *testing.T) (*WalletRepository, sqlmock.Sqlmock) {
+		db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		require.NoError(t, err)
+		t.Cleanup(func() { db.Close() })
+		return NewWalletRepository(sqlDB{db}), mock
+	}
+	begin := func(t *testing.T, repository *WalletRepository, mock sqlmock.Sqlmock) TxInterface {
+		mock.ExpectBegin()
+		tx, err := repository.db.BeginTx(ctx)
+		require.NoError(t, err)
+		return tx
+	}
+
+	t.Run("GetBalance", func(t *testing.T) {
+		repository, mock := newRepository(t)
+		mock.ExpectQuery(selectWalletBalanceQuery).WithArgs(walletID).
+			WillReturnRows(sqlmock.NewRows([]string{"balance", "closed", "version"}).AddRow(150.5, false, 7))
+
+		stored, err := repository.GetBalance(ctx, walletID)
+		require.NoError(t, err)
+		assert.Equal(t, StoredWallet{Balance: 150.5, Version: 7}, stored)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("GetBalance - кошелька нет", func(t *testing.T) {
+		repository, mock := newRepository(t)
+		mock.ExpectQuery(selectWalletBalanceQuery).WithArgs(walletID).
+			WillReturnRows(sqlmock.NewRows([]string{"balance", "closed", "version"}))
+
+		_, err := repository.GetBalance(ctx, walletID)
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("GetBalanceForUpdate - блокировка строки", func(t *testing.T) {
+		repository, mock := newRepository(t)
+		tx := begin(t, repository, mock)
+		mock.ExpectQuery(selectBalanceForUpdateQuery).WithArgs(walletID).
+			WillReturnRows(sqlmock.NewRows([]string{"balance", "closed", "deposit", "withdraw"}).AddRow(100.0, false, false, true))
+
+		stored, err := repository.GetBalanceForUpdate(ctx, tx, walletID, LockStrategyRow)
+		require.NoError(t, err)
+		assert.Equal(t, StoredWallet{Balance: 100, WithdrawDisabled: true}, stored)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("GetBalanceForUpdate - рекомендательная блокировка", func(t *testing.T) {
+		repository, mock := newRepository(t)
+		tx := begin(t, repository, mock)
+		mock.ExpectExec(advisoryLockQuery).WithArgs(walletID.String()).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(selectBalanceQuery).WithArgs(walletID).
+			WillReturnRows(sqlmock.NewRows([]string{"balance", "closed", "deposit", "withdraw"}).AddRow(20.0, true, false, false))
+
+		stored, err := repository.GetBalanceForUpdate(ctx, tx, walletID, LockStrategyAdvisory)
+		require.NoError(t, err)
+		assert.Equal(t, StoredWallet{Balance: 20, Closed: true}, stored)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("GetBalanceForUpdate - ошибка блокировки", func(t *testing.T) {
+		repository, mock := newRepository(t)
+		tx := begin(t, repository, mock)
+		mock.ExpectExec(advisoryLockQuery).WithArgs(walletID.String()).WillReturnError(errors.New("lock timeout"))
+
+		_, err := repository.GetBalanceForUpdate(ctx, tx, walletID, LockStrategyAdvisory)
+		assert.ErrorContains(t, err, ErrWalletLock)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("UpdateBalance и InsertTransaction", func(t *testing.T) {
+		repository, mock := newRepository(t)
+		tx := begin(t, repository, mock)
+		createdAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		mock.ExpectExec(createWalletQuery).WithArgs(walletID).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(updateBalanceQuery).WithArgs(75.0, walletID).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(insertTransactionQuery).WithArgs(walletID, -25.0, wallet.WITHDRAW, "ref", createdAt).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		require.NoError(t, repository.CreateWallet(ctx, tx, walletID))
+		require.NoError(t, repository.UpdateBalance(ctx, tx, walletID, 75))
+		require.NoError(t, repository.InsertTransaction(ctx, tx, walletID, -25, wallet.WITHDRAW, "ref", createdAt))
+		require.NoError(t, tx.Commit())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Ошибка записи возвращается вызывающему", func(t *testing.T) {
+		repository, mock := newRepository(t)
+		tx := begin(t, repository, mock)
+		mock.ExpectExec(updateBalanceQuery).WithArgs(75.0, walletID).WillReturnError(errors.New("connection reset"))
+
+		assert.EqualError(t, repository.UpdateBalance(ctx, tx, walletID, 75), "connection reset")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+// Сумма точнее дробных единиц валюты кошелька отклоняется при валидации
+func TestCurrencyPrecision(t *testing.T) {
+	body := []byte(`{"wallet_id":"` + uuid.New().String() + `","operation_type":"DEPOSIT","amount":10.005}`)
+	newHandler := func(enforce bool) (*WalletHandler, *listCache) {
+		cache := newListCache()
+		config := DefaultConfig()
+		config.DisplayCurrency = "USD"
+		config.EnforceCurrencyPrecision = enforce
+		return NewWalletHandlerWithConfig(new(MockDB), cache, false, config), cache
+	}
+
+	t.Run("Три знака для USD - 422", func(t *testing.T) {
+		handler, cache := newHandler(true)
+		w := httptest.NewRecorder()
+		handler.HandleWalletOperation(w, newJSONRequest(body))
+
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		var response ErrorResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, service.CodePrecisionExceeds, response.Code)
+		assert.Contains(t, response.Error, "USD, 2")
+		require.Len(t, response.Fields, 1)
+		assert.Equal(t, service.FieldCodeAmountPrecision, response.Fields[0].Code)
+		assert.Empty(t, cache.lists[operationsQueueKey])
+	})
+
+	t.Run("Проверка выключена", func(t *testing.T) {
+		handler, cache := newHandler(false)
+		w := httptest.NewRecorder()
+		handler.HandleWalletOperation(w, newJSONRequest(body))
+
+		assert.Equal(t, http.StatusAccepted, w.Code)
+		assert.Len(t, cache.lists[operationsQueueKey], 1)
+	})
+}
+
+// Тесты локального кэша балансов перед Redis
+func TestLocalCache(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	getBalance := func(handler *WalletHandler, walletID uuid.UUID) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.GetWalletBalance(w, httptest.NewRequest("GET", "/api/v1/wallets/"+walletID.String(), nil))
+		return w
+	}
+	newHandler := func(store Store, mockCache *MockCache, invalidations BalanceInvalidations) *WalletHandler {
+		config := DefaultConfig()
+		config.Store = store
+		config.WalletPolicy = WalletPolicyAutoCreate
+		config.Clock = newFakeClock(start)
+		config.LocalCacheSize = 2
+		config.BalanceInvalidations = invalidations
+		return NewWalletHandlerWithConfig(nil, mockCache, false, config)
+	}
+
+	t.Run("Повторное чтение не обращается к Redis", func(t *testing.T) {
+		walletID := uuid.New()
+		mockCache := new(MockCache)
+		mockCache.On("Get", mock.Anything, "balance:"+walletID.String()).Return("75.5", nil).Once()
+		handler := newHandler(NewMemoryStore(), mockCache, nil)
+
+		for i := 0; i < 2; i++ {
+			w := getBalance(handler, walletID)
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.JSONEq(t, `{"balance": "75.50", "balance_minor": 7550}`, w.Body.String())
+		}
+		mockCache.AssertNumberOfCalls(t, "Get", 1)
+	})
+
+	t.Run("Без размера кэш выключен", func(t *testing.T) {
+		walletID := uuid.New()
+		mockCache := new(MockCache)
+		mockCache.On("Get", mock.Anything, "balance:"+walletID.String()).Return("75.5", nil).Twice()
+		handler := NewWalletHandler(new(MockDB), mockCache, false)
+
+		getBalance(handler, walletID)
+		getBalance(handler, walletID)
+		mockCache.AssertNumberOfCalls(t, "Get", 2)
+	})
+
+	t.Run("Операция сбрасывает запись и ключ Redis", func(t *testing.T) {
+		walletID := uuid.New()
+		key := "balance:" + walletID.String()
+		store := NewMemoryStore()
+		store.Put(walletID, StoredWallet{Balance: 10})
+		// В Redis лежит баланс до операции, пока ключ не удалён
+		mockCache := new(MockCache)
+		redisGet := mockCache.On("Get", mock.Anything, key).Return("10", nil)
+		mockCache.On("Delete", mock.Anything, key).Run(func(mock.Arguments) {
+			redisGet.Return("", redis.Nil)
+		}).Return(nil).Once()
+		mockCache.On("Set", mock.Anything, key, mock.Anything, mock.Anything).Return(nil).Maybe()
+		handler := newHandler(store, mockCache, nil)
+
+		w := getBalance(handler, walletID)
+		assert.JSONEq(t, `{"balance": "10.00", "balance_minor": 1000}`, w.Body.String())
+		_, _, walletErr := handler.executeOperation(context.Background(), &wallet.WalletRequest{
+			WalletID: walletID.String(), OperationType: wallet.DEPOSIT, Amount: 5,
+		})
+		require.Nil(t, walletErr)
+
+		w = getBalance(handler, walletID)
+		assert.JSONEq(t, `{"balance": "15.00", "balance_minor": 1500}`, w.Body.String())
+		mockCache.AssertExpectations(t)
+	})
+
+	t.Run("Сброс от другого экземпляра", func(t *testing.T) {
+		walletID := uuid.New()
+		invalidations := NewLocalInvalidations()
+		readerCache := new(MockCache)
+		readerCache.On("Get", mock.Anything, "balance:"+walletID.String()).Return("10", nil).Twice()
+		reader := newHandler(NewMemoryStore(), readerCache, invalidations)
+		writer := newHandler(NewMemoryStore(), new(MockCache), invalidations)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		walletIDs, unsubscribe, err := invalidations.Subscribe(ctx)
+		require.NoError(t, err)
+		defer unsubscribe()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			reader.RunLocalCacheInvalidation(ctx)
+		}()
+		// Ждём подписки читателя: уведомление доходит до обоих подписчиков
+		require.Eventually(t, func() bool {
+			invalidations.mu.Lock()
+			defer invalidations.mu.Unlock()
+			return len(invalidations.subscribers) == 2
+		}, time.Second, time.Millisecond)
+
+		getBalance(reader, walletID)
+		writer.invalidateLocalBalance(walletID.String())
+		assert.Equal(t, walletID.String(), <-walletIDs)
+		require.Eventually(t, func() bool { return reader.localCache.len() == 0 }, time.Second, time.Millisecond)
+
+		getBalance(reader, walletID)
+		readerCache.AssertNumberOfCalls(t, "Get", 2)
+		cancel()
+		<-done
+	})
+
+	t.Run("Значение, прочитанное до сброса, не кэшируется", func(t *testing.T) {
+		cache := newLocalCache(2, time.Minute)
+		_, ok, generation := cache.get("balance:a", start)
+		assert.False(t, ok)
+		cache.invalidate("balance:a")
+		cache.put("balance:a", "10", generation, start)
+		assert.Equal(t, 0, cache.len())
+	})
+
+	t.Run("Вытеснение и время жизни", func(t *testing.T) {
+		cache := newLocalCache(2, time.Second)
+		for _, key := range []string{"a", "b"} {
+			_, _, generation := cache.get(key, start)
+			cache.put(key, key, generation, start)
+		}
+		// a использован позже b, поэтому вытесняется b
+		_, ok, generation := cache.get("a", start)
+		assert.True(t, ok)
+		cache.put("c", "c", generation, start)
+		_, ok, _ = cache.get("b", start)
+		assert.False(t, ok)
+		assert.Equal(t, 2, cache.len())
+
+		_, ok, _ = cache.get("a", start.Add(time.Second))
+		assert.False(t, ok)
+	})
+}
+
+// Ответ 204 на операцию, не изменившую баланс
+func TestNoOpOperations(t *testing.T) {
+	newHandler := func(enabled bool) (*WalletHandler, *MemoryStore, uuid.UUID) {
+		store := NewMemoryStore()
+		id := uuid.New()
+		store.Put(id, StoredWallet{Balance: 50})
+		config := DefaultConfig()
+		config.Store = store
+		config.NoContentOnNoOp = enabled
+		mockCache := newWriteCache()
+		expectNotBlocked(mockCache)
+		return NewWalletHandlerWithConfig(nil, mockCache, true, config), store, id
+	}
+	operation := func(handler *WalletHandler, req wallet.WalletRequest) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(req)
+		w := httptest.NewRecorder()
+		handler.HandleWalletOperation(w, newJSONRequest(body))
+		return w
+	}
+
+	tests := []struct {
+		name     string
+		opType   wallet.OperationType
+		amount   float64
+		dryRun   bool
+		expected int
+	}{
+		{"Зачисление нуля", wallet.DEPOSIT, 0, false, http.StatusNoContent},
+		{"Списание нуля", wallet.WITHDRAW, 0, false, http.StatusNoContent},
+		{"Пробное зачисление нуля", wallet.DEPOSIT, 0, true, http.StatusNoContent},
+		{"Пробное зачисление суммы", wallet.DEPOSIT, 10, true, http.StatusOK},
+		{"Зачисление суммы", wallet.DEPOSIT, 10, false, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler, _, id := newHandler(true)
+			w := operation(handler, wallet.WalletRequest{WalletID: id.String(), OperationType: tt.opType, Amount: tt.amount, DryRun: tt.dryRun})
+
+			assert.Equal(t, tt.expected, w.Code, w.Body.String())
+			if tt.expected == http.StatusNoContent {
+				assert.Empty(t, w.Body.String())
+			}
+		})
+	}
+
+	t.Run("Пустая операция записывается в журнал", func(t *testing.T) {
+		handler, store, id := newHandler(true)
+		w := operation(handler, wallet.WalletRequest{WalletID: id.String(), OperationType: wallet.DEPOSIT, Amount: 0})
+
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		assert.Len(t, store.Transactions(id), 1)
+	})
+
+	t.Run("Изменение меньше копейки", func(t *testing.T) {
+		assert.True(t, isNoOp(50, 50.004))
+		assert.False(t, isNoOp(50, 50.01))
+	})
+
+	t.Run("Без настройки - 200", func(t *testing.T) {
+		handler, _, id := newHandler(false)
+		w := operation(handler, wallet.WalletRequest{WalletID: id.String(), OperationType: wallet.DEPOSIT, Amount: 0})
+
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
 }
@@ -0,0 +1,311 @@
+package handler
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Минимальная реализация сервера WebSocket (RFC 6455): сервер только
+// отправляет события, от клиента принимаются ping, pong и close
+const (
+	websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+	wsOpText  = 0x1
+	wsOpClose = 0x8
+	wsOpPing  = 0x9
+	wsOpPong  = 0xA
+
+	// Коды закрытия соединения
+	wsCloseNormal    = 1000
+	wsCloseGoingAway = 1001
+	wsCloseProtocol  = 1002
+	wsCloseTooBig    = 1009
+	wsCloseTryLater  = 1013
+
+	wsMaxClientFrame = 4096
+	wsWriteTimeout   = 5 * time.Second
+)
+
+var (
+	errWSFrameTooBig = errors.New("кадр WebSocket превышает допустимый размер")
+	errWSHijack      = errors.New("не удалось перехватить соединение")
+)
+
+// HandleWalletEvents - подписка на события кошелька по WebSocket:
+// GET /api/v1/wallets/{uuid}/ws. Клиент получает JSON WalletEvent в текстовых
+// кадрах. Клиент, не успевающий читать события, отключается с кодом 1013.
+func (h *WalletHandler) HandleWalletEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.writeError(w, r, ErrMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+
+	rawID := strings.TrimPrefix(r.URL.Path, "/api/v1/wallets/")
+	rawID = strings.TrimSuffix(rawID, "/ws")
+	var walletID uuid.UUID
+	if !h.validate(w, r, h.pathID(rawID, &walletID, ErrInvalidUUID)) {
+		return
+	}
+
+	if h.config.BlockReads && !h.checkNotBlocked(r.Context(), w, r, walletID.String()) {
+		return
+	}
+
+	if !isWebSocketUpgrade(r) {
+		w.Header().Set("Upgrade", "websocket")
+		h.writeError(w, r, ErrWebSocketUpgrade, http.StatusUpgradeRequired)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	events, unsubscribe, err := h.events.Subscribe(ctx, walletID.String())
+	if err != nil {
+		h.writeError(w, r, ErrEventSubscribe, http.StatusServiceUnavailable)
+		return
+	}
+	defer unsubscribe()
+
+	conn, err := acceptWebSocket(w, r)
+	if err != nil {
+		// Без перехвата ответ ещё не отправлен. HTTP/2 (в том числе h2c)
+		// перехват не поддерживает: клиенту нужно подключиться по HTTP/1.1.
+		switch {
+		case errors.Is(err, http.ErrNotSupported):
+			h.writeError(w, r, ErrWebSocketHijack, http.StatusUpgradeRequired)
+		case errors.Is(err, errWSHijack):
+			h.writeError(w, r, ErrWebSocketHijack, http.StatusInternalServerError)
+		}
+		return
+	}
+	defer conn.Close()
+
+	pingInterval := h.config.WebSocketPingInterval
+	go func() {
+		// Чтение завершается ошибкой при закрытии соединения клиентом или без ответа на ping
+		conn.readLoop(2 * pingInterval)
+		cancel()
+	}()
+
+	var pings <-chan time.Time
+	if pingInterval > 0 {
+		ticker := time.NewTicker(pingInterval)
+		defer ticker.Stop()
+		pings = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			conn.writeClose(wsCloseNormal, "")
+			return
+		case <-pings:
+			if err := conn.writeFrame(wsOpPing, nil); err != nil {
+				return
+			}
+		case event, ok := <-events:
+			if !ok {
+				conn.writeClose(wsCloseGoingAway, "")
+				return
+			}
+			// Буфер подписки заполнен - клиент не успевает за событиями
+			if len(events) == cap(events) {
+				conn.writeClose(wsCloseTryLater, "slow consumer")
+				return
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				continue
+			}
+			if err := conn.writeFrame(wsOpText, data); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// isWebSocketUpgrade проверяет заголовки запроса на установку WebSocket версии 13
+func isWebSocketUpgrade(r *http.Request) bool {
+	return headerContainsToken(r.Header, "Connection", "upgrade") &&
+		headerContainsToken(r.Header, "Upgrade", "websocket") &&
+		r.Header.Get("Sec-WebSocket-Version") == "13" &&
+		r.Header.Get("Sec-WebSocket-Key") != ""
+}
+
+func headerContainsToken(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// websocketAccept - значение Sec-WebSocket-Accept для ключа клиента
+func websocketAccept(key string) string {
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// wsConn - серверная сторона WebSocket-соединения
+type wsConn struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	writeMu sync.Mutex
+	// После кадра close сервер больше ничего не отправляет
+	closeSent bool
+}
+
+// acceptWebSocket перехватывает соединение и отвечает 101 Switching Protocols.
+// Ошибка перехвата оборачивается в errWSHijack: ответ клиенту ещё не отправлен.
+func acceptWebSocket(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	conn, rw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errWSHijack, err)
+	}
+	// Таймауты http.Server остаются на перехваченном соединении; дальше ими управляет wsConn
+	conn.SetDeadline(time.Time{})
+
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + websocketAccept(r.Header.Get("Sec-WebSocket-Key")) + "\r\n\r\n"
+	conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	if _, err := io.WriteString(conn, response); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &wsConn{conn: conn, reader: rw.Reader}, nil
+}
+
+func (c *wsConn) Close() error {
+	return c.conn.Close()
+}
+
+// writeFrame отправляет один завершённый кадр; кадры сервера не маскируются
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closeSent {
+		return net.ErrClosed
+	}
+	c.closeSent = opcode == wsOpClose
+
+	header := []byte{0x80 | opcode, 0}
+	switch n := len(payload); {
+	case n < 126:
+		header[1] = byte(n)
+	case n <= 0xFFFF:
+		header[1] = 126
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header[1] = 127
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+
+	c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	if _, err := c.conn.Write(append(header, payload...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c *wsConn) writeClose(code uint16, reason string) error {
+	payload := binary.BigEndian.AppendUint16(nil, code)
+	return c.writeFrame(wsOpClose, append(payload, reason...))
+}
+
+// readFrame читает кадр клиента. Кадры клиента обязаны быть маскированы.
+func (c *wsConn) readFrame() (opcode byte, payload []byte, err error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		return 0, nil, err
+	}
+	opcode = header[0] & 0x0F
+	if header[1]&0x80 == 0 {
+		return 0, nil, errors.New("кадр WebSocket клиента не маскирован")
+	}
+
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if length > wsMaxClientFrame {
+		return 0, nil, errWSFrameTooBig
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(c.reader, mask[:]); err != nil {
+		return 0, nil, err
+	}
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return opcode, payload, nil
+}
+
+// readLoop отвечает на ping и close клиента, пока соединение живо. Любой кадр
+// клиента продлевает срок ожидания idle (0 - без ограничения); данные от
+// клиента не ожидаются и пропускаются.
+func (c *wsConn) readLoop(idle time.Duration) {
+	for {
+		if idle > 0 {
+			c.conn.SetReadDeadline(time.Now().Add(idle))
+		}
+		opcode, payload, err := c.readFrame()
+		if err != nil {
+			if errors.Is(err, errWSFrameTooBig) {
+				c.writeClose(wsCloseTooBig, "")
+			} else if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) && !isTimeout(err) {
+				c.writeClose(wsCloseProtocol, "")
+			}
+			return
+		}
+		switch opcode {
+		case wsOpPing:
+			if err := c.writeFrame(wsOpPong, payload); err != nil {
+				return
+			}
+		case wsOpClose:
+			c.writeFrame(wsOpClose, payload)
+			return
+		}
+	}
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -0,0 +1,120 @@
+package i18n
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Bundle хранит переводы сообщений, ключом служит стабильный машинный код
+type Bundle struct {
+	mu       sync.RWMutex
+	fallback string
+	messages map[string]map[string]string
+}
+
+// NewBundle создаёт набор переводов; fallback - язык по умолчанию
+func NewBundle(fallback string) *Bundle {
+	return &Bundle{
+		fallback: fallback,
+		messages: make(map[string]map[string]string),
+	}
+}
+
+// Add добавляет или заменяет переводы для языка
+func (b *Bundle) Add(lang string, messages map[string]string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	lang = strings.ToLower(lang)
+	if b.messages[lang] == nil {
+		b.messages[lang] = make(map[string]string, len(messages))
+	}
+	for code, text := range messages {
+		b.messages[lang][code] = text
+	}
+}
+
+// Fallback возвращает язык по умолчанию
+func (b *Bundle) Fallback() string {
+	return b.fallback
+}
+
+// Translate возвращает сообщение на языке lang, затем на языке по умолчанию,
+// и сам код, если перевода нет
+func (b *Bundle) Translate(lang, code string) string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if text, ok := b.messages[lang][code]; ok {
+		return text
+	}
+	if text, ok := b.messages[b.fallback][code]; ok {
+		return text
+	}
+	return code
+}
+
+// Negotiate выбирает поддерживаемый язык по заголовку Accept-Language
+// с учётом весов q, например "en-US,en;q=0.9,ru;q=0.8"
+func (b *Bundle) Negotiate(acceptLanguage string) string {
+	type candidate struct {
+		lang string
+		q    float64
+	}
+
+	var candidates []candidate
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if tag == "" {
+			continue
+		}
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+				q = parsed
+			}
+		}
+		// Используем только основной подтег: en-US -> en
+		primary, _, _ := strings.Cut(strings.ToLower(tag), "-")
+		candidates = append(candidates, candidate{lang: primary, q: q})
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].q > candidates[j].q })
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, c := range candidates {
+		if _, ok := b.messages[c.lang]; ok && c.q > 0 {
+			return c.lang
+		}
+	}
+	return b.fallback
+}
+
+// LoadDir загружает переводы из файлов <язык>.json каталога dir,
+// каждый файл - объект {"код": "текст"}
+func (b *Bundle) LoadDir(dir string) error {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+
+		var messages map[string]string
+		if err := json.Unmarshal(data, &messages); err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		b.Add(strings.TrimSuffix(filepath.Base(file), ".json"), messages)
+	}
+	return nil
+}
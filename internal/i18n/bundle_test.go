@@ -0,0 +1,69 @@
+package i18n
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAll(t *testing.T) {
+	t.Run("Translate", TestTranslate)
+	t.Run("Negotiate", TestNegotiate)
+	t.Run("LoadDir", TestLoadDir)
+}
+
+func TestLoadDir(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "en.json"), []byte(`{"wallet.not_found": "wallet not found"}`), 0o644))
+
+	b := newTestBundle()
+	assert.NoError(t, b.LoadDir(dir))
+	assert.Equal(t, "wallet not found", b.Translate("en", "wallet.not_found"))
+	// Ранее добавленные переводы сохраняются
+	assert.Equal(t, "Funds deposited successfully", b.Translate("en", "deposit.success"))
+
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "de.json"), []byte(`not json`), 0o644))
+	assert.Error(t, b.LoadDir(dir))
+}
+
+func newTestBundle() *Bundle {
+	b := NewBundle("ru")
+	b.Add("ru", map[string]string{"deposit.success": "Средства успешно внесены", "only.ru": "только ru"})
+	b.Add("en", map[string]string{"deposit.success": "Funds deposited successfully"})
+	return b
+}
+
+func TestTranslate(t *testing.T) {
+	b := newTestBundle()
+
+	assert.Equal(t, "Funds deposited successfully", b.Translate("en", "deposit.success"))
+	assert.Equal(t, "Средства успешно внесены", b.Translate("ru", "deposit.success"))
+	// Нет перевода - язык по умолчанию
+	assert.Equal(t, "только ru", b.Translate("en", "only.ru"))
+	// Неизвестный код возвращается как есть
+	assert.Equal(t, "unknown.code", b.Translate("en", "unknown.code"))
+}
+
+func TestNegotiate(t *testing.T) {
+	b := newTestBundle()
+
+	tests := []struct {
+		header   string
+		expected string
+	}{
+		{header: "", expected: "ru"},
+		{header: "en", expected: "en"},
+		{header: "en-US,en;q=0.9", expected: "en"},
+		{header: "de-DE,ru;q=0.5,en;q=0.8", expected: "en"},
+		{header: "de", expected: "ru"},
+		{header: "en;q=0", expected: "ru"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			assert.Equal(t, tt.expected, b.Negotiate(tt.header))
+		})
+	}
+}
@@ -0,0 +1,23 @@
+package metrics
+
+// Labels - метки измерения, например {"operation_type": "DEPOSIT"}
+type Labels map[string]string
+
+// Metrics принимает измерения сервиса. Помимо встроенных Nop и Prometheus
+// можно подключить свою реализацию, например отправку в StatsD или OpenTelemetry.
+// Реализация должна быть безопасна для одновременного вызова.
+type Metrics interface {
+	// IncCounter увеличивает счётчик name на единицу
+	IncCounter(name string, labels Labels)
+	// ObserveHistogram добавляет значение в гистограмму name
+	ObserveHistogram(name string, value float64, labels Labels)
+	// SetGauge устанавливает текущее значение показателя name
+	SetGauge(name string, value float64, labels Labels)
+}
+
+// Nop отбрасывает все измерения; используется, если метрики не подключены
+type Nop struct{}
+
+func (Nop) IncCounter(string, Labels)                {}
+func (Nop) ObserveHistogram(string, float64, Labels) {}
+func (Nop) SetGauge(string, float64, Labels)         {}
@@ -0,0 +1,57 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAll(t *testing.T) {
+	t.Run("PrometheusExport", TestPrometheusExport)
+	t.Run("PrometheusHandler", TestPrometheusHandler)
+}
+
+func TestPrometheusExport(t *testing.T) {
+	p := NewPrometheus(0.1, 1)
+	var _ Metrics = p
+	var _ Metrics = Nop{}
+
+	labels := Labels{"result": "success", "operation_type": "DEPOSIT"}
+	p.IncCounter("ops_total", labels)
+	p.IncCounter("ops_total", labels)
+	p.IncCounter("ops_total", Labels{"operation_type": "WITHDRAW", "result": "failure"})
+	p.SetGauge("queue_length", 3, nil)
+	p.SetGauge("queue_length", 7, nil)
+	p.ObserveHistogram("duration_seconds", 0.05, nil)
+	p.ObserveHistogram("duration_seconds", 0.5, nil)
+	p.ObserveHistogram("duration_seconds", 5, nil)
+	// Имя уже занято счётчиком - измерение другого типа игнорируется
+	p.SetGauge("ops_total", 100, labels)
+	// Изменение меток после вызова не влияет на сохранённое измерение
+	labels["result"] = "changed"
+
+	assert.Equal(t, `# TYPE duration_seconds histogram
+duration_seconds_bucket{le="0.1"} 1
+duration_seconds_bucket{le="1"} 2
+duration_seconds_bucket{le="+Inf"} 3
+duration_seconds_sum 5.55
+duration_seconds_count 3
+# TYPE ops_total counter
+ops_total{operation_type="DEPOSIT",result="success"} 2
+ops_total{operation_type="WITHDRAW",result="failure"} 1
+# TYPE queue_length gauge
+queue_length 7
+`, p.Export())
+}
+
+func TestPrometheusHandler(t *testing.T) {
+	p := NewPrometheus()
+	p.IncCounter("ops_total", Labels{"reference": "a\"b\\c\nd"})
+
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	assert.Equal(t, "text/plain; version=0.0.4", w.Header().Get("Content-Type"))
+	assert.Equal(t, "# TYPE ops_total counter\nops_total{reference=\"a\\\"b\\\\c\\nd\"} 1\n", w.Body.String())
+}
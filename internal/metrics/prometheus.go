@@ -0,0 +1,176 @@
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets - границы гистограмм в секундах, как у клиента Prometheus
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+const (
+	kindCounter   = "counter"
+	kindGauge     = "gauge"
+	kindHistogram = "histogram"
+)
+
+type sample struct {
+	labels Labels
+	value  float64
+	// Только для гистограмм: число значений не больше каждой границы, сумма и количество
+	buckets []uint64
+	sum     float64
+	count   uint64
+}
+
+type family struct {
+	kind    string
+	samples map[string]*sample
+}
+
+// Prometheus хранит измерения в памяти процесса и отдаёт их в текстовом
+// формате Prometheus (ServeHTTP), без зависимости от клиентской библиотеки.
+// Имя измерения используется с одним типом; вызов с другим типом игнорируется.
+type Prometheus struct {
+	mu       sync.Mutex
+	buckets  []float64
+	families map[string]*family
+}
+
+// NewPrometheus возвращает пустой набор измерений; buckets задают границы
+// гистограмм, без них используются DefaultBuckets
+func NewPrometheus(buckets ...float64) *Prometheus {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	sorted := append([]float64(nil), buckets...)
+	sort.Float64s(sorted)
+	return &Prometheus{buckets: sorted, families: make(map[string]*family)}
+}
+
+func (p *Prometheus) IncCounter(name string, labels Labels) {
+	p.update(name, kindCounter, labels, func(s *sample) { s.value++ })
+}
+
+func (p *Prometheus) SetGauge(name string, value float64, labels Labels) {
+	p.update(name, kindGauge, labels, func(s *sample) { s.value = value })
+}
+
+func (p *Prometheus) ObserveHistogram(name string, value float64, labels Labels) {
+	p.update(name, kindHistogram, labels, func(s *sample) {
+		if s.buckets == nil {
+			s.buckets = make([]uint64, len(p.buckets))
+		}
+		for i, bound := range p.buckets {
+			if value <= bound {
+				s.buckets[i]++
+			}
+		}
+		s.sum += value
+		s.count++
+	})
+}
+
+func (p *Prometheus) update(name, kind string, labels Labels, apply func(*sample)) {
+	key := formatLabels(labels)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	f, ok := p.families[name]
+	if !ok {
+		f = &family{kind: kind, samples: make(map[string]*sample)}
+		p.families[name] = f
+	}
+	if f.kind != kind {
+		return
+	}
+	s, ok := f.samples[key]
+	if !ok {
+		s = &sample{labels: make(Labels, len(labels))}
+		for k, v := range labels {
+			s.labels[k] = v
+		}
+		f.samples[key] = s
+	}
+	apply(s)
+}
+
+// ServeHTTP отдаёт измерения в текстовом формате Prometheus 0.0.4
+func (p *Prometheus) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write([]byte(p.Export()))
+}
+
+// Export записывает все измерения в текстовом формате Prometheus
+func (p *Prometheus) Export() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var b strings.Builder
+	for _, name := range sortedKeys(p.families) {
+		f := p.families[name]
+		fmt.Fprintf(&b, "# TYPE %s %s\n", name, f.kind)
+		for _, key := range sortedKeys(f.samples) {
+			s := f.samples[key]
+			if f.kind != kindHistogram {
+				fmt.Fprintf(&b, "%s%s %s\n", name, key, formatValue(s.value))
+				continue
+			}
+			for i, bound := range p.buckets {
+				fmt.Fprintf(&b, "%s_bucket%s %d\n", name, withLabel(s.labels, "le", formatValue(bound)), s.buckets[i])
+			}
+			fmt.Fprintf(&b, "%s_bucket%s %d\n", name, withLabel(s.labels, "le", "+Inf"), s.count)
+			fmt.Fprintf(&b, "%s_sum%s %s\n", name, key, formatValue(s.sum))
+			fmt.Fprintf(&b, "%s_count%s %d\n", name, key, s.count)
+		}
+	}
+	return b.String()
+}
+
+// formatLabels записывает метки в виде {a="1",b="2"} с ключами по алфавиту
+func formatLabels(labels Labels) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, key := range sortedKeys(labels) {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(key)
+		b.WriteString(`="`)
+		b.WriteString(labelEscaper.Replace(labels[key]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func withLabel(labels Labels, key, value string) string {
+	extended := make(Labels, len(labels)+1)
+	for k, v := range labels {
+		extended[k] = v
+	}
+	extended[key] = value
+	return formatLabels(extended)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatValue(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
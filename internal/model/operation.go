@@ -0,0 +1,67 @@
+package wallet
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+)
+
+// Direction - знак изменения баланса для типа операции
+type Direction int
+
+const (
+	// Credit увеличивает баланс
+	Credit Direction = 1
+	// Debit уменьшает баланс
+	Debit Direction = -1
+)
+
+// ErrUnknownOperationType - тип операции не зарегистрирован
+var ErrUnknownOperationType = errors.New("неверный тип операции")
+
+var (
+	operationTypesMu sync.RWMutex
+	operationTypes   = map[OperationType]Direction{
+		DEPOSIT:  Credit,
+		WITHDRAW: Debit,
+	}
+)
+
+// RegisterOperationType добавляет тип операции или меняет знак уже известного
+func RegisterOperationType(opType OperationType, direction Direction) {
+	operationTypesMu.Lock()
+	defer operationTypesMu.Unlock()
+	operationTypes[opType] = direction
+}
+
+// UnregisterOperationType удаляет тип операции из реестра
+func UnregisterOperationType(opType OperationType) {
+	operationTypesMu.Lock()
+	defer operationTypesMu.Unlock()
+	delete(operationTypes, opType)
+}
+
+// LookupOperationType возвращает знак зарегистрированного типа операции
+func LookupOperationType(opType OperationType) (Direction, bool) {
+	operationTypesMu.RLock()
+	defer operationTypesMu.RUnlock()
+	direction, ok := operationTypes[opType]
+	return direction, ok
+}
+
+// ParseOperationType приводит тип операции к каноническому виду: без пробелов
+// по краям и в верхнем регистре. Существование типа не проверяется.
+func ParseOperationType(raw string) OperationType {
+	return OperationType(strings.ToUpper(strings.TrimSpace(raw)))
+}
+
+// UnmarshalJSON принимает "deposit" и " DEPOSIT " наравне с "DEPOSIT"
+func (t *OperationType) UnmarshalJSON(data []byte) error {
+	var raw string
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*t = ParseOperationType(raw)
+	return nil
+}
@@ -1,14 +1,98 @@
 package wallet
 
+import "time"
+
 type OperationType string
 
 const (
 	DEPOSIT  OperationType = "DEPOSIT"
 	WITHDRAW OperationType = "WITHDRAW"
+	// VOID - компенсирующая запись отмены операции; клиенты не могут отправить её напрямую
+	VOID OperationType = "VOID"
+	// ADJUSTMENT - корректировка баланса администратором; клиенты не могут отправить её напрямую
+	ADJUSTMENT OperationType = "ADJUSTMENT"
 )
 
 type WalletRequest struct {
+	// ID присваивается при постановке в очередь
+	ID            string        `json:"id,omitempty"`
 	WalletID      string        `json:"wallet_id"`
 	OperationType OperationType `json:"operation_type"`
 	Amount        float64       `json:"amount"`
+	Reference     string        `json:"reference,omitempty"`
+	// Количество неудачных попыток обработки из очереди
+	Attempts int `json:"attempts,omitempty"`
+	// Кто и откуда отправил операцию - для журнала аудита
+	Subject  string `json:"subject,omitempty"`
+	SourceIP string `json:"source_ip,omitempty"`
+	// Операция доверенного вызова: валидация запроса пропускается. Выставляется
+	// только сервером, значение из тела запроса не принимается.
+	Trusted bool `json:"trusted,omitempty"`
+	// Идентификатор трассировки запроса (X-Request-ID), с которым операция
+	// поставлена в очередь; обработчик очереди пишет его в лог
+	TraceID string `json:"trace_id,omitempty"`
+	// Пробный запуск: проверки и расчёт нового баланса выполняются в транзакции,
+	// которая всегда откатывается
+	DryRun bool `json:"dry_run,omitempty"`
+	// Приоритет в очереди; пустой - обычный
+	Priority Priority `json:"priority,omitempty"`
+	// Срок действия: позже операция из очереди не выполняется; nil - без срока
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// Expired сообщает, истёк ли срок действия операции к моменту now
+func (r *WalletRequest) Expired(now time.Time) bool {
+	return r.ExpiresAt != nil && !now.Before(*r.ExpiresAt)
+}
+
+// Priority - приоритет операции в очереди обработки
+type Priority string
+
+const (
+	PriorityHigh   Priority = "high"
+	PriorityNormal Priority = "normal"
+	PriorityLow    Priority = "low"
+)
+
+// Valid сообщает, известен ли приоритет; пустой приоритет считается обычным
+func (p Priority) Valid() bool {
+	switch p {
+	case "", PriorityHigh, PriorityNormal, PriorityLow:
+		return true
+	}
+	return false
+}
+
+type OperationStatus string
+
+const (
+	OperationCompleted OperationStatus = "completed"
+	OperationFailed    OperationStatus = "failed"
+	// Операция пролежала в очереди дольше срока действия и отброшена
+	OperationExpired OperationStatus = "expired"
+)
+
+// OperationResult - итог обработки операции из очереди с балансом кошелька
+// до и после операции
+type OperationResult struct {
+	ID            string          `json:"id"`
+	BalanceBefore float64         `json:"balance_before"`
+	BalanceAfter  float64         `json:"balance_after"`
+	Status        OperationStatus `json:"status"`
+	// Подписанная квитанция выполненной операции, если квитанции включены
+	Receipt string `json:"receipt,omitempty"`
+}
+
+// Transaction - запись из истории операций кошелька
+type Transaction struct {
+	ID            string        `json:"id"`
+	WalletID      string        `json:"wallet_id"`
+	Amount        float64       `json:"amount"`
+	OperationType OperationType `json:"operation_type"`
+	Reference     string        `json:"reference,omitempty"`
+	CreatedAt     time.Time     `json:"created_at"`
+	// Время отмены операции; заполняется только в журнале аудита
+	VoidedAt *time.Time `json:"voided_at,omitempty"`
+	// Идентификатор отменённой операции для компенсирующей записи VOID
+	VoidOf *string `json:"void_of,omitempty"`
 }
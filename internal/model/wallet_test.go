@@ -3,6 +3,7 @@ package wallet
 import (
 	"encoding/json"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
@@ -11,6 +12,31 @@ import (
 func TestAll(t *testing.T) {
 	t.Run("OperationTypeConstants", TestOperationTypeConstants)
 	t.Run("WalletRequestJSONMarshaling", TestWalletRequestJSONMarshaling)
+	t.Run("OperationTypeRegistry", TestOperationTypeRegistry)
+	t.Run("OperationTypeNormalization", TestOperationTypeNormalization)
+	t.Run("WalletRequestExpiry", TestWalletRequestExpiry)
+}
+
+func TestOperationTypeRegistry(t *testing.T) {
+	// Встроенные типы зарегистрированы по умолчанию
+	direction, ok := LookupOperationType(DEPOSIT)
+	assert.True(t, ok)
+	assert.Equal(t, Credit, direction)
+
+	direction, ok = LookupOperationType(WITHDRAW)
+	assert.True(t, ok)
+	assert.Equal(t, Debit, direction)
+
+	fee := OperationType("FEE")
+	_, ok = LookupOperationType(fee)
+	assert.False(t, ok)
+
+	RegisterOperationType(fee, Debit)
+	defer UnregisterOperationType(fee)
+
+	direction, ok = LookupOperationType(fee)
+	assert.True(t, ok)
+	assert.Equal(t, Debit, direction)
 }
 
 func TestOperationTypeConstants(t *testing.T) {
@@ -62,3 +88,54 @@ func TestWalletRequestJSONMarshaling(t *testing.T) {
 		})
 	}
 }
+
+func TestOperationTypeNormalization(t *testing.T) {
+	tests := []struct {
+		name     string
+		raw      string
+		expected OperationType
+		known    bool
+	}{
+		{"Нижний регистр", `"deposit"`, DEPOSIT, true},
+		{"Пробелы по краям", `" DEPOSIT "`, DEPOSIT, true},
+		{"Смешанный регистр", `"\tWithDraw\n"`, WITHDRAW, true},
+		{"Неизвестный тип", `" transfer "`, OperationType("TRANSFER"), false},
+		{"Пустой тип", `"  "`, OperationType(""), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var decoded WalletRequest
+			err := json.Unmarshal([]byte(`{"operation_type": `+tt.raw+`}`), &decoded)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, decoded.OperationType)
+
+			_, ok := LookupOperationType(decoded.OperationType)
+			assert.Equal(t, tt.known, ok)
+		})
+	}
+
+	t.Run("Не строка", func(t *testing.T) {
+		var decoded WalletRequest
+		assert.Error(t, json.Unmarshal([]byte(`{"operation_type": 1}`), &decoded))
+	})
+}
+
+func TestWalletRequestExpiry(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	expiresAt := now.Add(time.Minute)
+	req := WalletRequest{ExpiresAt: &expiresAt}
+
+	assert.False(t, req.Expired(now))
+	assert.True(t, req.Expired(expiresAt))
+	assert.True(t, req.Expired(expiresAt.Add(time.Second)))
+	// Без срока действия операция не истекает
+	assert.False(t, (&WalletRequest{}).Expired(now.AddDate(100, 0, 0)))
+
+	// Срок действия переживает сериализацию в очередь
+	data, err := json.Marshal(req)
+	assert.NoError(t, err)
+	var decoded WalletRequest
+	assert.NoError(t, json.Unmarshal(data, &decoded))
+	assert.True(t, expiresAt.Equal(*decoded.ExpiresAt))
+}
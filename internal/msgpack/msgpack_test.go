@@ -0,0 +1,64 @@
+package msgpack
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAll(t *testing.T) {
+	t.Run("RoundTrip", TestRoundTrip)
+	t.Run("Encoding", TestEncoding)
+	t.Run("Truncated", TestTruncated)
+}
+
+type sample struct {
+	Name    string            `json:"name"`
+	Minor   int64             `json:"minor"`
+	Rate    float64           `json:"rate"`
+	Closed  bool              `json:"closed,omitempty"`
+	Items   []int             `json:"items"`
+	Labels  map[string]string `json:"labels"`
+	Missing *string           `json:"missing"`
+}
+
+func TestRoundTrip(t *testing.T) {
+	in := sample{
+		Name:   strings.Repeat("кошелек", 10),
+		Minor:  math.MinInt64,
+		Rate:   0.1,
+		Items:  []int{0, -1, -33, 200, 70000, 1 << 40},
+		Labels: map[string]string{"a": "b"},
+	}
+	data, err := Marshal(in)
+	require.NoError(t, err)
+
+	var out sample
+	require.NoError(t, Unmarshal(data, &out))
+	assert.Equal(t, in, out)
+}
+
+func TestEncoding(t *testing.T) {
+	// Ключи по алфавиту, целые - целыми, omitempty как в JSON
+	data, err := Marshal(map[string]interface{}{"b": 1, "a": "x", "c": 1.5, "d": nil})
+	require.NoError(t, err)
+	assert.Equal(t, []byte{
+		0x84,
+		0xa1, 'a', 0xa1, 'x',
+		0xa1, 'b', 0x01,
+		0xa1, 'c', 0xcb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0,
+		0xa1, 'd', 0xc0,
+	}, data)
+}
+
+func TestTruncated(t *testing.T) {
+	data, err := Marshal(sample{Name: "wallet"})
+	require.NoError(t, err)
+
+	var out sample
+	assert.ErrorIs(t, Unmarshal(data[:len(data)-1], &out), ErrTruncated)
+	assert.Error(t, Unmarshal(append(data, 0x00), &out))
+}
@@ -2,6 +2,11 @@ package service
 
 import (
 	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+	"wallet/internal/currency"
 	wallet "wallet/internal/model"
 
 	"errors"
@@ -9,40 +14,173 @@ import (
 	"github.com/google/uuid"
 )
 
+// storageScale - число знаков после точки, с которым суммы хранятся в базе
+// (DECIMAL(20,2)); точность валюты выше этой не поддерживается
+const storageScale = 2
+
 const (
 	ErrInvalidOperationType = "неверный тип операции: %s"
 	ErrValidationPrefix     = "ошибка валидации: %w"
+
+	// Стабильные коды ошибок валидации для перевода на язык клиента
+	CodeValidationFailed     = "validation.failed"
+	CodeNilRequest           = "validation.nil_request"
+	CodeInvalidWalletID      = "validation.invalid_wallet_id"
+	CodeEmptyWalletID        = "validation.empty_wallet_id"
+	CodeNegativeAmount       = "validation.negative_amount"
+	CodeInsufficientFunds    = "validation.insufficient_funds"
+	CodeInvalidAmount        = "validation.invalid_amount"
+	CodeUnknownOperationType = "validation.unknown_operation_type"
+	CodeReferenceTooLong     = "validation.reference_too_long"
+	CodeAmountTooSmall       = "validation.amount_too_small"
+	CodePrecisionExceeds     = "validation.precision_exceeds_currency"
+
+	// Коды ошибок отдельных полей запроса в виде <поле>.<причина>
+	FieldWalletID            = "wallet_id"
+	FieldAmount              = "amount"
+	FieldOperationType       = "operation_type"
+	FieldReference           = "reference"
+	FieldCodeWalletIDEmpty   = "wallet_id.empty"
+	FieldCodeWalletIDInvalid = "wallet_id.invalid"
+	FieldCodeAmountNegative  = "amount.negative"
+	FieldCodeAmountInvalid   = "amount.invalid"
+	FieldCodeAmountTooSmall  = "amount.too_small"
+	FieldCodeAmountPrecision = "amount.precision"
+	FieldCodeOperationType   = "operation_type.invalid"
+	FieldCodeReferenceLength = "reference.too_long"
+
+	// Максимальная длина комментария к операции в символах
+	MaxReferenceLength = 255
+)
+
+var (
+	ErrValidation           = errors.New("ошибка валидации")
+	ErrInvalidWalletID      = errors.New("неверный формат UUID")
+	ErrUnknownOperationType = wallet.ErrUnknownOperationType
+	ErrNilRequest           = errors.New("request не может быть nil")
+	ErrEmptyWalletID        = errors.New("wallet ID не может быть пустым")
+	ErrNegativeAmount       = errors.New("сумма должна быть положительной")
+	ErrInsufficientFunds    = errors.New("недостаточно средств")
+	ErrInvalidAmount        = errors.New("некорректная сумма")
+	ErrReferenceTooLong     = fmt.Errorf("комментарий не может быть длиннее %d символов", MaxReferenceLength)
+	ErrAmountTooSmall       = errors.New("сумма меньше минимальной")
+	// Знаков после точки в сумме больше, чем дробных единиц в валюте кошелька
+	ErrPrecisionExceedsCurrency = errors.New("точность суммы больше точности валюты кошелька")
 )
 
+// Ошибки состояния кошелька. Не относятся к валидации запроса (ErrorCode их
+// не распознаёт) и сравниваются только через errors.Is: по пути наверх они
+// оборачиваются контекстом.
 var (
-	ErrNilRequest        = errors.New("request не может быть nil")
-	ErrEmptyWalletID     = errors.New("wallet ID не может быть пустым")
-	ErrNegativeAmount    = errors.New("сумма должна быть положительной")
-	ErrInsufficientFunds = errors.New("недостаточно средств")
-	ErrInvalidAmount     = errors.New("некорректная сумма")
+	ErrWalletNotFound = errors.New("кошелек не найден")
+	ErrWalletClosed   = errors.New("кошелек закрыт")
+	ErrWalletBlocked  = errors.New("кошелек заблокирован")
 )
 
-type WalletValidator struct{}
+// Validator - проверки запросов к кошельку. Реализация по умолчанию - WalletValidator;
+// свою реализацию можно передать обработчику, например с более строгими правилами.
+type Validator interface {
+	ValidateWalletRequest(req *wallet.WalletRequest) error
+	ValidateWalletID(id uuid.UUID) error
+	ValidateAmount(amount float64) error
+	ValidateMinAmount(opType wallet.OperationType, amount float64) error
+	ValidateOperationType(opType wallet.OperationType) error
+	ValidateReference(reference string) error
+	ValidateBalance(currentBalance, requestAmount float64) error
+}
+
+var _ Validator = (*WalletValidator)(nil)
+
+type ValidatorConfig struct {
+	// Минимальная сумма для каждого типа операции; отсутствие типа - без ограничения
+	MinAmounts map[wallet.OperationType]float64
+	// Валюта кошельков (код ISO 4217): сумма не может быть точнее её дробных
+	// единиц из реестра валют и двух знаков хранения. Пустая или неизвестная
+	// валюта не проверяется.
+	Currency string
+}
+
+type WalletValidator struct {
+	config ValidatorConfig
+}
 
 func NewWalletValidator() *WalletValidator {
 	return &WalletValidator{}
 }
 
+func NewWalletValidatorWithConfig(config ValidatorConfig) *WalletValidator {
+	return &WalletValidator{config: config}
+}
+
 func (v *WalletValidator) ValidateWalletRequest(req *wallet.WalletRequest) error {
 	if err := v.validateRequest(req); err != nil {
-		return fmt.Errorf(ErrValidationPrefix, err)
+		return fmt.Errorf("%w: %w", ErrValidation, err)
 	}
 	return nil
 }
 
+// errorCodes - коды ошибок валидации. Для ошибок, относящихся к одному полю
+// запроса, указаны поле и код поля; ошибки без поля их не имеют.
+var errorCodes = []struct {
+	err       error
+	code      string
+	field     string
+	fieldCode string
+}{
+	{ErrNilRequest, CodeNilRequest, "", ""},
+	{ErrInvalidWalletID, CodeInvalidWalletID, FieldWalletID, FieldCodeWalletIDInvalid},
+	{ErrEmptyWalletID, CodeEmptyWalletID, FieldWalletID, FieldCodeWalletIDEmpty},
+	{ErrNegativeAmount, CodeNegativeAmount, FieldAmount, FieldCodeAmountNegative},
+	{ErrInsufficientFunds, CodeInsufficientFunds, "", ""},
+	{ErrInvalidAmount, CodeInvalidAmount, FieldAmount, FieldCodeAmountInvalid},
+	{ErrUnknownOperationType, CodeUnknownOperationType, FieldOperationType, FieldCodeOperationType},
+	{ErrReferenceTooLong, CodeReferenceTooLong, FieldReference, FieldCodeReferenceLength},
+	{ErrAmountTooSmall, CodeAmountTooSmall, FieldAmount, FieldCodeAmountTooSmall},
+	{ErrPrecisionExceedsCurrency, CodePrecisionExceeds, FieldAmount, FieldCodeAmountPrecision},
+}
+
+// ErrorMessages возвращает русские тексты ошибок валидации по их кодам
+func ErrorMessages() map[string]string {
+	messages := map[string]string{CodeValidationFailed: ErrValidation.Error()}
+	for _, entry := range errorCodes {
+		messages[entry.code] = entry.err.Error()
+	}
+	return messages
+}
+
+// ErrorCode возвращает стабильный код ошибки валидации и исходную ошибку-образец,
+// по которой он определён. ok=false для ошибок, не относящихся к валидации.
+func ErrorCode(err error) (code string, sentinel error, ok bool) {
+	for _, entry := range errorCodes {
+		if errors.Is(err, entry.err) {
+			return entry.code, entry.err, true
+		}
+	}
+	return "", nil, false
+}
+
+// FieldErrorCode возвращает поле запроса и стабильный код его ошибки, например
+// amount и amount.negative. ok=false, если ошибка не относится к одному полю.
+func FieldErrorCode(err error) (field, code string, ok bool) {
+	for _, entry := range errorCodes {
+		if errors.Is(err, entry.err) {
+			return entry.field, entry.fieldCode, entry.field != ""
+		}
+	}
+	return "", "", false
+}
+
 func (v *WalletValidator) validateRequest(req *wallet.WalletRequest) error {
 	if req == nil {
 		return ErrNilRequest
 	}
 
+	if req.WalletID == "" {
+		return ErrEmptyWalletID
+	}
 	walletID, err := uuid.Parse(req.WalletID)
 	if err != nil {
-		return fmt.Errorf("неверный формат UUID: %w", err)
+		return fmt.Errorf("%w: %w", ErrInvalidWalletID, err)
 	}
 
 	if err := v.ValidateWalletID(walletID); err != nil {
@@ -57,6 +195,14 @@ func (v *WalletValidator) validateRequest(req *wallet.WalletRequest) error {
 		return err
 	}
 
+	if err := v.ValidateMinAmount(req.OperationType, req.Amount); err != nil {
+		return err
+	}
+
+	if err := v.ValidateReference(req.Reference); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -67,16 +213,56 @@ func (v *WalletValidator) ValidateWalletID(id uuid.UUID) error {
 	return nil
 }
 
+// ValidateAmount отклоняет отрицательные суммы, NaN и бесконечности: NaN
+// не меньше нуля и прошёл бы любые сравнения с балансом
 func (v *WalletValidator) ValidateAmount(amount float64) error {
+	if math.IsNaN(amount) || math.IsInf(amount, 0) {
+		return ErrInvalidAmount
+	}
 	if amount < 0 {
 		return ErrNegativeAmount
 	}
+	return v.validatePrecision(amount)
+}
+
+// validatePrecision проверяет, что в сумме не больше знаков после точки, чем
+// допускает валюта кошелька: 10.005 для USD отклоняется, 10.5 для JPY - тоже.
+// Точность ограничена точностью хранения: третий знак KWD база не сохранит.
+func (v *WalletValidator) validatePrecision(amount float64) error {
+	if v.config.Currency == "" {
+		return nil
+	}
+	info, err := currency.Lookup(v.config.Currency)
+	if err != nil {
+		return nil
+	}
+	scale := min(info.Scale, storageScale)
+	// Кратчайшая запись числа совпадает с тем, как сумму передал клиент
+	formatted := strconv.FormatFloat(amount, 'f', -1, 64)
+	if _, fraction, ok := strings.Cut(formatted, "."); ok && len(fraction) > scale {
+		return fmt.Errorf("%w: %s, %d", ErrPrecisionExceedsCurrency, info.Code, scale)
+	}
+	return nil
+}
+
+// ValidateMinAmount проверяет нижнюю границу суммы для типа операции
+func (v *WalletValidator) ValidateMinAmount(opType wallet.OperationType, amount float64) error {
+	if min, ok := v.config.MinAmounts[opType]; ok && amount < min {
+		return fmt.Errorf("%w: %.2f", ErrAmountTooSmall, min)
+	}
 	return nil
 }
 
 func (v *WalletValidator) ValidateOperationType(opType wallet.OperationType) error {
-	if opType != wallet.DEPOSIT && opType != wallet.WITHDRAW {
-		return fmt.Errorf(ErrInvalidOperationType, opType)
+	if _, ok := wallet.LookupOperationType(opType); !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownOperationType, opType)
+	}
+	return nil
+}
+
+func (v *WalletValidator) ValidateReference(reference string) error {
+	if utf8.RuneCountInString(reference) > MaxReferenceLength {
+		return ErrReferenceTooLong
 	}
 	return nil
 }
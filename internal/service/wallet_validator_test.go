@@ -2,6 +2,8 @@ package service
 
 import (
 	"fmt"
+	"math"
+	"strings"
 	"testing"
 	wallet "wallet/internal/model"
 
@@ -12,6 +14,13 @@ import (
 func TestAll(t *testing.T) {
 	t.Run("WalletValidator", TestWalletValidator)
 	t.Run("ValidateNilRequest", TestWalletValidator_ValidateNilRequest)
+	t.Run("CustomOperationType", TestWalletValidator_CustomOperationType)
+	t.Run("MinAmounts", TestWalletValidator_MinAmounts)
+	t.Run("ErrorCode", TestErrorCode)
+	t.Run("WalletStateErrors", TestWalletStateErrors)
+	t.Run("FieldErrorCode", TestFieldErrorCode)
+	t.Run("CurrencyPrecision", TestWalletValidator_CurrencyPrecision)
+	t.Run("NonFiniteAmount", TestWalletValidator_NonFiniteAmount)
 }
 
 func TestWalletValidator(t *testing.T) {
@@ -68,6 +77,26 @@ func TestWalletValidator(t *testing.T) {
 			},
 			expectedErr: fmt.Errorf(ErrValidationPrefix, fmt.Errorf(ErrInvalidOperationType, "INVALID")).Error(),
 		},
+		{
+			name: "Валидный комментарий",
+			request: &wallet.WalletRequest{
+				WalletID:      validUUID.String(),
+				OperationType: wallet.DEPOSIT,
+				Amount:        100.0,
+				Reference:     "счёт №123",
+			},
+			expectedErr: "",
+		},
+		{
+			name: "Слишком длинный комментарий",
+			request: &wallet.WalletRequest{
+				WalletID:      validUUID.String(),
+				OperationType: wallet.DEPOSIT,
+				Amount:        100.0,
+				Reference:     strings.Repeat("я", MaxReferenceLength+1),
+			},
+			expectedErr: fmt.Errorf(ErrValidationPrefix, ErrReferenceTooLong).Error(),
+		},
 	}
 
 	for _, tt := range tests {
@@ -88,3 +117,183 @@ func TestWalletValidator_ValidateNilRequest(t *testing.T) {
 	err := validator.ValidateWalletRequest(nil)
 	assert.EqualError(t, err, fmt.Errorf(ErrValidationPrefix, ErrNilRequest).Error())
 }
+
+// Тест пользовательского типа операции из реестра
+func TestWalletValidator_CustomOperationType(t *testing.T) {
+	validator := NewWalletValidator()
+	adjustment := wallet.OperationType("ADJUSTMENT")
+
+	assert.Error(t, validator.ValidateOperationType(adjustment))
+
+	wallet.RegisterOperationType(adjustment, wallet.Credit)
+	defer wallet.UnregisterOperationType(adjustment)
+
+	assert.NoError(t, validator.ValidateOperationType(adjustment))
+	assert.NoError(t, validator.ValidateWalletRequest(&wallet.WalletRequest{
+		WalletID:      uuid.New().String(),
+		OperationType: adjustment,
+		Amount:        10,
+	}))
+}
+
+// Тест минимальных сумм на границе для депозита и вывода
+func TestWalletValidator_MinAmounts(t *testing.T) {
+	validator := NewWalletValidatorWithConfig(ValidatorConfig{
+		MinAmounts: map[wallet.OperationType]float64{
+			wallet.DEPOSIT:  1.00,
+			wallet.WITHDRAW: 10.00,
+		},
+	})
+
+	tests := []struct {
+		name      string
+		opType    wallet.OperationType
+		amount    float64
+		wantError bool
+	}{
+		{name: "Депозит на границе", opType: wallet.DEPOSIT, amount: 1.00},
+		{name: "Депозит ниже границы", opType: wallet.DEPOSIT, amount: 0.99, wantError: true},
+		{name: "Вывод на границе", opType: wallet.WITHDRAW, amount: 10.00},
+		{name: "Вывод ниже границы", opType: wallet.WITHDRAW, amount: 9.99, wantError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validator.ValidateWalletRequest(&wallet.WalletRequest{
+				WalletID:      uuid.New().String(),
+				OperationType: tt.opType,
+				Amount:        tt.amount,
+			})
+			if tt.wantError {
+				assert.ErrorIs(t, err, ErrAmountTooSmall)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+
+	// Без настроек минимум не проверяется
+	assert.NoError(t, NewWalletValidator().ValidateMinAmount(wallet.DEPOSIT, 0.01))
+}
+
+// Тест кодов ошибок валидации для обёрнутых ошибок
+func TestErrorCode(t *testing.T) {
+	validator := NewWalletValidator()
+
+	err := validator.ValidateWalletRequest(&wallet.WalletRequest{
+		WalletID:      uuid.New().String(),
+		OperationType: wallet.DEPOSIT,
+		Amount:        -1,
+	})
+	code, sentinel, ok := ErrorCode(err)
+	assert.True(t, ok)
+	assert.Equal(t, CodeNegativeAmount, code)
+	assert.Equal(t, ErrNegativeAmount, sentinel)
+
+	err = validator.ValidateWalletRequest(&wallet.WalletRequest{WalletID: "bad", OperationType: wallet.DEPOSIT})
+	code, _, ok = ErrorCode(err)
+	assert.True(t, ok)
+	assert.Equal(t, CodeInvalidWalletID, code)
+
+	_, _, ok = ErrorCode(fmt.Errorf("посторонняя ошибка"))
+	assert.False(t, ok)
+}
+
+// Тест ошибок состояния кошелька: errors.Is находит их сквозь обёртки,
+// а ErrorCode не принимает за ошибки валидации
+func TestWalletStateErrors(t *testing.T) {
+	for _, sentinel := range []error{ErrWalletNotFound, ErrWalletClosed, ErrWalletBlocked} {
+		wrapped := fmt.Errorf("операция: %w", fmt.Errorf("блокировка: %w", sentinel))
+		assert.ErrorIs(t, wrapped, sentinel)
+		assert.NotEqual(t, sentinel.Error(), wrapped.Error())
+
+		_, _, ok := ErrorCode(wrapped)
+		assert.False(t, ok)
+	}
+	assert.NotErrorIs(t, fmt.Errorf("операция: %w", ErrWalletClosed), ErrWalletNotFound)
+}
+
+// Тест кодов ошибок по полям: каждая ошибка валидации поля даёт свой код
+func TestFieldErrorCode(t *testing.T) {
+	validator := NewWalletValidatorWithConfig(ValidatorConfig{
+		MinAmounts: map[wallet.OperationType]float64{wallet.DEPOSIT: 10},
+	})
+	walletID := uuid.New().String()
+
+	tests := []struct {
+		name  string
+		req   *wallet.WalletRequest
+		field string
+		code  string
+	}{
+		{"Пустой wallet_id", &wallet.WalletRequest{OperationType: wallet.DEPOSIT, Amount: 20}, FieldWalletID, "wallet_id.empty"},
+		{"Неверный wallet_id", &wallet.WalletRequest{WalletID: "bad", OperationType: wallet.DEPOSIT, Amount: 20}, FieldWalletID, "wallet_id.invalid"},
+		{"Нулевой wallet_id", &wallet.WalletRequest{WalletID: uuid.Nil.String(), OperationType: wallet.DEPOSIT, Amount: 20}, FieldWalletID, "wallet_id.empty"},
+		{"Отрицательная сумма", &wallet.WalletRequest{WalletID: walletID, OperationType: wallet.DEPOSIT, Amount: -1}, FieldAmount, "amount.negative"},
+		{"Сумма меньше минимальной", &wallet.WalletRequest{WalletID: walletID, OperationType: wallet.DEPOSIT, Amount: 5}, FieldAmount, "amount.too_small"},
+		{"Неизвестный тип операции", &wallet.WalletRequest{WalletID: walletID, OperationType: "TRANSFER", Amount: 20}, FieldOperationType, "operation_type.invalid"},
+		{"Длинный комментарий", &wallet.WalletRequest{WalletID: walletID, OperationType: wallet.DEPOSIT, Amount: 20, Reference: strings.Repeat("x", MaxReferenceLength+1)}, FieldReference, "reference.too_long"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			field, code, ok := FieldErrorCode(validator.ValidateWalletRequest(tt.req))
+			assert.True(t, ok)
+			assert.Equal(t, tt.field, field)
+			assert.Equal(t, tt.code, code)
+		})
+	}
+
+	_, _, ok := FieldErrorCode(validator.ValidateWalletRequest(nil))
+	assert.False(t, ok, "ошибка без поля не даёт кода поля")
+	_, _, ok = FieldErrorCode(ErrInsufficientFunds)
+	assert.False(t, ok)
+}
+
+func TestWalletValidator_CurrencyPrecision(t *testing.T) {
+	tests := []struct {
+		name      string
+		currency  string
+		amount    float64
+		wantError bool
+	}{
+		{name: "USD, три знака", currency: "USD", amount: 10.005, wantError: true},
+		{name: "USD, два знака", currency: "usd", amount: 10.05},
+		{name: "USD, целая сумма", currency: "USD", amount: 10},
+		{name: "JPY, дробная сумма", currency: "JPY", amount: 10.5, wantError: true},
+		{name: "KWD, три знака - больше точности хранения", currency: "KWD", amount: 1.005, wantError: true},
+		{name: "KWD, два знака", currency: "KWD", amount: 1.05},
+		{name: "Валюта не задана", currency: "", amount: 10.005},
+		{name: "Неизвестная валюта", currency: "XYZ", amount: 10.005},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			validator := NewWalletValidatorWithConfig(ValidatorConfig{Currency: tt.currency})
+			err := validator.ValidateWalletRequest(&wallet.WalletRequest{
+				WalletID:      uuid.New().String(),
+				OperationType: wallet.DEPOSIT,
+				Amount:        tt.amount,
+			})
+			if tt.wantError {
+				assert.ErrorIs(t, err, ErrPrecisionExceedsCurrency)
+				field, code, ok := FieldErrorCode(err)
+				assert.True(t, ok)
+				assert.Equal(t, FieldAmount, field)
+				assert.Equal(t, FieldCodeAmountPrecision, code)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+// Тест отклонения NaN и бесконечных сумм
+func TestWalletValidator_NonFiniteAmount(t *testing.T) {
+	validator := NewWalletValidatorWithConfig(ValidatorConfig{Currency: "USD"})
+
+	for _, amount := range []float64{math.NaN(), math.Inf(1), math.Inf(-1)} {
+		assert.ErrorIs(t, validator.ValidateAmount(amount), ErrInvalidAmount)
+	}
+	assert.NoError(t, validator.ValidateAmount(0))
+	assert.NoError(t, validator.ValidateAmount(10.05))
+}
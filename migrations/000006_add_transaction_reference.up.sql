@@ -0,0 +1,2 @@
+-- Комментарий клиента к операции (например, номер счёта)
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS reference VARCHAR(255) NOT NULL DEFAULT '';
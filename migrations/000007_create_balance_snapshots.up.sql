@@ -0,0 +1,7 @@
+-- Периодические снимки балансов для быстрых запросов на момент времени
+CREATE TABLE IF NOT EXISTS balance_snapshots (
+    wallet_id UUID NOT NULL REFERENCES wallets(id),
+    balance DECIMAL(20, 2) NOT NULL,
+    as_of TIMESTAMP WITH TIME ZONE NOT NULL,
+    PRIMARY KEY (wallet_id, as_of)
+);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_transactions_void_of;
+ALTER TABLE transactions DROP COLUMN IF EXISTS void_of;
+ALTER TABLE transactions DROP COLUMN IF EXISTS voided_at;
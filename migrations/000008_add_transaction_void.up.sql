@@ -0,0 +1,5 @@
+-- Отмена операции: исходная запись помечается voided_at, компенсирующая ссылается на неё через void_of
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS voided_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS void_of UUID REFERENCES transactions(id);
+-- Операцию можно отменить только один раз
+CREATE UNIQUE INDEX IF NOT EXISTS idx_transactions_void_of ON transactions(void_of);
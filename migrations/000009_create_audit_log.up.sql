@@ -0,0 +1,18 @@
+-- Журнал аудита операций, отдельный от transactions
+CREATE TABLE IF NOT EXISTS audit_log (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    action VARCHAR(64) NOT NULL,
+    wallet_id VARCHAR(36) NOT NULL DEFAULT '',
+    operation_id VARCHAR(36) NOT NULL DEFAULT '',
+    operation_type VARCHAR(10) NOT NULL DEFAULT '',
+    amount DECIMAL(20, 2) NOT NULL DEFAULT 0,
+    source_ip VARCHAR(64) NOT NULL DEFAULT '',
+    result VARCHAR(16) NOT NULL,
+    error TEXT NOT NULL DEFAULT ''
+);
+-- Записи аудита нельзя изменить или удалить
+CREATE OR REPLACE RULE audit_log_no_update AS ON UPDATE TO audit_log DO INSTEAD NOTHING;
+CREATE OR REPLACE RULE audit_log_no_delete AS ON DELETE TO audit_log DO INSTEAD NOTHING;
+CREATE INDEX IF NOT EXISTS idx_audit_log_wallet ON audit_log(wallet_id, created_at);
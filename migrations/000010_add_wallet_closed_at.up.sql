@@ -0,0 +1,2 @@
+-- Мягкое закрытие кошелька: запись сохраняется, операции по нему запрещены
+ALTER TABLE wallets ADD COLUMN IF NOT EXISTS closed_at TIMESTAMP WITH TIME ZONE;
@@ -0,0 +1,2 @@
+ALTER TABLE wallets DROP COLUMN IF EXISTS allow_withdraw;
+ALTER TABLE wallets DROP COLUMN IF EXISTS allow_deposit;
@@ -0,0 +1,3 @@
+-- Разрешённые для кошелька операции: например, кошелек для пожертвований принимает только зачисления
+ALTER TABLE wallets ADD COLUMN IF NOT EXISTS allow_deposit BOOLEAN NOT NULL DEFAULT TRUE;
+ALTER TABLE wallets ADD COLUMN IF NOT EXISTS allow_withdraw BOOLEAN NOT NULL DEFAULT TRUE;
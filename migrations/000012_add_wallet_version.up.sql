@@ -0,0 +1,2 @@
+-- Версия баланса: увеличивается при каждом изменении, позволяет клиентам обнаружить устаревшее значение
+ALTER TABLE wallets ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 0;
@@ -0,0 +1,3 @@
+DROP VIEW IF EXISTS transactions_all;
+INSERT INTO transactions SELECT * FROM transactions_archive;
+DROP TABLE IF EXISTS transactions_archive;
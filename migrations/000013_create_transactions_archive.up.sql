@@ -0,0 +1,8 @@
+-- Архив старых операций: строки переносятся из transactions фоновой задачей, баланс на момент переноса сохраняется снимком
+CREATE TABLE IF NOT EXISTS transactions_archive (LIKE transactions INCLUDING DEFAULTS INCLUDING INDEXES);
+CREATE INDEX IF NOT EXISTS idx_transactions_archive_wallet_created ON transactions_archive(wallet_id, created_at);
+-- Вся история: действующие и архивные операции
+CREATE OR REPLACE VIEW transactions_all AS
+    SELECT * FROM transactions
+    UNION ALL
+    SELECT * FROM transactions_archive;
@@ -0,0 +1,3 @@
+DROP VIEW IF EXISTS wallet_ledger_balances;
+DROP TABLE IF EXISTS ledger_rollups;
+DROP INDEX IF EXISTS idx_transactions_wallet_amount;
@@ -0,0 +1,20 @@
+-- Покрывающий индекс: баланс по журналу (BALANCE_MODE=ledger) суммируется без чтения строк таблицы
+CREATE INDEX IF NOT EXISTS idx_transactions_wallet_amount ON transactions(wallet_id) INCLUDE (amount);
+-- Свёртка операций, перенесённых в архив: пополняется тем же запросом, что переносит строки
+CREATE TABLE IF NOT EXISTS ledger_rollups (
+    wallet_id UUID PRIMARY KEY REFERENCES wallets(id),
+    balance DECIMAL(20,2) NOT NULL DEFAULT 0
+);
+-- Начальная свёртка - разница между балансом кошелька и его действующим журналом.
+-- Она покрывает архив и балансы без записей в журнале (DEFAULT 1000.00, балансы
+-- до появления журнала), поэтому сразу после миграции баланс по журналу совпадает с хранимым
+INSERT INTO ledger_rollups (wallet_id, balance)
+SELECT w.id, w.balance - COALESCE((SELECT SUM(t.amount) FROM transactions t WHERE t.wallet_id = w.id), 0)
+FROM wallets w
+ON CONFLICT (wallet_id) DO NOTHING;
+-- Баланс кошелька по журналу: свёртка архива плюс действующие операции
+CREATE OR REPLACE VIEW wallet_ledger_balances AS
+    SELECT w.id AS wallet_id,
+           COALESCE(r.balance, 0) + COALESCE((SELECT SUM(t.amount) FROM transactions t WHERE t.wallet_id = w.id), 0) AS balance
+    FROM wallets w
+    LEFT JOIN ledger_rollups r ON r.wallet_id = w.id;
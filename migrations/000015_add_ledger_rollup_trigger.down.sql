@@ -0,0 +1,2 @@
+DROP TRIGGER IF EXISTS wallets_seed_ledger_rollup ON wallets;
+DROP FUNCTION IF EXISTS seed_ledger_rollup();
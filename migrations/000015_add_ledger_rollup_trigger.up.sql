@@ -0,0 +1,16 @@
+-- Начальный баланс нового кошелька (в том числе DEFAULT 1000.00) не имеет записи
+-- в журнале: он сразу попадает в свёртку, иначе баланс по журналу был бы нулевым
+CREATE OR REPLACE FUNCTION seed_ledger_rollup() RETURNS TRIGGER AS $$
+BEGIN
+    IF NEW.balance <> 0 THEN
+        INSERT INTO ledger_rollups (wallet_id, balance) VALUES (NEW.id, NEW.balance)
+        ON CONFLICT (wallet_id) DO NOTHING;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS wallets_seed_ledger_rollup ON wallets;
+CREATE TRIGGER wallets_seed_ledger_rollup
+    AFTER INSERT ON wallets
+    FOR EACH ROW EXECUTE FUNCTION seed_ledger_rollup();